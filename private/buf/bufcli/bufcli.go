@@ -571,7 +571,11 @@ func NewConfig(container appflag.Container) (*bufapp.Config, error) {
 }
 
 // Returns a registry provider with the given options applied in addition to default ones for all providers
-func newConnectClientConfigWithOptions(container appflag.Container, opts ...connectclient.ConfigOption) (*connectclient.Config, error) {
+func newConnectClientConfigWithOptions(
+	container appflag.Container,
+	extraInterceptors []connect.Interceptor,
+	opts ...connectclient.ConfigOption,
+) (*connectclient.Config, error) {
 	config, err := NewConfig(container)
 	if err != nil {
 		return nil, err
@@ -584,11 +588,14 @@ func newConnectClientConfigWithOptions(container appflag.Container, opts ...conn
 			}
 			return buftransport.PrependHTTPS(address)
 		}),
-		connectclient.WithInterceptors([]connect.Interceptor{
-			bufconnect.NewSetCLIVersionInterceptor(Version),
-			bufconnect.NewCLIWarningInterceptor(container),
-			otelconnect.NewInterceptor(),
-		}),
+		connectclient.WithInterceptors(append(
+			[]connect.Interceptor{
+				bufconnect.NewSetCLIVersionInterceptor(Version),
+				bufconnect.NewCLIWarningInterceptor(container),
+				otelconnect.NewInterceptor(),
+			},
+			extraInterceptors...,
+		)),
 	}
 	options = append(options, opts...)
 
@@ -599,13 +606,25 @@ func newConnectClientConfigWithOptions(container appflag.Container, opts ...conn
 // up the token in the container or in netrc based on the address of each individual client.
 // It is then set in the header of all outgoing requests from clients created using this config.
 func NewConnectClientConfig(container appflag.Container) (*connectclient.Config, error) {
+	return NewConnectClientConfigWithHeaders(container, nil)
+}
+
+// NewConnectClientConfigWithHeaders creates a new connect.ClientConfig like NewConnectClientConfig, which
+// also sets the provided headers in all outgoing requests from clients created using this config, such as
+// a custom User-Agent.
+func NewConnectClientConfigWithHeaders(container appflag.Container, header http.Header) (*connectclient.Config, error) {
 	envTokenProvider, err := bufconnect.NewTokenProviderFromContainer(container)
 	if err != nil {
 		return nil, err
 	}
 	netrcTokenProvider := bufconnect.NewNetrcTokenProvider(container, netrc.GetMachineForName)
+	var extraInterceptors []connect.Interceptor
+	if len(header) > 0 {
+		extraInterceptors = append(extraInterceptors, bufconnect.NewSetHeadersInterceptor(header))
+	}
 	return newConnectClientConfigWithOptions(
 		container,
+		extraInterceptors,
 		connectclient.WithAuthInterceptorProvider(
 			bufconnect.NewAuthorizationInterceptorProvider(envTokenProvider, netrcTokenProvider),
 		),
@@ -621,6 +640,7 @@ func NewConnectClientConfigWithToken(container appflag.Container, token string)
 	}
 	return newConnectClientConfigWithOptions(
 		container,
+		nil,
 		connectclient.WithAuthInterceptorProvider(
 			bufconnect.NewAuthorizationInterceptorProvider(tokenProvider),
 		),
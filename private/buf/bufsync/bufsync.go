@@ -18,17 +18,213 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bufbuild/buf/private/bufpkg/bufanalysis"
+	"github.com/bufbuild/buf/private/bufpkg/bufcheck/buflint/buflintconfig"
+	"github.com/bufbuild/buf/private/bufpkg/buflock"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule"
 	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
 	"github.com/bufbuild/buf/private/pkg/git"
+	"github.com/bufbuild/buf/private/pkg/manifest"
+	"github.com/bufbuild/buf/private/pkg/normalpath"
 	"github.com/bufbuild/buf/private/pkg/storage"
 	"github.com/bufbuild/buf/private/pkg/storage/storagegit"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
 )
 
 // ErrModuleDoesNotExist is an error returned when looking for a remote module.
 var ErrModuleDoesNotExist = errors.New("BSR module does not exist")
 
+// ErrBuildTimeout is an error found in the error chain passed to ErrorHandler.BuildFailure when a
+// module build does not finish within the timeout configured with SyncerWithBuildTimeout.
+var ErrBuildTimeout = errors.New("module build timed out")
+
+// LintError is an error passed to ErrorHandler.LintFailure when a module fails to compile or fails
+// the lint checks configured with SyncerWithLintOnSync.
+type LintError struct {
+	// FileAnnotations are the compilation or lint failures, sorted.
+	FileAnnotations []bufanalysis.FileAnnotation
+}
+
+// Error implements error. It returns the failures, one per line.
+func (e *LintError) Error() string {
+	fileAnnotationStrings := make([]string, len(e.FileAnnotations))
+	for i, fileAnnotation := range e.FileAnnotations {
+		fileAnnotationStrings[i] = fileAnnotation.String()
+	}
+	return fmt.Sprintf("%d lint failure(s):\n%s", len(e.FileAnnotations), strings.Join(fileAnnotationStrings, "\n"))
+}
+
+// ErrInterrupted is an error found in the error chain returned by Syncer when configured with
+// SyncerWithInterruptCheckpoint, and the context is done before syncing the next module commit.
+var ErrInterrupted = errors.New("sync interrupted")
+
+// ErrNondeterministicManifest is an error found in the error chain returned by Syncer when configured
+// with SyncerWithDeterministicManifest, and building a module commit twice results in different
+// manifest digests.
+var ErrNondeterministicManifest = errors.New("nondeterministic module manifest")
+
+// ErrModuleNotFound is an error found in the error chain returned by Syncer when configured with
+// SyncerWithStrictModules, and a module dir to sync is not found in any commit of the branches to sync.
+var ErrModuleNotFound = errors.New("module not found")
+
+// ErrPathCollision is an error found in the error chain passed to ErrorHandler.InvalidModuleConfig
+// with PathCollisionPolicyError, when a module bucket has paths differing only by case, which collide
+// on case-insensitive filesystems.
+var ErrPathCollision = errors.New("paths differ only by case")
+
+// BuildError is returned by Syncer when a module has an invalid module config, fails to build, or
+// is not found in a git commit, and the ErrorHandler aborts sync. Retrying the sync will fail the
+// same way, unless the ErrorHandler behavior changes.
+type BuildError struct {
+	// Module is the module that failed to build.
+	Module Module
+	// Branch is the git branch being synced.
+	Branch string
+	// Commit is the hash of the git commit the module failed to build in.
+	Commit git.Hash
+	// Err is the error returned by the ErrorHandler.
+	Err error
+}
+
+// Error implements error. It returns the message of the wrapped error.
+func (e *BuildError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// PolicyError is returned by Syncer when a module fails lint, is deleted, or its commit signature
+// cannot be verified, in a git commit, and the ErrorHandler aborts sync. Unlike a *BuildError, the
+// module builds, but the ErrorHandler refuses to sync it.
+type PolicyError struct {
+	// Module is the module that was refused.
+	Module Module
+	// Branch is the git branch being synced.
+	Branch string
+	// Commit is the hash of the git commit the module was refused in.
+	Commit git.Hash
+	// Err is the error returned by the ErrorHandler.
+	Err error
+}
+
+// Error implements error. It returns the message of the wrapped error.
+func (e *PolicyError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// PushError is returned by Syncer when the SyncFunc fails to process a module commit. Such errors
+// are usually transient, and retrying the sync may succeed.
+type PushError struct {
+	// Module is the module that failed to be pushed.
+	Module Module
+	// Branch is the git branch being synced.
+	Branch string
+	// Commit is the hash of the git commit the module failed to be pushed from.
+	Commit git.Hash
+	// Err is the error returned by the SyncFunc.
+	Err error
+}
+
+// Error implements error. It returns the message of the wrapped error.
+func (e *PushError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+// SyncPointError is returned by Syncer when a module's branch sync point is invalid, diverged from
+// the branch, or an already synced commit does not match the remote content, and the ErrorHandler
+// aborts sync. It is also returned when an already synced commit is found in the default branch
+// before its sync point, like after a rebase. Retrying the sync will fail the same way until
+// the git repository or the BSR module are fixed.
+type SyncPointError struct {
+	// Module is the module with the failing sync point.
+	Module Module
+	// Branch is the git branch the sync point was resolved for.
+	Branch string
+	// SyncPoint is the hash of the git commit the sync point points to, or the already synced
+	// git commit whose remote content does not match.
+	SyncPoint git.Hash
+	// Err is the error returned by the ErrorHandler.
+	Err error
+}
+
+// Error implements error. It returns the message of the wrapped error.
+func (e *SyncPointError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *SyncPointError) Unwrap() error {
+	return e.Err
+}
+
+// ModulesConfigError is returned by Syncer when the modules to sync are misconfigured, such as the
+// modules listed in a workspace configured with SyncerWithWorkspace, which are only read by Sync and
+// Plan. Retrying the sync will fail the same way until the configuration is fixed.
+type ModulesConfigError struct {
+	// Err is the configuration error.
+	Err error
+}
+
+// Error implements error. It returns the message of the wrapped error.
+func (e *ModulesConfigError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *ModulesConfigError) Unwrap() error {
+	return e.Err
+}
+
+// TagConflictError is returned by Syncer with TagConflictPolicyFailIfAnyExists when a git tag
+// already exists in a remote module pointing to a different commit. Retrying the sync will fail the
+// same way until the tag points to the same commit in the git repository and the BSR module.
+type TagConflictError struct {
+	// Module is the module with the conflicting remote tag.
+	Module Module
+	// Tag is the name of the git tag.
+	Tag string
+	// Commit is the hash of the git commit the tag points to in the git repository.
+	Commit git.Hash
+	// RemoteCommit is the git commit hash the tag points to in the remote module, or its commit
+	// label with SyncerWithCommitLabelMapper.
+	RemoteCommit string
+}
+
+// Error implements error.
+func (e *TagConflictError) Error() string {
+	return fmt.Sprintf(
+		"tag %q already exists in module %s pointing to %q, but it points to %q in the git repository",
+		e.Tag,
+		e.Module.RemoteIdentity().IdentityString(),
+		e.RemoteCommit,
+		e.Commit.Hex(),
+	)
+}
+
 // ErrorHandler handles errors reported by the Syncer. If a non-nil
 // error is returned by the handler, sync will abort in a partially-synced
 // state.
@@ -51,6 +247,17 @@ type ErrorHandler interface {
 		commit git.Commit,
 		err error,
 	) error
+	// LintFailure is invoked by Syncer upon encountering a module that fails
+	// to compile or fails lint, when configured with SyncerWithLintOnSync. The
+	// error is a *LintError.
+	//
+	// Returning an error will abort sync. Returning nil skips syncing the
+	// module in this commit.
+	LintFailure(
+		module Module,
+		commit git.Commit,
+		err error,
+	) error
 	// InvalidSyncPoint is invoked by Syncer upon encountering a module's branch
 	// sync point that is invalid. A typical example is either a sync point that
 	// point to a commit that cannot be found anymore, or the commit itself has
@@ -63,6 +270,53 @@ type ErrorHandler interface {
 		syncPoint git.Hash,
 		err error,
 	) error
+	// SyncPointDiverged is invoked by Syncer upon encountering a module's branch
+	// sync point that exists, but is not an ancestor of the branch's HEAD commit.
+	// A typical example is a branch that was force-pushed after it was synced.
+	//
+	// Returning an error will abort sync. Returning nil ignores the sync point, and
+	// the branch is re-synced from the most recent commit already synced for the
+	// module, which is the merge base between the diverged histories.
+	SyncPointDiverged(
+		module Module,
+		branch string,
+		syncPoint git.Hash,
+		headHash git.Hash,
+	) error
+	// ModuleDeleted is invoked by Syncer upon encountering a commit where a
+	// module is not found, but it was found in the commit's first parent.
+	//
+	// Returning an error will abort sync. Returning nil handles the deleted
+	// module according to the DeletedModulePolicy.
+	ModuleDeleted(
+		module Module,
+		commit git.Commit,
+	) error
+	// UnsignedCommit is invoked by Syncer upon encountering a commit with the
+	// module whose signature cannot be verified against the keys configured
+	// with SyncerWithRequireSignedCommits or SyncerWithRequireSSHSignedCommits,
+	// either because the commit is not signed, or because it is not signed by
+	// any of the keys.
+	//
+	// Returning an error will abort sync. Returning nil skips syncing the
+	// module in this commit.
+	UnsignedCommit(
+		module Module,
+		commit git.Commit,
+	) error
+	// RemoteContentMismatch is invoked by Syncer upon encountering a commit
+	// already synced for a module, whose locally built content digest does not
+	// match the remote content digest, when configured with
+	// SyncerWithVerifyRemoteContent.
+	//
+	// Returning an error will abort sync. Returning nil keeps the commit as
+	// synced, without syncing it again.
+	RemoteContentMismatch(
+		module Module,
+		commit git.Commit,
+		localDigest *manifest.Digest,
+		remoteDigest *manifest.Digest,
+	) error
 }
 
 // Module is a module that will be synced by Syncer.
@@ -92,8 +346,59 @@ type Syncer interface {
 	// SyncFunc with a ModuleCommit.
 	//
 	// Only commits/branches belonging to the remote named 'origin' are
-	// processed. All tags are processed.
+	// processed. All tags are processed for the synced commits, and tagged
+	// commits not reachable from any synced branch are only synced for the
+	// modules configured with SyncerWithTagsOnly.
+	//
+	// If sync aborts because of the ErrorHandler or the SyncFunc, the returned
+	// error wraps a *BuildError, *PolicyError, *PushError, or *SyncPointError, which can be
+	// inspected with errors.As. With SyncerWithContinueOnBranchError, the returned
+	// error combines the errors of all the failed branches.
 	Sync(context.Context, SyncFunc) error
+	// Plan computes the commits that Sync would process for each branch and module,
+	// after applying resumption and branch filters, without building any module or
+	// invoking any SyncFunc. Plan fails if any branch fails to resolve its sync
+	// points, regardless of SyncerWithContinueOnBranchError.
+	Plan(context.Context) (SyncPlan, error)
+}
+
+// SyncPlan is the set of commits that a Syncer would process, grouped by branch in
+// the order the branches are synced.
+type SyncPlan struct {
+	Branches []BranchSyncPlan
+}
+
+// BranchSyncPlan is the set of commits that a Syncer would process for a branch.
+type BranchSyncPlan struct {
+	// Branch is the git branch name. It is empty for the tagged commits synced with
+	// SyncerWithTagsOnly.
+	Branch string
+	// SyncPoints are the resolved sync points for the modules in this branch. Modules
+	// without a sync point are not present.
+	SyncPoints map[Module]git.Hash
+	// Commits are the commits to sync in this branch, in the order they'd be synced.
+	Commits []CommitSyncPlan
+	// FilteredCommits are the commits traversed in this branch that are not synced for any
+	// module, because they do not change any module or are excluded by the commit filter, in
+	// the order they were traversed.
+	FilteredCommits []git.Commit
+	// Orphan is true if the branch has no merge base with the default branch, such as a branch
+	// created with `git checkout --orphan`. Orphan branches sync their full history independently
+	// of the default branch, resuming from their own sync points.
+	Orphan bool
+}
+
+// CommitSyncPlan is a git commit that a Syncer would process, along with the modules
+// pending to sync in that commit.
+type CommitSyncPlan struct {
+	// Commit is the git commit to sync.
+	Commit git.Commit
+	// Modules are the modules pending to sync in this commit, in the order they were
+	// configured. A module might still be skipped at sync time if it is not found, or
+	// is invalid, in this commit.
+	Modules []Module
+	// Tags are the git tags associated with Commit.
+	Tags []string
 }
 
 // NewSyncer creates a new Syncer.
@@ -131,6 +436,38 @@ func SyncerWithModule(module Module) SyncerOption {
 	}
 }
 
+// SyncerWithTimeWindowCoalesce configures a Syncer to coalesce runs of consecutive commits in a
+// branch, each committed within the window of the next one, into a single module commit for the
+// last commit of the run, with the tags of the whole run.
+func SyncerWithTimeWindowCoalesce(window time.Duration) SyncerOption {
+	return func(s *syncer) error {
+		if window <= 0 {
+			return fmt.Errorf("coalesce window must be positive, got %v", window)
+		}
+		s.coalesceWindow = window
+		return nil
+	}
+}
+
+// SyncerWithWorkspace configures a Syncer to sync the modules listed in the buf.work.yaml in the
+// workspace dir, as read at the HEAD commit of the current branch. Modules configured with
+// SyncerWithModule take precedence, and this option can be provided multiple times.
+func SyncerWithWorkspace(dir string) SyncerOption {
+	return func(s *syncer) error {
+		normalized, err := normalpath.NormalizeAndValidate(dir)
+		if err != nil {
+			return fmt.Errorf("invalid workspace dir %q: %w", dir, err)
+		}
+		for _, existingDir := range s.workspaceDirs {
+			if existingDir == normalized {
+				return fmt.Errorf("duplicate workspace %q", dir)
+			}
+		}
+		s.workspaceDirs = append(s.workspaceDirs, normalized)
+		return nil
+	}
+}
+
 // SyncerWithResumption configures a Syncer with a resumption using a SyncPointResolver.
 func SyncerWithResumption(resolver SyncPointResolver) SyncerOption {
 	return func(s *syncer) error {
@@ -139,6 +476,46 @@ func SyncerWithResumption(resolver SyncPointResolver) SyncerOption {
 	}
 }
 
+// SyncerWithResumeOverrides configures a Syncer to resume from the sync points listed in the file
+// at the path, one `<module-identity> <branch> <git-hash>` per line, overriding the ones the
+// SyncPointResolver returns.
+func SyncerWithResumeOverrides(path string) SyncerOption {
+	return func(s *syncer) (retErr error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("open resume overrides: %w", err)
+		}
+		defer func() {
+			retErr = multierr.Append(retErr, file.Close())
+		}()
+		resumeOverrides, err := parseResumeOverrides(file)
+		if err != nil {
+			return fmt.Errorf("parse resume overrides %q: %w", path, err)
+		}
+		s.resumeOverrides = resumeOverrides
+		return nil
+	}
+}
+
+// SyncerWithLocalResumePoint configures a Syncer to resume syncing the branch after the commit for
+// all modules, bypassing the SyncPointResolver and the resume overrides. This option can be
+// provided multiple times to resume multiple distinct branches.
+func SyncerWithLocalResumePoint(branch string, hash git.Hash) SyncerOption {
+	return func(s *syncer) error {
+		if hash == nil {
+			return fmt.Errorf("local resume point for branch %q has no hash", branch)
+		}
+		if _, ok := s.localResumePoints[branch]; ok {
+			return fmt.Errorf("duplicate local resume point for branch %q", branch)
+		}
+		if s.localResumePoints == nil {
+			s.localResumePoints = make(map[string]git.Hash)
+		}
+		s.localResumePoints[branch] = hash
+		return nil
+	}
+}
+
 // SyncerWithGitCommitChecker configures a git commit checker, to know if a module has a given git
 // hash alrady synced in a BSR instance.
 func SyncerWithGitCommitChecker(checker SyncedGitCommitChecker) SyncerOption {
@@ -158,6 +535,19 @@ func SyncerWithModuleDefaultBranchGetter(getter ModuleDefaultBranchGetter) Synce
 	}
 }
 
+// SyncerWithExpectedDefaultBranch configures the BSR branch the ModuleDefaultBranchGetter is
+// expected to return for the modules. By default, it is the git repository default branch, as
+// mapped by the BranchNameMapper.
+func SyncerWithExpectedDefaultBranch(branch string) SyncerOption {
+	return func(s *syncer) error {
+		if branch == "" {
+			return errors.New("expected default branch must not be empty")
+		}
+		s.expectedDefaultBranch = branch
+		return nil
+	}
+}
+
 // SyncerWithAllBranches sets the syncer to sync all branches. Be default the syncer only processes
 // commits in the current checked out branch.
 func SyncerWithAllBranches() SyncerOption {
@@ -167,6 +557,707 @@ func SyncerWithAllBranches() SyncerOption {
 	}
 }
 
+// SyncerWithoutDefaultBranchPriority configures the syncer to sort the default branch by name as
+// any other branch to sync, instead of syncing it first, so the commits it shares with other
+// branches are synced with the first branch that reaches them.
+func SyncerWithoutDefaultBranchPriority() SyncerOption {
+	return func(s *syncer) error {
+		s.noDefaultBranchPriority = true
+		return nil
+	}
+}
+
+// SyncerWithContinueOnBranchError configures the syncer to continue with the rest of the branches
+// when a branch fails to sync, and return the combined errors at the end. The branches and tagged
+// commits depending on the commits of a failed branch are skipped.
+func SyncerWithContinueOnBranchError() SyncerOption {
+	return func(s *syncer) error {
+		s.continueOnBranchError = true
+		return nil
+	}
+}
+
+// SyncerWithHeadOnly configures the syncer to only sync the HEAD commit of the current branch, even
+// if it was already synced, ignoring resumption and without traversing the branch history.
+func SyncerWithHeadOnly() SyncerOption {
+	return func(s *syncer) error {
+		s.headOnly = true
+		return nil
+	}
+}
+
+// SyncerWithInterruptCheckpoint configures a Syncer to finish the module commit being synced when
+// the context is done, and stop before the next one with ErrInterrupted in the returned error
+// chain.
+func SyncerWithInterruptCheckpoint() SyncerOption {
+	return func(s *syncer) error {
+		s.interruptCheckpoint = true
+		return nil
+	}
+}
+
+// SyncerWithCheckpointInterval configures a Syncer to invoke the CheckpointHook every n module
+// commits synced per module in a branch, instead of after every module commit.
+func SyncerWithCheckpointInterval(n int) SyncerOption {
+	return func(s *syncer) error {
+		if n <= 0 {
+			return fmt.Errorf("checkpoint interval must be positive, got %d", n)
+		}
+		s.checkpointInterval = n
+		return nil
+	}
+}
+
+// SyncerWithCheckpointHook configures a Syncer to invoke the hook with the last module commit
+// synced every checkpoint interval, and once more at the end of a branch for the module commits
+// synced since the last checkpoint.
+func SyncerWithCheckpointHook(hook CheckpointHook) SyncerOption {
+	return func(s *syncer) error {
+		s.checkpointHook = hook
+		return nil
+	}
+}
+
+// CheckpointHook is invoked by Syncer with the hash of the last git commit synced for a module in a
+// branch, so the module can be resumed from it. If an error is returned, sync will abort.
+type CheckpointHook func(ctx context.Context, module Module, branch string, commitHash git.Hash) error
+
+// SyncerWithInitialSyncConfirmation configures a Syncer to ask the confirmer, once per sync and
+// before any commit is synced, to sync each branch with more commits than the threshold of a module
+// with no sync point in any branch. The branches not confirmed are skipped.
+func SyncerWithInitialSyncConfirmation(threshold int, confirmer InitialSyncConfirmer) SyncerOption {
+	return func(s *syncer) error {
+		if threshold <= 0 {
+			return fmt.Errorf("initial sync confirmation threshold must be positive, got %d", threshold)
+		}
+		if confirmer == nil {
+			return errors.New("initial sync confirmer must not be nil")
+		}
+		s.initialSyncThreshold = threshold
+		s.initialSyncConfirmer = confirmer
+		return nil
+	}
+}
+
+// SyncerWithHeadFirstBackfill configures a Syncer to sync the HEAD commit of each branch first, and
+// then backfill its history since the sync point from the most recent commit to the oldest. It only
+// supports MergeCommitPolicyFirstParentOnly, and the CheckpointHook is only invoked once the
+// backfill of a branch completes.
+func SyncerWithHeadFirstBackfill() SyncerOption {
+	return func(s *syncer) error {
+		s.headFirstBackfill = true
+		return nil
+	}
+}
+
+// SyncerWithStrictModules configures a Syncer to fail before syncing, with ErrModuleNotFound in the
+// returned error chain, if a module dir is not found in any commit of the branches to sync since
+// its sync point. By default, a warning is logged instead.
+func SyncerWithStrictModules() SyncerOption {
+	return func(s *syncer) error {
+		s.strictModules = true
+		return nil
+	}
+}
+
+// SyncerWithMaxHistoryDepth configures the syncer to visit at most the passed number of commits per
+// branch, from its HEAD commit, when looking for the commits to sync. A warning is logged if the
+// sync points are not found within the depth.
+func SyncerWithMaxHistoryDepth(depth int) SyncerOption {
+	return func(s *syncer) error {
+		if depth <= 0 {
+			return fmt.Errorf("invalid max history depth %d, must be positive", depth)
+		}
+		s.maxHistoryDepth = depth
+		return nil
+	}
+}
+
+// SyncerWithReanchorOnMissingSyncPoint configures the syncer to resume a branch whose sync point is
+// not found in the repository from its most recent commit already synced, as reported by the
+// SyncedGitCommitChecker, instead of handling it as an invalid sync point.
+func SyncerWithReanchorOnMissingSyncPoint() SyncerOption {
+	return func(s *syncer) error {
+		s.reanchorOnMissingSyncPoint = true
+		return nil
+	}
+}
+
+// SyncerWithSkipUnchangedCommits configures the syncer to skip a module in the commits where its
+// module dir tree is unchanged from the first parent's. Skipped commits do not become sync points.
+func SyncerWithSkipUnchangedCommits() SyncerOption {
+	return func(s *syncer) error {
+		s.skipUnchangedCommits = true
+		return nil
+	}
+}
+
+// SyncerWithPathExclude configures the syncer to exclude the files matching any of the path.Match
+// patterns, relative to the repository root, from every synced module, with a matching directory
+// excluding everything under it. This option can be provided multiple times.
+func SyncerWithPathExclude(patterns ...string) SyncerOption {
+	return func(s *syncer) error {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid path exclude pattern %q: %w", pattern, err)
+			}
+			s.pathExcludePatterns = append(s.pathExcludePatterns, normalpath.Normalize(pattern))
+		}
+		return nil
+	}
+}
+
+// SyncerWithSubmodules configures the syncer to include the content of git submodules in the synced
+// modules, read from the submodule commits in the repository object store. Submodules with commits
+// not present are skipped with a warning.
+func SyncerWithSubmodules() SyncerOption {
+	return func(s *syncer) error {
+		s.submodules = true
+		return nil
+	}
+}
+
+// SyncerWithLazyBuckets configures the syncer to stream the files of the module buckets from the
+// git object store as they are read, instead of reading them in memory. Files must be read one at a
+// time, and the bucket must not be used after the SyncFunc returns.
+func SyncerWithLazyBuckets() SyncerOption {
+	return func(s *syncer) error {
+		s.lazyBuckets = true
+		return nil
+	}
+}
+
+// SyncerWithTreeCacheSize configures the syncer to cache up to the passed number of built modules,
+// keyed by module dir and the git tree hash of the module dir, so a module unchanged across commits
+// or branches is built once.
+func SyncerWithTreeCacheSize(entries int) SyncerOption {
+	return func(s *syncer) error {
+		if entries <= 0 {
+			return fmt.Errorf("tree cache size must be positive, got %d", entries)
+		}
+		s.treeCache = newTreeCache(entries)
+		return nil
+	}
+}
+
+// SyncerWithBuildPipelineDepth configures the syncer to build up to the passed number of module
+// commits of a branch ahead of the one being pushed, to hide the build latency behind the push
+// latency. It cannot be used with SyncerWithLazyBuckets.
+func SyncerWithBuildPipelineDepth(depth int) SyncerOption {
+	return func(s *syncer) error {
+		if depth <= 0 {
+			return fmt.Errorf("invalid build pipeline depth %d, must be positive", depth)
+		}
+		s.buildPipelineDepth = depth
+		return nil
+	}
+}
+
+// SyncerWithDeterministicManifest configures the syncer to build every module commit twice, from
+// separate reads of the git tree, and abort sync with ErrNondeterministicManifest in the returned
+// error chain if their manifest digests differ.
+func SyncerWithDeterministicManifest() SyncerOption {
+	return func(s *syncer) error {
+		s.deterministicManifest = true
+		return nil
+	}
+}
+
+// SyncerWithExtraRefs configures the syncer to also sync the refs matching any of the path.Match
+// patterns, such as `refs/custom/published/*`, each as a branch named after the last element of the
+// ref name.
+func SyncerWithExtraRefs(patterns ...string) SyncerOption {
+	return func(s *syncer) error {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid ref pattern %q: %w", pattern, err)
+			}
+		}
+		s.extraRefPatterns = append(s.extraRefPatterns, patterns...)
+		return nil
+	}
+}
+
+// SyncerWithGitNotes configures the syncer to expose the git notes of the notes ref through
+// ModuleCommit.Notes, with refs not starting with `refs/` expanded under `refs/notes/`. This option
+// can be provided multiple times to read notes from multiple refs.
+func SyncerWithGitNotes(ref string) SyncerOption {
+	return func(s *syncer) error {
+		if ref == "" {
+			return errors.New("empty notes ref")
+		}
+		if !strings.HasPrefix(ref, "refs/") {
+			ref = "refs/notes/" + ref
+		}
+		s.gitNotesRefs = append(s.gitNotesRefs, ref)
+		return nil
+	}
+}
+
+// SyncerWithCommitAnnotator configures a Syncer to expose the metadata the annotator returns for
+// every synced git commit through ModuleCommit.Annotations. The annotator is invoked once per git
+// commit.
+func SyncerWithCommitAnnotator(annotator CommitAnnotator) SyncerOption {
+	return func(s *syncer) error {
+		if annotator == nil {
+			return errors.New("nil commit annotator")
+		}
+		s.commitAnnotator = annotator
+		return nil
+	}
+}
+
+// CommitAnnotator is invoked by Syncer to get the annotations of a git commit to sync. It can return
+// nil for a commit without annotations. If an error is returned, sync will abort.
+type CommitAnnotator func(commit git.Commit) (map[string]string, error)
+
+// MergeCommitPolicy controls how a Syncer handles merge commits, which are commits with more than
+// one parent.
+type MergeCommitPolicy int
+
+const (
+	// MergeCommitPolicyFirstParentOnly travels only the first parent of merge commits, ignoring the
+	// individual commits brought in to a branch's history by a merge. Merge commits are synced like
+	// any other commit. This is the default policy.
+	MergeCommitPolicyFirstParentOnly MergeCommitPolicy = iota
+	// MergeCommitPolicyInclude travels all parents of merge commits, syncing the commits brought in
+	// to a branch's history by a merge before the merge commit itself.
+	MergeCommitPolicyInclude
+	// MergeCommitPolicySkip travels all parents of merge commits like MergeCommitPolicyInclude, but
+	// merge commits themselves are not synced, as their content is usually already synced via the
+	// merged commits.
+	MergeCommitPolicySkip
+)
+
+// SyncerWithMergeCommitPolicy configures the policy a Syncer uses to handle merge commits. By
+// default, the syncer uses MergeCommitPolicyFirstParentOnly.
+func SyncerWithMergeCommitPolicy(policy MergeCommitPolicy) SyncerOption {
+	return func(s *syncer) error {
+		switch policy {
+		case MergeCommitPolicyFirstParentOnly, MergeCommitPolicyInclude, MergeCommitPolicySkip:
+			s.mergeCommitPolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("unknown merge commit policy %d", policy)
+		}
+	}
+}
+
+// TagConflictPolicy controls how a Syncer handles a git tag that already exists in some of the
+// remote modules it is synced to, pointing to a different commit.
+type TagConflictPolicy int
+
+const (
+	// TagConflictPolicyIndependent syncs the tags of every module independently, moving a tag in the
+	// modules synced in the tagged commit. This is the default policy.
+	TagConflictPolicyIndependent TagConflictPolicy = iota
+	// TagConflictPolicyFailIfAnyExists aborts sync before syncing any commit if a git tag already
+	// exists in any of the remote modules pointing to a different commit, so a tag shared by many
+	// modules is never moved in some of them only. The remote tags are resolved with the
+	// TagResolver configured with SyncerWithTagResolver.
+	TagConflictPolicyFailIfAnyExists
+)
+
+// SyncerWithTagConflictPolicy configures the policy a Syncer uses to handle git tags already
+// existing in the remote modules, pointing to a different commit. By default, the syncer uses
+// TagConflictPolicyIndependent.
+func SyncerWithTagConflictPolicy(policy TagConflictPolicy) SyncerOption {
+	return func(s *syncer) error {
+		switch policy {
+		case TagConflictPolicyIndependent, TagConflictPolicyFailIfAnyExists:
+			s.tagConflictPolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("unknown tag conflict policy %d", policy)
+		}
+	}
+}
+
+// SyncerWithTagsOnly configures the syncer to also sync the tagged commits of the module with the
+// remote identity that are not reachable from any synced branch, after all branches and with an
+// empty branch. This option can be provided multiple times.
+func SyncerWithTagsOnly(identity bufmoduleref.ModuleIdentity) SyncerOption {
+	return func(s *syncer) error {
+		for _, existingIdentity := range s.tagsOnlyModuleIdentities {
+			if existingIdentity.IdentityString() == identity.IdentityString() {
+				return fmt.Errorf("duplicate tags only module %s", identity.IdentityString())
+			}
+		}
+		s.tagsOnlyModuleIdentities = append(s.tagsOnlyModuleIdentities, identity)
+		return nil
+	}
+}
+
+// SyncerWithModuleOrder configures a Syncer to sync the modules with the remote identities first in
+// every commit, in the passed order, such as to push a module before the modules that depend on it.
+func SyncerWithModuleOrder(identities []bufmoduleref.ModuleIdentity) SyncerOption {
+	return func(s *syncer) error {
+		seenIdentities := make(map[string]struct{}, len(identities))
+		for _, identity := range identities {
+			if _, seen := seenIdentities[identity.IdentityString()]; seen {
+				return fmt.Errorf("duplicate module %s in module order", identity.IdentityString())
+			}
+			seenIdentities[identity.IdentityString()] = struct{}{}
+		}
+		s.moduleOrder = identities
+		return nil
+	}
+}
+
+// SyncerWithOverlayFiles configures a Syncer to overlay the files, keyed by their path relative to
+// the module root, onto the bucket of every commit of the module in the dir, before the bucket
+// transformers. This option can be provided multiple times, but a path cannot be overlaid twice.
+func SyncerWithOverlayFiles(dir string, files map[string][]byte) SyncerOption {
+	return func(s *syncer) error {
+		dir, err := normalpath.NormalizeAndValidate(dir)
+		if err != nil {
+			return fmt.Errorf("invalid overlay dir: %w", err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no overlay files for module %q", dir)
+		}
+		if s.overlayFilesByModuleDir == nil {
+			s.overlayFilesByModuleDir = make(map[string]map[string][]byte)
+		}
+		overlayFiles, ok := s.overlayFilesByModuleDir[dir]
+		if !ok {
+			overlayFiles = make(map[string][]byte, len(files))
+			s.overlayFilesByModuleDir[dir] = overlayFiles
+		}
+		for path, data := range files {
+			path, err := normalpath.NormalizeAndValidate(path)
+			if err != nil {
+				return fmt.Errorf("invalid overlay file for module %q: %w", dir, err)
+			}
+			if _, ok := overlayFiles[path]; ok {
+				return fmt.Errorf("overlay file %q for module %q already set", path, dir)
+			}
+			overlayFiles[path] = append([]byte(nil), data...)
+		}
+		return nil
+	}
+}
+
+// SyncerWithBucketTransformer configures a Syncer to transform the bucket of every module commit
+// after it is built and before invoking the SyncFunc. This option can be provided multiple times,
+// and transformers are applied in order.
+func SyncerWithBucketTransformer(transformer BucketTransformer) SyncerOption {
+	return func(s *syncer) error {
+		s.bucketTransformers = append(s.bucketTransformers, transformer)
+		return nil
+	}
+}
+
+// SyncerWithLockRewriter configures a Syncer to rewrite the `buf.lock` file of every module commit
+// that has one, after the bucket transformers, such as to pin dependencies on the BSR commits of
+// other synced modules.
+func SyncerWithLockRewriter(rewriter LockRewriter) SyncerOption {
+	return func(s *syncer) error {
+		s.lockRewriter = rewriter
+		return nil
+	}
+}
+
+// LockRewriter is invoked by Syncer to rewrite the parsed `buf.lock` file of a module commit before
+// it is passed to the SyncFunc. It receives the module commit being synced, and its lock file to
+// rewrite, and returns the rewritten lock file. If an error is returned, sync will abort.
+type LockRewriter func(
+	ctx context.Context,
+	moduleCommit ModuleCommit,
+	lock *buflock.Config,
+) (*buflock.Config, error)
+
+// BucketTransformer is invoked by Syncer to transform a module commit bucket before it is passed to
+// the SyncFunc. It receives the module commit being synced, and its bucket to transform, and returns
+// the transformed bucket. If an error is returned, sync will abort.
+type BucketTransformer func(
+	ctx context.Context,
+	moduleCommit ModuleCommit,
+	bucket storage.ReadBucket,
+) (storage.ReadBucket, error)
+
+// DeletedModulePolicy controls how a Syncer handles a module that is deleted in a branch, after the
+// ErrorHandler is notified and continues.
+type DeletedModulePolicy int
+
+const (
+	// DeletedModulePolicyStop stops syncing the module in the rest of the branch commits, even if the
+	// module is added again in a later commit. This is the default policy.
+	DeletedModulePolicyStop DeletedModulePolicy = iota
+	// DeletedModulePolicySkip skips the commits where the module is not found, and resumes syncing the
+	// module if it is added again in a later commit.
+	DeletedModulePolicySkip
+)
+
+// SyncerWithDeletedModulePolicy configures the policy a Syncer uses to handle modules deleted in a
+// branch. By default, the syncer uses DeletedModulePolicyStop.
+func SyncerWithDeletedModulePolicy(policy DeletedModulePolicy) SyncerOption {
+	return func(s *syncer) error {
+		switch policy {
+		case DeletedModulePolicyStop, DeletedModulePolicySkip:
+			s.deletedModulePolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("unknown deleted module policy %d", policy)
+		}
+	}
+}
+
+// PathCollisionPolicy controls how a Syncer handles a module bucket with paths differing only by case,
+// like `Foo.proto` and `foo.proto`, which are ambiguous on case-insensitive filesystems.
+type PathCollisionPolicy int
+
+const (
+	// PathCollisionPolicyError invokes ErrorHandler.InvalidModuleConfig with ErrPathCollision in the
+	// error chain, naming the colliding paths. This is the default policy.
+	PathCollisionPolicyError PathCollisionPolicy = iota
+	// PathCollisionPolicyWarn logs a warning naming the colliding paths, and syncs the module commit.
+	PathCollisionPolicyWarn
+)
+
+// SyncerWithPathCollisionPolicy configures the policy a Syncer uses to handle module buckets with
+// paths differing only by case. By default, the syncer uses PathCollisionPolicyError.
+func SyncerWithPathCollisionPolicy(policy PathCollisionPolicy) SyncerOption {
+	return func(s *syncer) error {
+		switch policy {
+		case PathCollisionPolicyError, PathCollisionPolicyWarn:
+			s.pathCollisionPolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("unknown path collision policy %d", policy)
+		}
+	}
+}
+
+// Clock reads the current time. All time reads and waits inside a Syncer go through its Clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned
+	// channel, like time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SyncerWithClock configures the clock a Syncer reads the current time from, and waits with. By
+// default, the syncer uses the wall clock.
+func SyncerWithClock(clock Clock) SyncerOption {
+	return func(s *syncer) error {
+		s.clock = clock
+		return nil
+	}
+}
+
+// SyncerWithRateLimit configures a Syncer to invoke the SyncFunc at most commitsPerSecond times per
+// second. By default, the SyncFunc is invoked as fast as commits are built.
+func SyncerWithRateLimit(commitsPerSecond float64) SyncerOption {
+	return func(s *syncer) error {
+		if !(commitsPerSecond > 0) || math.IsInf(commitsPerSecond, 1) {
+			return fmt.Errorf("invalid rate limit %v, must be a positive number", commitsPerSecond)
+		}
+		s.commitsPerSecond = commitsPerSecond
+		return nil
+	}
+}
+
+// SyncerWithTagReconcileOnly configures the syncer to only sync the tagged commits with a tag
+// missing in the remote module, or pointing to a different commit, as resolved with the
+// TagResolver, without walking the history of any branch.
+func SyncerWithTagReconcileOnly() SyncerOption {
+	return func(s *syncer) error {
+		s.tagReconcileOnly = true
+		return nil
+	}
+}
+
+// SyncerWithTagResolver configures a Syncer with a TagResolver, to reconcile tags with
+// SyncerWithTagReconcileOnly.
+func SyncerWithTagResolver(resolver TagResolver) SyncerOption {
+	return func(s *syncer) error {
+		s.tagResolver = resolver
+		return nil
+	}
+}
+
+// SyncerWithBuildTimeout configures a Syncer to give up on building a module in a commit after the
+// duration, and handle it as a build failure with ErrBuildTimeout in the error chain.
+func SyncerWithBuildTimeout(timeout time.Duration) SyncerOption {
+	return func(s *syncer) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid build timeout %v, must be positive", timeout)
+		}
+		s.buildTimeout = timeout
+		return nil
+	}
+}
+
+// LintConfig configures the lint checks run by a Syncer configured with SyncerWithLintOnSync.
+type LintConfig struct {
+	// Config is the lint config to check the modules with. If nil, each module is checked with the
+	// lint config in its module config file in the commit being synced.
+	Config *buflintconfig.Config
+	// ModuleReader reads the dependencies of the modules to compile them. If nil, no dependency
+	// can be read, and modules with dependencies fail to compile.
+	ModuleReader bufmodule.ModuleReader
+}
+
+// SyncerWithLintOnSync configures a Syncer to compile and lint each module after it is built, and
+// before it is synced. A module that fails to compile or fails lint is handled by
+// ErrorHandler.LintFailure.
+func SyncerWithLintOnSync(config LintConfig) SyncerOption {
+	return func(s *syncer) error {
+		s.lintConfig = &config
+		return nil
+	}
+}
+
+// SyncerWithIdentityResolver configures a Syncer to resolve the identity of the remote module each
+// module is synced to per branch, overriding the module RemoteIdentity.
+func SyncerWithIdentityResolver(resolver IdentityResolver) SyncerOption {
+	return func(s *syncer) error {
+		s.identityResolver = resolver
+		return nil
+	}
+}
+
+// IdentityResolver is invoked by Syncer to resolve the identity of the remote module a module is
+// synced to in a particular branch. It is invoked once per module and branch. If an error is
+// returned, sync will abort.
+type IdentityResolver func(
+	module Module,
+	branch string,
+) (bufmoduleref.ModuleIdentity, error)
+
+// SyncerWithCommitFilter configures a Syncer to only sync the commits the filter includes, invoked
+// at most once per commit and branch after the built-in filters. Excluded commits do not become
+// sync points.
+func SyncerWithCommitFilter(filter CommitFilter) SyncerOption {
+	return func(s *syncer) error {
+		s.commitFilter = filter
+		return nil
+	}
+}
+
+// CommitFilter is invoked by Syncer to know if a commit should be synced. It returns true if the
+// commit is included. If an error is returned, sync will abort.
+type CommitFilter func(commit git.Commit) (include bool, err error)
+
+// SyncerWithCommitLabelMapper configures a Syncer to label the synced commits with the label the
+// mapper returns, instead of the hex commit hash. The mapper must return the same label for a
+// commit across runs for resumption to work.
+func SyncerWithCommitLabelMapper(mapper CommitLabelMapper) SyncerOption {
+	return func(s *syncer) error {
+		s.commitLabelMapper = mapper
+		return nil
+	}
+}
+
+// CommitLabelMapper is invoked by Syncer to compute the label of a commit, such as a change ID
+// embedded in the commit message. It must return a non-empty label. If an error is returned, sync
+// will abort.
+type CommitLabelMapper func(commit git.Commit) (string, error)
+
+// SyncerWithBranchNameMapper configures a Syncer to sync the git branches to the BSR branches the
+// mapper returns, instead of the git branch names. The mapper must return the same, distinct, name
+// for a branch across runs for resumption to work.
+func SyncerWithBranchNameMapper(mapper BranchNameMapper) SyncerOption {
+	return func(s *syncer) error {
+		s.branchNameMapper = mapper
+		return nil
+	}
+}
+
+// BranchNameMapper is invoked by Syncer to compute the BSR branch label a git branch is synced to.
+// It must return a non-empty label.
+type BranchNameMapper func(gitBranch string) (bsrLabel string)
+
+// SyncerWithRequireSignedCommits configures a Syncer to verify the GPG signature of every commit
+// with a module against the keyring. Commits not signed by any of its keys are handled by the
+// ErrorHandler's UnsignedCommit.
+func SyncerWithRequireSignedCommits(keyring openpgp.KeyRing) SyncerOption {
+	return func(s *syncer) error {
+		if keyring == nil {
+			return errors.New("keyring is required to verify signed commits")
+		}
+		s.signedCommitsKeyring = keyring
+		return nil
+	}
+}
+
+// SyncerWithRequireSSHSignedCommits configures a Syncer to verify the SSH signature of every commit
+// against the signing keys, like SyncerWithRequireSignedCommits does for GPG signatures. Both can be
+// set to accept either kind of signature.
+func SyncerWithRequireSSHSignedCommits(signingKeys []ssh.PublicKey) SyncerOption {
+	return func(s *syncer) error {
+		if len(signingKeys) == 0 {
+			return errors.New("signing keys are required to verify signed commits")
+		}
+		s.sshSigningKeys = signingKeys
+		return nil
+	}
+}
+
+// SyncerWithVerifyRemoteContent configures a Syncer to compare the manifest digest of the commits
+// already synced where branches and tagged commits start syncing from with the remote digest
+// resolved by the resolver. Mismatches are handled by the ErrorHandler's RemoteContentMismatch.
+func SyncerWithVerifyRemoteContent(resolver RemoteContentDigestResolver) SyncerOption {
+	return func(s *syncer) error {
+		s.remoteContentDigestResolver = resolver
+		return nil
+	}
+}
+
+// SyncerWithSkipIdenticalRemote configures a Syncer to skip pushing the module commits whose
+// manifest digest matches the remote digest of the commit label and all its tags, as resolved by
+// the resolver, invoking the skipFunc instead, if not nil.
+func SyncerWithSkipIdenticalRemote(resolver RemoteLabelDigestResolver, skipFunc SkipFunc) SyncerOption {
+	return func(s *syncer) error {
+		if resolver == nil {
+			return errors.New("remote label digest resolver must not be nil")
+		}
+		s.remoteLabelDigestResolver = resolver
+		s.identicalRemoteSkipFunc = skipFunc
+		return nil
+	}
+}
+
+// SyncerWithRunID configures the ID of the sync run, attached as a run_id field to every log line
+// the Syncer emits. By default, the syncer generates a random UUID.
+func SyncerWithRunID(id string) SyncerOption {
+	return func(s *syncer) error {
+		if id == "" {
+			return errors.New("run ID must not be empty")
+		}
+		s.runID = id
+		return nil
+	}
+}
+
+// SyncerWithTracerProvider configures the TracerProvider of the spans the Syncer records for the
+// sync run, each branch, and each module build and SyncFunc invocation. By default, the syncer
+// records no spans.
+func SyncerWithTracerProvider(tracerProvider trace.TracerProvider) SyncerOption {
+	return func(s *syncer) error {
+		if tracerProvider == nil {
+			return errors.New("tracer provider must not be nil")
+		}
+		s.tracerProvider = tracerProvider
+		return nil
+	}
+}
+
+// SyncerWithMeterProvider configures the MeterProvider of the counters of the module commits synced,
+// skipped, and failed to build or push, by module identity and branch. By default, the syncer records
+// no metrics.
+func SyncerWithMeterProvider(meterProvider metric.MeterProvider) SyncerOption {
+	return func(s *syncer) error {
+		if meterProvider == nil {
+			return errors.New("meter provider must not be nil")
+		}
+		s.meterProvider = meterProvider
+		return nil
+	}
+}
+
 // SyncFunc is invoked by Syncer to process a sync point. If an error is returned,
 // sync will abort.
 type SyncFunc func(ctx context.Context, commit ModuleCommit) error
@@ -180,15 +1271,60 @@ type SyncPointResolver func(
 	branch string,
 ) (git.Hash, error)
 
+// RemoteContentDigestResolver is invoked by Syncer to resolve the manifest digest of the remote
+// module content synced for a git commit. If the git commit is not synced, this function returns
+// nil. If an error is returned, sync will abort.
+type RemoteContentDigestResolver func(
+	ctx context.Context,
+	module bufmoduleref.ModuleIdentity,
+	gitHash git.Hash,
+) (*manifest.Digest, error)
+
+// RemoteLabelDigestResolver is invoked by Syncer to resolve the manifest digest of the remote module
+// content at a label, such as a tag or a commit label. If the label does not exist, this function
+// returns nil. If an error is returned, sync will abort.
+type RemoteLabelDigestResolver func(
+	ctx context.Context,
+	module bufmoduleref.ModuleIdentity,
+	label string,
+) (*manifest.Digest, error)
+
+// SkipFunc is invoked by Syncer instead of SyncFunc for a module commit that is not pushed because
+// its content is identical to the remote content, with SyncerWithSkipIdenticalRemote.
+type SkipFunc func(ctx context.Context, commit ModuleCommit)
+
 // SyncedGitCommitChecker is invoked when syncing branches to know which commits hashes from a set
 // are already synced inthe BSR. It expects to receive the commit hashes that are synced already. If
 // an error is returned, sync will abort.
+//
+// With SyncerWithCommitLabelMapper, it receives and returns the commit labels instead of hashes.
 type SyncedGitCommitChecker func(
 	ctx context.Context,
 	module bufmoduleref.ModuleIdentity,
 	commitHashes map[string]struct{},
 ) (map[string]struct{}, error)
 
+// InitialSyncConfirmer is invoked by Syncer to confirm syncing the commits of a module without a sync
+// point in any branch, when the branch has more commits of the module than the threshold configured
+// with SyncerWithInitialSyncConfirmation. If it returns false, the branch is skipped. If an error is
+// returned, sync will abort before any commit is synced.
+type InitialSyncConfirmer func(
+	ctx context.Context,
+	module Module,
+	branch string,
+	commitCount int,
+) (bool, error)
+
+// TagResolver is invoked by Syncer to resolve the tags of a remote module when reconciling tags, or
+// checking for conflicting tags with TagConflictPolicyFailIfAnyExists, keyed by tag name, with the git commit hash each tag points to. It returns an empty map if the remote
+// module has no tags, or does not exist. If an error is returned, sync will abort.
+//
+// With SyncerWithCommitLabelMapper, it returns the commit labels instead of hashes.
+type TagResolver func(
+	ctx context.Context,
+	module bufmoduleref.ModuleIdentity,
+) (map[string]string, error)
+
 // ModuleDefaultBranchGetter is invoked before syncing, to make sure all modules that are about to
 // be synced have a BSR default branch that matches the local git repo. If the BSR remote module
 // does not exist, the implementation should return `ModuleDoesNotExistErr` error.
@@ -197,16 +1333,52 @@ type ModuleDefaultBranchGetter func(
 	module bufmoduleref.ModuleIdentity,
 ) (string, error)
 
+// TagDetail is a git tag of a module commit.
+type TagDetail struct {
+	// Name is the name of the tag.
+	Name string
+	// Tagger is the identity of the tagger of an annotated tag. It is nil for lightweight tags, which
+	// have no tagger.
+	Tagger git.Ident
+	// Message is the message of an annotated tag. It is empty for lightweight tags, which have no
+	// message.
+	Message string
+}
+
 // ModuleCommit is a module at a particular commit.
 type ModuleCommit interface {
-	// Identity is the identity of the module, accounting for any configured override.
+	// Identity is the identity of the module, accounting for any configured override
+	// or identity resolved for the branch.
 	Identity() bufmoduleref.ModuleIdentity
 	// Bucket is the bucket for the module.
 	Bucket() storage.ReadBucket
+	// HasModule reports whether the module is found in Commit. It is always true for the module
+	// commits passed to the SyncFunc, as the commits without the module are skipped.
+	HasModule() bool
 	// Commit is the commit that the module is sourced from.
 	Commit() git.Commit
-	// Branch is the git branch that this module is sourced from.
+	// Label is the label of Commit, as mapped by SyncerWithCommitLabelMapper. It is the hex commit
+	// hash by default.
+	Label() string
+	// Parents are the hashes of the git parents of Commit, in order. They are not necessarily
+	// synced, nor sourcing the module.
+	Parents() []git.Hash
+	// Branch is the git branch that this module is sourced from, as mapped by
+	// SyncerWithBranchNameMapper. It is empty for tagged commits synced with
+	// SyncerWithTagsOnly that are not reachable from any synced branch.
 	Branch() string
-	// Tags are the git tags associated with Commit.
+	// Tags are the git tags associated with Commit. It is empty, and never nil, if the commit has no
+	// tags, which does not affect syncing the module.
 	Tags() []string
+	// TagDetails are the details of Tags, in the same order, with the tagger and message of the
+	// annotated tags. It is empty, and never nil, if the commit has no tags.
+	TagDetails() []TagDetail
+	// Notes are the git notes attached to Commit, keyed by their notes ref, like
+	// `refs/notes/commits`, for the notes refs configured with SyncerWithGitNotes. It is empty if the
+	// commit has no notes in any of them.
+	Notes() map[string]string
+	// Annotations are the key/value metadata returned for Commit by the CommitAnnotator configured
+	// with SyncerWithCommitAnnotator. It is empty if there is no annotator, or it returned no
+	// annotations.
+	Annotations() map[string]string
 }
@@ -0,0 +1,105 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bufsynctest provides testing utilities for bufsync.
+package bufsynctest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a bufsync.Clock that only moves when told to, for deterministic tests of
+// time-based features. It is safe for concurrent use.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeClockWaiter
+	// waitersChanged is signaled every time a waiter is added.
+	waitersChanged *sync.Cond
+}
+
+// fakeClockWaiter is a pending call to After, fired once the clock reaches its deadline.
+type fakeClockWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// NewFakeClock returns a new FakeClock set at the passed time.
+func NewFakeClock(now time.Time) *FakeClock {
+	clock := &FakeClock{now: now}
+	clock.waitersChanged = sync.NewCond(&clock.lock)
+	return clock
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the current time of the clock once it is moved at least the
+// passed duration forward. Non-positive durations fire immediately.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	channel := make(chan time.Time, 1)
+	if d <= 0 {
+		channel <- c.now
+		return channel
+	}
+	c.waiters = append(c.waiters, fakeClockWaiter{deadline: c.now.Add(d), c: channel})
+	c.waitersChanged.Broadcast()
+	return channel
+}
+
+// BlockUntilWaiters blocks until at least the passed number of calls to After are waiting for the
+// clock to move.
+func (c *FakeClock) BlockUntilWaiters(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for len(c.waiters) < n {
+		c.waitersChanged.Wait()
+	}
+}
+
+// Advance moves the clock forward by the passed duration.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	c.fireWaiters()
+}
+
+// Set sets the clock at the passed time.
+func (c *FakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = now
+	c.fireWaiters()
+}
+
+// fireWaiters fires the waiters whose deadline is reached. It must be called with the lock held.
+func (c *FakeClock) fireWaiters() {
+	pendingWaiters := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.deadline.After(c.now) {
+			pendingWaiters = append(pendingWaiters, waiter)
+			continue
+		}
+		waiter.c <- c.now
+	}
+	c.waiters = pendingWaiters
+}
@@ -0,0 +1,586 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/buf/private/bufpkg/bufconfig"
+	"github.com/bufbuild/buf/private/bufpkg/buflock"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmodulebuild"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleconfig"
+	"github.com/bufbuild/buf/private/pkg/git"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagegit"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// transformModuleCommit returns the module commit for the built module bucket, after applying the
+// bucket transformers.
+func (s *syncer) transformModuleCommit(
+	ctx context.Context,
+	branch string,
+	commit git.Commit,
+	module Module,
+	moduleBucket storage.ReadBucket,
+) (ModuleCommit, error) {
+	identity, err := s.moduleIdentity(module, branch)
+	if err != nil {
+		return nil, err
+	}
+	label, err := s.commitLabel(commit)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.commitTagDetails(commit)
+	if err != nil {
+		return nil, err
+	}
+	notes := s.notesByCommitHash[commit.Hash().Hex()]
+	annotations, err := s.commitAnnotations(commit)
+	if err != nil {
+		return nil, err
+	}
+	remoteBranch := s.remoteBranch(branch)
+	if overlayFiles, ok := s.overlayFilesByModuleDir[module.Dir()]; ok {
+		moduleBucket, err = overlayBucket(moduleBucket, overlayFiles)
+		if err != nil {
+			return nil, fmt.Errorf("overlay module bucket: %w", err)
+		}
+	}
+	moduleCommit := newModuleCommit(identity, moduleBucket, commit, label, remoteBranch, tags, notes, annotations)
+	for _, bucketTransformer := range s.bucketTransformers {
+		moduleBucket, err = bucketTransformer(ctx, moduleCommit, moduleCommit.Bucket())
+		if err != nil {
+			return nil, fmt.Errorf("transform module bucket: %w", err)
+		}
+		if moduleBucket == nil {
+			return nil, errors.New("transform module bucket: transformer returned a nil bucket")
+		}
+		moduleCommit = newModuleCommit(identity, moduleBucket, commit, label, remoteBranch, tags, notes, annotations)
+	}
+	if s.lockRewriter != nil {
+		moduleBucket, err = s.rewriteLock(ctx, moduleCommit)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite lock file: %w", err)
+		}
+		moduleCommit = newModuleCommit(identity, moduleBucket, commit, label, remoteBranch, tags, notes, annotations)
+	}
+	return moduleCommit, nil
+}
+
+// overlayBucket returns the bucket with the files overlaid, replacing the files at the same paths.
+func overlayBucket(bucket storage.ReadBucket, files map[string][]byte) (storage.ReadBucket, error) {
+	filesBucket, err := storagemem.NewReadBucket(files)
+	if err != nil {
+		return nil, err
+	}
+	overlaidPathMatchers := make([]storage.Matcher, 0, len(files))
+	for path := range files {
+		overlaidPathMatchers = append(overlaidPathMatchers, storage.MatchPathEqual(path))
+	}
+	return storage.MultiReadBucket(
+		storage.MapReadBucket(bucket, storage.MatchNot(storage.MatchOr(overlaidPathMatchers...))),
+		filesBucket,
+	), nil
+}
+
+// rewriteLock returns the module commit bucket with its lock file rewritten by the lock rewriter.
+// The bucket is returned as is if it has no lock file.
+func (s *syncer) rewriteLock(ctx context.Context, moduleCommit ModuleCommit) (storage.ReadBucket, error) {
+	moduleBucket := moduleCommit.Bucket()
+	hasLock, err := storage.Exists(ctx, moduleBucket, buflock.ExternalConfigFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if !hasLock {
+		return moduleBucket, nil
+	}
+	lock, err := buflock.ReadConfig(ctx, moduleBucket)
+	if err != nil {
+		return nil, err
+	}
+	lock, err = s.lockRewriter(ctx, moduleCommit, lock)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		return nil, errors.New("rewriter returned a nil lock file")
+	}
+	lockBucket := storagemem.NewReadWriteBucket()
+	if err := buflock.WriteConfig(ctx, lockBucket, lock); err != nil {
+		return nil, err
+	}
+	return storage.MultiReadBucket(
+		storage.MapReadBucket(moduleBucket, storage.MatchNot(storage.MatchPathEqual(buflock.ExternalConfigFilePath))),
+		lockBucket,
+	), nil
+}
+
+// commitAnnotations returns the annotations of the commit from the commit annotator, if any. The
+// annotator is invoked once per commit.
+func (s *syncer) commitAnnotations(commit git.Commit) (map[string]string, error) {
+	if s.commitAnnotator == nil {
+		return nil, nil
+	}
+	if annotations, ok := s.annotationsByCommitHash[commit.Hash().Hex()]; ok {
+		return annotations, nil
+	}
+	annotations, err := s.commitAnnotator(commit)
+	if err != nil {
+		return nil, fmt.Errorf("annotate commit %q: %w", commit.Hash().Hex(), err)
+	}
+	if s.annotationsByCommitHash == nil {
+		s.annotationsByCommitHash = make(map[string]map[string]string)
+	}
+	s.annotationsByCommitHash[commit.Hash().Hex()] = annotations
+	return annotations, nil
+}
+
+// buildModuleBucket looks for the module in the commit, validates it, and builds it. It returns a nil
+// bucket if the module should be skipped in this commit, either because it is not found, or because
+// it is invalid and the error handler chose to continue. If the error handler aborts, it returns a
+// *BuildError, or a *PolicyError if the module is deleted, its commit is unsigned, or it fails lint.
+//
+// When debug logging is enabled, it logs how the module was resolved in the commit. Skipped commits
+// are recorded in the metrics, as failed if the module is invalid.
+func (s *syncer) buildModuleBucket(
+	ctx context.Context,
+	branch string,
+	commit git.Commit,
+	module Module,
+) (moduleBucket storage.ReadBucket, retErr error) {
+	logger := s.logger.With(
+		zap.Stringer("commit", commit.Hash()),
+		zap.Stringer("module", module),
+	)
+	var resolution moduleResolution
+	ctx, span := s.tracer.Start(
+		ctx,
+		"build_module",
+		moduleCommitAttributes(module.RemoteIdentity().IdentityString(), branch, commit),
+	)
+	defer func() {
+		if resolution.skipReason != "" {
+			span.SetAttributes(attribute.String("skip_reason", resolution.skipReason))
+		}
+		endSpan(span, retErr)
+		if retErr == nil && moduleBucket == nil {
+			if resolution.invalid {
+				s.metrics.record(ctx, s.metrics.failedCommits, module, branch)
+			} else {
+				s.metrics.record(ctx, s.metrics.skippedCommits, module, branch)
+			}
+		}
+	}()
+	if logger.Core().Enabled(zap.DebugLevel) {
+		defer func() {
+			if retErr == nil {
+				logger.Debug("module resolution", resolution.fields()...)
+			}
+		}()
+	}
+	sourceBucket, err := s.moduleSourceBucket(commit, module)
+	if err != nil {
+		return nil, err
+	}
+	foundModule, err := bufconfig.ExistingConfigFilePath(ctx, sourceBucket)
+	if err != nil {
+		return nil, err
+	}
+	if foundModule == "" {
+		if logger.Core().Enabled(zap.DebugLevel) {
+			isEmpty, err := storage.IsEmpty(ctx, sourceBucket, "")
+			if err != nil && !storage.IsNotExist(err) {
+				return nil, err
+			}
+			resolution.dirFound = err == nil && !isEmpty
+		}
+		deleted, err := s.isModuleDeleted(ctx, commit, module)
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			resolution.skipReason = "module not found"
+			logger.Debug("module not found, skipping commit")
+			return nil, nil
+		}
+		resolution.skipReason = "module deleted"
+		if err := s.errorHandler.ModuleDeleted(module, commit); err != nil {
+			return nil, &PolicyError{Module: module, Branch: branch, Commit: commit.Hash(), Err: err}
+		}
+		if s.deletedModulePolicy == DeletedModulePolicyStop && !s.headFirstBackfill {
+			logger.Debug("module deleted, skipping rest of branch")
+			s.deletedModules[module] = struct{}{}
+		} else {
+			logger.Debug("module deleted, skipping commit")
+		}
+		return nil, nil
+	}
+	resolution.dirFound = true
+	resolution.configFilePath = foundModule
+	sourceConfig, err := bufconfig.GetConfigForBucket(ctx, sourceBucket)
+	if err != nil {
+		resolution.skipReason = "invalid module config"
+		resolution.invalid = true
+		if err := s.errorHandler.InvalidModuleConfig(module, commit, err); err != nil {
+			return nil, &BuildError{Module: module, Branch: branch, Commit: commit.Hash(), Err: err}
+		}
+		return nil, nil
+	}
+	if sourceConfig.ModuleIdentity == nil {
+		resolution.skipReason = "unnamed module"
+		logger.Debug("unnamed module, skipping commit")
+		return nil, nil
+	}
+	resolution.configIdentity = sourceConfig.ModuleIdentity.IdentityString()
+	remoteIdentity, err := s.moduleIdentity(module, branch)
+	if err != nil {
+		return nil, err
+	}
+	resolution.remoteIdentity = remoteIdentity.IdentityString()
+	if s.signedCommitsKeyring != nil || len(s.sshSigningKeys) > 0 {
+		if err := verifyCommitSignature(s.signedCommitsKeyring, s.sshSigningKeys, commit); err != nil {
+			resolution.skipReason = "unsigned commit"
+			logger.Debug("commit signature not verified", zap.Error(err))
+			if err := s.errorHandler.UnsignedCommit(module, commit); err != nil {
+				return nil, &PolicyError{Module: module, Branch: branch, Commit: commit.Hash(), Err: err}
+			}
+			return nil, nil
+		}
+	}
+	builtModule, err := s.buildCachedModule(ctx, commit, module, sourceBucket, sourceConfig.Build)
+	if err != nil {
+		resolution.skipReason = "build failure"
+		resolution.invalid = true
+		if err := s.errorHandler.BuildFailure(module, commit, err); err != nil {
+			return nil, &BuildError{Module: module, Branch: branch, Commit: commit.Hash(), Err: err}
+		}
+		return nil, nil
+	}
+	pathCollisions, err := findPathCollisions(ctx, builtModule.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if len(pathCollisions) > 0 {
+		if s.pathCollisionPolicy == PathCollisionPolicyWarn {
+			for _, collidingPaths := range pathCollisions {
+				logger.Warn(
+					"module paths differ only by case, and collide on case-insensitive filesystems",
+					zap.Strings("paths", collidingPaths),
+				)
+			}
+		} else {
+			resolution.skipReason = "path collision"
+			resolution.invalid = true
+			if err := s.errorHandler.InvalidModuleConfig(module, commit, newPathCollisionError(pathCollisions)); err != nil {
+				return nil, &BuildError{Module: module, Branch: branch, Commit: commit.Hash(), Err: err}
+			}
+			return nil, nil
+		}
+	}
+	if s.lintConfig != nil {
+		lintErr, err := s.lintModule(ctx, builtModule.Module, sourceConfig.Lint)
+		if err != nil {
+			return nil, err
+		}
+		if lintErr != nil {
+			resolution.skipReason = "lint failure"
+			resolution.invalid = true
+			if err := s.errorHandler.LintFailure(module, commit, lintErr); err != nil {
+				return nil, &PolicyError{Module: module, Branch: branch, Commit: commit.Hash(), Err: err}
+			}
+			return nil, nil
+		}
+	}
+	if s.deterministicManifest {
+		if err := s.checkDeterministicManifest(ctx, commit, module, sourceConfig.Build, builtModule.Bucket); err != nil {
+			return nil, err
+		}
+	}
+	if logger.Core().Enabled(zap.DebugLevel) {
+		paths, err := storage.AllPaths(ctx, builtModule.Bucket, "")
+		if err != nil {
+			return nil, err
+		}
+		resolution.fileCount = len(paths)
+	}
+	return builtModule.Bucket, nil
+}
+
+// checkDeterministicManifest builds the module in the commit again, from a new read of the commit
+// tree, and returns an error with ErrNondeterministicManifest in its chain if its manifest digest is
+// not the same as the one of the built module bucket.
+func (s *syncer) checkDeterministicManifest(
+	ctx context.Context,
+	commit git.Commit,
+	module Module,
+	buildConfig *bufmoduleconfig.Config,
+	moduleBucket storage.ReadBucket,
+) error {
+	digest, err := bucketDigest(ctx, moduleBucket)
+	if err != nil {
+		return err
+	}
+	sourceBucket, err := s.moduleSourceBucket(commit, module)
+	if err != nil {
+		return err
+	}
+	rebuiltModule, err := s.buildModule(ctx, sourceBucket, buildConfig)
+	if err != nil {
+		return fmt.Errorf("rebuild module: %w", err)
+	}
+	rebuiltDigest, err := bucketDigest(ctx, rebuiltModule.Bucket)
+	if err != nil {
+		return err
+	}
+	if !digest.Equal(*rebuiltDigest) {
+		return fmt.Errorf(
+			"%w: module %q in commit %q built with manifest digests %q and %q",
+			ErrNondeterministicManifest,
+			module.String(),
+			commit.Hash().Hex(),
+			digest.String(),
+			rebuiltDigest.String(),
+		)
+	}
+	return nil
+}
+
+// buildCachedModule builds the module in the source bucket of the commit, reusing the module built
+// for the same module dir and module dir tree if the syncer is configured with SyncerWithTreeCacheSize.
+func (s *syncer) buildCachedModule(
+	ctx context.Context,
+	commit git.Commit,
+	module Module,
+	sourceBucket storage.ReadBucket,
+	buildConfig *bufmoduleconfig.Config,
+) (*bufmodulebuild.BuiltModule, error) {
+	if s.treeCache == nil {
+		return s.buildPipelinedModule(ctx, commit, module, sourceBucket, buildConfig)
+	}
+	moduleTreeHash, err := s.moduleTreeHash(commit, module)
+	if err != nil {
+		return nil, err
+	}
+	if moduleTreeHash == nil {
+		return s.buildPipelinedModule(ctx, commit, module, sourceBucket, buildConfig)
+	}
+	key := treeCacheKey{moduleDir: module.Dir(), treeHash: moduleTreeHash.Hex()}
+	if builtModule, ok := s.treeCache.get(key); ok {
+		return builtModule, nil
+	}
+	builtModule, err := s.buildPipelinedModule(ctx, commit, module, sourceBucket, buildConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.treeCache.add(key, builtModule)
+	return builtModule, nil
+}
+
+// buildPipelinedModule returns the module in the commit built ahead by the build pipeline, if any, or
+// builds it from the source bucket.
+func (s *syncer) buildPipelinedModule(
+	ctx context.Context,
+	commit git.Commit,
+	module Module,
+	sourceBucket storage.ReadBucket,
+	buildConfig *bufmoduleconfig.Config,
+) (*bufmodulebuild.BuiltModule, error) {
+	if s.buildPipeline != nil {
+		key := buildPipelineKey{commitHash: commit.Hash().Hex(), moduleDir: module.Dir()}
+		if result, ok := s.buildPipeline.take(key); ok {
+			return result.builtModule, result.err
+		}
+	}
+	return s.buildModule(ctx, sourceBucket, buildConfig)
+}
+
+// buildModule builds the module in the source bucket. If a build timeout is configured, it returns an
+// error with ErrBuildTimeout in its chain when the build does not finish in time, without waiting for
+// the build to return. The source bucket is closed for the timed out build, so it fails at its next
+// read instead of reading the git object store after the sync moves on.
+func (s *syncer) buildModule(
+	ctx context.Context,
+	sourceBucket storage.ReadBucket,
+	buildConfig *bufmoduleconfig.Config,
+) (*bufmodulebuild.BuiltModule, error) {
+	if s.buildTimeout == 0 {
+		return s.moduleBucketBuilder.BuildForBucket(ctx, sourceBucket, buildConfig)
+	}
+	buildCtx, cancel := context.WithTimeout(ctx, s.buildTimeout)
+	defer cancel()
+	type buildResult struct {
+		builtModule *bufmodulebuild.BuiltModule
+		err         error
+	}
+	scopedBucket := newScopedReadBucket(sourceBucket)
+	// buffered, so a timed out build does not block on sending its result once it returns
+	buildResults := make(chan buildResult, 1)
+	go func() {
+		builtModule, err := s.moduleBucketBuilder.BuildForBucket(buildCtx, scopedBucket, buildConfig)
+		buildResults <- buildResult{builtModule: builtModule, err: err}
+	}()
+	select {
+	case result := <-buildResults:
+		if result.err != nil && ctx.Err() == nil && errors.Is(buildCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("build timed out after %v: %w", s.buildTimeout, ErrBuildTimeout)
+		}
+		return result.builtModule, result.err
+	case <-buildCtx.Done():
+		scopedBucket.close()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("build timed out after %v: %w", s.buildTimeout, ErrBuildTimeout)
+	}
+}
+
+// findPathCollisions returns the groups of paths in the bucket differing only by case, sorted.
+func findPathCollisions(ctx context.Context, bucket storage.ReadBucket) ([][]string, error) {
+	paths, err := storage.AllPaths(ctx, bucket, "")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	pathsByFoldedPath := make(map[string][]string, len(paths))
+	var foldedPaths []string
+	for _, path := range paths {
+		foldedPath := strings.ToLower(path)
+		if _, ok := pathsByFoldedPath[foldedPath]; !ok {
+			foldedPaths = append(foldedPaths, foldedPath)
+		}
+		pathsByFoldedPath[foldedPath] = append(pathsByFoldedPath[foldedPath], path)
+	}
+	var pathCollisions [][]string
+	for _, foldedPath := range foldedPaths {
+		if collidingPaths := pathsByFoldedPath[foldedPath]; len(collidingPaths) > 1 {
+			pathCollisions = append(pathCollisions, collidingPaths)
+		}
+	}
+	return pathCollisions, nil
+}
+
+// newPathCollisionError returns an error naming the colliding paths, with ErrPathCollision in its
+// chain.
+func newPathCollisionError(pathCollisions [][]string) error {
+	formattedCollisions := make([]string, 0, len(pathCollisions))
+	for _, collidingPaths := range pathCollisions {
+		formattedCollisions = append(formattedCollisions, strings.Join(collidingPaths, ", "))
+	}
+	return fmt.Errorf(
+		"%w, and collide on case-insensitive filesystems: %s",
+		ErrPathCollision,
+		strings.Join(formattedCollisions, "; "),
+	)
+}
+
+// moduleSourceBucket returns the bucket for the module dir in the commit tree.
+func (s *syncer) moduleSourceBucket(commit git.Commit, module Module) (storage.ReadBucket, error) {
+	readBucketOptions := []storagegit.ReadBucketOption{storagegit.ReadBucketWithSymlinksIfSupported()}
+	if s.lazyBuckets {
+		readBucketOptions = append(readBucketOptions, storagegit.ReadBucketWithStreamingBlobs())
+	}
+	if s.submodules {
+		// the bucket is walked more than once, warn only once per missing submodule
+		warnedSubmodulePaths := make(map[string]struct{})
+		readBucketOptions = append(
+			readBucketOptions,
+			storagegit.ReadBucketWithSubmodules(func(path string, commitHash git.Hash) {
+				if _, warned := warnedSubmodulePaths[path]; warned {
+					return
+				}
+				warnedSubmodulePaths[path] = struct{}{}
+				s.logger.Warn(
+					"submodule commit not found in the repository, skipping submodule",
+					zap.Stringer("commit", commit.Hash()),
+					zap.String("module", module.String()),
+					zap.String("submodulePath", path),
+					zap.Stringer("submoduleCommit", commitHash),
+				)
+			}),
+		)
+	}
+	sourceBucket, err := s.storageGitProvider.NewReadBucket(commit.Tree(), readBucketOptions...)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.pathExcludePatterns) == 0 {
+		return storage.MapReadBucket(sourceBucket, storage.MapOnPrefix(module.Dir())), nil
+	}
+	excludeMatchers := make([]storage.Matcher, 0, len(s.pathExcludePatterns))
+	for _, pattern := range s.pathExcludePatterns {
+		excludeMatchers = append(excludeMatchers, storage.MatchPathGlobOrContained(pattern))
+	}
+	return storage.MapReadBucket(
+		sourceBucket,
+		storage.MatchNot(storage.MatchOr(excludeMatchers...)),
+		storage.MapOnPrefix(module.Dir()),
+	), nil
+}
+
+// isModuleDeleted returns true if the module, which is not found in the commit, is found in the
+// commit's first parent.
+func (s *syncer) isModuleDeleted(ctx context.Context, commit git.Commit, module Module) (bool, error) {
+	if len(commit.Parents()) == 0 {
+		return false, nil
+	}
+	parentCommit, err := s.repo.Objects().Commit(commit.Parents()[0])
+	if err != nil {
+		return false, fmt.Errorf("read commit %s: %w", commit.Parents()[0], err)
+	}
+	parentSourceBucket, err := s.moduleSourceBucket(parentCommit, module)
+	if err != nil {
+		return false, err
+	}
+	foundModule, err := bufconfig.ExistingConfigFilePath(ctx, parentSourceBucket)
+	if err != nil {
+		return false, err
+	}
+	return foundModule != "", nil
+}
+
+// moduleResolution describes how a module was resolved in a commit, for debugging purposes.
+type moduleResolution struct {
+	dirFound       bool
+	configFilePath string
+	configIdentity string
+	remoteIdentity string
+	fileCount      int
+	// skipReason is empty if the module is synced in the commit.
+	skipReason string
+	// invalid is true if the module is skipped because its config is invalid or it fails to build.
+	invalid bool
+}
+
+func (r moduleResolution) fields() []zap.Field {
+	fields := []zap.Field{
+		zap.Bool("dir_found", r.dirFound),
+		zap.String("config_file", r.configFilePath),
+		zap.String("config_identity", r.configIdentity),
+		zap.String("remote_identity", r.remoteIdentity),
+		zap.Int("file_count", r.fileCount),
+	}
+	if r.skipReason != "" {
+		fields = append(fields, zap.String("skip_reason", r.skipReason))
+	}
+	return fields
+}
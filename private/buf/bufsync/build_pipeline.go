@@ -0,0 +1,158 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+
+	"github.com/bufbuild/buf/private/bufpkg/bufconfig"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmodulebuild"
+	"github.com/bufbuild/buf/private/pkg/git"
+)
+
+// buildPipelineKey identifies the build of a module in a commit.
+type buildPipelineKey struct {
+	commitHash string
+	moduleDir  string
+}
+
+// buildPipelineResult is a module built ahead by the build pipeline.
+type buildPipelineResult struct {
+	key         buildPipelineKey
+	builtModule *bufmodulebuild.BuiltModule
+	err         error
+}
+
+// buildPipeline builds the modules of the commits to sync in a goroutine, in order, ahead of the
+// module commit being synced. The syncer takes the builds in the same order, discarding the ones of
+// the module commits it does not build, such as unchanged or filtered out ones.
+type buildPipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// results are the modules built ahead, in order. It is closed once the pipeline stops.
+	results chan buildPipelineResult
+	// done is closed once the pipeline goroutine returns.
+	done chan struct{}
+	// positions are the positions of the builds in the pipeline, by key.
+	positions map[buildPipelineKey]int
+	// pending is the result received from results, but not taken yet.
+	pending *buildPipelineResult
+}
+
+// startBuildPipeline starts building the modules to sync in the commits, up to the build pipeline
+// depth ahead of the module commit being synced. The pipeline must be closed once the commits are
+// synced.
+func (s *syncer) startBuildPipeline(ctx context.Context, commitsToSync []syncableCommit) *buildPipeline {
+	ctx, cancel := context.WithCancel(ctx)
+	pipeline := &buildPipeline{
+		ctx:    ctx,
+		cancel: cancel,
+		// a build finished while the channel is full is also ahead of the module commit being synced
+		results:   make(chan buildPipelineResult, s.buildPipelineDepth-1),
+		positions: make(map[buildPipelineKey]int),
+		done:      make(chan struct{}),
+	}
+	type build struct {
+		commit git.Commit
+		module Module
+	}
+	var builds []build
+	for _, commitToSync := range commitsToSync {
+		for _, module := range s.modulesToSync {
+			if _, shouldSyncModule := commitToSync.modules[module]; !shouldSyncModule {
+				continue
+			}
+			key := buildPipelineKey{commitHash: commitToSync.commit.Hash().Hex(), moduleDir: module.Dir()}
+			pipeline.positions[key] = len(builds)
+			builds = append(builds, build{commit: commitToSync.commit, module: module})
+		}
+	}
+	go func() {
+		defer close(pipeline.done)
+		defer close(pipeline.results)
+		for _, build := range builds {
+			result, ok := s.buildAhead(ctx, build.commit, build.module)
+			if !ok {
+				continue
+			}
+			select {
+			case pipeline.results <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return pipeline
+}
+
+// buildAhead builds the module in the commit in the build pipeline. It returns false if there is no
+// module to build, leaving the module resolution to the syncer. It must not modify the syncer state,
+// as it runs concurrently with the sync.
+func (s *syncer) buildAhead(ctx context.Context, commit git.Commit, module Module) (buildPipelineResult, bool) {
+	sourceBucket, err := s.moduleSourceBucket(commit, module)
+	if err != nil {
+		return buildPipelineResult{}, false
+	}
+	sourceConfig, err := bufconfig.GetConfigForBucket(ctx, sourceBucket)
+	if err != nil || sourceConfig.ModuleIdentity == nil {
+		return buildPipelineResult{}, false
+	}
+	builtModule, err := s.buildModule(ctx, sourceBucket, sourceConfig.Build)
+	return buildPipelineResult{
+		key:         buildPipelineKey{commitHash: commit.Hash().Hex(), moduleDir: module.Dir()},
+		builtModule: builtModule,
+		err:         err,
+	}, true
+}
+
+// take returns the module built ahead for the key, waiting for it to be built. It returns false if
+// the module is not built by the pipeline, or its build was interrupted, so it must be built by the
+// syncer.
+func (p *buildPipeline) take(key buildPipelineKey) (buildPipelineResult, bool) {
+	keyPosition, ok := p.positions[key]
+	if !ok {
+		return buildPipelineResult{}, false
+	}
+	for {
+		if p.pending == nil {
+			result, ok := <-p.results
+			if !ok {
+				return buildPipelineResult{}, false
+			}
+			p.pending = &result
+		}
+		position := p.positions[p.pending.key]
+		if position > keyPosition {
+			// the pipeline found no module to build for the key
+			return buildPipelineResult{}, false
+		}
+		result := *p.pending
+		p.pending = nil
+		if position < keyPosition {
+			// the syncer did not build this module commit
+			continue
+		}
+		if result.err != nil && p.ctx.Err() != nil {
+			return buildPipelineResult{}, false
+		}
+		return result, true
+	}
+}
+
+// close stops the pipeline, and waits for its build in progress, if any, to return.
+func (p *buildPipeline) close() {
+	p.cancel()
+	<-p.done
+}
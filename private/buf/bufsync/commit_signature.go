@@ -0,0 +1,150 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bufbuild/buf/private/pkg/git"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshSignatureArmorStart = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureArmorEnd   = "-----END SSH SIGNATURE-----"
+	// sshSignatureMagic is the preamble of SSH signatures, see
+	// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig.
+	sshSignatureMagic = "SSHSIG"
+	// sshSignatureNamespace is the namespace git signs commits in with SSH keys.
+	sshSignatureNamespace = "git"
+)
+
+// verifyCommitSignature verifies the commit signature, against the GPG keyring if it is a GPG
+// signature, or against the SSH signing keys if it is an SSH signature. It returns an error if the
+// commit is not signed, or not signed by any of the keys.
+func verifyCommitSignature(keyring openpgp.KeyRing, sshSigningKeys []ssh.PublicKey, commit git.Commit) error {
+	signature := commit.Signature()
+	if signature == "" {
+		return errors.New("commit is not signed")
+	}
+	if strings.HasPrefix(signature, sshSignatureArmorStart) {
+		return verifySSHSignature(sshSigningKeys, commit.SignedPayload(), signature)
+	}
+	if keyring == nil {
+		return errors.New("commit has a GPG signature, but no GPG keyring is configured")
+	}
+	if _, err := openpgp.CheckArmoredDetachedSignature(
+		keyring,
+		bytes.NewReader(commit.SignedPayload()),
+		strings.NewReader(signature),
+		nil,
+	); err != nil {
+		return fmt.Errorf("check commit signature: %w", err)
+	}
+	return nil
+}
+
+// verifySSHSignature verifies the armored SSH signature of the payload, made in the git namespace by
+// any of the signing keys.
+func verifySSHSignature(signingKeys []ssh.PublicKey, payload []byte, armoredSignature string) error {
+	if len(signingKeys) == 0 {
+		return errors.New("commit has an SSH signature, but no SSH signing keys are configured")
+	}
+	encodedSignature := strings.TrimSpace(armoredSignature)
+	encodedSignature = strings.TrimPrefix(encodedSignature, sshSignatureArmorStart)
+	encodedSignature = strings.TrimSuffix(encodedSignature, sshSignatureArmorEnd)
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encodedSignature), ""))
+	if err != nil {
+		return fmt.Errorf("decode SSH signature: %w", err)
+	}
+	if !bytes.HasPrefix(data, []byte(sshSignatureMagic)) {
+		return errors.New("invalid SSH signature preamble")
+	}
+	var sshSignature struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := ssh.Unmarshal(data[len(sshSignatureMagic):], &sshSignature); err != nil {
+		return fmt.Errorf("parse SSH signature: %w", err)
+	}
+	if sshSignature.Version != 1 {
+		return fmt.Errorf("unsupported SSH signature version %d", sshSignature.Version)
+	}
+	if sshSignature.Namespace != sshSignatureNamespace {
+		return fmt.Errorf("SSH signature is in namespace %q, expected %q", sshSignature.Namespace, sshSignatureNamespace)
+	}
+	publicKey, err := ssh.ParsePublicKey(sshSignature.PublicKey)
+	if err != nil {
+		return fmt.Errorf("parse SSH signature public key: %w", err)
+	}
+	if !containsSSHKey(signingKeys, publicKey) {
+		return fmt.Errorf("commit is signed by SSH key %s, which is not a signing key", ssh.FingerprintSHA256(publicKey))
+	}
+	var payloadHash hash.Hash
+	switch sshSignature.HashAlgorithm {
+	case "sha256":
+		payloadHash = sha256.New()
+	case "sha512":
+		payloadHash = sha512.New()
+	default:
+		return fmt.Errorf("unsupported SSH signature hash algorithm %q", sshSignature.HashAlgorithm)
+	}
+	_, _ = payloadHash.Write(payload)
+	signedData := append(
+		[]byte(sshSignatureMagic),
+		ssh.Marshal(struct {
+			Namespace     string
+			Reserved      string
+			HashAlgorithm string
+			Hash          []byte
+		}{
+			Namespace:     sshSignature.Namespace,
+			Reserved:      sshSignature.Reserved,
+			HashAlgorithm: sshSignature.HashAlgorithm,
+			Hash:          payloadHash.Sum(nil),
+		})...,
+	)
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sshSignature.Signature, &signature); err != nil {
+		return fmt.Errorf("parse SSH signature blob: %w", err)
+	}
+	if err := publicKey.Verify(signedData, &signature); err != nil {
+		return fmt.Errorf("check commit signature: %w", err)
+	}
+	return nil
+}
+
+// containsSSHKey returns true if the key is one of the keys.
+func containsSSHKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	keyBytes := key.Marshal()
+	for _, candidate := range keys {
+		if bytes.Equal(candidate.Marshal(), keyBytes) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bufbuild/buf/private/bufpkg/bufanalysis"
+	"github.com/bufbuild/buf/private/bufpkg/bufcheck/buflint"
+	"github.com/bufbuild/buf/private/bufpkg/bufcheck/buflint/buflintconfig"
+	"github.com/bufbuild/buf/private/bufpkg/bufimage"
+	"github.com/bufbuild/buf/private/bufpkg/bufimage/bufimagebuild"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule"
+)
+
+// lintModule compiles the module and runs the lint checks against its files, excluding its
+// dependencies. The configured lint config is used if set, otherwise the module's own lint config.
+// It returns a *LintError if the module fails to compile or fails lint, and an error if the checks
+// could not run.
+func (s *syncer) lintModule(
+	ctx context.Context,
+	module bufmodule.Module,
+	moduleLintConfig *buflintconfig.Config,
+) (*LintError, error) {
+	lintConfig := moduleLintConfig
+	if s.lintConfig.Config != nil {
+		lintConfig = s.lintConfig.Config
+	}
+	moduleReader := s.lintConfig.ModuleReader
+	if moduleReader == nil {
+		moduleReader = bufmodule.NewNopModuleReader()
+	}
+	image, fileAnnotations, err := bufimagebuild.NewBuilder(s.logger, moduleReader).Build(ctx, module)
+	if err != nil {
+		return nil, fmt.Errorf("compile module: %w", err)
+	}
+	if len(fileAnnotations) == 0 {
+		fileAnnotations, err = buflint.NewHandler(s.logger).Check(ctx, lintConfig, bufimage.ImageWithoutImports(image))
+		if err != nil {
+			return nil, fmt.Errorf("lint module: %w", err)
+		}
+	}
+	if len(fileAnnotations) == 0 {
+		return nil, nil
+	}
+	return &LintError{FileAnnotations: bufanalysis.DeduplicateAndSortFileAnnotations(fileAnnotations)}, nil
+}
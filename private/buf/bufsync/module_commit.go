@@ -15,32 +15,58 @@
 package bufsync
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
+
 	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
 	"github.com/bufbuild/buf/private/pkg/git"
 	"github.com/bufbuild/buf/private/pkg/storage"
 )
 
+// errBucketOutOfScope is returned by the module commit buckets used after the SyncFunc returned, with
+// SyncerWithLazyBuckets.
+var errBucketOutOfScope = errors.New("module commit bucket used after the SyncFunc returned")
+
 type moduleCommit struct {
-	identity bufmoduleref.ModuleIdentity
-	bucket   storage.ReadBucket
-	commit   git.Commit
-	branch   string
-	tags     []string
+	identity    bufmoduleref.ModuleIdentity
+	bucket      storage.ReadBucket
+	commit      git.Commit
+	label       string
+	branch      string
+	tags        []string
+	tagDetails  []TagDetail
+	notes       map[string]string
+	annotations map[string]string
 }
 
 func newModuleCommit(
 	identity bufmoduleref.ModuleIdentity,
 	bucket storage.ReadBucket,
 	commit git.Commit,
+	label string,
 	branch string,
-	tags []string,
+	tagDetails []TagDetail,
+	notes map[string]string,
+	annotations map[string]string,
 ) ModuleCommit {
+	if tagDetails == nil {
+		tagDetails = []TagDetail{}
+	}
+	tags := make([]string, 0, len(tagDetails))
+	for _, tagDetail := range tagDetails {
+		tags = append(tags, tagDetail.Name)
+	}
 	return &moduleCommit{
-		identity: identity,
-		bucket:   bucket,
-		commit:   commit,
-		branch:   branch,
-		tags:     tags,
+		identity:    identity,
+		bucket:      bucket,
+		commit:      commit,
+		label:       label,
+		branch:      branch,
+		tags:        tags,
+		tagDetails:  tagDetails,
+		notes:       notes,
+		annotations: annotations,
 	}
 }
 
@@ -52,10 +78,22 @@ func (m *moduleCommit) Bucket() storage.ReadBucket {
 	return m.bucket
 }
 
+func (m *moduleCommit) HasModule() bool {
+	return true
+}
+
 func (m *moduleCommit) Commit() git.Commit {
 	return m.commit
 }
 
+func (m *moduleCommit) Label() string {
+	return m.label
+}
+
+func (m *moduleCommit) Parents() []git.Hash {
+	return m.commit.Parents()
+}
+
 func (m *moduleCommit) Branch() string {
 	return m.branch
 }
@@ -63,3 +101,69 @@ func (m *moduleCommit) Branch() string {
 func (m *moduleCommit) Tags() []string {
 	return m.tags
 }
+
+func (m *moduleCommit) TagDetails() []TagDetail {
+	return m.tagDetails
+}
+
+func (m *moduleCommit) Notes() map[string]string {
+	return m.notes
+}
+
+func (m *moduleCommit) Annotations() map[string]string {
+	return m.annotations
+}
+
+// scopedBucketModuleCommit is a module commit with a bucket valid only while the SyncFunc runs.
+type scopedBucketModuleCommit struct {
+	ModuleCommit
+
+	bucket *scopedReadBucket
+}
+
+func (m *scopedBucketModuleCommit) Bucket() storage.ReadBucket {
+	return m.bucket
+}
+
+// scopedReadBucket is a read bucket that fails every call once closed. It can be closed while
+// another goroutine reads it.
+type scopedReadBucket struct {
+	delegate storage.ReadBucket
+	closed   atomic.Bool
+}
+
+func newScopedReadBucket(delegate storage.ReadBucket) *scopedReadBucket {
+	return &scopedReadBucket{
+		delegate: delegate,
+	}
+}
+
+func (b *scopedReadBucket) Get(ctx context.Context, path string) (storage.ReadObjectCloser, error) {
+	if b.closed.Load() {
+		return nil, errBucketOutOfScope
+	}
+	return b.delegate.Get(ctx, path)
+}
+
+func (b *scopedReadBucket) Stat(ctx context.Context, path string) (storage.ObjectInfo, error) {
+	if b.closed.Load() {
+		return nil, errBucketOutOfScope
+	}
+	return b.delegate.Stat(ctx, path)
+}
+
+func (b *scopedReadBucket) Walk(ctx context.Context, prefix string, f func(storage.ObjectInfo) error) error {
+	if b.closed.Load() {
+		return errBucketOutOfScope
+	}
+	return b.delegate.Walk(ctx, prefix, func(objectInfo storage.ObjectInfo) error {
+		if b.closed.Load() {
+			return errBucketOutOfScope
+		}
+		return f(objectInfo)
+	})
+}
+
+func (b *scopedReadBucket) close() {
+	b.closed.Store(true)
+}
@@ -17,16 +17,22 @@ package bufsync
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/bufbuild/buf/private/pkg/command"
 	"github.com/bufbuild/buf/private/pkg/git"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
 // scaffoldGitRepository returns a repo with the following commits:
@@ -106,7 +112,189 @@ func scaffoldGitRepositoryDir(t *testing.T, runner command.Runner) string {
 	return localDir
 }
 
-func runInDir(t *testing.T, runner command.Runner, dir string, cmd string, args ...string) {
+// testGitRepository is a local git repository with a bare "origin" remote, that tests can modify
+// commit by commit.
+type testGitRepository struct {
+	t        testing.TB
+	runner   command.Runner
+	localDir string
+}
+
+// newTestGitRepository initializes an empty local git repository with a default branch "main" and
+// a bare remote named "origin".
+func newTestGitRepository(t testing.TB) *testGitRepository {
+	return newTestGitRepositoryWithObjectFormat(t, "sha1")
+}
+
+// newTestGitRepositoryWithObjectFormat is like newTestGitRepository, with the passed object format
+// ("sha1" or "sha256") for both the local and the remote repositories.
+func newTestGitRepositoryWithObjectFormat(t testing.TB, objectFormat string) *testGitRepository {
+	runner := command.NewRunner()
+	dir := t.TempDir()
+	runInDir(t, runner, dir, "mkdir", "local", "remote")
+	remoteDir := path.Join(dir, "remote")
+	runInDir(t, runner, remoteDir, "git", "init", "--bare", "--object-format", objectFormat)
+	localDir := path.Join(dir, "local")
+	runInDir(t, runner, localDir, "git", "init", "--initial-branch", "main", "--object-format", objectFormat)
+	runInDir(t, runner, localDir, "git", "config", "user.name", "Buf TestBot")
+	runInDir(t, runner, localDir, "git", "config", "user.email", "testbot@buf.build")
+	runInDir(t, runner, localDir, "git", "remote", "add", "origin", remoteDir)
+	return &testGitRepository{
+		t:        t,
+		runner:   runner,
+		localDir: localDir,
+	}
+}
+
+// git runs a git command in the local repository, and returns its trimmed stdout.
+func (r *testGitRepository) git(args ...string) string {
+	return r.gitWithEnv(nil, args...)
+}
+
+// gitWithEnv runs a git command in the local repository with the passed environment variables, and
+// returns its trimmed stdout.
+func (r *testGitRepository) gitWithEnv(env map[string]string, args ...string) string {
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	err := r.runner.Run(
+		context.Background(),
+		"git",
+		command.RunWithArgs(args...),
+		command.RunWithDir(r.localDir),
+		command.RunWithEnv(env),
+		command.RunWithStdout(stdout),
+		command.RunWithStderr(stderr),
+	)
+	require.NoError(r.t, err, "git %s: %s", strings.Join(args, " "), stderr.String())
+	return strings.TrimSpace(stdout.String())
+}
+
+// commit writes the passed files (path -> content) to the working tree, and commits them along with
+// any other pending change. An empty content deletes the file. It returns the new commit hash.
+func (r *testGitRepository) commit(message string, files map[string]string) git.Hash {
+	return r.commitAt(message, files, time.Time{})
+}
+
+// commitAt is like commit, with the passed author and committer time. A zero time commits at the
+// current time.
+func (r *testGitRepository) commitAt(message string, files map[string]string, commitTime time.Time) git.Hash {
+	for filePath, content := range files {
+		fullPath := path.Join(r.localDir, filePath)
+		if content == "" {
+			require.NoError(r.t, os.RemoveAll(fullPath))
+			continue
+		}
+		require.NoError(r.t, os.MkdirAll(path.Dir(fullPath), 0755))
+		require.NoError(r.t, os.WriteFile(fullPath, []byte(content), 0600))
+	}
+	r.git("add", "-A")
+	var env map[string]string
+	if !commitTime.IsZero() {
+		date := commitTime.Format(time.RFC3339)
+		env = map[string]string{"GIT_AUTHOR_DATE": date, "GIT_COMMITTER_DATE": date}
+	}
+	r.gitWithEnv(env, "commit", "--allow-empty", "-m", message)
+	return r.head()
+}
+
+// sign replaces the local HEAD commit with the same commit signed by the passed entity, as git would
+// sign it with GPG. It returns the new commit hash.
+func (r *testGitRepository) sign(signer *openpgp.Entity) git.Hash {
+	return r.signWith(func(payload string) string {
+		var signature bytes.Buffer
+		require.NoError(r.t, openpgp.ArmoredDetachSign(&signature, signer, strings.NewReader(payload), nil))
+		return signature.String()
+	})
+}
+
+// signSSH replaces the local HEAD commit with the same commit signed by the passed SSH signer, as git
+// would sign it with an SSH key. It returns the new commit hash.
+func (r *testGitRepository) signSSH(signer ssh.Signer) git.Hash {
+	return r.signWith(func(payload string) string {
+		payloadHash := sha512.Sum512([]byte(payload))
+		signedData := append([]byte(sshSignatureMagic), ssh.Marshal(struct {
+			Namespace     string
+			Reserved      string
+			HashAlgorithm string
+			Hash          []byte
+		}{
+			Namespace:     sshSignatureNamespace,
+			HashAlgorithm: "sha512",
+			Hash:          payloadHash[:],
+		})...)
+		signature, err := signer.Sign(rand.Reader, signedData)
+		require.NoError(r.t, err)
+		blob := append([]byte(sshSignatureMagic), ssh.Marshal(struct {
+			Version       uint32
+			PublicKey     []byte
+			Namespace     string
+			Reserved      string
+			HashAlgorithm string
+			Signature     []byte
+		}{
+			Version:       1,
+			PublicKey:     signer.PublicKey().Marshal(),
+			Namespace:     sshSignatureNamespace,
+			HashAlgorithm: "sha512",
+			Signature:     ssh.Marshal(signature),
+		})...)
+		encodedBlob := base64.StdEncoding.EncodeToString(blob)
+		var armoredSignature strings.Builder
+		armoredSignature.WriteString(sshSignatureArmorStart + "\n")
+		for len(encodedBlob) > 70 {
+			armoredSignature.WriteString(encodedBlob[:70] + "\n")
+			encodedBlob = encodedBlob[70:]
+		}
+		armoredSignature.WriteString(encodedBlob + "\n" + sshSignatureArmorEnd + "\n")
+		return armoredSignature.String()
+	})
+}
+
+// signWith replaces the local HEAD commit with the same commit signed with the armored signature
+// returned for its payload. It returns the new commit hash.
+func (r *testGitRepository) signWith(sign func(payload string) string) git.Hash {
+	payload := r.git("cat-file", "commit", "HEAD") + "\n"
+	signature := sign(payload)
+	headers, message, found := strings.Cut(payload, "\n\n")
+	require.True(r.t, found)
+	signatureHeader := "gpgsig " + strings.ReplaceAll(strings.TrimSpace(signature), "\n", "\n ")
+	signedCommitPath := path.Join(r.t.TempDir(), "commit")
+	require.NoError(r.t, os.WriteFile(signedCommitPath, []byte(headers+"\n"+signatureHeader+"\n\n"+message), 0600))
+	signedCommitHash := r.git("hash-object", "-t", "commit", "-w", signedCommitPath)
+	r.git("update-ref", "HEAD", signedCommitHash)
+	return r.head()
+}
+
+// head returns the hash of the local HEAD commit.
+func (r *testGitRepository) head() git.Hash {
+	hash, err := git.NewHashFromHex(r.git("rev-parse", "HEAD"))
+	require.NoError(r.t, err)
+	return hash
+}
+
+// push force-pushes the passed branches to the "origin" remote.
+func (r *testGitRepository) push(branches ...string) {
+	for _, branch := range branches {
+		r.git("push", "-u", "-f", "origin", branch)
+	}
+}
+
+// open sets the remote default branch to "main" and opens the git repository.
+func (r *testGitRepository) open() git.Repository {
+	r.git("remote", "set-head", "origin", "main")
+	repo, err := git.OpenRepository(
+		context.Background(),
+		path.Join(r.localDir, git.DotGitDir),
+		r.runner,
+	)
+	require.NoError(r.t, err)
+	r.t.Cleanup(func() {
+		require.NoError(r.t, repo.Close())
+	})
+	return repo
+}
+
+func runInDir(t testing.TB, runner command.Runner, dir string, cmd string, args ...string) {
 	stderr := bytes.NewBuffer(nil)
 	err := runner.Run(
 		context.Background(),
@@ -0,0 +1,946 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/buf/private/pkg/git"
+	"go.uber.org/zap"
+)
+
+// maxReanchorDepth is the maximum number of commits visited from the HEAD commit of a branch when
+// looking for a synced commit to re-anchor a missing sync point.
+const maxReanchorDepth = 1000
+
+// resolveSyncPoints resolves sync points for all known modules for the specified branch,
+// returning all modules for which sync points were found, along with their sync points.
+//
+// If neither a SyncPointResolver nor resume overrides are configured, or only the HEAD commit is
+// synced, this returns an empty map immediately.
+func (s *syncer) resolveSyncPoints(ctx context.Context, branch string) (map[Module]git.Hash, error) {
+	syncPoints := map[Module]git.Hash{}
+	if s.headOnly {
+		return syncPoints, nil
+	}
+	if localResumePoint, ok := s.localResumePoints[branch]; ok {
+		syncPoint, err := s.validateResumePoint(branch, localResumePoint, "local resume point")
+		if err != nil {
+			return nil, err
+		}
+		for _, module := range s.modulesToSync {
+			syncPoints[module] = syncPoint
+		}
+		return syncPoints, nil
+	}
+	// If resumption is not enabled, we can bail early.
+	if s.syncPointResolver == nil && len(s.resumeOverrides) == 0 {
+		return syncPoints, nil
+	}
+	for _, module := range s.modulesToSync {
+		syncPoint, err := s.resolveSyncPoint(ctx, module, branch)
+		if err != nil {
+			return nil, fmt.Errorf("resolve sync point for module %q in branch %q: %w", module.String(), branch, err)
+		}
+		if syncPoint != nil {
+			s.logger.Debug(
+				"resolved sync point, will sync after this commit",
+				zap.String("branch", branch),
+				zap.Stringer("module", module),
+				zap.Stringer("syncPoint", syncPoint),
+			)
+			syncPoints[module] = syncPoint
+		} else {
+			s.logger.Debug(
+				"no sync point, syncing all branch",
+				zap.String("branch", branch),
+				zap.Stringer("module", module),
+			)
+		}
+	}
+	return syncPoints, nil
+}
+
+// resolveSyncPoint resolves a sync point for a particular module and branch, from the resume
+// overrides or the SyncPointResolver.
+func (s *syncer) resolveSyncPoint(ctx context.Context, module Module, branch string) (git.Hash, error) {
+	identity, err := s.moduleIdentity(module, branch)
+	if err != nil {
+		return nil, err
+	}
+	if overrideSyncPoint, ok := s.resumeOverrides[identity.IdentityString()][branch]; ok {
+		return s.validateResumePoint(branch, overrideSyncPoint, "resume override")
+	}
+	if s.syncPointResolver == nil {
+		return nil, nil
+	}
+	syncPoint, err := s.syncPointResolver(ctx, identity, s.remoteBranch(branch))
+	if err != nil {
+		return nil, fmt.Errorf("resolve syncPoint for module %s: %w", identity.IdentityString(), err)
+	}
+	if syncPoint == nil {
+		return nil, nil
+	}
+	// Validate that the sync point is a hash of the repository object format, and that the commit
+	// pointed to by it exists.
+	if err := s.validateHashAlgorithm(syncPoint); err != nil {
+		if err := s.errorHandler.InvalidSyncPoint(module, branch, syncPoint, err); err != nil {
+			return nil, &SyncPointError{Module: module, Branch: branch, SyncPoint: syncPoint, Err: err}
+		}
+		return nil, nil
+	}
+	if _, err := s.repo.Objects().Commit(syncPoint); err != nil {
+		if s.reanchorOnMissingSyncPoint && errors.Is(err, git.ErrObjectNotFound) {
+			anchor, err := s.reanchorSyncPoint(ctx, module, branch)
+			if err != nil {
+				return nil, fmt.Errorf("re-anchor missing sync point %q: %w", syncPoint, err)
+			}
+			if anchor != nil {
+				s.logger.Warn(
+					"sync point not found, re-anchoring to the most recent synced commit in branch",
+					zap.String("branch", branch),
+					zap.Stringer("module", module),
+					zap.Stringer("syncPoint", syncPoint),
+					zap.Stringer("anchor", anchor),
+				)
+				return anchor, nil
+			}
+		}
+		if err := s.errorHandler.InvalidSyncPoint(module, branch, syncPoint, err); err != nil {
+			return nil, &SyncPointError{Module: module, Branch: branch, SyncPoint: syncPoint, Err: err}
+		}
+		return nil, nil
+	}
+	// Validate that the sync point is still part of the branch history.
+	headCommit, err := s.headCommit(branch)
+	if err != nil {
+		return nil, fmt.Errorf("read HEAD commit for branch %q: %w", branch, err)
+	}
+	isAncestor, err := s.isAncestor(branch, syncPoint)
+	if err != nil {
+		return nil, fmt.Errorf("check if sync point %q is an ancestor of branch %q: %w", syncPoint, branch, err)
+	}
+	if !isAncestor {
+		if err := s.errorHandler.SyncPointDiverged(module, branch, syncPoint, headCommit.Hash()); err != nil {
+			return nil, &SyncPointError{Module: module, Branch: branch, SyncPoint: syncPoint, Err: err}
+		}
+		message := "sync point diverged from branch, re-syncing from merge base"
+		if _, isOrphan := s.orphanBranches[branch]; isOrphan {
+			// orphan branches have no merge base, such as with a sync point from the default branch
+			message = "sync point diverged from orphan branch, re-syncing full branch history"
+		}
+		s.logger.Warn(
+			message,
+			zap.String("branch", branch),
+			zap.Stringer("module", module),
+			zap.Stringer("syncPoint", syncPoint),
+			zap.Stringer("head", headCommit.Hash()),
+		)
+		return nil, nil
+	}
+	return syncPoint, nil
+}
+
+// reanchorSyncPoint returns the most recent commit in the branch already synced for the module, to
+// resume from it in place of a sync point not found in the repository. It visits at most
+// maxReanchorDepth commits from the branch HEAD, or the max history depth if lower, and returns nil
+// if none of them is synced.
+func (s *syncer) reanchorSyncPoint(ctx context.Context, module Module, branch string) (git.Hash, error) {
+	maxDepth := maxReanchorDepth
+	if s.maxHistoryDepth > 0 && s.maxHistoryDepth < maxDepth {
+		maxDepth = s.maxHistoryDepth
+	}
+	var (
+		anchor         git.Hash
+		visitedCommits int
+	)
+	stopLoopErr := errors.New("stop loop")
+	if err := s.forEachCommit(branch, func(commit git.Commit) error {
+		if visitedCommits == maxDepth {
+			return stopLoopErr
+		}
+		visitedCommits++
+		isSynced, err := s.isGitCommitSynced(ctx, module, branch, commit)
+		if err != nil {
+			return fmt.Errorf("check if module %q already synced git commit %q: %w", module.String(), commit.Hash(), err)
+		}
+		if isSynced {
+			anchor = commit.Hash()
+			return stopLoopErr
+		}
+		return nil
+	}); err != nil && !errors.Is(err, stopLoopErr) {
+		return nil, err
+	}
+	return anchor, nil
+}
+
+// validateResumePoint validates that a sync point not resolved by the SyncPointResolver, described by
+// source, is a commit in the branch history.
+// Overrides are set explicitly to re-anchor resumption, so unlike the resolved sync points, invalid
+// ones always fail without going through the error handler.
+func (s *syncer) validateResumePoint(branch string, syncPoint git.Hash, source string) (git.Hash, error) {
+	if err := s.validateHashAlgorithm(syncPoint); err != nil {
+		return nil, fmt.Errorf("%s %q: %w", source, syncPoint, err)
+	}
+	if _, err := s.repo.Objects().Commit(syncPoint); err != nil {
+		return nil, fmt.Errorf("read %s commit %q: %w", source, syncPoint, err)
+	}
+	isAncestor, err := s.isAncestor(branch, syncPoint)
+	if err != nil {
+		return nil, fmt.Errorf("check if %s %q is an ancestor of branch %q: %w", source, syncPoint, branch, err)
+	}
+	if !isAncestor {
+		return nil, fmt.Errorf("%s %q is not an ancestor of branch %q", source, syncPoint, branch)
+	}
+	s.logger.Debug(
+		source+", will sync after this commit",
+		zap.String("branch", branch),
+		zap.Stringer("syncPoint", syncPoint),
+	)
+	return syncPoint, nil
+}
+
+// validateHashAlgorithm validates that the hash algorithm of the hash matches the repository object
+// format, such as to reject SHA-1 hashes in a repository migrated to SHA-256.
+func (s *syncer) validateHashAlgorithm(hash git.Hash) error {
+	if hash.Algorithm() != s.repo.HashAlgorithm() {
+		return fmt.Errorf(
+			"%s hash does not match the %s object format of the repository",
+			hash.Algorithm(),
+			s.repo.HashAlgorithm(),
+		)
+	}
+	return nil
+}
+
+// isAncestor returns true if the passed commit hash is found when traveling the branch commits
+// from its HEAD. Merge commits' parents other than the first one are only traveled if the merge
+// commit policy walks all parents.
+func (s *syncer) isAncestor(branch string, hash git.Hash) (bool, error) {
+	forEachCommit := s.forEachCommit
+	if s.mergeCommitPolicy != MergeCommitPolicyFirstParentOnly {
+		forEachCommit = s.forEachReachableCommit
+	}
+	var found bool
+	stopLoopErr := errors.New("stop loop")
+	if err := forEachCommit(branch, func(commit git.Commit) error {
+		if commit.Hash().Hex() == hash.Hex() {
+			found = true
+			return stopLoopErr
+		}
+		return nil
+	}); err != nil && !errors.Is(err, stopLoopErr) {
+		return false, err
+	}
+	return found, nil
+}
+
+func (s *syncer) Plan(ctx context.Context) (SyncPlan, error) {
+	branchesSyncPoints, err := s.prepareSync(ctx)
+	if err != nil {
+		return SyncPlan{}, err
+	}
+	if s.branchErrs != nil {
+		return SyncPlan{}, s.branchErrs
+	}
+	var plan SyncPlan
+	if s.tagReconcileOnly {
+		tagsToReconcile, err := s.tagsToReconcile(ctx)
+		if err != nil {
+			return SyncPlan{}, fmt.Errorf("finding tags to reconcile: %w", err)
+		}
+		if len(tagsToReconcile) > 0 {
+			tagsPlan, err := s.branchSyncPlan("", nil, tagsToReconcile)
+			if err != nil {
+				return SyncPlan{}, fmt.Errorf("plan tags to reconcile: %w", err)
+			}
+			plan.Branches = append(plan.Branches, tagsPlan)
+		}
+		return plan, nil
+	}
+	for _, branch := range s.sortedBranchesToSync() {
+		commitsToSync, err := s.commitsToSync(ctx, branch, branchesSyncPoints[branch])
+		if err != nil {
+			return SyncPlan{}, fmt.Errorf("finding commits to sync for branch %q: %w", branch, err)
+		}
+		branchPlan, err := s.branchSyncPlan(branch, branchesSyncPoints[branch], commitsToSync)
+		if err != nil {
+			return SyncPlan{}, fmt.Errorf("plan branch %q: %w", branch, err)
+		}
+		plan.Branches = append(plan.Branches, branchPlan)
+	}
+	taggedCommitsToSync, err := s.taggedCommitsToSync(ctx)
+	if err != nil {
+		return SyncPlan{}, fmt.Errorf("finding tagged commits to sync: %w", err)
+	}
+	if len(taggedCommitsToSync) > 0 {
+		taggedCommitsPlan, err := s.branchSyncPlan("", nil, taggedCommitsToSync)
+		if err != nil {
+			return SyncPlan{}, fmt.Errorf("plan tagged commits: %w", err)
+		}
+		plan.Branches = append(plan.Branches, taggedCommitsPlan)
+	}
+	return plan, nil
+}
+
+// branchSyncPlan returns the plan for the commits to sync in a branch, and marks the planned commits
+// as processed. Commits that would be skipped for not changing any module, or are excluded by the
+// commit filter, are not planned, and are added to the filtered commits instead.
+func (s *syncer) branchSyncPlan(
+	branch string,
+	modulesSyncPoints map[Module]git.Hash,
+	commitsToSync []syncableCommit,
+) (BranchSyncPlan, error) {
+	_, isOrphan := s.orphanBranches[branch]
+	branchPlan := BranchSyncPlan{
+		Branch:     branch,
+		SyncPoints: modulesSyncPoints,
+		Orphan:     isOrphan,
+	}
+	for _, commitToSync := range commitsToSync {
+		commitPlan := CommitSyncPlan{
+			Commit: commitToSync.commit,
+			Tags:   s.commitTags(commitToSync.commit),
+		}
+		isIncluded := s.commitFilterFunc(commitToSync.commit)
+		for _, module := range s.modulesToSync { // looping over the original sort order of modules
+			if _, shouldSyncModule := commitToSync.modules[module]; !shouldSyncModule {
+				continue
+			}
+			// Commits planned in a branch would be synced by the time the next branches are synced,
+			// so we mark them as processed to stop traversing the next branches where Sync would.
+			if err := s.markGitCommitProcessed(module, branch, commitToSync.commit.Hash().Hex()); err != nil {
+				return BranchSyncPlan{}, err
+			}
+			shouldSkip, err := s.shouldSkipUnchangedCommit(commitToSync.commit, module)
+			if err != nil {
+				return BranchSyncPlan{}, err
+			}
+			if shouldSkip {
+				continue
+			}
+			included, err := isIncluded()
+			if err != nil {
+				return BranchSyncPlan{}, err
+			}
+			if !included {
+				continue
+			}
+			commitPlan.Modules = append(commitPlan.Modules, module)
+		}
+		if len(commitPlan.Modules) > 0 {
+			branchPlan.Commits = append(branchPlan.Commits, commitPlan)
+		} else {
+			branchPlan.FilteredCommits = append(branchPlan.FilteredCommits, commitToSync.commit)
+		}
+	}
+	return branchPlan, nil
+}
+
+// confirmInitialSync invokes the InitialSyncConfirmer for the modules without a sync point in any
+// branch, for each branch with more commits of the module than the initial sync threshold. It runs
+// once, before any branch is synced, and returns the branches not confirmed.
+func (s *syncer) confirmInitialSync(
+	ctx context.Context,
+	branchesSyncPoints map[string]map[Module]git.Hash,
+) (map[string]struct{}, error) {
+	unconfirmedBranches := make(map[string]struct{})
+	for _, module := range s.modulesToSync {
+		hasSyncPoint := false
+		for _, modulesSyncPoints := range branchesSyncPoints {
+			if _, ok := modulesSyncPoints[module]; ok {
+				hasSyncPoint = true
+				break
+			}
+		}
+		if hasSyncPoint {
+			continue
+		}
+		for _, branch := range s.sortedBranchesToSync() {
+			if _, unconfirmed := unconfirmedBranches[branch]; unconfirmed {
+				continue
+			}
+			commitCount, err := s.moduleCommitCount(branch, module)
+			if err != nil {
+				return nil, err
+			}
+			if commitCount <= s.initialSyncThreshold {
+				continue
+			}
+			confirmed, err := s.initialSyncConfirmer(ctx, module, branch, commitCount)
+			if err != nil {
+				return nil, err
+			}
+			if !confirmed {
+				unconfirmedBranches[branch] = struct{}{}
+			}
+		}
+	}
+	return unconfirmedBranches, nil
+}
+
+// moduleCommitCount returns the number of commits of the branch the module is found in, up to the
+// max history depth.
+func (s *syncer) moduleCommitCount(branch string, module Module) (int, error) {
+	stopLoopErr := errors.New("stop loop")
+	var visitedCommits, commitCount int
+	if err := s.forEachCommit(branch, func(commit git.Commit) error {
+		if s.maxHistoryDepth > 0 && visitedCommits == s.maxHistoryDepth {
+			return stopLoopErr
+		}
+		visitedCommits++
+		moduleTreeHash, err := s.moduleTreeHash(commit, module)
+		if err != nil {
+			return err
+		}
+		if moduleTreeHash != nil {
+			commitCount++
+		}
+		return nil
+	}); err != nil && !errors.Is(err, stopLoopErr) {
+		return 0, fmt.Errorf("count commits of module %s in branch %q: %w", module, branch, err)
+	}
+	return commitCount, nil
+}
+
+// syncableCommit holds the git commit and modules in that commit that need to be synced.
+type syncableCommit struct {
+	commit  git.Commit
+	modules map[Module]struct{}
+}
+
+// commitsToSync returns a sorted commit+modules tuples array that are pending to sync for a branch,
+// according to the merge commit policy.
+func (s *syncer) commitsToSync(
+	ctx context.Context,
+	branch string,
+	modulesSyncPoints map[Module]git.Hash,
+) ([]syncableCommit, error) {
+	if s.headOnly {
+		return s.headCommitToSync(branch)
+	}
+	if s.mergeCommitPolicy == MergeCommitPolicyFirstParentOnly {
+		commitsToSync, err := s.firstParentCommitsToSync(ctx, branch, modulesSyncPoints)
+		if err != nil {
+			return nil, err
+		}
+		commitsToSync = s.coalesceCommits(branch, commitsToSync)
+		if s.headFirstBackfill {
+			// sync the HEAD commit first, and backfill its history from the most recent commit
+			for i := len(commitsToSync)/2 - 1; i >= 0; i-- {
+				opp := len(commitsToSync) - 1 - i
+				commitsToSync[i], commitsToSync[opp] = commitsToSync[opp], commitsToSync[i]
+			}
+		}
+		return commitsToSync, nil
+	}
+	commitsToSync, err := s.allParentsCommitsToSync(ctx, branch, modulesSyncPoints)
+	if err != nil {
+		return nil, err
+	}
+	if s.mergeCommitPolicy != MergeCommitPolicySkip {
+		return s.coalesceCommits(branch, commitsToSync), nil
+	}
+	nonMergeCommitsToSync := make([]syncableCommit, 0, len(commitsToSync))
+	for _, commitToSync := range commitsToSync {
+		if len(commitToSync.commit.Parents()) > 1 {
+			s.logger.Debug(
+				"skipping merge commit",
+				zap.String("branch", branch),
+				zap.Stringer("commit", commitToSync.commit.Hash()),
+			)
+			continue
+		}
+		nonMergeCommitsToSync = append(nonMergeCommitsToSync, commitToSync)
+	}
+	return s.coalesceCommits(branch, nonMergeCommitsToSync), nil
+}
+
+// coalesceCommits coalesces the runs of consecutive commits to sync, with committer times within the
+// coalesce window of the next commit, into the last commit of each run. The last commit syncs the
+// modules of all the commits in its run, with their tags.
+func (s *syncer) coalesceCommits(branch string, commitsToSync []syncableCommit) []syncableCommit {
+	if s.coalesceWindow <= 0 {
+		return commitsToSync
+	}
+	coalescedCommitsToSync := make([]syncableCommit, 0, len(commitsToSync))
+	var run []syncableCommit
+	for i, commitToSync := range commitsToSync {
+		if i+1 < len(commitsToSync) {
+			nextCommitTime := commitsToSync[i+1].commit.Committer().Timestamp()
+			if nextCommitTime.Sub(commitToSync.commit.Committer().Timestamp()) <= s.coalesceWindow {
+				run = append(run, commitToSync)
+				continue
+			}
+		}
+		if len(run) > 0 {
+			commitToSync = s.coalesceCommit(branch, run, commitToSync)
+			run = nil
+		}
+		coalescedCommitsToSync = append(coalescedCommitsToSync, commitToSync)
+	}
+	return coalescedCommitsToSync
+}
+
+// coalesceCommit coalesces a run of consecutive commits into the commit after them, and returns it to
+// be synced with the modules of the whole run.
+func (s *syncer) coalesceCommit(branch string, run []syncableCommit, commitToSync syncableCommit) syncableCommit {
+	modules := make(map[Module]struct{}, len(commitToSync.modules))
+	for module := range commitToSync.modules {
+		modules[module] = struct{}{}
+	}
+	var coalesced coalescedCommit
+	if parents := run[0].commit.Parents(); len(parents) > 0 {
+		coalesced.baseParent = parents[0]
+	}
+	for _, runCommit := range run {
+		s.logger.Debug(
+			"coalescing commit into a later commit",
+			zap.String("branch", branch),
+			zap.Stringer("commit", runCommit.commit.Hash()),
+			zap.Stringer("into", commitToSync.commit.Hash()),
+		)
+		for module := range runCommit.modules {
+			modules[module] = struct{}{}
+		}
+		coalesced.tags = append(coalesced.tags, s.tagsByCommitHash[runCommit.commit.Hash().Hex()]...)
+	}
+	s.coalescedCommits[commitToSync.commit.Hash().Hex()] = coalesced
+	return syncableCommit{commit: commitToSync.commit, modules: modules}
+}
+
+// headCommitToSync returns the HEAD commit of a branch with all modules pending to sync, regardless
+// of them being already synced.
+func (s *syncer) headCommitToSync(branch string) ([]syncableCommit, error) {
+	headCommit, err := s.headCommit(branch)
+	if err != nil {
+		return nil, fmt.Errorf("get head commit for branch %q: %w", branch, err)
+	}
+	modulesToSync := make(map[Module]struct{}, len(s.modulesToSync))
+	for _, module := range s.modulesToSync {
+		modulesToSync[module] = struct{}{}
+	}
+	return []syncableCommit{{commit: headCommit, modules: modulesToSync}}, nil
+}
+
+// firstParentCommitsToSync returns a sorted commit+modules tuples array that are pending to sync for
+// a branch, only traveling the first parent of merge commits.
+func (s *syncer) firstParentCommitsToSync(
+	ctx context.Context,
+	branch string,
+	modulesSyncPoints map[Module]git.Hash,
+) ([]syncableCommit, error) {
+	// First, mark all modules as pending, until its starting sync point is reached. They'll be
+	// removed from this list as its initial sync point is found.
+	pendingModules := make(map[Module]struct{}, len(s.modulesToSync))
+	for _, module := range s.modulesToSync {
+		pendingModules[module] = struct{}{}
+	}
+	var (
+		commitsToSync  []syncableCommit
+		visitedCommits int
+	)
+	// travel branch commits from HEAD and check if they're already synced, until finding a synced git
+	// commit, or adding them all to be synced
+	stopLoopErr := errors.New("stop loop")
+	if err := s.forEachCommit(branch, func(commit git.Commit) error {
+		if len(pendingModules) == 0 {
+			// no more pending modules to sync, no need to keep navigating the branch
+			return stopLoopErr
+		}
+		if s.maxHistoryDepth > 0 && visitedCommits == s.maxHistoryDepth {
+			s.warnMaxHistoryDepthReached(branch, commit)
+			return stopLoopErr
+		}
+		visitedCommits++
+		commitHash := commit.Hash().Hex()
+		modulesToSyncInThisCommit := make(map[Module]struct{})
+		modulesFoundSyncPointInThisCommit := make(map[Module]struct{})
+		for module := range pendingModules {
+			// TODO do this in a paginated fashion
+			isSynced, err := s.isGitCommitSynced(ctx, module, branch, commit)
+			if err != nil {
+				return fmt.Errorf("check if module %q already synced git commit %q: %w", module.String(), commitHash, err)
+			}
+			if !isSynced {
+				modulesToSyncInThisCommit[module] = struct{}{}
+				continue
+			}
+			expectedSyncPoint, ok := modulesSyncPoints[module]
+			if s.headFirstBackfill && (!ok || commitHash != expectedSyncPoint.Hex()) {
+				// synced ahead of the sync point by an interrupted run, keep backfilling its history
+				continue
+			}
+			// reached a commit that is already synced for this module
+			modulesFoundSyncPointInThisCommit[module] = struct{}{}
+			if !ok {
+				// this module did not have an expected sync point, we probably reached the beginning of the
+				// branch off another branch that is already synced. Orphan branches can only reach commits
+				// synced for their own history, such as from another branch sharing their root.
+				continue
+			}
+			if commitHash != expectedSyncPoint.Hex() {
+				if err := s.unexpectedSyncPoint(module, branch, expectedSyncPoint, commitHash); err != nil {
+					return err
+				}
+			}
+		}
+		// clear modules that already found its sync point
+		for module := range modulesFoundSyncPointInThisCommit {
+			delete(pendingModules, module)
+		}
+		if len(modulesToSyncInThisCommit) > 0 {
+			commitsToSync = append(commitsToSync, syncableCommit{
+				commit:  commit,
+				modules: modulesToSyncInThisCommit,
+			})
+		} else {
+			// no modules to sync in this commit, we should not have any pending modules, unless they are
+			// synced ahead of their sync points
+			if len(pendingModules) > 0 && !s.headFirstBackfill {
+				return fmt.Errorf(
+					"commit %q has no modules to sync, but still has pending modules %v",
+					commitHash,
+					pendingModules,
+				)
+			}
+		}
+		return nil
+	}); err != nil && !errors.Is(err, stopLoopErr) {
+		return nil, err
+	}
+	if len(commitsToSync) == 0 {
+		return nil, nil
+	}
+	// https://github.com/golang/go/wiki/SliceTricks#reversing
+	for i := len(commitsToSync)/2 - 1; i >= 0; i-- {
+		opp := len(commitsToSync) - 1 - i
+		commitsToSync[i], commitsToSync[opp] = commitsToSync[opp], commitsToSync[i]
+	}
+	return commitsToSync, nil
+}
+
+// unexpectedSyncPoint handles a synced commit found in place of the expected sync point of the module
+// in the branch. It returns a SyncPointError for the default branch, as its history was probably
+// rebased or reset, and warns for the rest of the branches. Commits after a local resume point may
+// already be synced by other means, so they are accepted.
+func (s *syncer) unexpectedSyncPoint(module Module, branch string, expectedSyncPoint git.Hash, foundCommitHash string) error {
+	if _, isLocalResumePoint := s.localResumePoints[branch]; isLocalResumePoint {
+		return nil
+	}
+	if s.repo.DefaultBranch() == branch {
+		// TODO: add details to error message saying: "run again with --force-branch-sync <branch
+		// name>" when we support a flag like that.
+		return &SyncPointError{
+			Module:    module,
+			Branch:    branch,
+			SyncPoint: expectedSyncPoint,
+			Err: fmt.Errorf(
+				"found synced git commit %q for default branch %q, but expected sync point was %q, did you rebase or reset your default branch?",
+				foundCommitHash,
+				branch,
+				expectedSyncPoint,
+			),
+		}
+	}
+	// syncing non-default branches from an unexpected sync point can be a common scenario in PRs,
+	// we can just WARN and continue
+	s.logger.Warn(
+		"unexpected_sync_point",
+		zap.String("expected_sync_point", expectedSyncPoint.Hex()),
+		zap.String("found_sync_point", foundCommitHash),
+		zap.String("branch", branch),
+		zap.String("module", module.String()),
+	)
+	return nil
+}
+
+// allParentsCommitsToSync returns a commit+modules tuples array that are pending to sync for a
+// branch, traveling all parents of merge commits. Commits are sorted so that all parents of a
+// commit come before it.
+//
+// The travel stops at commits already synced for all modules, as their ancestors are expected to be
+// synced as well. The last synced commit in the branch might come from any of the merged histories, so
+// the expected sync point of a module is contrasted with all the synced commits reached for it,
+// instead of with the first one as in firstParentCommitsToSync.
+func (s *syncer) allParentsCommitsToSync(
+	ctx context.Context,
+	branch string,
+	modulesSyncPoints map[Module]git.Hash,
+) ([]syncableCommit, error) {
+	headCommit, err := s.headCommit(branch)
+	if err != nil {
+		return nil, fmt.Errorf("get head commit for branch %q: %w", branch, err)
+	}
+	// pendingCommit is a commit in the travel stack, expanded once its parents are pushed.
+	type pendingCommit struct {
+		commit   git.Commit
+		modules  map[Module]struct{}
+		expanded bool
+	}
+	var (
+		commitsToSync []syncableCommit
+		depthReached  bool
+	)
+	visitedCommits := make(map[string]struct{})
+	// syncedCommits are the hashes of the synced commits reached for each module
+	syncedCommits := make(map[Module]map[string]struct{}, len(s.modulesToSync))
+	pendingCommits := []*pendingCommit{{commit: headCommit}}
+	for len(pendingCommits) > 0 {
+		current := pendingCommits[len(pendingCommits)-1]
+		if current.expanded {
+			// all parents are sorted before it
+			pendingCommits = pendingCommits[:len(pendingCommits)-1]
+			commitsToSync = append(commitsToSync, syncableCommit{
+				commit:  current.commit,
+				modules: current.modules,
+			})
+			continue
+		}
+		commitHash := current.commit.Hash().Hex()
+		if _, visited := visitedCommits[commitHash]; visited {
+			pendingCommits = pendingCommits[:len(pendingCommits)-1]
+			continue
+		}
+		if s.maxHistoryDepth > 0 && len(visitedCommits) == s.maxHistoryDepth {
+			// the rest of the history is not synced, but the visited commits still have all their
+			// visited parents sorted before them
+			if !depthReached {
+				s.warnMaxHistoryDepthReached(branch, current.commit)
+				depthReached = true
+			}
+			pendingCommits = pendingCommits[:len(pendingCommits)-1]
+			continue
+		}
+		visitedCommits[commitHash] = struct{}{}
+		current.modules = make(map[Module]struct{})
+		for _, module := range s.modulesToSync {
+			isSynced, err := s.isGitCommitSynced(ctx, module, branch, current.commit)
+			if err != nil {
+				return nil, fmt.Errorf("check if module %q already synced git commit %q: %w", module.String(), commitHash, err)
+			}
+			if !isSynced {
+				current.modules[module] = struct{}{}
+				continue
+			}
+			if syncedCommits[module] == nil {
+				syncedCommits[module] = make(map[string]struct{})
+			}
+			syncedCommits[module][commitHash] = struct{}{}
+		}
+		if len(current.modules) == 0 {
+			pendingCommits = pendingCommits[:len(pendingCommits)-1]
+			continue
+		}
+		current.expanded = true
+		// push parents in reverse order, so the first parent is visited first
+		parents := current.commit.Parents()
+		for i := len(parents) - 1; i >= 0; i-- {
+			if _, visited := visitedCommits[parents[i].Hex()]; visited {
+				continue
+			}
+			parentCommit, err := s.repo.Objects().Commit(parents[i])
+			if err != nil {
+				return nil, fmt.Errorf("read commit %s: %w", parents[i], err)
+			}
+			pendingCommits = append(pendingCommits, &pendingCommit{commit: parentCommit})
+		}
+	}
+	if !depthReached {
+		// the expected sync points are reached, unless the history was traveled only up to the depth
+		for _, module := range s.modulesToSync {
+			expectedSyncPoint, ok := modulesSyncPoints[module]
+			if !ok {
+				continue
+			}
+			if _, reached := syncedCommits[module][expectedSyncPoint.Hex()]; reached || len(syncedCommits[module]) == 0 {
+				continue
+			}
+			foundCommitHashes := make([]string, 0, len(syncedCommits[module]))
+			for commitHash := range syncedCommits[module] {
+				foundCommitHashes = append(foundCommitHashes, commitHash)
+			}
+			sort.Strings(foundCommitHashes)
+			if err := s.unexpectedSyncPoint(module, branch, expectedSyncPoint, strings.Join(foundCommitHashes, ", ")); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return commitsToSync, nil
+}
+
+// warnMaxHistoryDepthReached logs that the max history depth was reached in the branch, at the first
+// commit that is not visited, before finding the sync points of all the modules.
+func (s *syncer) warnMaxHistoryDepthReached(branch string, commit git.Commit) {
+	s.logger.Warn(
+		"max history depth reached before finding the sync point, syncing only the visited commits",
+		zap.String("branch", branch),
+		zap.Int("max_history_depth", s.maxHistoryDepth),
+		zap.Stringer("first_unvisited_commit", commit.Hash()),
+	)
+}
+
+// taggedCommitsToSync returns the tagged commit+modules tuples pending to sync for the tags only
+// modules, which were not synced by any branch. Commits are sorted by committer timestamp.
+func (s *syncer) taggedCommitsToSync(ctx context.Context) ([]syncableCommit, error) {
+	if len(s.tagsOnlyModuleIdentities) == 0 || s.headOnly {
+		return nil, nil
+	}
+	taggedCommits, err := s.taggedCommits()
+	if err != nil {
+		return nil, err
+	}
+	var commitsToSync []syncableCommit
+	for _, commit := range taggedCommits {
+		modulesToSyncInThisCommit := make(map[Module]struct{})
+		for _, identity := range s.tagsOnlyModuleIdentities {
+			module := s.moduleForRemoteIdentity(identity)
+			isSynced, err := s.isGitCommitSynced(ctx, module, "", commit)
+			if err != nil {
+				return nil, fmt.Errorf("check if module %q already synced git commit %q: %w", module.String(), commit.Hash(), err)
+			}
+			if !isSynced {
+				modulesToSyncInThisCommit[module] = struct{}{}
+			}
+		}
+		if len(modulesToSyncInThisCommit) == 0 {
+			continue
+		}
+		commitsToSync = append(commitsToSync, syncableCommit{
+			commit:  commit,
+			modules: modulesToSyncInThisCommit,
+		})
+	}
+	sort.SliceStable(commitsToSync, func(i, j int) bool {
+		return commitsToSync[i].commit.Committer().Timestamp().Before(commitsToSync[j].commit.Committer().Timestamp())
+	})
+	return commitsToSync, nil
+}
+
+// tagsToReconcile returns the tagged commit+modules tuples to sync to reconcile the tags of the remote
+// modules, for the modules with any of the commit tags missing in the remote module, or pointing to
+// a different commit. Commits are sorted by committer timestamp.
+func (s *syncer) tagsToReconcile(ctx context.Context) ([]syncableCommit, error) {
+	remoteTagsByModule, err := s.resolveRemoteTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	taggedCommits, err := s.taggedCommits()
+	if err != nil {
+		return nil, err
+	}
+	var commitsToSync []syncableCommit
+	for _, commit := range taggedCommits {
+		label, err := s.commitLabel(commit)
+		if err != nil {
+			return nil, err
+		}
+		modulesToSyncInThisCommit := make(map[Module]struct{})
+		for _, module := range s.modulesToSync {
+			for _, tag := range s.tagsByCommitHash[commit.Hash().Hex()] {
+				if remoteLabel, ok := remoteTagsByModule[module][tag]; !ok || remoteLabel != label {
+					modulesToSyncInThisCommit[module] = struct{}{}
+					break
+				}
+			}
+		}
+		if len(modulesToSyncInThisCommit) == 0 {
+			continue
+		}
+		commitsToSync = append(commitsToSync, syncableCommit{
+			commit:  commit,
+			modules: modulesToSyncInThisCommit,
+		})
+	}
+	sort.SliceStable(commitsToSync, func(i, j int) bool {
+		return commitsToSync[i].commit.Committer().Timestamp().Before(commitsToSync[j].commit.Committer().Timestamp())
+	})
+	return commitsToSync, nil
+}
+
+// isGitCommitSynced returns true if the git commit is already processed in this run, or synced in
+// the BSR, for the identity the module is synced to in the branch.
+func (s *syncer) isGitCommitSynced(ctx context.Context, module Module, branch string, commit git.Commit) (bool, error) {
+	identity, err := s.moduleIdentity(module, branch)
+	if err != nil {
+		return false, err
+	}
+	commitHash := commit.Hash().Hex()
+	if _, processed := s.processedGitCommits[identity.IdentityString()][commitHash]; processed {
+		return true, nil
+	}
+	if localResumePoint, ok := s.localResumePoints[branch]; ok && localResumePoint.Hex() == commitHash {
+		return true, nil
+	}
+	if s.syncedGitCommitChecker == nil {
+		return false, nil
+	}
+	label, err := s.commitLabel(commit)
+	if err != nil {
+		return false, err
+	}
+	syncedCommits, err := s.syncedGitCommitChecker(ctx, identity, map[string]struct{}{label: {}})
+	if err != nil {
+		return false, err
+	}
+	_, synced := syncedCommits[label]
+	if synced && s.remoteContentDigestResolver != nil {
+		s.remoteSyncedCommits = append(s.remoteSyncedCommits, remoteSyncedCommit{
+			module:     module,
+			branch:     branch,
+			commitHash: commitHash,
+		})
+	}
+	return synced, nil
+}
+
+// shouldSkipUnchangedCommit returns true if the syncer is configured to skip unchanged commits, and
+// none of the paths under the module dir changed between the commit and its first parent. Root
+// commits are always considered changed. HEAD only syncs and tag reconciliations never skip commits.
+func (s *syncer) shouldSkipUnchangedCommit(commit git.Commit, module Module) (bool, error) {
+	if !s.skipUnchangedCommits || s.headOnly || s.tagReconcileOnly || len(commit.Parents()) == 0 {
+		return false, nil
+	}
+	parentHash := commit.Parents()[0]
+	if coalesced, ok := s.coalescedCommits[commit.Hash().Hex()]; ok {
+		// compare against the parent of the earliest coalesced commit, as any of them may have changed
+		// the module
+		if coalesced.baseParent == nil {
+			return false, nil
+		}
+		parentHash = coalesced.baseParent
+	}
+	parentCommit, err := s.repo.Objects().Commit(parentHash)
+	if err != nil {
+		return false, fmt.Errorf("read commit %s: %w", parentHash, err)
+	}
+	moduleTreeHash, err := s.moduleTreeHash(commit, module)
+	if err != nil {
+		return false, err
+	}
+	parentModuleTreeHash, err := s.moduleTreeHash(parentCommit, module)
+	if err != nil {
+		return false, err
+	}
+	if moduleTreeHash == nil || parentModuleTreeHash == nil {
+		return moduleTreeHash == nil && parentModuleTreeHash == nil, nil
+	}
+	return moduleTreeHash.Hex() == parentModuleTreeHash.Hex(), nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
+	"github.com/bufbuild/buf/private/pkg/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+	// scaffoldGitRepository returns a repo with the following commits:
+	// | o-o----------o-----------------o (main)
+	// |   └o-o (foo) └o--------o (bar)
+	// |               └o (baz)
+	repo := scaffoldGitRepository(t)
+	moduleToSync := newTestSyncableModule(t, ".", "buf.test/owner/repo")
+	var mainCommits []git.Commit
+	require.NoError(t, repo.ForEachCommit("main", func(commit git.Commit) error {
+		mainCommits = append(mainCommits, commit)
+		return nil
+	}))
+	require.Len(t, mainCommits, 4)
+
+	t.Run("when_fresh", func(t *testing.T) {
+		mockBSRChecker := newMockSyncGitChecker()
+		s := syncer{
+			logger:                 zap.NewNop(),
+			repo:                   repo,
+			errorHandler:           &mockErrorHandler{},
+			modulesToSync:          []Module{moduleToSync},
+			syncedGitCommitChecker: mockBSRChecker.checkFunc(),
+			allBranches:            true,
+		}
+		plan, err := s.Plan(context.Background())
+		require.NoError(t, err)
+		assertPlanCommits(t, plan, map[string]int{
+			"main": 4,
+			"bar":  2,
+			"baz":  1,
+			"foo":  2,
+		})
+		assert.Empty(t, mockBSRChecker.syncedCommitsSHAs, "planning never syncs")
+		for _, branchPlan := range plan.Branches {
+			assert.Empty(t, branchPlan.SyncPoints)
+			for _, commitPlan := range branchPlan.Commits {
+				assert.Equal(t, []Module{moduleToSync}, commitPlan.Modules)
+			}
+		}
+	})
+	t.Run("when_resumed", func(t *testing.T) {
+		// main is synced up to its second to last commit
+		mockBSRChecker := newMockSyncGitChecker()
+		for _, commit := range mainCommits[1:] {
+			mockBSRChecker.markSynced(commit.Hash().Hex())
+		}
+		syncPoint := mainCommits[1].Hash()
+		s := syncer{
+			logger:                 zap.NewNop(),
+			repo:                   repo,
+			errorHandler:           &mockErrorHandler{},
+			modulesToSync:          []Module{moduleToSync},
+			syncedGitCommitChecker: mockBSRChecker.checkFunc(),
+			syncPointResolver: func(_ context.Context, _ bufmoduleref.ModuleIdentity, branch string) (git.Hash, error) {
+				if branch == "main" {
+					return syncPoint, nil
+				}
+				return nil, nil
+			},
+			allBranches: true,
+		}
+		plan, err := s.Plan(context.Background())
+		require.NoError(t, err)
+		assertPlanCommits(t, plan, map[string]int{
+			"main": 1,
+			"bar":  2,
+			"baz":  1,
+			"foo":  2,
+		})
+		require.Len(t, plan.Branches[0].Commits, 1)
+		assert.Equal(t, mainCommits[0].Hash().Hex(), plan.Branches[0].Commits[0].Commit.Hash().Hex())
+		require.Len(t, plan.Branches[0].SyncPoints, 1)
+		assert.Equal(t, syncPoint.Hex(), plan.Branches[0].SyncPoints[moduleToSync].Hex())
+	})
+}
+
+// assertPlanCommits asserts the branches in the plan are sorted as Sync would sync them, and that
+// each branch has the expected amount of commits.
+func assertPlanCommits(t *testing.T, plan SyncPlan, expectedCommitsByBranch map[string]int) {
+	t.Helper()
+	require.Len(t, plan.Branches, len(expectedCommitsByBranch))
+	assert.Equal(t, "main", plan.Branches[0].Branch, "default branch goes first")
+	for i, branchPlan := range plan.Branches {
+		if i > 1 {
+			assert.Less(t, plan.Branches[i-1].Branch, branchPlan.Branch)
+		}
+		assert.Len(t, branchPlan.Commits, expectedCommitsByBranch[branchPlan.Branch], "branch %q", branchPlan.Branch)
+	}
+}
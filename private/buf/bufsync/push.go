@@ -0,0 +1,444 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bufbuild/buf/private/pkg/git"
+	"github.com/bufbuild/buf/private/pkg/manifest"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// remoteSyncedCommit is a git commit already synced in the BSR for a module in a branch.
+type remoteSyncedCommit struct {
+	module     Module
+	branch     string
+	commitHash string
+}
+
+// syncBranch syncs all modules in a branch.
+func (s *syncer) syncBranch(
+	ctx context.Context,
+	branch string,
+	modulesSyncPoints map[Module]git.Hash,
+	syncFunc SyncFunc,
+) (retErr error) {
+	ctx, span := s.tracer.Start(ctx, "sync_branch", trace.WithAttributes(attribute.String("branch", branch)))
+	defer func() {
+		endSpan(span, retErr)
+	}()
+	traversalCtx, traversalSpan := s.tracer.Start(ctx, "commits_to_sync")
+	commitsToSync, err := s.commitsToSync(traversalCtx, branch, modulesSyncPoints)
+	traversalSpan.SetAttributes(attribute.Int("commit_count", len(commitsToSync)))
+	endSpan(traversalSpan, err)
+	if err != nil {
+		return fmt.Errorf("finding commits to sync: %w", err)
+	}
+	if err := s.checkFailedBranchesDependency(commitsToSync); err != nil {
+		return err
+	}
+	if err := s.verifyRemoteSyncedCommits(ctx); err != nil {
+		return fmt.Errorf("verify remote content: %w", err)
+	}
+	if len(commitsToSync) == 0 {
+		s.logger.Debug(
+			"modules already up to date in branch",
+			zap.String("branch", branch),
+		)
+		return nil
+	}
+	return s.syncCommits(ctx, branch, commitsToSync, syncFunc)
+}
+
+// syncCommits syncs the modules pending to sync in each commit, in order.
+func (s *syncer) syncCommits(
+	ctx context.Context,
+	branch string,
+	commitsToSync []syncableCommit,
+	syncFunc SyncFunc,
+) error {
+	s.deletedModules = make(map[Module]struct{})
+	if s.buildPipelineDepth > 0 {
+		s.buildPipeline = s.startBuildPipeline(ctx, commitsToSync)
+		defer func() {
+			s.buildPipeline.close()
+			s.buildPipeline = nil
+		}()
+	}
+	// checkpoints are the module commits synced since the last checkpoint of each module.
+	checkpoints := make(map[Module]pendingCheckpoint)
+	for _, commitToSync := range commitsToSync {
+		isIncluded := s.commitFilterFunc(commitToSync.commit)
+		for _, module := range s.modulesToSync { // looping over the original sort order of modules
+			if _, shouldSyncModule := commitToSync.modules[module]; !shouldSyncModule {
+				continue
+			}
+			shouldSkip, err := s.shouldSkipUnchangedCommit(commitToSync.commit, module)
+			if err != nil {
+				return fmt.Errorf("check if module %q changed in commit %q: %w", module.String(), commitToSync.commit.Hash().Hex(), err)
+			}
+			if shouldSkip {
+				s.logger.Debug(
+					"module unchanged, skipping commit",
+					zap.String("branch", branch),
+					zap.Stringer("commit", commitToSync.commit.Hash()),
+					zap.Stringer("module", module),
+				)
+				s.metrics.record(ctx, s.metrics.skippedCommits, module, branch)
+				// Unchanged commits are skipped in every branch, there is no need to check them again.
+				if err := s.markGitCommitProcessed(module, branch, commitToSync.commit.Hash().Hex()); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, deleted := s.deletedModules[module]; deleted {
+				s.logger.Debug(
+					"module deleted earlier in branch, skipping commit",
+					zap.String("branch", branch),
+					zap.Stringer("commit", commitToSync.commit.Hash()),
+					zap.Stringer("module", module),
+				)
+				s.metrics.record(ctx, s.metrics.skippedCommits, module, branch)
+				continue
+			}
+			included, err := isIncluded()
+			if err != nil {
+				return err
+			}
+			if !included {
+				s.logger.Debug(
+					"commit filtered out, skipping commit",
+					zap.String("branch", branch),
+					zap.Stringer("commit", commitToSync.commit.Hash()),
+					zap.Stringer("module", module),
+				)
+				s.metrics.record(ctx, s.metrics.skippedCommits, module, branch)
+				continue
+			}
+			if err := s.checkInterrupted(ctx); err != nil {
+				return fmt.Errorf("stop before module %q in commit %q: %w", module.String(), commitToSync.commit.Hash().Hex(), err)
+			}
+			syncModuleCtx := ctx
+			if s.interruptCheckpoint {
+				// the module commit is fully synced, even if interrupted meanwhile
+				syncModuleCtx = uncanceledContext{Context: ctx}
+			}
+			if err := s.syncModule(syncModuleCtx, branch, commitToSync.commit, module, syncFunc); err != nil {
+				return fmt.Errorf("sync module %q in commit %q: %w", module.String(), commitToSync.commit.Hash().Hex(), err)
+			}
+			if err := s.markGitCommitProcessed(module, branch, commitToSync.commit.Hash().Hex()); err != nil {
+				return err
+			}
+			if s.checkpointHook != nil {
+				checkpoint := checkpoints[module]
+				checkpoint.commits++
+				checkpoint.commitHash = commitToSync.commit.Hash()
+				checkpoints[module] = checkpoint
+				// when backfilling head first, the history is only fully synced at the end of the branch
+				if !s.headFirstBackfill && checkpoint.commits >= s.checkpointIntervalOrDefault() {
+					if err := s.checkpointHook(ctx, module, branch, checkpoint.commitHash); err != nil {
+						return fmt.Errorf("checkpoint module %q in commit %q: %w", module.String(), checkpoint.commitHash.Hex(), err)
+					}
+					delete(checkpoints, module)
+				}
+			}
+		}
+	}
+	if s.headFirstBackfill && len(checkpoints) > 0 {
+		// the commits of the branch are synced up to its HEAD commit, including the ones synced ahead
+		// of the sync point by an interrupted run
+		headCommit, err := s.headCommit(branch)
+		if err != nil {
+			return fmt.Errorf("read HEAD commit for branch %q: %w", branch, err)
+		}
+		for module, checkpoint := range checkpoints {
+			checkpoint.commitHash = headCommit.Hash()
+			checkpoints[module] = checkpoint
+		}
+	}
+	for _, module := range s.modulesToSync {
+		checkpoint, ok := checkpoints[module]
+		if !ok {
+			continue
+		}
+		if err := s.checkpointHook(ctx, module, branch, checkpoint.commitHash); err != nil {
+			return fmt.Errorf("checkpoint module %q in commit %q: %w", module.String(), checkpoint.commitHash.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// pendingCheckpoint is the last module commit synced for a module since its last checkpoint.
+type pendingCheckpoint struct {
+	commits    int
+	commitHash git.Hash
+}
+
+// checkpointIntervalOrDefault returns the configured checkpoint interval, defaulting to a checkpoint
+// after every module commit synced.
+func (s *syncer) checkpointIntervalOrDefault() int {
+	if s.checkpointInterval == 0 {
+		return 1
+	}
+	return s.checkpointInterval
+}
+
+// checkInterrupted returns an error with ErrInterrupted in its chain if the syncer is configured with
+// SyncerWithInterruptCheckpoint, and the context is done.
+func (s *syncer) checkInterrupted(ctx context.Context) error {
+	if !s.interruptCheckpoint {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInterrupted, err)
+	}
+	return nil
+}
+
+// uncanceledContext is a context with the values of its parent context, that is never canceled nor
+// reaches a deadline.
+type uncanceledContext struct {
+	context.Context
+}
+
+func (uncanceledContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (uncanceledContext) Done() <-chan struct{} { return nil }
+
+func (uncanceledContext) Err() error { return nil }
+
+// verifyRemoteSyncedCommits verifies the remote content of the commits reported as synced since the
+// last verification, and marks them as processed so they are verified once per run.
+//
+// It does not return errors on mismatches unless the error handler aborts, in which case it returns
+// a *SyncPointError.
+func (s *syncer) verifyRemoteSyncedCommits(ctx context.Context) error {
+	remoteSyncedCommits := s.remoteSyncedCommits
+	s.remoteSyncedCommits = nil
+	for _, remoteSyncedCommit := range remoteSyncedCommits {
+		module, branch := remoteSyncedCommit.module, remoteSyncedCommit.branch
+		identity, err := s.moduleIdentity(module, branch)
+		if err != nil {
+			return err
+		}
+		if _, processed := s.processedGitCommits[identity.IdentityString()][remoteSyncedCommit.commitHash]; processed {
+			continue
+		}
+		if err := s.verifyRemoteContent(ctx, module, branch, remoteSyncedCommit.commitHash); err != nil {
+			return fmt.Errorf("verify module %q in commit %q: %w", module.String(), remoteSyncedCommit.commitHash, err)
+		}
+		if err := s.markGitCommitProcessed(module, branch, remoteSyncedCommit.commitHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyRemoteContent builds the module in an already synced commit, and compares its manifest
+// digest with the remote one. Commits where the module is skipped, or with no remote digest, are not
+// verified.
+func (s *syncer) verifyRemoteContent(ctx context.Context, module Module, branch string, commitHash string) error {
+	hash, err := git.NewHashFromHex(commitHash)
+	if err != nil {
+		return fmt.Errorf("parse commit hash: %w", err)
+	}
+	commit, err := s.repo.Objects().Commit(hash)
+	if err != nil {
+		return fmt.Errorf("read commit: %w", err)
+	}
+	identity, err := s.moduleIdentity(module, branch)
+	if err != nil {
+		return err
+	}
+	remoteDigest, err := s.remoteContentDigestResolver(ctx, identity, hash)
+	if err != nil {
+		return fmt.Errorf("resolve remote content digest: %w", err)
+	}
+	if remoteDigest == nil {
+		s.logger.Debug(
+			"no remote content digest, skipping verification",
+			zap.String("branch", branch),
+			zap.Stringer("commit", hash),
+			zap.Stringer("module", module),
+		)
+		return nil
+	}
+	moduleBucket, err := s.buildModuleBucket(ctx, branch, commit, module)
+	if err != nil {
+		return err
+	}
+	if moduleBucket == nil {
+		return nil
+	}
+	moduleCommit, err := s.transformModuleCommit(ctx, branch, commit, module, moduleBucket)
+	if err != nil {
+		return err
+	}
+	localDigest, err := moduleCommitDigest(ctx, moduleCommit)
+	if err != nil {
+		return err
+	}
+	if localDigest.Equal(*remoteDigest) {
+		return nil
+	}
+	if err := s.errorHandler.RemoteContentMismatch(module, commit, localDigest, remoteDigest); err != nil {
+		return &SyncPointError{Module: module, Branch: branch, SyncPoint: hash, Err: err}
+	}
+	s.logger.Warn(
+		"remote content mismatch, keeping commit as synced",
+		zap.String("branch", branch),
+		zap.Stringer("commit", hash),
+		zap.Stringer("module", module),
+		zap.Stringer("local_digest", localDigest),
+		zap.Stringer("remote_digest", remoteDigest),
+	)
+	return nil
+}
+
+// markGitCommitProcessed marks a git commit as processed in this run, for the identity the module is
+// synced to in the branch.
+func (s *syncer) markGitCommitProcessed(module Module, branch string, commitHash string) error {
+	identity, err := s.moduleIdentity(module, branch)
+	if err != nil {
+		return err
+	}
+	if s.processedGitCommits == nil {
+		s.processedGitCommits = make(map[string]map[string]struct{})
+	}
+	if s.processedGitCommits[identity.IdentityString()] == nil {
+		s.processedGitCommits[identity.IdentityString()] = make(map[string]struct{})
+	}
+	s.processedGitCommits[identity.IdentityString()][commitHash] = struct{}{}
+	return nil
+}
+
+// syncModule looks for the module in the commit, and if found tries to validate it. If it is valid,
+// it applies the bucket transformers to the built module bucket, and invokes `syncFunc`.
+//
+// It does not return errors on invalid modules or unverified commits unless the error handler
+// aborts, in which case it returns a *BuildError or a *PolicyError, but it will return any errors
+// from `syncFunc` as a *PushError as those may be transient.
+//
+// It records the module commit as synced, skipped, or failed in the metrics.
+func (s *syncer) syncModule(
+	ctx context.Context,
+	branch string,
+	commit git.Commit,
+	module Module,
+	syncFunc SyncFunc,
+) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			s.metrics.record(ctx, s.metrics.failedCommits, module, branch)
+		}
+	}()
+	moduleBucket, err := s.buildModuleBucket(ctx, branch, commit, module)
+	if err != nil {
+		return err
+	}
+	if moduleBucket == nil {
+		return nil
+	}
+	moduleCommit, err := s.transformModuleCommit(ctx, branch, commit, module, moduleBucket)
+	if err != nil {
+		return err
+	}
+	if s.remoteLabelDigestResolver != nil {
+		isIdentical, err := s.isIdenticalToRemote(ctx, moduleCommit)
+		if err != nil {
+			return err
+		}
+		if isIdentical {
+			s.logger.Debug(
+				"module content identical to remote, skipping push",
+				zap.String("branch", branch),
+				zap.Stringer("commit", commit.Hash()),
+				zap.Stringer("module", module),
+			)
+			if s.identicalRemoteSkipFunc != nil {
+				s.identicalRemoteSkipFunc(ctx, moduleCommit)
+			}
+			s.metrics.record(ctx, s.metrics.skippedCommits, module, branch)
+			return nil
+		}
+	}
+	if s.rateLimiter != nil {
+		if err := s.rateLimiter.wait(ctx); err != nil {
+			return fmt.Errorf("wait for rate limit: %w", err)
+		}
+	}
+	if s.lazyBuckets {
+		// the bucket streams from the git object store, which is read for the next commits once the
+		// SyncFunc returns
+		scopedBucket := newScopedReadBucket(moduleCommit.Bucket())
+		defer scopedBucket.close()
+		moduleCommit = &scopedBucketModuleCommit{ModuleCommit: moduleCommit, bucket: scopedBucket}
+	}
+	pushCtx, pushSpan := s.tracer.Start(
+		ctx,
+		"push_module_commit",
+		moduleCommitAttributes(moduleCommit.Identity().IdentityString(), branch, commit),
+	)
+	err = syncFunc(pushCtx, moduleCommit)
+	endSpan(pushSpan, err)
+	if err != nil {
+		return &PushError{Module: module, Branch: branch, Commit: commit.Hash(), Err: err}
+	}
+	s.metrics.record(ctx, s.metrics.syncedCommits, module, branch)
+	return nil
+}
+
+// isIdenticalToRemote returns true if the module commit content is identical to the remote content of
+// all its target labels, the commit label and its tags, so pushing it would not change the BSR.
+func (s *syncer) isIdenticalToRemote(ctx context.Context, moduleCommit ModuleCommit) (bool, error) {
+	localDigest, err := moduleCommitDigest(ctx, moduleCommit)
+	if err != nil {
+		return false, err
+	}
+	for _, label := range append([]string{moduleCommit.Label()}, moduleCommit.Tags()...) {
+		remoteDigest, err := s.remoteLabelDigestResolver(ctx, moduleCommit.Identity(), label)
+		if err != nil {
+			return false, fmt.Errorf("resolve remote content digest for label %q: %w", label, err)
+		}
+		if remoteDigest == nil || !localDigest.Equal(*remoteDigest) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// moduleCommitDigest returns the manifest digest of the module commit bucket.
+func moduleCommitDigest(ctx context.Context, moduleCommit ModuleCommit) (*manifest.Digest, error) {
+	return bucketDigest(ctx, moduleCommit.Bucket())
+}
+
+// bucketDigest returns the manifest digest of the bucket.
+func bucketDigest(ctx context.Context, bucket storage.ReadBucket) (*manifest.Digest, error) {
+	moduleManifest, _, err := manifest.NewFromBucket(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("build module manifest: %w", err)
+	}
+	manifestBlob, err := moduleManifest.Blob()
+	if err != nil {
+		return nil, fmt.Errorf("build module manifest blob: %w", err)
+	}
+	return manifestBlob.Digest(), nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+	"time"
+)
+
+// rateLimiter paces calls with a token bucket of a single token, refilled at a constant rate.
+type rateLimiter struct {
+	clock Clock
+	// refillInterval is the time it takes to refill the token.
+	refillInterval time.Duration
+	// refilledAt is the time the token is available at. The token is available if it is not after
+	// the current time.
+	refilledAt time.Time
+}
+
+func newRateLimiter(clock Clock, callsPerSecond float64) *rateLimiter {
+	return &rateLimiter{
+		clock:          clock,
+		refillInterval: time.Duration(float64(time.Second) / callsPerSecond),
+	}
+}
+
+// wait blocks until the token is available, and takes it. It returns the context error if the
+// context is done before.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	now := l.clock.Now()
+	if waitDuration := l.refilledAt.Sub(now); waitDuration > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now = <-l.clock.After(waitDuration):
+		}
+	}
+	l.refilledAt = now.Add(l.refillInterval)
+	return nil
+}
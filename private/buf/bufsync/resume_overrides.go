@@ -0,0 +1,68 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
+	"github.com/bufbuild/buf/private/pkg/git"
+)
+
+// parseResumeOverrides parses the resume overrides, one per line in the format
+// `<module-identity> <branch> <git-hash>`, keyed by module identity and branch. Empty lines and lines
+// starting with `#` are ignored.
+func parseResumeOverrides(reader io.Reader) (map[string]map[string]git.Hash, error) {
+	resumeOverrides := make(map[string]map[string]git.Hash)
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected <module-identity> <branch> <git-hash>, got %q", lineNumber, line)
+		}
+		identity, err := bufmoduleref.ModuleIdentityForString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid module identity: %w", lineNumber, err)
+		}
+		branch := fields[1]
+		hash, err := git.NewHashFromHex(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid git hash: %w", lineNumber, err)
+		}
+		if _, ok := resumeOverrides[identity.IdentityString()][branch]; ok {
+			return nil, fmt.Errorf(
+				"line %d: duplicate override for module %s in branch %q",
+				lineNumber,
+				identity.IdentityString(),
+				branch,
+			)
+		}
+		if resumeOverrides[identity.IdentityString()] == nil {
+			resumeOverrides[identity.IdentityString()] = make(map[string]git.Hash)
+		}
+		resumeOverrides[identity.IdentityString()][branch] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return resumeOverrides, nil
+}
@@ -18,31 +18,164 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/bufbuild/buf/private/bufpkg/bufconfig"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmodulebuild"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
 	"github.com/bufbuild/buf/private/pkg/git"
-	"github.com/bufbuild/buf/private/pkg/storage"
 	"github.com/bufbuild/buf/private/pkg/storage/storagegit"
 	"github.com/bufbuild/buf/private/pkg/stringutil"
+	"github.com/bufbuild/buf/private/pkg/uuidutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
 )
 
+// tagRefPrefix is the prefix of the git refs of tags.
+const tagRefPrefix = "refs/tags/"
+
 type syncer struct {
-	logger                    *zap.Logger
-	repo                      git.Repository
-	storageGitProvider        storagegit.Provider
-	errorHandler              ErrorHandler
-	modulesToSync             []Module
-	syncPointResolver         SyncPointResolver
-	syncedGitCommitChecker    SyncedGitCommitChecker
-	moduleDefaultBranchGetter ModuleDefaultBranchGetter
-	allBranches               bool
+	logger                      *zap.Logger
+	repo                        git.Repository
+	storageGitProvider          storagegit.Provider
+	errorHandler                ErrorHandler
+	modulesToSync               []Module
+	syncPointResolver           SyncPointResolver
+	syncedGitCommitChecker      SyncedGitCommitChecker
+	moduleDefaultBranchGetter   ModuleDefaultBranchGetter
+	expectedDefaultBranch       string
+	allBranches                 bool
+	extraRefPatterns            []string
+	mergeCommitPolicy           MergeCommitPolicy
+	bucketTransformers          []BucketTransformer
+	overlayFilesByModuleDir     map[string]map[string][]byte
+	lockRewriter                LockRewriter
+	buildTimeout                time.Duration
+	lintConfig                  *LintConfig
+	maxHistoryDepth             int
+	reanchorOnMissingSyncPoint  bool
+	interruptCheckpoint         bool
+	checkpointInterval          int
+	checkpointHook              CheckpointHook
+	strictModules               bool
+	headFirstBackfill           bool
+	initialSyncThreshold        int
+	initialSyncConfirmer        InitialSyncConfirmer
+	deterministicManifest       bool
+	treeCache                   *treeCache
+	buildPipelineDepth          int
+	moduleBucketBuilder         bufmodulebuild.ModuleBucketBuilder
+	tagsOnlyModuleIdentities    []bufmoduleref.ModuleIdentity
+	moduleOrder                 []bufmoduleref.ModuleIdentity
+	headOnly                    bool
+	tagReconcileOnly            bool
+	tagConflictPolicy           TagConflictPolicy
+	tagResolver                 TagResolver
+	deletedModulePolicy         DeletedModulePolicy
+	pathCollisionPolicy         PathCollisionPolicy
+	clock                       Clock
+	skipUnchangedCommits        bool
+	identityResolver            IdentityResolver
+	commitFilter                CommitFilter
+	signedCommitsKeyring        openpgp.KeyRing
+	sshSigningKeys              []ssh.PublicKey
+	remoteContentDigestResolver RemoteContentDigestResolver
+	remoteLabelDigestResolver   RemoteLabelDigestResolver
+	identicalRemoteSkipFunc     SkipFunc
+	runID                       string
+	gitNotesRefs                []string
+	commitAnnotator             CommitAnnotator
+	continueOnBranchError       bool
+	commitsPerSecond            float64
+	rateLimiter                 *rateLimiter
+	commitLabelMapper           CommitLabelMapper
+	branchNameMapper            BranchNameMapper
+	pathExcludePatterns         []string
+	submodules                  bool
+	lazyBuckets                 bool
+	workspaceDirs               []string
+	// workspaceModulesAdded is true once the modules of the workspace dirs are added to the modules to
+	// sync, on the first sync or plan.
+	workspaceModulesAdded   bool
+	coalesceWindow          time.Duration
+	noDefaultBranchPriority bool
+	tracerProvider          trace.TracerProvider
+	meterProvider           metric.MeterProvider
+	tracer                  trace.Tracer
+	metrics                 *syncMetrics
+	// resumeOverrides are the sync points overriding the SyncPointResolver, keyed by module identity
+	// and branch.
+	resumeOverrides map[string]map[string]git.Hash
+	// localResumePoints are the commits to resume each branch from for all modules, bypassing the
+	// SyncPointResolver and the resume overrides, keyed by branch.
+	localResumePoints map[string]git.Hash
 
 	// scanned information from the repo on sync start
 	tagsByCommitHash map[string][]string
-	branchesToSync   map[string]struct{}
+	// annotatedTagHashes are the hashes of the annotated tag objects, keyed by tag name. Lightweight
+	// tags point to their commits directly, so they are not present.
+	annotatedTagHashes map[string]git.Hash
+	// annotatedTagsByName are the annotated tags read so far, keyed by tag name.
+	annotatedTagsByName map[string]git.AnnotatedTag
+	// notesByCommitHash are the git notes for each commit, keyed by notes ref.
+	notesByCommitHash map[string]map[string]string
+	// annotationsByCommitHash are the annotations returned by the commit annotator for each commit
+	// annotated so far.
+	annotationsByCommitHash map[string]map[string]string
+	branchesToSync          map[string]struct{}
+	// orphanBranches are the branches to sync that share no history with the default branch.
+	orphanBranches map[string]struct{}
+	// commitLabels are the labels mapped by the commit label mapper in this run, keyed by commit hash.
+	commitLabels map[string]string
+	// coalescedCommits are the commits that consecutive commits were coalesced into with
+	// SyncerWithTimeWindowCoalesce in this run, keyed by commit hash.
+	coalescedCommits map[string]coalescedCommit
+	// extraRefHeads are the head commits of the extra refs to sync, keyed by their branch name.
+	extraRefHeads map[string]git.Hash
+	// processedGitCommits are the git commits already synced, or planned to be synced, for each
+	// remote module identity in this run. Commits reachable from multiple branches are only processed
+	// once for the same identity.
+	processedGitCommits map[string]map[string]struct{}
+	// resolvedIdentities are the identities resolved by the identity resolver, keyed by module and
+	// branch.
+	resolvedIdentities map[Module]map[string]bufmoduleref.ModuleIdentity
+	// deletedModules are the modules deleted in the branch being synced, that are not synced in the
+	// rest of the branch commits.
+	deletedModules map[Module]struct{}
+	// remoteSyncedCommits are the git commits reported as synced by the SyncedGitCommitChecker, that
+	// are pending to verify their remote content.
+	remoteSyncedCommits []remoteSyncedCommit
+	// failedBranches are the branches that failed to sync in this run with
+	// SyncerWithContinueOnBranchError, in the order they failed.
+	failedBranches []failedBranch
+	// branchErrs are the combined errors of the failed branches.
+	branchErrs error
+	// buildPipeline is the pipeline building ahead the modules of the commits being synced, if any.
+	buildPipeline *buildPipeline
+}
+
+// coalescedCommit is a commit synced in place of the consecutive commits before it in a branch.
+type coalescedCommit struct {
+	// tags are the tags of the commits coalesced into this commit.
+	tags []string
+	// baseParent is the first parent of the earliest commit coalesced into this commit, or nil if it
+	// has no parents. The module content of the commit is compared against it.
+	baseParent git.Hash
+}
+
+// failedBranch is a branch that failed to sync, with the hashes of all the commits reachable from its
+// HEAD commit, which its dependent branches would sync on top of.
+type failedBranch struct {
+	branch           string
+	reachableCommits map[string]struct{}
 }
 
 func newSyncer(
@@ -53,108 +186,509 @@ func newSyncer(
 	options ...SyncerOption,
 ) (Syncer, error) {
 	s := &syncer{
-		logger:             logger,
-		repo:               repo,
-		storageGitProvider: storageGitProvider,
+		logger:              logger,
+		repo:                repo,
+		storageGitProvider:  storageGitProvider,
+		errorHandler:        errorHandler,
+		clock:               wallClock{},
+		moduleBucketBuilder: bufmodulebuild.NewModuleBucketBuilder(),
+		tracerProvider:      trace.NewNoopTracerProvider(),
+		meterProvider:       noop.NewMeterProvider(),
 	}
 	for _, opt := range options {
 		if err := opt(s); err != nil {
 			return nil, err
 		}
 	}
+	if s.runID == "" {
+		runID, err := uuidutil.New()
+		if err != nil {
+			return nil, fmt.Errorf("generate run ID: %w", err)
+		}
+		s.runID = runID.String()
+	}
+	s.logger = s.logger.With(zap.String("run_id", s.runID))
+	s.tracer = s.tracerProvider.Tracer(instrumentationName)
+	metrics, err := newSyncMetrics(s.meterProvider.Meter(instrumentationName))
+	if err != nil {
+		return nil, fmt.Errorf("create metrics: %w", err)
+	}
+	s.metrics = metrics
+	if s.commitsPerSecond > 0 {
+		s.rateLimiter = newRateLimiter(s.clock, s.commitsPerSecond)
+	}
+	if s.headOnly && s.allBranches {
+		return nil, errors.New("cannot sync only the HEAD commit and all branches at the same time")
+	}
+	if s.headOnly && len(s.extraRefPatterns) > 0 {
+		return nil, errors.New("cannot sync only the HEAD commit and extra refs at the same time")
+	}
+	if s.tagReconcileOnly && s.tagResolver == nil {
+		return nil, errors.New("cannot reconcile only tags without a tag resolver")
+	}
+	if s.tagConflictPolicy == TagConflictPolicyFailIfAnyExists && s.tagResolver == nil {
+		return nil, errors.New("cannot fail on conflicting tags without a tag resolver")
+	}
+	if s.buildPipelineDepth > 0 && s.lazyBuckets {
+		// building ahead reads the git object store while the SyncFunc streams from it
+		return nil, errors.New("cannot pipeline module builds with lazy buckets")
+	}
+	if s.checkpointInterval > 0 && s.checkpointHook == nil {
+		return nil, errors.New("cannot set a checkpoint interval without a checkpoint hook")
+	}
+	if s.headFirstBackfill && s.headOnly {
+		return nil, errors.New("cannot backfill head first and sync only the HEAD commit at the same time")
+	}
+	if s.headFirstBackfill && s.mergeCommitPolicy != MergeCommitPolicyFirstParentOnly {
+		return nil, errors.New("cannot backfill head first with a merge commit policy other than first parent only")
+	}
+	if s.headFirstBackfill && s.checkpointInterval > 0 {
+		// the history is only fully synced once the backfill completes
+		return nil, errors.New("cannot set a checkpoint interval when backfilling head first")
+	}
+	if s.reanchorOnMissingSyncPoint && s.syncedGitCommitChecker == nil {
+		return nil, errors.New("cannot re-anchor missing sync points without a git commit checker")
+	}
+	if s.tagReconcileOnly && s.headOnly {
+		return nil, errors.New("cannot reconcile only tags and sync only the HEAD commit at the same time")
+	}
+	if len(s.workspaceDirs) == 0 {
+		// with workspaces, the modules to sync are only known once the workspaces are read
+		if err := s.prepareModulesToSync(); err != nil {
+			return nil, err
+		}
+	}
 	return s, nil
 }
 
-// resolveSyncPoints resolves sync points for all known modules for the specified branch,
-// returning all modules for which sync points were found, along with their sync points.
-//
-// If a SyncPointResolver is not configured, this returns an empty map immediately.
-func (s *syncer) resolveSyncPoints(ctx context.Context, branch string) (map[Module]git.Hash, error) {
-	syncPoints := map[Module]git.Hash{}
-	// If resumption is not enabled, we can bail early.
-	if s.syncPointResolver == nil {
-		return syncPoints, nil
+// prepareModulesToSync validates and sorts the modules to sync.
+func (s *syncer) prepareModulesToSync() error {
+	if err := s.validateUniqueRemoteIdentities(); err != nil {
+		return err
+	}
+	if err := s.sortModulesToSync(); err != nil {
+		return err
+	}
+	for _, identity := range s.tagsOnlyModuleIdentities {
+		if s.moduleForRemoteIdentity(identity) == nil {
+			return fmt.Errorf("tags only module %s is not configured to sync", identity.IdentityString())
+		}
+	}
+	return nil
+}
+
+// sortModulesToSync sorts the modules to sync in the module order, if any, followed by the unlisted
+// modules in the order they were configured. Modules are synced in this order in every commit.
+func (s *syncer) sortModulesToSync() error {
+	if len(s.moduleOrder) == 0 {
+		return nil
+	}
+	sortedModules := make([]Module, 0, len(s.modulesToSync))
+	sortedIdentities := make(map[string]struct{}, len(s.moduleOrder))
+	for _, identity := range s.moduleOrder {
+		module := s.moduleForRemoteIdentity(identity)
+		if module == nil {
+			return fmt.Errorf("module %s in the module order is not configured to sync", identity.IdentityString())
+		}
+		sortedModules = append(sortedModules, module)
+		sortedIdentities[identity.IdentityString()] = struct{}{}
 	}
 	for _, module := range s.modulesToSync {
-		syncPoint, err := s.resolveSyncPoint(ctx, module, branch)
+		if _, sorted := sortedIdentities[module.RemoteIdentity().IdentityString()]; !sorted {
+			sortedModules = append(sortedModules, module)
+		}
+	}
+	s.modulesToSync = sortedModules
+	return nil
+}
+
+// validateUniqueRemoteIdentities checks that no two modules in distinct dirs are synced to the same
+// remote identity, which would push conflicting content to the same BSR repository.
+func (s *syncer) validateUniqueRemoteIdentities() error {
+	var identities []string
+	dirsByIdentity := make(map[string][]string)
+	for _, module := range s.modulesToSync {
+		identity := module.RemoteIdentity().IdentityString()
+		if _, seen := dirsByIdentity[identity]; !seen {
+			identities = append(identities, identity)
+		}
+		dirsByIdentity[identity] = append(dirsByIdentity[identity], module.Dir())
+	}
+	var validationErrs error
+	for _, identity := range identities {
+		if dirs := dirsByIdentity[identity]; len(dirs) > 1 {
+			validationErrs = multierr.Append(validationErrs, fmt.Errorf(
+				"modules in dirs %s are all synced to the same remote identity %s",
+				stringutil.SliceToHumanStringQuoted(dirs),
+				identity,
+			))
+		}
+	}
+	return validationErrs
+}
+
+// moduleForRemoteIdentity returns the module to sync with the passed remote identity, or nil if there
+// is none.
+func (s *syncer) moduleForRemoteIdentity(identity bufmoduleref.ModuleIdentity) Module {
+	for _, module := range s.modulesToSync {
+		if module.RemoteIdentity().IdentityString() == identity.IdentityString() {
+			return module
+		}
+	}
+	return nil
+}
+
+// moduleIdentity returns the identity of the remote module the module is synced to in a branch. If
+// an identity resolver is configured, the resolved identity is cached for the rest of the run,
+// otherwise it returns the module RemoteIdentity.
+func (s *syncer) moduleIdentity(module Module, branch string) (bufmoduleref.ModuleIdentity, error) {
+	if s.identityResolver == nil {
+		return module.RemoteIdentity(), nil
+	}
+	if identity, ok := s.resolvedIdentities[module][branch]; ok {
+		return identity, nil
+	}
+	identity, err := s.identityResolver(module, branch)
+	if err != nil {
+		return nil, fmt.Errorf("resolve identity for module %q in branch %q: %w", module.String(), branch, err)
+	}
+	if identity == nil {
+		return nil, fmt.Errorf("resolve identity for module %q in branch %q: resolver returned a nil identity", module.String(), branch)
+	}
+	if s.resolvedIdentities == nil {
+		s.resolvedIdentities = make(map[Module]map[string]bufmoduleref.ModuleIdentity)
+	}
+	if s.resolvedIdentities[module] == nil {
+		s.resolvedIdentities[module] = make(map[string]bufmoduleref.ModuleIdentity)
+	}
+	s.resolvedIdentities[module][branch] = identity
+	return identity, nil
+}
+
+func (s *syncer) Sync(ctx context.Context, syncFunc SyncFunc) (retErr error) {
+	ctx, span := s.tracer.Start(ctx, "sync", trace.WithAttributes(attribute.String("run_id", s.runID)))
+	defer func() {
+		endSpan(span, retErr)
+	}()
+	branchesSyncPoints, err := s.prepareSync(ctx)
+	if err != nil {
+		return err
+	}
+	if s.tagReconcileOnly {
+		tagsToReconcile, err := s.tagsToReconcile(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("resolve sync point for module %q in branch %q: %w", module.String(), branch, err)
+			return fmt.Errorf("finding tags to reconcile: %w", err)
 		}
-		if syncPoint != nil {
-			s.logger.Debug(
-				"resolved sync point, will sync after this commit",
-				zap.String("branch", branch),
-				zap.Stringer("module", module),
-				zap.Stringer("syncPoint", syncPoint),
-			)
-			syncPoints[module] = syncPoint
-		} else {
-			s.logger.Debug(
-				"no sync point, syncing all branch",
+		if err := s.syncCommits(ctx, "", tagsToReconcile, syncFunc); err != nil {
+			return fmt.Errorf("reconcile tags: %w", err)
+		}
+		return nil
+	}
+	var unconfirmedBranches map[string]struct{}
+	if s.initialSyncConfirmer != nil {
+		unconfirmedBranches, err = s.confirmInitialSync(ctx, branchesSyncPoints)
+		if err != nil {
+			return fmt.Errorf("confirm initial sync: %w", err)
+		}
+	}
+	defaultBranch := s.repo.DefaultBranch()
+	for _, branch := range s.sortedBranchesToSync() {
+		if s.isBranchFailed(branch) {
+			continue
+		}
+		if _, unconfirmed := unconfirmedBranches[branch]; unconfirmed {
+			s.logger.Warn(
+				"initial sync not confirmed, skipping branch",
 				zap.String("branch", branch),
-				zap.Stringer("module", module),
 			)
+			continue
+		}
+		if err := s.checkInterrupted(ctx); err != nil {
+			return multierr.Append(s.branchErrs, fmt.Errorf("stop before branch %q: %w", branch, err))
+		}
+		branchSyncStart := s.clock.Now()
+		if err := s.syncBranch(ctx, branch, branchesSyncPoints[branch], syncFunc); err != nil {
+			if branch == defaultBranch {
+				err = fmt.Errorf("sync default branch %q: %w", branch, err)
+			} else {
+				err = fmt.Errorf("sync branch %q: %w", branch, err)
+			}
+			if err := s.recordBranchError(ctx, branch, err); err != nil {
+				return err
+			}
+			continue
 		}
+		s.logger.Debug(
+			"branch synced",
+			zap.String("branch", branch),
+			zap.Duration("duration", s.clock.Now().Sub(branchSyncStart)),
+		)
+	}
+	if err := s.checkInterrupted(ctx); err != nil {
+		return multierr.Append(s.branchErrs, fmt.Errorf("stop before tagged commits: %w", err))
+	}
+	taggedCommitsToSync, err := s.taggedCommitsToSync(ctx)
+	if err != nil {
+		return multierr.Append(s.branchErrs, fmt.Errorf("finding tagged commits to sync: %w", err))
+	}
+	if err := s.checkFailedBranchesDependency(taggedCommitsToSync); err != nil {
+		return multierr.Append(s.branchErrs, fmt.Errorf("sync tagged commits: %w", err))
+	}
+	if err := s.verifyRemoteSyncedCommits(ctx); err != nil {
+		return multierr.Append(s.branchErrs, fmt.Errorf("verify tagged commits remote content: %w", err))
 	}
-	return syncPoints, nil
+	if err := s.syncCommits(ctx, "", taggedCommitsToSync, syncFunc); err != nil {
+		return multierr.Append(s.branchErrs, fmt.Errorf("sync tagged commits: %w", err))
+	}
+	return s.branchErrs
 }
 
-// resolveSyncPoint resolves a sync point for a particular module and branch. It assumes
-// that a SyncPointResolver is configured.
-func (s *syncer) resolveSyncPoint(ctx context.Context, module Module, branch string) (git.Hash, error) {
-	syncPoint, err := s.syncPointResolver(ctx, module.RemoteIdentity(), branch)
-	if err != nil {
-		return nil, fmt.Errorf("resolve syncPoint for module %s: %w", module.RemoteIdentity().IdentityString(), err)
+// recordBranchError records the error of a branch that failed to sync, so the rest of the branches
+// can continue to sync. It returns the error back if sync must be aborted instead, because
+// SyncerWithContinueOnBranchError is not configured, or the context is done.
+func (s *syncer) recordBranchError(ctx context.Context, branch string, err error) error {
+	if !s.continueOnBranchError || ctx.Err() != nil {
+		return err
 	}
-	if syncPoint == nil {
-		return nil, nil
+	forEachCommit := s.forEachCommit
+	if s.mergeCommitPolicy != MergeCommitPolicyFirstParentOnly {
+		forEachCommit = s.forEachReachableCommit
 	}
-	// Validate that the commit pointed to by the sync point exists.
-	if _, err := s.repo.Objects().Commit(syncPoint); err != nil {
-		return nil, s.errorHandler.InvalidSyncPoint(module, branch, syncPoint, err)
+	reachableCommits := make(map[string]struct{})
+	if readErr := forEachCommit(branch, func(commit git.Commit) error {
+		reachableCommits[commit.Hash().Hex()] = struct{}{}
+		return nil
+	}); readErr != nil {
+		return multierr.Append(err, fmt.Errorf("read commits of failed branch %q: %w", branch, readErr))
 	}
-	return syncPoint, nil
+	s.logger.Warn(
+		"branch failed to sync, continuing with the rest of branches",
+		zap.String("branch", branch),
+		zap.Error(err),
+	)
+	s.failedBranches = append(s.failedBranches, failedBranch{branch: branch, reachableCommits: reachableCommits})
+	s.branchErrs = multierr.Append(s.branchErrs, err)
+	return nil
 }
 
-func (s *syncer) Sync(ctx context.Context, syncFunc SyncFunc) error {
+// isBranchFailed returns true if the branch already failed to sync in this run.
+func (s *syncer) isBranchFailed(branch string) bool {
+	for _, failedBranch := range s.failedBranches {
+		if failedBranch.branch == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFailedBranchesDependency returns an error if any of the commits to sync is reachable from the
+// HEAD of a branch that failed to sync in this run.
+func (s *syncer) checkFailedBranchesDependency(commitsToSync []syncableCommit) error {
+	for _, failedBranch := range s.failedBranches {
+		for _, commitToSync := range commitsToSync {
+			if _, isReachable := failedBranch.reachableCommits[commitToSync.commit.Hash().Hex()]; isReachable {
+				return fmt.Errorf(
+					"commit %q depends on failed branch %q, skipping",
+					commitToSync.commit.Hash().Hex(),
+					failedBranch.branch,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// prepareSync scans the repo, validates the modules default branches, and resolves the sync points
+// for all branches to sync.
+func (s *syncer) prepareSync(ctx context.Context) (map[string]map[Module]git.Hash, error) {
+	if len(s.workspaceDirs) > 0 && !s.workspaceModulesAdded {
+		if err := s.addWorkspaceModules(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, &ModulesConfigError{Err: err}
+		}
+		if err := s.prepareModulesToSync(); err != nil {
+			return nil, &ModulesConfigError{Err: err}
+		}
+		s.workspaceModulesAdded = true
+	}
 	if err := s.scanRepo(); err != nil {
-		return fmt.Errorf("scan repo: %w", err)
+		return nil, fmt.Errorf("scan repo: %w", err)
+	}
+	s.processedGitCommits = make(map[string]map[string]struct{}, len(s.modulesToSync))
+	s.resolvedIdentities = make(map[Module]map[string]bufmoduleref.ModuleIdentity, len(s.modulesToSync))
+	s.deletedModules = make(map[Module]struct{})
+	s.remoteSyncedCommits = nil
+	s.failedBranches = nil
+	s.branchErrs = nil
+	s.commitLabels = make(map[string]string)
+	s.coalescedCommits = make(map[string]coalescedCommit)
+	if err := s.validateUniqueRemoteBranches(); err != nil {
+		return nil, err
 	}
 	if err := s.validateDefaultBranches(ctx); err != nil {
-		return err
+		return nil, err
+	}
+	if s.tagConflictPolicy == TagConflictPolicyFailIfAnyExists {
+		if err := s.validateNoTagConflicts(ctx); err != nil {
+			return nil, err
+		}
 	}
 	branchesSyncPoints := make(map[string]map[Module]git.Hash)
-	for branch := range s.branchesToSync {
+	if s.tagReconcileOnly {
+		// branches are not synced
+		return branchesSyncPoints, nil
+	}
+	for _, branch := range s.sortedBranchesToSync() {
 		syncPoints, err := s.resolveSyncPoints(ctx, branch)
 		if err != nil {
-			return fmt.Errorf("resolve sync points for branch %q: %w", branch, err)
+			err = fmt.Errorf("resolve sync points for branch %q: %w", branch, err)
+			if err := s.recordBranchError(ctx, branch, err); err != nil {
+				return nil, err
+			}
+			continue
 		}
 		branchesSyncPoints[branch] = syncPoints
 	}
-	// first, default branch, if present
-	defaultBranch := s.repo.DefaultBranch()
-	if _, shouldSyncDefaultBranch := s.branchesToSync[defaultBranch]; shouldSyncDefaultBranch {
-		if err := s.syncBranch(ctx, defaultBranch, branchesSyncPoints[defaultBranch], syncFunc); err != nil {
-			return fmt.Errorf("sync default branch %q: %w", defaultBranch, err)
+	if err := s.validateModulesFound(branchesSyncPoints); err != nil {
+		return nil, err
+	}
+	return branchesSyncPoints, nil
+}
+
+// validateModulesFound checks that every module dir to sync is found in at least one in-scope commit
+// of the branches to sync, from the module sync point in the branch, if any, to the branch HEAD
+// commit, warning about the modules that are not, as they would sync nothing. With
+// SyncerWithStrictModules, an error is returned instead.
+func (s *syncer) validateModulesFound(branchesSyncPoints map[string]map[Module]git.Hash) error {
+	pendingModules := make(map[Module]struct{}, len(s.modulesToSync))
+	for _, module := range s.modulesToSync {
+		pendingModules[module] = struct{}{}
+	}
+	stopLoopErr := errors.New("stop loop")
+	for _, branch := range s.sortedBranchesToSync() {
+		if len(pendingModules) == 0 {
+			break
+		}
+		modulesSyncPoints, ok := branchesSyncPoints[branch]
+		if !ok {
+			// the branch failed to resolve its sync points, and is not synced
+			continue
+		}
+		// branchModules are the modules pending to find in the in-scope commits of the branch
+		branchModules := make(map[Module]struct{}, len(pendingModules))
+		for module := range pendingModules {
+			branchModules[module] = struct{}{}
+		}
+		var visitedCommits int
+		if err := s.forEachCommit(branch, func(commit git.Commit) error {
+			if len(branchModules) == 0 || (s.maxHistoryDepth > 0 && visitedCommits == s.maxHistoryDepth) {
+				return stopLoopErr
+			}
+			visitedCommits++
+			for module := range branchModules {
+				moduleTreeHash, err := s.moduleTreeHash(commit, module)
+				if err != nil {
+					return err
+				}
+				if moduleTreeHash != nil {
+					delete(pendingModules, module)
+					delete(branchModules, module)
+					continue
+				}
+				if syncPoint, ok := modulesSyncPoints[module]; ok && syncPoint.Hex() == commit.Hash().Hex() {
+					// the older commits are already synced
+					delete(branchModules, module)
+				}
+			}
+			return nil
+		}); err != nil && !errors.Is(err, stopLoopErr) {
+			return fmt.Errorf("find modules in branch %q: %w", branch, err)
 		}
 	}
-	// then the rest of the branches, in a deterministic order
-	sortedBranchesToSync := stringutil.MapToSortedSlice(s.branchesToSync)
-	for _, branch := range sortedBranchesToSync {
-		if branch == defaultBranch {
-			continue // default branch already synced
+	var notFoundModules []string
+	for _, module := range s.modulesToSync {
+		if _, notFound := pendingModules[module]; !notFound {
+			continue
 		}
-		if err := s.syncBranch(ctx, branch, branchesSyncPoints[branch], syncFunc); err != nil {
-			return fmt.Errorf("sync branch %q: %w", branch, err)
+		if !s.strictModules {
+			s.logger.Warn(
+				"module dir not found in any commit of the branches to sync, check the module dir",
+				zap.Stringer("module", module),
+			)
+			continue
 		}
+		notFoundModules = append(notFoundModules, module.String())
+	}
+	if len(notFoundModules) > 0 {
+		return fmt.Errorf(
+			"%w in any commit of the branches to sync: %s",
+			ErrModuleNotFound,
+			strings.Join(notFoundModules, ", "),
+		)
 	}
 	return nil
 }
 
+// validateUniqueRemoteBranches checks that the branches to sync are mapped to distinct remote
+// branches, so their sync points do not mix.
+func (s *syncer) validateUniqueRemoteBranches() error {
+	if s.branchNameMapper == nil {
+		return nil
+	}
+	branchesByRemoteBranch := make(map[string]string, len(s.branchesToSync))
+	for _, branch := range s.sortedBranchesToSync() {
+		remoteBranch := s.remoteBranch(branch)
+		if remoteBranch == "" {
+			return fmt.Errorf("branch %q is mapped to an empty remote branch", branch)
+		}
+		if otherBranch, ok := branchesByRemoteBranch[remoteBranch]; ok {
+			return fmt.Errorf("branches %q and %q are both mapped to remote branch %q", otherBranch, branch, remoteBranch)
+		}
+		branchesByRemoteBranch[remoteBranch] = branch
+	}
+	return nil
+}
+
+// remoteBranch returns the BSR branch a git branch is synced to, as mapped by the branch name mapper.
+// An empty branch, for tagged commits not reachable from any synced branch, stays empty.
+func (s *syncer) remoteBranch(branch string) string {
+	if s.branchNameMapper == nil || branch == "" {
+		return branch
+	}
+	return s.branchNameMapper(branch)
+}
+
+// sortedBranchesToSync returns the branches to sync in the order they should be synced: first the
+// default branch, if present, and then the rest of the branches in a deterministic order. With
+// SyncerWithoutDefaultBranchPriority, the default branch is sorted as any other branch.
+func (s *syncer) sortedBranchesToSync() []string {
+	if s.noDefaultBranchPriority {
+		return stringutil.MapToSortedSlice(s.branchesToSync)
+	}
+	defaultBranch := s.repo.DefaultBranch()
+	var sortedBranchesToSync []string
+	if _, shouldSyncDefaultBranch := s.branchesToSync[defaultBranch]; shouldSyncDefaultBranch {
+		sortedBranchesToSync = append(sortedBranchesToSync, defaultBranch)
+	}
+	for _, branch := range stringutil.MapToSortedSlice(s.branchesToSync) {
+		if branch == defaultBranch {
+			continue // default branch already added
+		}
+		sortedBranchesToSync = append(sortedBranchesToSync, branch)
+	}
+	return sortedBranchesToSync
+}
+
 // validateDefaultBranches checks that all modules to sync, are being synced to BSR repositories
-// that have the same default git branch as this repo.
+// that have the same default git branch as this repo. If an identity resolver is configured, every
+// identity resolved for the branches to sync is validated once.
 func (s *syncer) validateDefaultBranches(ctx context.Context) error {
-	expectedDefaultGitBranch := s.repo.DefaultBranch()
+	expectedDefaultGitBranch := s.remoteBranch(s.repo.DefaultBranch())
+	if s.expectedDefaultBranch != "" {
+		expectedDefaultGitBranch = s.expectedDefaultBranch
+	}
 	if s.moduleDefaultBranchGetter == nil {
 		s.logger.Warn(
 			"default branch validation skipped for all modules",
@@ -162,20 +696,24 @@ func (s *syncer) validateDefaultBranches(ctx context.Context) error {
 		)
 		return nil
 	}
+	identities, err := s.identitiesToSync()
+	if err != nil {
+		return err
+	}
 	var validationErr error
-	for _, module := range s.modulesToSync {
-		bsrDefaultBranch, err := s.moduleDefaultBranchGetter(ctx, module.RemoteIdentity())
+	for _, identity := range identities {
+		bsrDefaultBranch, err := s.moduleDefaultBranchGetter(ctx, identity)
 		if err != nil {
 			if errors.Is(err, ErrModuleDoesNotExist) {
 				s.logger.Warn(
 					"default branch validation skipped",
 					zap.String("expected_default_branch", expectedDefaultGitBranch),
-					zap.String("module", module.RemoteIdentity().IdentityString()),
+					zap.String("module", identity.IdentityString()),
 					zap.Error(err),
 				)
 				continue
 			}
-			validationErr = multierr.Append(validationErr, fmt.Errorf("getting bsr module %q default branch: %w", module.RemoteIdentity().IdentityString(), err))
+			validationErr = multierr.Append(validationErr, fmt.Errorf("getting bsr module %q default branch: %w", identity.IdentityString(), err))
 			continue
 		}
 		if bsrDefaultBranch != expectedDefaultGitBranch {
@@ -183,7 +721,7 @@ func (s *syncer) validateDefaultBranches(ctx context.Context) error {
 				validationErr,
 				fmt.Errorf(
 					"remote module %q with default branch %q does not match the git repository's default branch %q, aborting sync",
-					module.RemoteIdentity().IdentityString(), bsrDefaultBranch, expectedDefaultGitBranch,
+					identity.IdentityString(), bsrDefaultBranch, expectedDefaultGitBranch,
 				),
 			)
 		}
@@ -191,162 +729,296 @@ func (s *syncer) validateDefaultBranches(ctx context.Context) error {
 	return validationErr
 }
 
-// syncBranch syncs all modules in a branch.
-func (s *syncer) syncBranch(
-	ctx context.Context,
-	branch string,
-	modulesSyncPoints map[Module]git.Hash,
-	syncFunc SyncFunc,
-) error {
-	commitsToSync, err := s.commitsToSync(ctx, branch, modulesSyncPoints)
-	if err != nil {
-		return fmt.Errorf("finding commits to sync: %w", err)
-	}
-	if len(commitsToSync) == 0 {
-		s.logger.Debug(
-			"modules already up to date in branch",
-			zap.String("branch", branch),
-		)
-		return nil
+// identitiesToSync returns the distinct identities of the remote modules to sync, in the modules and
+// branches sort order.
+func (s *syncer) identitiesToSync() ([]bufmoduleref.ModuleIdentity, error) {
+	if s.identityResolver == nil {
+		identities := make([]bufmoduleref.ModuleIdentity, 0, len(s.modulesToSync))
+		for _, module := range s.modulesToSync {
+			identities = append(identities, module.RemoteIdentity())
+		}
+		return identities, nil
 	}
-	for _, commitToSync := range commitsToSync {
-		for _, module := range s.modulesToSync { // looping over the original sort order of modules
-			if _, shouldSyncModule := commitToSync.modules[module]; !shouldSyncModule {
-				continue
+	var identities []bufmoduleref.ModuleIdentity
+	seenIdentities := make(map[string]struct{})
+	for _, module := range s.modulesToSync {
+		for _, branch := range s.sortedBranchesToSync() {
+			identity, err := s.moduleIdentity(module, branch)
+			if err != nil {
+				return nil, err
 			}
-			if err := s.syncModule(ctx, branch, commitToSync.commit, module, syncFunc); err != nil {
-				return fmt.Errorf("sync module %q in commit %q: %w", module.String(), commitToSync.commit.Hash().Hex(), err)
+			if _, seen := seenIdentities[identity.IdentityString()]; seen {
+				continue
 			}
+			seenIdentities[identity.IdentityString()] = struct{}{}
+			identities = append(identities, identity)
 		}
 	}
-	return nil
+	return identities, nil
 }
 
-// syncableCommit holds the git commit and modules in that commit that need to be synced.
-type syncableCommit struct {
-	commit  git.Commit
-	modules map[Module]struct{}
+// commitFilterFunc returns a func that invokes the commit filter for the commit the first time it is
+// called, and returns the same result afterwards. If no commit filter is configured, the commit is
+// always included.
+func (s *syncer) commitFilterFunc(commit git.Commit) func() (bool, error) {
+	var (
+		evaluated bool
+		included  bool
+		err       error
+	)
+	return func() (bool, error) {
+		if s.commitFilter == nil {
+			return true, nil
+		}
+		if !evaluated {
+			evaluated = true
+			included, err = s.commitFilter(commit)
+			if err != nil {
+				err = fmt.Errorf("filter commit %q: %w", commit.Hash().Hex(), err)
+			}
+		}
+		return included, err
+	}
 }
 
-// commitsToSync returns a sorted commit+modules tuples array that are pending to sync for a branch.
-func (s *syncer) commitsToSync(
-	ctx context.Context,
-	branch string,
-	modulesSyncPoints map[Module]git.Hash,
-) ([]syncableCommit, error) {
-	// First, mark all modules as pending, until its starting sync point is reached. They'll be
-	// removed from this list as its initial sync point is found.
-	pendingModules := make(map[Module]struct{}, len(s.modulesToSync))
-	for _, module := range s.modulesToSync {
-		pendingModules[module] = struct{}{}
+// commitTags returns the tags of a commit, followed by the tags of the commits coalesced into it, if
+// any.
+func (s *syncer) commitTags(commit git.Commit) []string {
+	tags := s.tagsByCommitHash[commit.Hash().Hex()]
+	coalesced, ok := s.coalescedCommits[commit.Hash().Hex()]
+	if !ok || len(coalesced.tags) == 0 {
+		return tags
 	}
-	var commitsToSync []syncableCommit
-	// travel branch commits from HEAD and check if they're already synced, until finding a synced git
-	// commit, or adding them all to be synced
-	stopLoopErr := errors.New("stop loop")
-	if err := s.repo.ForEachCommit(branch, func(commit git.Commit) error {
-		if len(pendingModules) == 0 {
-			// no more pending modules to sync, no need to keep navigating the branch
-			return stopLoopErr
+	return append(append(make([]string, 0, len(tags)+len(coalesced.tags)), tags...), coalesced.tags...)
+}
+
+// commitTagDetails returns the details of the commit tags, in the same order as commitTags. The
+// annotated tag objects are read once per tag.
+func (s *syncer) commitTagDetails(commit git.Commit) ([]TagDetail, error) {
+	tags := s.commitTags(commit)
+	tagDetails := make([]TagDetail, 0, len(tags))
+	for _, tag := range tags {
+		tagHash, isAnnotated := s.annotatedTagHashes[tag]
+		if !isAnnotated {
+			tagDetails = append(tagDetails, TagDetail{Name: tag})
+			continue
 		}
-		commitHash := commit.Hash().Hex()
-		modulesToSyncInThisCommit := make(map[Module]struct{})
-		modulesFoundSyncPointInThisCommit := make(map[Module]struct{})
-		for module := range pendingModules {
-			// TODO do this in a paginated fashion
-			isSynced, err := s.isGitCommitSynced(ctx, module, commitHash)
+		annotatedTag, ok := s.annotatedTagsByName[tag]
+		if !ok {
+			var err error
+			annotatedTag, err = s.repo.Objects().Tag(tagHash)
 			if err != nil {
-				return fmt.Errorf("check if module %q already synced git commit %q: %w", module.String(), commitHash, err)
+				return nil, fmt.Errorf("read annotated tag %q: %w", tag, err)
 			}
-			if !isSynced {
-				modulesToSyncInThisCommit[module] = struct{}{}
-				continue
+			if s.annotatedTagsByName == nil {
+				s.annotatedTagsByName = make(map[string]git.AnnotatedTag)
 			}
-			// reached a commit that is already synced for this module
-			modulesFoundSyncPointInThisCommit[module] = struct{}{}
-			expectedSyncPoint, ok := modulesSyncPoints[module]
-			if !ok {
-				// this module did not have an expected sync point, we probably reached the beginning of the
-				// branch off another branch that is already synced.
-				continue
-			}
-			if commitHash != expectedSyncPoint.Hex() {
-				if s.repo.DefaultBranch() == branch {
-					// TODO: add details to error message saying: "run again with --force-branch-sync <branch
-					// name>" when we support a flag like that.
-					return fmt.Errorf(
-						"found synced git commit %q for default branch %q, but expected sync point was %q, did you rebase or reset your default branch?",
-						commitHash,
-						branch,
-						expectedSyncPoint,
-					)
+			s.annotatedTagsByName[tag] = annotatedTag
+		}
+		tagDetails = append(tagDetails, TagDetail{
+			Name:    tag,
+			Tagger:  annotatedTag.Tagger(),
+			Message: annotatedTag.Message(),
+		})
+	}
+	return tagDetails, nil
+}
+
+// validateNoTagConflicts returns a *TagConflictError if any git tag in the repository already exists
+// in any of the remote modules to sync, pointing to a different commit.
+func (s *syncer) validateNoTagConflicts(ctx context.Context) error {
+	remoteTagsByModule, err := s.resolveRemoteTags(ctx)
+	if err != nil {
+		return err
+	}
+	taggedCommits, err := s.taggedCommits()
+	if err != nil {
+		return err
+	}
+	for _, commit := range taggedCommits {
+		label, err := s.commitLabel(commit)
+		if err != nil {
+			return err
+		}
+		for _, module := range s.modulesToSync {
+			for _, tag := range s.tagsByCommitHash[commit.Hash().Hex()] {
+				if remoteLabel, ok := remoteTagsByModule[module][tag]; ok && remoteLabel != label {
+					return &TagConflictError{
+						Module:       module,
+						Tag:          tag,
+						Commit:       commit.Hash(),
+						RemoteCommit: remoteLabel,
+					}
 				}
-				// syncing non-default branches from an unexpected sync point can be a common scenario in PRs,
-				// we can just WARN and continue
-				s.logger.Warn(
-					"unexpected_sync_point",
-					zap.String("expected_sync_point", expectedSyncPoint.Hex()),
-					zap.String("found_sync_point", commitHash),
-					zap.String("branch", branch),
-					zap.String("module", module.String()),
-				)
 			}
 		}
-		// clear modules that already found its sync point
-		for module := range modulesFoundSyncPointInThisCommit {
-			delete(pendingModules, module)
-		}
-		if len(modulesToSyncInThisCommit) > 0 {
-			commitsToSync = append(commitsToSync, syncableCommit{
-				commit:  commit,
-				modules: modulesToSyncInThisCommit,
-			})
-		} else {
-			// no modules to sync in this commit, we should not have any pending modules
-			if len(pendingModules) > 0 {
-				return fmt.Errorf(
-					"commit %q has no modules to sync, but still has pending modules %v",
-					commitHash,
-					pendingModules,
-				)
-			}
+	}
+	return nil
+}
+
+// resolveRemoteTags resolves the tags of the remote modules to sync, keyed by module.
+func (s *syncer) resolveRemoteTags(ctx context.Context) (map[Module]map[string]string, error) {
+	remoteTagsByModule := make(map[Module]map[string]string, len(s.modulesToSync))
+	for _, module := range s.modulesToSync {
+		identity, err := s.moduleIdentity(module, "")
+		if err != nil {
+			return nil, err
 		}
-		return nil
-	}); err != nil && !errors.Is(err, stopLoopErr) {
-		return nil, err
+		remoteTags, err := s.tagResolver(ctx, identity)
+		if err != nil {
+			return nil, fmt.Errorf("resolve tags for module %s: %w", identity.IdentityString(), err)
+		}
+		remoteTagsByModule[module] = remoteTags
 	}
-	if len(commitsToSync) == 0 {
-		return nil, nil
+	return remoteTagsByModule, nil
+}
+
+// taggedCommits returns the tagged commits in the repository, sorted by hash.
+func (s *syncer) taggedCommits() ([]git.Commit, error) {
+	taggedCommitHashes := make([]string, 0, len(s.tagsByCommitHash))
+	for commitHash := range s.tagsByCommitHash {
+		taggedCommitHashes = append(taggedCommitHashes, commitHash)
 	}
-	// https://github.com/golang/go/wiki/SliceTricks#reversing
-	for i := len(commitsToSync)/2 - 1; i >= 0; i-- {
-		opp := len(commitsToSync) - 1 - i
-		commitsToSync[i], commitsToSync[opp] = commitsToSync[opp], commitsToSync[i]
+	sort.Strings(taggedCommitHashes)
+	taggedCommits := make([]git.Commit, 0, len(taggedCommitHashes))
+	for _, commitHash := range taggedCommitHashes {
+		hash, err := git.NewHashFromHex(commitHash)
+		if err != nil {
+			return nil, fmt.Errorf("parse tagged commit hash %q: %w", commitHash, err)
+		}
+		commit, err := s.repo.Objects().Commit(hash)
+		if err != nil {
+			return nil, fmt.Errorf("read tagged commit %s: %w", commitHash, err)
+		}
+		taggedCommits = append(taggedCommits, commit)
 	}
-	return commitsToSync, nil
+	return taggedCommits, nil
 }
 
-func (s *syncer) isGitCommitSynced(ctx context.Context, module Module, commitHash string) (bool, error) {
-	if s.syncedGitCommitChecker == nil {
-		return false, nil
+// commitLabel returns the label of the commit, as mapped by the commit label mapper, or its hex hash
+// if none is configured.
+func (s *syncer) commitLabel(commit git.Commit) (string, error) {
+	if s.commitLabelMapper == nil {
+		return commit.Hash().Hex(), nil
+	}
+	if label, ok := s.commitLabels[commit.Hash().Hex()]; ok {
+		return label, nil
 	}
-	syncedCommits, err := s.syncedGitCommitChecker(ctx, module.RemoteIdentity(), map[string]struct{}{commitHash: {}})
+	label, err := s.commitLabelMapper(commit)
 	if err != nil {
-		return false, err
+		return "", fmt.Errorf("map label for commit %q: %w", commit.Hash().Hex(), err)
+	}
+	if label == "" {
+		return "", fmt.Errorf("map label for commit %q: empty label", commit.Hash().Hex())
+	}
+	if s.commitLabels == nil {
+		s.commitLabels = make(map[string]string)
+	}
+	s.commitLabels[commit.Hash().Hex()] = label
+	return label, nil
+}
+
+// headCommit returns the HEAD commit of a branch to sync, which can be a remote branch or an extra
+// ref.
+func (s *syncer) headCommit(branch string) (git.Commit, error) {
+	if headHash, isExtraRef := s.extraRefHeads[branch]; isExtraRef {
+		return s.repo.Objects().Commit(headHash)
 	}
-	_, synced := syncedCommits[commitHash]
-	return synced, nil
+	return s.repo.HEADCommit(branch)
+}
+
+// forEachCommit ranges over the commits of a branch to sync, which can be a remote branch or an
+// extra ref, starting from its HEAD commit and going backwards always choosing the first parent.
+func (s *syncer) forEachCommit(branch string, f func(commit git.Commit) error) error {
+	if _, isExtraRef := s.extraRefHeads[branch]; !isExtraRef {
+		return s.repo.ForEachCommit(branch, f)
+	}
+	currentCommit, err := s.headCommit(branch)
+	if err != nil {
+		return fmt.Errorf("get head commit for ref %q: %w", branch, err)
+	}
+	for {
+		if err := f(currentCommit); err != nil {
+			return err
+		}
+		if len(currentCommit.Parents()) == 0 {
+			return nil
+		}
+		nextCommitHash := currentCommit.Parents()[0]
+		currentCommit, err = s.repo.Objects().Commit(nextCommitHash)
+		if err != nil {
+			return fmt.Errorf("read commit %s: %w", nextCommitHash, err)
+		}
+	}
+}
+
+// forEachReachableCommit ranges over all the commits reachable from the HEAD commit of a branch to
+// sync, traveling all parents of merge commits. Each commit is visited once, before its parents.
+func (s *syncer) forEachReachableCommit(branch string, f func(commit git.Commit) error) error {
+	headCommit, err := s.headCommit(branch)
+	if err != nil {
+		return fmt.Errorf("get head commit for branch %q: %w", branch, err)
+	}
+	visitedCommits := map[string]struct{}{headCommit.Hash().Hex(): {}}
+	pendingCommits := []git.Commit{headCommit}
+	for len(pendingCommits) > 0 {
+		currentCommit := pendingCommits[len(pendingCommits)-1]
+		pendingCommits = pendingCommits[:len(pendingCommits)-1]
+		if err := f(currentCommit); err != nil {
+			return err
+		}
+		// push parents in reverse order, so the first parent is visited first
+		parents := currentCommit.Parents()
+		for i := len(parents) - 1; i >= 0; i-- {
+			if _, visited := visitedCommits[parents[i].Hex()]; visited {
+				continue
+			}
+			visitedCommits[parents[i].Hex()] = struct{}{}
+			parentCommit, err := s.repo.Objects().Commit(parents[i])
+			if err != nil {
+				return fmt.Errorf("read commit %s: %w", parents[i], err)
+			}
+			pendingCommits = append(pendingCommits, parentCommit)
+		}
+	}
+	return nil
 }
 
 // scanRepo gathers repo information and stores it in the syncer, like tags and branches to sync.
 func (s *syncer) scanRepo() error {
+	// Tag refs point to the tag objects of annotated tags, and to the commits of lightweight tags.
+	tagRefHashes := make(map[string]git.Hash)
+	if err := s.repo.ForEachRef(func(ref string, hash git.Hash) error {
+		if strings.HasPrefix(ref, tagRefPrefix) {
+			tagRefHashes[strings.TrimPrefix(ref, tagRefPrefix)] = hash
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("load tag refs: %w", err)
+	}
 	s.tagsByCommitHash = make(map[string][]string)
+	s.annotatedTagHashes = make(map[string]git.Hash)
 	if err := s.repo.ForEachTag(func(tag string, commitHash git.Hash) error {
 		s.tagsByCommitHash[commitHash.Hex()] = append(s.tagsByCommitHash[commitHash.Hex()], tag)
+		if tagRefHash, ok := tagRefHashes[tag]; ok && tagRefHash.Hex() != commitHash.Hex() {
+			s.annotatedTagHashes[tag] = tagRefHash
+		}
 		return nil
 	}); err != nil {
 		return fmt.Errorf("load tags: %w", err)
 	}
+	s.notesByCommitHash = make(map[string]map[string]string)
+	for _, notesRef := range s.gitNotesRefs {
+		if err := s.repo.ForEachNote(notesRef, func(commitHash git.Hash, note string) error {
+			if s.notesByCommitHash[commitHash.Hex()] == nil {
+				s.notesByCommitHash[commitHash.Hex()] = make(map[string]string)
+			}
+			s.notesByCommitHash[commitHash.Hex()][notesRef] = note
+			return nil
+		}); err != nil {
+			return fmt.Errorf("load notes from %q: %w", notesRef, err)
+		}
+	}
 	remoteBranches := make(map[string]struct{})
 	if err := s.repo.ForEachBranch(func(branch string, _ git.Hash) error {
 		remoteBranches[branch] = struct{}{}
@@ -370,65 +1042,132 @@ func (s *syncer) scanRepo() error {
 		s.branchesToSync = map[string]struct{}{currentBranch: {}}
 		s.logger.Debug("current branch", zap.String("name", currentBranch))
 	}
-	return nil
+	if err := s.scanExtraRefs(remoteBranches); err != nil {
+		return err
+	}
+	return s.scanOrphanBranches(remoteBranches)
 }
 
-// syncModule looks for the module in the commit, and if found tries to validate it. If it is valid,
-// it invokes `syncFunc`.
-//
-// It does not return errors on invalid modules, but it will return any errors from `syncFunc` as
-// those may be transient.
-func (s *syncer) syncModule(
-	ctx context.Context,
-	branch string,
-	commit git.Commit,
-	module Module,
-	syncFunc SyncFunc,
-) error {
-	logger := s.logger.With(
-		zap.Stringer("commit", commit.Hash()),
-		zap.Stringer("module", module),
-	)
-	sourceBucket, err := s.storageGitProvider.NewReadBucket(
-		commit.Tree(),
-		storagegit.ReadBucketWithSymlinksIfSupported(),
-	)
-	if err != nil {
-		return err
+// scanOrphanBranches finds the branches to sync that have no merge base with the default branch,
+// such as branches created with `git checkout --orphan`. Orphan branches sync their full history
+// independently of the default branch, so their commits never depend on the default branch commits
+// or sync points.
+func (s *syncer) scanOrphanBranches(remoteBranches map[string]struct{}) error {
+	s.orphanBranches = make(map[string]struct{})
+	defaultBranch := s.repo.DefaultBranch()
+	if _, isDefaultBranchPushedInRemote := remoteBranches[defaultBranch]; !isDefaultBranchPushedInRemote {
+		return nil
 	}
-	sourceBucket = storage.MapReadBucket(sourceBucket, storage.MapOnPrefix(module.Dir()))
-	foundModule, err := bufconfig.ExistingConfigFilePath(ctx, sourceBucket)
-	if err != nil {
-		return err
+	var defaultBranchCommits map[string]struct{}
+	for _, branch := range s.sortedBranchesToSync() {
+		if branch == defaultBranch {
+			continue
+		}
+		if defaultBranchCommits == nil {
+			defaultBranchCommits = make(map[string]struct{})
+			if err := s.forEachReachableCommit(defaultBranch, func(commit git.Commit) error {
+				defaultBranchCommits[commit.Hash().Hex()] = struct{}{}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("read commits of default branch %q: %w", defaultBranch, err)
+			}
+		}
+		stopLoopErr := errors.New("stop loop")
+		if err := s.forEachReachableCommit(branch, func(commit git.Commit) error {
+			if _, isDefaultBranchCommit := defaultBranchCommits[commit.Hash().Hex()]; isDefaultBranchCommit {
+				return stopLoopErr
+			}
+			return nil
+		}); err != nil {
+			if errors.Is(err, stopLoopErr) {
+				continue
+			}
+			return fmt.Errorf("read commits of branch %q: %w", branch, err)
+		}
+		s.logger.Debug(
+			"orphan branch, syncing its history independently of the default branch",
+			zap.String("branch", branch),
+			zap.String("default_branch", defaultBranch),
+		)
+		s.orphanBranches[branch] = struct{}{}
 	}
-	if foundModule == "" {
-		logger.Debug("module not found, skipping commit")
+	return nil
+}
+
+// scanExtraRefs adds the refs matching any of the extra ref patterns to the branches to sync, using
+// the last element of the ref name as the branch name.
+func (s *syncer) scanExtraRefs(remoteBranches map[string]struct{}) error {
+	s.extraRefHeads = make(map[string]git.Hash)
+	if len(s.extraRefPatterns) == 0 {
 		return nil
 	}
-	sourceConfig, err := bufconfig.GetConfigForBucket(ctx, sourceBucket)
-	if err != nil {
-		return s.errorHandler.InvalidModuleConfig(module, commit, err)
-	}
-	if sourceConfig.ModuleIdentity == nil {
-		logger.Debug("unnamed module, skipping commit")
+	extraRefsByBranch := make(map[string]string)
+	if err := s.repo.ForEachRef(func(ref string, hash git.Hash) error {
+		var matches bool
+		for _, pattern := range s.extraRefPatterns {
+			// patterns are validated when the option is set
+			if matches, _ = path.Match(pattern, ref); matches {
+				break
+			}
+		}
+		if !matches {
+			return nil
+		}
+		branch := path.Base(ref)
+		if existingRef, ok := extraRefsByBranch[branch]; ok {
+			return fmt.Errorf("refs %q and %q would both be synced as branch %q", existingRef, ref, branch)
+		}
+		if _, isRemoteBranch := remoteBranches[branch]; isRemoteBranch {
+			return fmt.Errorf("ref %q would be synced as branch %q, which is already a remote branch", ref, branch)
+		}
+		if _, err := s.repo.Objects().Commit(hash); err != nil {
+			return fmt.Errorf("ref %q does not point to a commit: %w", ref, err)
+		}
+		extraRefsByBranch[branch] = ref
+		s.extraRefHeads[branch] = hash
 		return nil
+	}); err != nil {
+		return fmt.Errorf("looping over repo refs: %w", err)
 	}
-	builtModule, err := bufmodulebuild.NewModuleBucketBuilder().BuildForBucket(
-		ctx,
-		sourceBucket,
-		sourceConfig.Build,
-	)
+	branchesToSync := make(map[string]struct{}, len(s.branchesToSync)+len(s.extraRefHeads))
+	for branch := range s.branchesToSync {
+		branchesToSync[branch] = struct{}{}
+	}
+	for branch := range s.extraRefHeads {
+		s.logger.Debug("extra ref", zap.String("ref", extraRefsByBranch[branch]), zap.String("branch", branch))
+		branchesToSync[branch] = struct{}{}
+	}
+	s.branchesToSync = branchesToSync
+	return nil
+}
+
+// moduleTreeHash returns the hash of the module dir in the commit tree, or nil if the module dir is
+// not found.
+func (s *syncer) moduleTreeHash(commit git.Commit, module Module) (git.Hash, error) {
+	if module.Dir() == "." {
+		return commit.Tree(), nil
+	}
+	tree, err := s.repo.Objects().Tree(commit.Tree())
 	if err != nil {
-		return s.errorHandler.BuildFailure(module, commit, err)
-	}
-	return syncFunc(
-		ctx,
-		newModuleCommit(
-			module.RemoteIdentity(),
-			builtModule.Bucket,
-			commit,
-			branch,
-			s.tagsByCommitHash[commit.Hash().Hex()],
-		),
-	)
+		return nil, fmt.Errorf("read tree %s: %w", commit.Tree(), err)
+	}
+	node, err := tree.Descendant(module.Dir(), s.repo.Objects())
+	if err != nil {
+		if errors.Is(err, git.ErrTreeNodeNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("find module dir %q in tree %s: %w", module.Dir(), commit.Tree(), err)
+	}
+	return node.Hash(), nil
+}
+
+// wallClock is a Clock that reads the wall clock time.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
 }
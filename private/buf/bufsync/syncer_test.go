@@ -0,0 +1,4570 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bufbuild/buf/private/buf/bufsync/bufsynctest"
+	"github.com/bufbuild/buf/private/bufpkg/bufcheck/buflint/buflintconfig"
+	"github.com/bufbuild/buf/private/bufpkg/bufconfig"
+	"github.com/bufbuild/buf/private/bufpkg/buflock"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmodulebuild"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleconfig"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
+	"github.com/bufbuild/buf/private/pkg/git"
+	"github.com/bufbuild/buf/private/pkg/manifest"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagegit"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/buf/private/pkg/uuidutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestResolveSyncPointDiverged(t *testing.T) {
+	t.Parallel()
+	// | o-o-x (main, before force push)
+	// |    └o (main, after force push)
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", nil)
+	testRepo.commit("commit 2", nil)
+	divergedSyncPoint := testRepo.commit("commit 3", nil)
+	testRepo.push("main")
+	testRepo.git("reset", "--hard", "HEAD~1")
+	newHead := testRepo.commit("commit 4", nil)
+	testRepo.push("main")
+	repo := testRepo.open()
+	moduleToSync := newTestSyncableModule(t, ".", "buf.test/owner/repo")
+	resolver := func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+		return divergedSyncPoint, nil
+	}
+
+	t.Run("when_handler_aborts", func(t *testing.T) {
+		abortErr := errors.New("abort")
+		errorHandler := &mockErrorHandler{syncPointDivergedErr: abortErr}
+		s := syncer{
+			logger:            zap.NewNop(),
+			repo:              repo,
+			errorHandler:      errorHandler,
+			modulesToSync:     []Module{moduleToSync},
+			syncPointResolver: resolver,
+		}
+		_, err := s.resolveSyncPoints(context.Background(), "main")
+		require.ErrorIs(t, err, abortErr)
+		require.Len(t, errorHandler.syncPointDivergedCalls, 1)
+		assert.Equal(t, divergedSyncPoint.Hex(), errorHandler.syncPointDivergedCalls[0].syncPoint.Hex())
+		assert.Equal(t, newHead.Hex(), errorHandler.syncPointDivergedCalls[0].headHash.Hex())
+	})
+	t.Run("when_handler_continues", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		s := syncer{
+			logger:            zap.NewNop(),
+			repo:              repo,
+			errorHandler:      errorHandler,
+			modulesToSync:     []Module{moduleToSync},
+			syncPointResolver: resolver,
+		}
+		syncPoints, err := s.resolveSyncPoints(context.Background(), "main")
+		require.NoError(t, err)
+		assert.Empty(t, syncPoints)
+		require.Len(t, errorHandler.syncPointDivergedCalls, 1)
+	})
+	t.Run("when_sync_point_is_ancestor", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		s := syncer{
+			logger:        zap.NewNop(),
+			repo:          repo,
+			errorHandler:  errorHandler,
+			modulesToSync: []Module{moduleToSync},
+			syncPointResolver: func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+				return newHead, nil
+			},
+		}
+		syncPoints, err := s.resolveSyncPoints(context.Background(), "main")
+		require.NoError(t, err)
+		require.Len(t, syncPoints, 1)
+		assert.Equal(t, newHead.Hex(), syncPoints[moduleToSync].Hex())
+		assert.Empty(t, errorHandler.syncPointDivergedCalls)
+	})
+}
+
+func TestSyncExtraRefs(t *testing.T) {
+	t.Parallel()
+	// | o-o (main)
+	// |   └o (refs/custom/published/foo)
+	// |    └o (refs/custom/published/bar)
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	mainHead := testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	fooHead := testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	barHead := testRepo.commit("commit 4", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.git("update-ref", "refs/custom/published/foo", fooHead.Hex())
+	testRepo.git("update-ref", "refs/custom/published/bar", barHead.Hex())
+	testRepo.git("update-ref", "refs/custom/unpublished/baz", barHead.Hex())
+	testRepo.git("reset", "--hard", mainHead.Hex())
+	repo := testRepo.open()
+
+	recorder := &syncFuncRecorder{}
+	syncer := newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		SyncerWithExtraRefs("refs/custom/published/*"),
+	)
+	require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+	// foo is fully reachable from bar, which is synced first, so nothing is left to sync in foo.
+	assert.Equal(
+		t,
+		[]string{
+			"main:commit 1",
+			"main:commit 2",
+			"bar:commit 3",
+			"bar:commit 4",
+		},
+		recorder.branchCommitMessages(),
+	)
+
+	_, err := NewSyncer(
+		zap.NewNop(),
+		repo,
+		nil,
+		&mockErrorHandler{},
+		SyncerWithExtraRefs("refs/custom/[published/*"),
+	)
+	require.Error(t, err)
+}
+
+func TestSyncExpectedDefaultBranch(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.push("main")
+	repo := testRepo.open()
+	syncDefaultBranch := func(t *testing.T, options ...SyncerOption) error {
+		return newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithModuleDefaultBranchGetter(func(context.Context, bufmoduleref.ModuleIdentity) (string, error) {
+					return "trunk", nil
+				}),
+			)...,
+		).Sync(context.Background(), (&syncFuncRecorder{}).syncFunc)
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("git_default_branch", func(t *testing.T) {
+		err := syncDefaultBranch(t)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `does not match the git repository's default branch "main"`)
+	})
+	t.Run("expected_default_branch", func(t *testing.T) {
+		require.NoError(t, syncDefaultBranch(t, SyncerWithExpectedDefaultBranch("trunk")))
+	})
+	t.Run("expected_default_branch_mismatch", func(t *testing.T) {
+		err := syncDefaultBranch(t, SyncerWithExpectedDefaultBranch("main"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `with default branch "trunk"`)
+	})
+	t.Run("empty", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithExpectedDefaultBranch(""),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncOrphanBranch(t *testing.T) {
+	t.Parallel()
+	// | o-o (main)
+	// |   └o (feature)
+	// o-o (docs, orphan)
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("checkout", "-b", "feature")
+	testRepo.commit("feature 1", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.git("checkout", "--orphan", "docs")
+	testRepo.git("rm", "-rf", "-q", ".")
+	testRepo.commit("orphan 1", newTestModuleFiles("buf.test/owner/repo", "d"))
+	testRepo.commit("orphan 2", map[string]string{"proto/e.proto": testProtoFile("e")})
+	testRepo.git("checkout", "main")
+	testRepo.push("main", "feature", "docs")
+	repo := testRepo.open()
+	newSyncer := func(t *testing.T, repo git.Repository, options ...SyncerOption) Syncer {
+		return newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithAllBranches(),
+			)...,
+		)
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("plan", func(t *testing.T) {
+		plan, err := newSyncer(t, repo).Plan(context.Background())
+		require.NoError(t, err)
+		orphanBranches := make(map[string]bool)
+		var docsCommits []string
+		for _, branchPlan := range plan.Branches {
+			orphanBranches[branchPlan.Branch] = branchPlan.Orphan
+			if branchPlan.Branch == "docs" {
+				for _, commitPlan := range branchPlan.Commits {
+					docsCommits = append(docsCommits, strings.TrimSpace(commitPlan.Commit.Message()))
+				}
+			}
+		}
+		assert.Equal(t, map[string]bool{"main": false, "feature": false, "docs": true}, orphanBranches)
+		assert.Equal(t, []string{"orphan 1", "orphan 2"}, docsCommits)
+	})
+	t.Run("default_branch_failure", func(t *testing.T) {
+		pushErr := errors.New("push failed")
+		recorder := &syncFuncRecorder{}
+		err := newSyncer(t, repo, SyncerWithContinueOnBranchError()).Sync(
+			context.Background(),
+			func(ctx context.Context, moduleCommit ModuleCommit) error {
+				if strings.TrimSpace(moduleCommit.Commit().Message()) == "commit 2" {
+					return pushErr
+				}
+				return recorder.syncFunc(ctx, moduleCommit)
+			},
+		)
+		require.Error(t, err)
+		// feature depends on the failed default branch, but the orphan branch does not.
+		assert.Equal(t, []string{"main:commit 1", "docs:orphan 1", "docs:orphan 2"}, recorder.branchCommitMessages())
+		branchErrs := multierr.Errors(err)
+		require.Len(t, branchErrs, 2)
+		assert.ErrorIs(t, branchErrs[0], pushErr)
+		assert.Contains(t, branchErrs[1].Error(), `depends on failed branch "main"`)
+	})
+	t.Run("resumption", func(t *testing.T) {
+		mockBSRChecker := newMockSyncGitChecker()
+		syncPoints := make(map[string]git.Hash)
+		resumeSyncer := func(t *testing.T, repo git.Repository) Syncer {
+			return newSyncer(
+				t,
+				repo,
+				SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+				SyncerWithResumption(func(_ context.Context, _ bufmoduleref.ModuleIdentity, branch string) (git.Hash, error) {
+					return syncPoints[branch], nil
+				}),
+			)
+		}
+		recorder := &syncFuncRecorder{}
+		syncFunc := func(ctx context.Context, moduleCommit ModuleCommit) error {
+			mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+			syncPoints[moduleCommit.Branch()] = moduleCommit.Commit().Hash()
+			return recorder.syncFunc(ctx, moduleCommit)
+		}
+		require.NoError(t, resumeSyncer(t, repo).Sync(context.Background(), syncFunc))
+		assert.Equal(
+			t,
+			[]string{"main:commit 1", "main:commit 2", "docs:orphan 1", "docs:orphan 2", "feature:feature 1"},
+			recorder.branchCommitMessages(),
+		)
+		testRepo.git("checkout", "docs")
+		testRepo.commit("orphan 3", map[string]string{"proto/f.proto": testProtoFile("f")})
+		testRepo.git("checkout", "main")
+		testRepo.push("docs")
+		recorder.moduleCommits = nil
+		require.NoError(t, resumeSyncer(t, testRepo.open()).Sync(context.Background(), syncFunc))
+		// the orphan branch resumes from its own sync point
+		assert.Equal(t, []string{"docs:orphan 3"}, recorder.branchCommitMessages())
+	})
+}
+
+func TestSyncWithoutDefaultBranchPriority(t *testing.T) {
+	t.Parallel()
+	// | o-o (main)
+	// |   └o (feature)
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("checkout", "-b", "feature")
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.git("checkout", "main")
+	testRepo.push("main", "feature")
+	repo := testRepo.open()
+	// syncBranches syncs all branches, and returns the synced commits and the planned branches.
+	syncBranches := func(t *testing.T, options ...SyncerOption) ([]string, []string) {
+		options = append(
+			options,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithAllBranches(),
+		)
+		plan, err := newTestSyncer(t, repo, &mockErrorHandler{}, options...).Plan(context.Background())
+		require.NoError(t, err)
+		var plannedBranches []string
+		for _, branchPlan := range plan.Branches {
+			plannedBranches = append(plannedBranches, branchPlan.Branch)
+		}
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(t, repo, &mockErrorHandler{}, options...).Sync(context.Background(), recorder.syncFunc))
+		return recorder.branchCommitMessages(), plannedBranches
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("default_branch_first", func(t *testing.T) {
+		synced, plannedBranches := syncBranches(t)
+		assert.Equal(t, []string{"main", "feature"}, plannedBranches)
+		assert.Equal(t, []string{"main:commit 1", "main:commit 2", "feature:commit 3"}, synced)
+	})
+	t.Run("without_default_branch_priority", func(t *testing.T) {
+		synced, plannedBranches := syncBranches(t, SyncerWithoutDefaultBranchPriority())
+		assert.Equal(t, []string{"feature", "main"}, plannedBranches)
+		// the commits shared with the default branch are synced with the first branch reaching them
+		assert.Equal(t, []string{"feature:commit 1", "feature:commit 2", "feature:commit 3"}, synced)
+	})
+}
+
+func TestSyncLogsModuleResolution(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", map[string]string{"README.md": "# repo"})
+	testRepo.commit("commit 2", map[string]string{"proto/a.proto": testProtoFile("a")})
+	testRepo.commit("commit 3", newTestModuleFiles("buf.test/owner/repo", "b"))
+	testRepo.push("main")
+	repo := testRepo.open()
+
+	core, logs := observer.New(zap.DebugLevel)
+	syncer, err := NewSyncer(
+		zap.New(core),
+		repo,
+		storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/override")),
+		SyncerWithRunID("test-run"),
+	)
+	require.NoError(t, err)
+	require.NoError(t, syncer.Sync(context.Background(), (&syncFuncRecorder{}).syncFunc))
+	resolutions := logs.FilterMessage("module resolution").AllUntimed()
+	require.Len(t, resolutions, 3)
+	assert.Equal(t, map[string]interface{}{
+		"commit":          resolutions[0].ContextMap()["commit"],
+		"module":          resolutions[0].ContextMap()["module"],
+		"dir_found":       false,
+		"config_file":     "",
+		"config_identity": "",
+		"remote_identity": "",
+		"file_count":      int64(0),
+		"skip_reason":     "module not found",
+		"run_id":          "test-run",
+	}, resolutions[0].ContextMap())
+	assert.Equal(t, true, resolutions[1].ContextMap()["dir_found"])
+	assert.Equal(t, "module not found", resolutions[1].ContextMap()["skip_reason"])
+	synced := resolutions[2].ContextMap()
+	assert.Equal(t, true, synced["dir_found"])
+	assert.Equal(t, "buf.yaml", synced["config_file"])
+	assert.Equal(t, "buf.test/owner/repo", synced["config_identity"])
+	assert.Equal(t, "buf.test/owner/override", synced["remote_identity"])
+	assert.Equal(t, int64(3), synced["file_count"]) // a.proto, b.proto and buf.yaml
+	assert.NotContains(t, synced, "skip_reason")
+}
+
+func TestSyncMergeCommitPolicy(t *testing.T) {
+	t.Parallel()
+	// | o-o-o-----o (main)
+	// |  └o-o (feature)┘
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("branch", "feature")
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("checkout", "feature")
+	testRepo.commit("feature 1", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.commit("feature 2", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.git("checkout", "main")
+	testRepo.commit("commit 3", map[string]string{"proto/e.proto": testProtoFile("e")})
+	testRepo.git("merge", "--no-ff", "-m", "merge feature", "feature")
+	testRepo.push("main")
+	repo := testRepo.open()
+
+	type testCase struct {
+		name            string
+		policy          MergeCommitPolicy
+		expectedCommits []string
+	}
+	testCases := []testCase{
+		{
+			name:   "first_parent_only",
+			policy: MergeCommitPolicyFirstParentOnly,
+			expectedCommits: []string{
+				"main:commit 1",
+				"main:commit 2",
+				"main:commit 3",
+				"main:merge feature",
+			},
+		},
+		{
+			name:   "include",
+			policy: MergeCommitPolicyInclude,
+			expectedCommits: []string{
+				"main:commit 1",
+				"main:commit 2",
+				"main:commit 3",
+				"main:feature 1",
+				"main:feature 2",
+				"main:merge feature",
+			},
+		},
+		{
+			name:   "skip",
+			policy: MergeCommitPolicySkip,
+			expectedCommits: []string{
+				"main:commit 1",
+				"main:commit 2",
+				"main:commit 3",
+				"main:feature 1",
+				"main:feature 2",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		func(tc testCase) {
+			// not running in parallel, the subtests share the same repository
+			t.Run(tc.name, func(t *testing.T) {
+				recorder := &syncFuncRecorder{}
+				mockBSRChecker := newMockSyncGitChecker()
+				syncFunc := func(ctx context.Context, moduleCommit ModuleCommit) error {
+					mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+					return recorder.syncFunc(ctx, moduleCommit)
+				}
+				newPolicySyncer := func() Syncer {
+					return newTestSyncer(
+						t,
+						repo,
+						&mockErrorHandler{},
+						SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+						SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+						SyncerWithMergeCommitPolicy(tc.policy),
+					)
+				}
+				require.NoError(t, newPolicySyncer().Sync(context.Background(), syncFunc))
+				assert.Equal(t, tc.expectedCommits, recorder.branchCommitMessages())
+				// syncing again is a no-op
+				require.NoError(t, newPolicySyncer().Sync(context.Background(), syncFunc))
+				assert.Equal(t, tc.expectedCommits, recorder.branchCommitMessages())
+			})
+		}(tc)
+	}
+
+	_, err := NewSyncer(
+		zap.NewNop(),
+		repo,
+		nil,
+		&mockErrorHandler{},
+		SyncerWithMergeCommitPolicy(MergeCommitPolicy(42)),
+	)
+	require.Error(t, err)
+}
+
+func TestSyncMergeCommitPolicyIncludeSyncPoint(t *testing.T) {
+	t.Parallel()
+	// | o-o-o-----o (main)
+	// |  └o-o (feature)┘
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("branch", "feature")
+	commit2 := testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("checkout", "feature")
+	feature1 := testRepo.commit("feature 1", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.commit("feature 2", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.git("checkout", "main")
+	commit3 := testRepo.commit("commit 3", map[string]string{"proto/e.proto": testProtoFile("e")})
+	testRepo.git("merge", "--no-ff", "-m", "merge feature", "feature")
+	testRepo.push("main")
+	repo := testRepo.open()
+	// the merged histories are synced up to commit 3 and feature 1
+	mockBSRChecker := newMockSyncGitChecker()
+	mockBSRChecker.markSynced(commit2.Hex())
+	mockBSRChecker.markSynced(commit3.Hex())
+	mockBSRChecker.markSynced(feature1.Hex())
+	syncWithSyncPoint := func(t *testing.T, syncPoint git.Hash) ([]string, error) {
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithMergeCommitPolicy(MergeCommitPolicyInclude),
+			SyncerWithResumption(func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+				return syncPoint, nil
+			}),
+		).Sync(context.Background(), recorder.syncFunc)
+		return recorder.branchCommitMessages(), err
+	}
+
+	t.Run("sync_point_reached", func(t *testing.T) {
+		t.Parallel()
+		// feature 1 is synced too, but commit 3 is still reached in the other merged history
+		synced, err := syncWithSyncPoint(t, commit3)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"main:feature 2", "main:merge feature"}, synced)
+	})
+	t.Run("stale_sync_point", func(t *testing.T) {
+		t.Parallel()
+		synced, err := syncWithSyncPoint(t, commit2)
+		assert.ErrorContains(t, err, "did you rebase or reset your default branch?")
+		var syncPointErr *SyncPointError
+		require.ErrorAs(t, err, &syncPointErr)
+		assert.Equal(t, commit2.Hex(), syncPointErr.SyncPoint.Hex())
+		assert.Empty(t, synced)
+	})
+}
+
+func TestSyncErrors(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	validCommit := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	invalidCommit := testRepo.commit("commit 2", map[string]string{"proto/buf.yaml": "version: v42\n"})
+	testRepo.push("main")
+	repo := testRepo.open()
+	moduleToSync := newTestSyncableModule(t, "proto", "buf.test/owner/repo")
+	abortErr := errors.New("abort")
+
+	t.Run("build_error", func(t *testing.T) {
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{invalidModuleConfigErr: abortErr},
+			SyncerWithModule(moduleToSync),
+		)
+		err := syncer.Sync(context.Background(), (&syncFuncRecorder{}).syncFunc)
+		require.ErrorIs(t, err, abortErr)
+		var buildErr *BuildError
+		require.ErrorAs(t, err, &buildErr)
+		assert.Equal(t, moduleToSync, buildErr.Module)
+		assert.Equal(t, "main", buildErr.Branch)
+		assert.Equal(t, invalidCommit.Hex(), buildErr.Commit.Hex())
+	})
+	t.Run("push_error", func(t *testing.T) {
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(moduleToSync),
+		)
+		err := syncer.Sync(context.Background(), func(context.Context, ModuleCommit) error {
+			return abortErr
+		})
+		require.ErrorIs(t, err, abortErr)
+		var pushErr *PushError
+		require.ErrorAs(t, err, &pushErr)
+		assert.Equal(t, moduleToSync, pushErr.Module)
+		assert.Equal(t, "main", pushErr.Branch)
+		assert.Equal(t, validCommit.Hex(), pushErr.Commit.Hex())
+	})
+	t.Run("sync_point_error", func(t *testing.T) {
+		unknownSyncPoint, err := git.NewHashFromHex("0123456789abcdef0123456789abcdef01234567")
+		require.NoError(t, err)
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{invalidSyncPointErr: abortErr},
+			SyncerWithModule(moduleToSync),
+			SyncerWithResumption(func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+				return unknownSyncPoint, nil
+			}),
+		)
+		err = syncer.Sync(context.Background(), (&syncFuncRecorder{}).syncFunc)
+		require.ErrorIs(t, err, abortErr)
+		var syncPointErr *SyncPointError
+		require.ErrorAs(t, err, &syncPointErr)
+		assert.Equal(t, moduleToSync, syncPointErr.Module)
+		assert.Equal(t, "main", syncPointErr.Branch)
+		assert.Equal(t, unknownSyncPoint.Hex(), syncPointErr.SyncPoint.Hex())
+	})
+}
+
+func TestSyncReanchorOnMissingSyncPoint(t *testing.T) {
+	t.Parallel()
+	// | o-x (main, before the rebase)
+	// |  \
+	// |   o-o (main)
+	testRepo := newTestGitRepository(t)
+	syncedCommit := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	droppedCommit := testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("reset", "--hard", "HEAD~1")
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.commit("commit 4", map[string]string{"proto/d.proto": testProtoFile("d")})
+	// the dropped commit is garbage collected
+	testRepo.git("reflog", "expire", "--expire=now", "--all")
+	testRepo.git("gc", "--prune=now")
+	testRepo.push("main")
+	repo := testRepo.open()
+	checker := newMockSyncGitChecker()
+	checker.markSynced(syncedCommit.Hex())
+	checker.markSynced(droppedCommit.Hex())
+	invalidSyncPointErr := errors.New("invalid sync point")
+	// syncReanchor syncs the repository resuming from the dropped commit.
+	syncReanchor := func(t *testing.T, options ...SyncerOption) ([]string, error) {
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{invalidSyncPointErr: invalidSyncPointErr},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithGitCommitChecker(checker.checkFunc()),
+				SyncerWithResumption(func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+					return droppedCommit, nil
+				}),
+			)...,
+		).Sync(context.Background(), recorder.syncFunc)
+		return recorder.branchCommitMessages(), err
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("without_reanchor", func(t *testing.T) {
+		synced, err := syncReanchor(t)
+		require.ErrorIs(t, err, invalidSyncPointErr)
+		assert.Empty(t, synced)
+	})
+	t.Run("reanchor", func(t *testing.T) {
+		synced, err := syncReanchor(t, SyncerWithReanchorOnMissingSyncPoint())
+		require.NoError(t, err)
+		// the synced commit is not synced again
+		assert.Equal(t, []string{"main:commit 3", "main:commit 4"}, synced)
+	})
+	t.Run("synced_commit_beyond_search_depth", func(t *testing.T) {
+		synced, err := syncReanchor(t, SyncerWithReanchorOnMissingSyncPoint(), SyncerWithMaxHistoryDepth(2))
+		require.ErrorIs(t, err, invalidSyncPointErr)
+		assert.Empty(t, synced)
+	})
+	t.Run("without_checker", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithReanchorOnMissingSyncPoint(),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncPathExclude(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/experimental/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncPaths syncs the repository, and returns the paths of the synced module buckets, keyed by
+	// commit message.
+	syncPaths := func(t *testing.T, options ...SyncerOption) map[string][]string {
+		recorder := &syncFuncRecorder{}
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{buildFailureErr: errors.New("abort")},
+			append(options, SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")))...,
+		)
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		pathsByCommitMessage := make(map[string][]string)
+		for _, moduleCommit := range recorder.moduleCommits {
+			paths, err := storage.AllPaths(context.Background(), moduleCommit.Bucket(), "")
+			require.NoError(t, err)
+			pathsByCommitMessage[strings.TrimSpace(moduleCommit.Commit().Message())] = paths
+		}
+		return pathsByCommitMessage
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("not_excluded", func(t *testing.T) {
+		assert.Contains(t, syncPaths(t)["commit 2"], "experimental/b.proto")
+	})
+	for _, pattern := range []string{"proto/experimental", "proto/experimental/", "proto/exp*", "*/experimental/*.proto"} {
+		pattern := pattern
+		t.Run(pattern, func(t *testing.T) {
+			paths := syncPaths(t, SyncerWithPathExclude(pattern))
+			assert.Equal(t, paths["commit 1"], paths["commit 2"])
+			assert.NotContains(t, paths["commit 2"], "experimental/b.proto")
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			nil,
+			&mockErrorHandler{},
+			SyncerWithPathExclude("proto/[experimental"),
+		)
+		require.Error(t, err)
+	})
+}
+
+func TestSyncSubmodules(t *testing.T) {
+	t.Parallel()
+	submoduleRepo := newTestGitRepository(t)
+	fetchedSubmoduleCommit := submoduleRepo.commit("shared 1", map[string]string{"shared/v1/shared.proto": testProtoFile("shared.v1")})
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	// only the first submodule commit is fetched into the repository object store
+	testRepo.git("fetch", submoduleRepo.localDir, "main")
+	missingSubmoduleCommit := submoduleRepo.commit("shared 2", map[string]string{"shared/v2/shared.proto": testProtoFile("shared.v2")})
+	testRepo.git("update-index", "--add", "--cacheinfo", "160000,"+fetchedSubmoduleCommit.Hex()+",proto/shared")
+	testRepo.git("update-index", "--add", "--cacheinfo", "160000,"+missingSubmoduleCommit.Hex()+",proto/missing")
+	testRepo.git("commit", "-m", "commit 2")
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncPaths syncs the repository, and returns the paths of the synced module bucket for the HEAD
+	// commit, and the logged missing submodule warnings.
+	syncPaths := func(t *testing.T, options ...SyncerOption) ([]string, []observer.LoggedEntry) {
+		core, logs := observer.New(zap.WarnLevel)
+		recorder := &syncFuncRecorder{}
+		syncer, err := NewSyncer(
+			zap.New(core),
+			repo,
+			storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+			&mockErrorHandler{buildFailureErr: errors.New("abort")},
+			append(options, SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")))...,
+		)
+		require.NoError(t, err)
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		require.Len(t, recorder.moduleCommits, 2)
+		paths, err := storage.AllPaths(context.Background(), recorder.moduleCommits[1].Bucket(), "")
+		require.NoError(t, err)
+		return paths, logs.FilterMessage("submodule commit not found in the repository, skipping submodule").AllUntimed()
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("without_submodules", func(t *testing.T) {
+		paths, warnings := syncPaths(t)
+		assert.ElementsMatch(t, []string{"a.proto", "buf.yaml"}, paths)
+		assert.Empty(t, warnings)
+	})
+	t.Run("with_submodules", func(t *testing.T) {
+		paths, warnings := syncPaths(t, SyncerWithSubmodules())
+		assert.ElementsMatch(t, []string{"a.proto", "buf.yaml", "shared/shared/v1/shared.proto"}, paths)
+		require.Len(t, warnings, 1)
+		assert.Equal(t, "proto/missing", warnings[0].ContextMap()["submodulePath"])
+		assert.Equal(t, missingSubmoduleCommit.Hex(), warnings[0].ContextMap()["submoduleCommit"])
+	})
+}
+
+func TestSyncBuildTimeout(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/slow.proto": testProtoFile("slow")})
+	testRepo.commit("commit 3", map[string]string{"proto/slow.proto": "", "proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	builder := &blockingModuleBucketBuilder{blockPath: "slow.proto", unblock: make(chan struct{})}
+	t.Cleanup(func() { close(builder.unblock) })
+	withBlockingBuilder := func(s *syncer) error {
+		s.moduleBucketBuilder = builder
+		return nil
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("continue", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		recorder := &syncFuncRecorder{}
+		syncer := newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithBuildTimeout(50*time.Millisecond),
+			withBlockingBuilder,
+		)
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		assert.Equal(t, []string{"main:commit 1", "main:commit 3"}, recorder.branchCommitMessages())
+		require.Len(t, errorHandler.buildFailureErrs, 1)
+		assert.ErrorIs(t, errorHandler.buildFailureErrs[0], ErrBuildTimeout)
+	})
+	t.Run("build_ignores_context", func(t *testing.T) {
+		ignoringBuilder := &blockingModuleBucketBuilder{
+			blockPath:     "slow.proto",
+			unblock:       make(chan struct{}),
+			ignoreContext: true,
+			buildErrs:     make(chan error, 3),
+		}
+		errorHandler := &mockErrorHandler{}
+		recorder := &syncFuncRecorder{}
+		syncer := newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithBuildTimeout(50*time.Millisecond),
+			func(s *syncer) error {
+				s.moduleBucketBuilder = ignoringBuilder
+				return nil
+			},
+		)
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		assert.Equal(t, []string{"main:commit 1", "main:commit 3"}, recorder.branchCommitMessages())
+		require.Len(t, errorHandler.buildFailureErrs, 1)
+		assert.ErrorIs(t, errorHandler.buildFailureErrs[0], ErrBuildTimeout)
+		// the sync does not wait for the timed out build to return
+		assert.Equal(t, int32(1), ignoringBuilder.running.Load())
+		close(ignoringBuilder.unblock)
+		// the builds of commits 1 and 3 succeeded, and the timed out build can no longer read the
+		// source bucket
+		assert.NoError(t, <-ignoringBuilder.buildErrs)
+		assert.NoError(t, <-ignoringBuilder.buildErrs)
+		assert.ErrorIs(t, <-ignoringBuilder.buildErrs, errBucketOutOfScope)
+	})
+	t.Run("abort", func(t *testing.T) {
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{buildFailureErr: errors.New("abort")},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithBuildTimeout(50*time.Millisecond),
+			withBlockingBuilder,
+		)
+		var buildErr *BuildError
+		assert.ErrorAs(t, syncer.Sync(context.Background(), (&syncFuncRecorder{}).syncFunc), &buildErr)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewSyncer(zap.NewNop(), repo, nil, &mockErrorHandler{}, SyncerWithBuildTimeout(0))
+		require.Error(t, err)
+	})
+}
+
+func TestSyncBucketTransformer(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	moduleFiles := newTestModuleFiles("buf.test/owner/repo", "a")
+	moduleFiles["proto/vendor/v.proto"] = testProtoFile("v")
+	testRepo.commit("commit 1", moduleFiles)
+	testRepo.push("main")
+	repo := testRepo.open()
+	injectedBucket, err := storagemem.NewReadBucket(map[string][]byte{
+		"generated.proto": []byte(testProtoFile("generated")),
+	})
+	require.NoError(t, err)
+	stripVendor := func(_ context.Context, _ ModuleCommit, bucket storage.ReadBucket) (storage.ReadBucket, error) {
+		return storage.MapReadBucket(bucket, storage.MatchNot(storage.MatchPathContained("vendor"))), nil
+	}
+	var transformedCommitMessages []string
+	injectGenerated := func(_ context.Context, moduleCommit ModuleCommit, bucket storage.ReadBucket) (storage.ReadBucket, error) {
+		transformedCommitMessages = append(transformedCommitMessages, moduleCommit.Commit().Message())
+		return storage.MultiReadBucket(bucket, injectedBucket), nil
+	}
+
+	recorder := &syncFuncRecorder{}
+	syncer := newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		SyncerWithBucketTransformer(stripVendor),
+		SyncerWithBucketTransformer(injectGenerated),
+	)
+	require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+	assert.Equal(t, []string{"commit 1"}, transformedCommitMessages)
+	require.Len(t, recorder.moduleCommits, 1)
+	pushedPaths, err := storage.AllPaths(context.Background(), recorder.moduleCommits[0].Bucket(), "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.proto", "buf.yaml", "generated.proto"}, pushedPaths)
+
+	transformErr := errors.New("transform")
+	syncer = newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		SyncerWithBucketTransformer(func(context.Context, ModuleCommit, storage.ReadBucket) (storage.ReadBucket, error) {
+			return nil, transformErr
+		}),
+	)
+	require.ErrorIs(t, syncer.Sync(context.Background(), recorder.syncFunc), transformErr)
+	assert.Len(t, recorder.moduleCommits, 1, "sync func is not invoked")
+}
+
+func TestSyncOverlayFiles(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	moduleFiles := newTestModuleFiles("buf.test/owner/repo", "a")
+	moduleFiles["proto/generated.proto"] = testProtoFile("committed")
+	testRepo.commit("commit 1", moduleFiles)
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	readFile := func(t *testing.T, bucket storage.ReadBucket, path string) string {
+		data, err := storage.ReadPath(context.Background(), bucket, path)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("precedence", func(t *testing.T) {
+		t.Parallel()
+		overlayFiles := map[string][]byte{
+			"generated.proto": []byte(testProtoFile("generated")),
+			"LICENSE":         []byte("license"),
+		}
+		recorder := &syncFuncRecorder{}
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithOverlayFiles("proto", overlayFiles),
+			// overlays onto other dirs are not applied to the module
+			SyncerWithOverlayFiles("other", map[string][]byte{"other.proto": []byte(testProtoFile("other"))}),
+		)
+		// the overlay files are copied
+		overlayFiles["LICENSE"][0] = 'L'
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		require.Len(t, recorder.moduleCommits, 2)
+		for _, moduleCommit := range recorder.moduleCommits {
+			assert.Equal(t, testProtoFile("generated"), readFile(t, moduleCommit.Bucket(), "generated.proto"))
+			assert.Equal(t, "license", readFile(t, moduleCommit.Bucket(), "LICENSE"))
+			exists, err := storage.Exists(context.Background(), moduleCommit.Bucket(), "other.proto")
+			require.NoError(t, err)
+			assert.False(t, exists)
+		}
+		pushedPaths, err := storage.AllPaths(context.Background(), recorder.moduleCommits[1].Bucket(), "")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"LICENSE", "a.proto", "b.proto", "buf.yaml", "generated.proto"}, pushedPaths)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, options := range [][]SyncerOption{
+			{SyncerWithOverlayFiles("../proto", map[string][]byte{"a.proto": nil})},
+			{SyncerWithOverlayFiles("proto", map[string][]byte{"../a.proto": nil})},
+			{SyncerWithOverlayFiles("proto", nil)},
+			{
+				SyncerWithOverlayFiles("proto", map[string][]byte{"a.proto": nil}),
+				SyncerWithOverlayFiles("proto/", map[string][]byte{"./a.proto": nil}),
+			},
+		} {
+			_, err := NewSyncer(
+				zap.NewNop(),
+				repo,
+				storagegit.NewProvider(repo.Objects()),
+				&mockErrorHandler{},
+				options...,
+			)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestSyncModulesNotFound(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.push("main")
+	repo := testRepo.open()
+	newSyncer := func(t *testing.T, logger *zap.Logger, options ...SyncerOption) (Syncer, error) {
+		return NewSyncer(
+			logger,
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithModule(newTestSyncableModule(t, "protoo", "buf.test/owner/typo")),
+			)...,
+		)
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		t.Parallel()
+		core, logs := observer.New(zap.WarnLevel)
+		syncer, err := newSyncer(t, zap.New(core))
+		require.NoError(t, err)
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		require.Len(t, recorder.moduleCommits, 1)
+		assert.Equal(t, "buf.test/owner/repo", recorder.moduleCommits[0].Identity().IdentityString())
+		warnings := logs.FilterMessage("module dir not found in any commit of the branches to sync, check the module dir").AllUntimed()
+		require.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0].ContextMap()["module"], "protoo")
+	})
+	t.Run("strict", func(t *testing.T) {
+		t.Parallel()
+		syncer, err := newSyncer(t, zap.NewNop(), SyncerWithStrictModules())
+		require.NoError(t, err)
+		recorder := &syncFuncRecorder{}
+		err = syncer.Sync(context.Background(), recorder.syncFunc)
+		require.ErrorIs(t, err, ErrModuleNotFound)
+		assert.Contains(t, err.Error(), "protoo")
+		assert.NotContains(t, err.Error(), "proto:")
+		assert.Empty(t, recorder.moduleCommits)
+	})
+	t.Run("before_sync_point", func(t *testing.T) {
+		t.Parallel()
+		// the module dir is only found before its sync point
+		testRepo := newTestGitRepository(t)
+		testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+		testRepo.git("rm", "-rf", "-q", "proto")
+		syncPoint := testRepo.commit("commit 2", map[string]string{"README.md": "# repo\n"})
+		testRepo.commit("commit 3", map[string]string{"README.md": "# repo 3\n"})
+		testRepo.push("main")
+		repo := testRepo.open()
+		syncer, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithResumption(func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+				return syncPoint, nil
+			}),
+			SyncerWithStrictModules(),
+		)
+		require.NoError(t, err)
+		err = syncer.Sync(context.Background(), (&syncFuncRecorder{}).syncFunc)
+		require.ErrorIs(t, err, ErrModuleNotFound)
+	})
+}
+
+func TestSyncPathCollisionPolicy(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{
+		"proto/Foo.proto": testProtoFile("foo1"),
+		"proto/foo.proto": testProtoFile("foo2"),
+	})
+	testRepo.push("main")
+	repo := testRepo.open()
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		handler := &mockErrorHandler{}
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			handler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		).Sync(context.Background(), recorder.syncFunc))
+		// the colliding commit is skipped by the error handler
+		require.Len(t, recorder.moduleCommits, 1)
+		assert.Equal(t, "commit 1", recorder.moduleCommits[0].Commit().Message())
+		require.Len(t, handler.invalidModuleConfigErrs, 1)
+		assert.ErrorIs(t, handler.invalidModuleConfigErrs[0], ErrPathCollision)
+		assert.Contains(t, handler.invalidModuleConfigErrs[0].Error(), "Foo.proto, foo.proto")
+
+		handler = &mockErrorHandler{invalidModuleConfigErr: errors.New("abort")}
+		err := newTestSyncer(
+			t,
+			repo,
+			handler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		).Sync(context.Background(), (&syncFuncRecorder{}).syncFunc)
+		var buildErr *BuildError
+		require.ErrorAs(t, err, &buildErr)
+	})
+	t.Run("warn", func(t *testing.T) {
+		t.Parallel()
+		core, logs := observer.New(zap.WarnLevel)
+		handler := &mockErrorHandler{}
+		recorder := &syncFuncRecorder{}
+		syncer, err := NewSyncer(
+			zap.New(core),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			handler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithPathCollisionPolicy(PathCollisionPolicyWarn),
+		)
+		require.NoError(t, err)
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		require.Len(t, recorder.moduleCommits, 2)
+		assert.Empty(t, handler.invalidModuleConfigErrs)
+		warnings := logs.FilterMessage("module paths differ only by case, and collide on case-insensitive filesystems").AllUntimed()
+		require.Len(t, warnings, 1)
+		assert.Equal(t, []interface{}{"Foo.proto", "foo.proto"}, warnings[0].ContextMap()["paths"])
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithPathCollisionPolicy(PathCollisionPolicy(42)),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncLockRewriter(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", map[string]string{
+		"a/buf.yaml": "version: v1\nname: buf.test/owner/a\ndeps:\n  - buf.test/owner/b\n",
+		"a/buf.lock": "# Generated by buf. DO NOT EDIT.\nversion: v1\ndeps:\n" +
+			"  - remote: buf.test\n    owner: owner\n    repository: b\n    commit: not-synced-yet\n",
+		"a/a.proto":  testProtoFile("a"),
+		"b/buf.yaml": "version: v1\nname: buf.test/owner/b\n",
+		"b/b.proto":  testProtoFile("b"),
+	})
+	testRepo.push("main")
+	repo := testRepo.open()
+	const syncedCommit = "0123456789abcdef0123456789abcdef"
+	var rewrittenModules []string
+	rewritePins := func(_ context.Context, moduleCommit ModuleCommit, lock *buflock.Config) (*buflock.Config, error) {
+		rewrittenModules = append(rewrittenModules, moduleCommit.Identity().IdentityString())
+		for i := range lock.Dependencies {
+			lock.Dependencies[i].Commit = syncedCommit
+		}
+		return lock, nil
+	}
+
+	recorder := &syncFuncRecorder{}
+	syncer := newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "a", "buf.test/owner/a")),
+		SyncerWithModule(newTestSyncableModule(t, "b", "buf.test/owner/b")),
+		SyncerWithLockRewriter(rewritePins),
+	)
+	require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+	// the module without a lock file is not rewritten
+	assert.Equal(t, []string{"buf.test/owner/a"}, rewrittenModules)
+	require.Len(t, recorder.moduleCommits, 2)
+	pushedBuckets := make(map[string]storage.ReadBucket)
+	for _, moduleCommit := range recorder.moduleCommits {
+		pushedBuckets[moduleCommit.Identity().IdentityString()] = moduleCommit.Bucket()
+	}
+	pushedLock, err := buflock.ReadConfig(context.Background(), pushedBuckets["buf.test/owner/a"])
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]buflock.Dependency{{Remote: "buf.test", Owner: "owner", Repository: "b", Commit: syncedCommit}},
+		pushedLock.Dependencies,
+	)
+	pushedPaths, err := storage.AllPaths(context.Background(), pushedBuckets["buf.test/owner/a"], "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.proto", "buf.lock", "buf.yaml"}, pushedPaths)
+	pushedPaths, err = storage.AllPaths(context.Background(), pushedBuckets["buf.test/owner/b"], "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b.proto", "buf.yaml"}, pushedPaths)
+
+	rewriteErr := errors.New("rewrite")
+	syncer = newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "a", "buf.test/owner/a")),
+		SyncerWithLockRewriter(func(context.Context, ModuleCommit, *buflock.Config) (*buflock.Config, error) {
+			return nil, rewriteErr
+		}),
+	)
+	require.ErrorIs(t, syncer.Sync(context.Background(), recorder.syncFunc), rewriteErr)
+	assert.Len(t, recorder.moduleCommits, 2, "sync func is not invoked")
+}
+
+func TestSyncTimeWindowCoalesce(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	startTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	testRepo.commitAt("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"), startTime)
+	// a burst of commits seconds apart, the last one not changing the module
+	testRepo.commitAt("wip 1", map[string]string{"proto/b.proto": testProtoFile("b")}, startTime.Add(time.Hour))
+	testRepo.git("tag", "v1")
+	testRepo.commitAt("wip 2", map[string]string{"proto/c.proto": testProtoFile("c")}, startTime.Add(time.Hour+10*time.Second))
+	testRepo.git("tag", "v2")
+	testRepo.commitAt("wip 3", nil, startTime.Add(time.Hour+20*time.Second))
+	testRepo.commitAt("commit 2", map[string]string{"proto/d.proto": testProtoFile("d")}, startTime.Add(2*time.Hour))
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncTags syncs the repository, and returns the synced tags keyed by branch commit message.
+	syncTags := func(t *testing.T, options ...SyncerOption) map[string][]string {
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(options, SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")))...,
+		).Sync(context.Background(), recorder.syncFunc))
+		tagsByBranchCommitMessage := make(map[string][]string)
+		for i, branchCommitMessage := range recorder.branchCommitMessages() {
+			tagsByBranchCommitMessage[branchCommitMessage] = recorder.moduleCommits[i].Tags()
+		}
+		return tagsByBranchCommitMessage
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("bursty", func(t *testing.T) {
+		tags := syncTags(t, SyncerWithTimeWindowCoalesce(time.Minute))
+		require.Len(t, tags, 3)
+		assert.Contains(t, tags, "main:commit 1")
+		assert.ElementsMatch(t, []string{"v1", "v2"}, tags["main:wip 3"])
+		assert.Contains(t, tags, "main:commit 2")
+	})
+	t.Run("bursty_skip_unchanged", func(t *testing.T) {
+		// the coalesced commit changes the module since the commit before the burst
+		tags := syncTags(t, SyncerWithTimeWindowCoalesce(time.Minute), SyncerWithSkipUnchangedCommits())
+		require.Len(t, tags, 3)
+		assert.ElementsMatch(t, []string{"v1", "v2"}, tags["main:wip 3"])
+	})
+	t.Run("spread_out", func(t *testing.T) {
+		tags := syncTags(t, SyncerWithTimeWindowCoalesce(time.Second))
+		require.Len(t, tags, 5)
+		assert.Equal(t, []string{"v1"}, tags["main:wip 1"])
+		assert.Equal(t, []string{"v2"}, tags["main:wip 2"])
+		assert.Empty(t, tags["main:wip 3"])
+	})
+	t.Run("whole_history", func(t *testing.T) {
+		tags := syncTags(t, SyncerWithTimeWindowCoalesce(24*time.Hour))
+		require.Len(t, tags, 1)
+		assert.ElementsMatch(t, []string{"v1", "v2"}, tags["main:commit 2"])
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewSyncer(zap.NewNop(), repo, nil, &mockErrorHandler{}, SyncerWithTimeWindowCoalesce(0))
+		require.Error(t, err)
+	})
+}
+
+func TestSyncTagDetails(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("tag", "v1")
+	testRepo.git("tag", "-a", "v2", "-m", "release v2\n\nwith notes")
+	// the refs of packed tags are read from the packed-refs file
+	testRepo.git("pack-refs", "--all")
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("tag", "-a", "v3", "-m", "release v3")
+	testRepo.git("push", "--tags", "origin", "main")
+	repo := testRepo.open()
+
+	recorder := &syncFuncRecorder{}
+	syncer := newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+	)
+	require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+	require.Len(t, recorder.moduleCommits, 2)
+	tagDetailsByName := make(map[string]TagDetail)
+	for _, moduleCommit := range recorder.moduleCommits {
+		tagNames := make([]string, 0, len(moduleCommit.TagDetails()))
+		for _, tagDetail := range moduleCommit.TagDetails() {
+			tagNames = append(tagNames, tagDetail.Name)
+			tagDetailsByName[tagDetail.Name] = tagDetail
+		}
+		assert.Equal(t, moduleCommit.Tags(), tagNames)
+	}
+	require.Len(t, tagDetailsByName, 3)
+	// lightweight tags have no tagger nor message
+	assert.Nil(t, tagDetailsByName["v1"].Tagger)
+	assert.Empty(t, tagDetailsByName["v1"].Message)
+	for tag, expectedMessage := range map[string]string{
+		"v2": "release v2\n\nwith notes",
+		"v3": "release v3",
+	} {
+		require.NotNil(t, tagDetailsByName[tag].Tagger, tag)
+		assert.Equal(t, "Buf TestBot", tagDetailsByName[tag].Tagger.Name(), tag)
+		assert.Equal(t, "testbot@buf.build", tagDetailsByName[tag].Tagger.Email(), tag)
+		assert.Equal(t, expectedMessage, tagDetailsByName[tag].Message, tag)
+	}
+}
+
+func TestSyncTagsOnly(t *testing.T) {
+	t.Parallel()
+	// | o-o (main)
+	// |  └o (release, not synced, tagged v1.0.0)
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("tag", "v0.1.0")
+	testRepo.git("checkout", "-b", "release")
+	testRepo.commit("release 1", map[string]string{"proto/r.proto": testProtoFile("r")})
+	testRepo.git("tag", "v1.0.0")
+	testRepo.git("checkout", "main")
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main", "release")
+	repo := testRepo.open()
+	moduleIdentity, err := bufmoduleref.ModuleIdentityForString("buf.test/owner/repo")
+	require.NoError(t, err)
+
+	recorder := &syncFuncRecorder{}
+	mockBSRChecker := newMockSyncGitChecker()
+	syncFunc := func(ctx context.Context, moduleCommit ModuleCommit) error {
+		mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+		return recorder.syncFunc(ctx, moduleCommit)
+	}
+	newTagsOnlySyncer := func() Syncer {
+		return newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithTagsOnly(moduleIdentity),
+		)
+	}
+	require.NoError(t, newTagsOnlySyncer().Sync(context.Background(), syncFunc))
+	expectedCommits := []string{
+		"main:commit 1",
+		"main:commit 2",
+		":release 1",
+	}
+	assert.Equal(t, expectedCommits, recorder.branchCommitMessages())
+	assert.Equal(t, []string{"v1.0.0"}, recorder.moduleCommits[2].Tags())
+	// re-running does not re-push the tagged commits
+	require.NoError(t, newTagsOnlySyncer().Sync(context.Background(), syncFunc))
+	assert.Equal(t, expectedCommits, recorder.branchCommitMessages())
+
+	otherIdentity, err := bufmoduleref.ModuleIdentityForString("buf.test/owner/other")
+	require.NoError(t, err)
+	_, err = NewSyncer(
+		zap.NewNop(),
+		repo,
+		nil,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		SyncerWithTagsOnly(otherIdentity),
+	)
+	require.Error(t, err)
+}
+
+func TestSyncLintOnSync(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("lint clean", map[string]string{
+		"proto/buf.yaml": "version: v1\nname: buf.test/owner/repo\nlint:\n  use:\n    - MESSAGE_PASCAL_CASE\n",
+		"proto/a.proto":  testProtoFile("a") + "\nmessage Foo {}\n",
+	})
+	testRepo.commit("lint failure", map[string]string{
+		"proto/a.proto": testProtoFile("a") + "\nmessage foo_bar {}\n",
+	})
+	testRepo.commit("compile failure", map[string]string{
+		"proto/a.proto": testProtoFile("a") + "\nmessage Foo {\n",
+	})
+	testRepo.commit("lint fixed", map[string]string{
+		"proto/a.proto": testProtoFile("a") + "\nmessage FooBar {}\n",
+	})
+	testRepo.push("main")
+	repo := testRepo.open()
+	module := newTestSyncableModule(t, "proto", "buf.test/owner/repo")
+	// not running in parallel, the subtests share the same repository
+	t.Run("skip", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		var recorder syncFuncRecorder
+		err := newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(module),
+			SyncerWithLintOnSync(LintConfig{}),
+		).Sync(context.Background(), recorder.syncFunc)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"main:lint clean", "main:lint fixed"}, recorder.branchCommitMessages())
+		require.Len(t, errorHandler.lintFailureErrs, 2)
+		var lintErr *LintError
+		require.ErrorAs(t, errorHandler.lintFailureErrs[0], &lintErr)
+		require.Len(t, lintErr.FileAnnotations, 1)
+		assert.Equal(t, "MESSAGE_PASCAL_CASE", lintErr.FileAnnotations[0].Type())
+		require.ErrorAs(t, errorHandler.lintFailureErrs[1], &lintErr)
+		assert.NotEmpty(t, lintErr.FileAnnotations)
+	})
+	t.Run("config_override", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		var recorder syncFuncRecorder
+		err := newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(module),
+			SyncerWithLintOnSync(LintConfig{
+				Config: &buflintconfig.Config{Use: []string{"FIELD_LOWER_SNAKE_CASE"}, Version: bufconfig.V1Version},
+			}),
+		).Sync(context.Background(), recorder.syncFunc)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"main:lint clean", "main:lint failure", "main:lint fixed"}, recorder.branchCommitMessages())
+		assert.Len(t, errorHandler.lintFailureErrs, 1)
+	})
+	t.Run("abort", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{lintFailureErr: errors.New("lint failure")}
+		var recorder syncFuncRecorder
+		err := newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(module),
+			SyncerWithLintOnSync(LintConfig{}),
+		).Sync(context.Background(), recorder.syncFunc)
+		var policyErr *PolicyError
+		require.ErrorAs(t, err, &policyErr)
+		assert.Equal(t, "lint failure", strings.TrimSpace(policyErr.Err.Error()))
+		assert.Equal(t, []string{"main:lint clean"}, recorder.branchCommitMessages())
+	})
+	t.Run("disabled", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		var recorder syncFuncRecorder
+		err := newTestSyncer(t, repo, errorHandler, SyncerWithModule(module)).Sync(context.Background(), recorder.syncFunc)
+		require.NoError(t, err)
+		assert.Len(t, recorder.moduleCommits, 4)
+		assert.Empty(t, errorHandler.lintFailureErrs)
+	})
+}
+
+func TestSyncLazyBuckets(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{
+		"proto/b.proto": testProtoFile("b") + "\n// " + strings.Repeat("large comment ", 1<<16) + "\n",
+		"proto/LICENSE": "license",
+	})
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncBuckets syncs the module, and returns the content of the synced buckets, keyed by commit
+	// message, and the synced buckets.
+	syncBuckets := func(t *testing.T, options ...SyncerOption) (map[string]map[string]string, []storage.ReadBucket) {
+		commitsContent := make(map[string]map[string]string)
+		var buckets []storage.ReadBucket
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(options, SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")))...,
+		).Sync(context.Background(), func(ctx context.Context, moduleCommit ModuleCommit) error {
+			pathToContent := make(map[string]string)
+			if err := storage.WalkReadObjects(ctx, moduleCommit.Bucket(), "", func(readObject storage.ReadObject) error {
+				data, err := io.ReadAll(readObject)
+				if err != nil {
+					return err
+				}
+				pathToContent[readObject.Path()] = string(data)
+				return nil
+			}); err != nil {
+				return err
+			}
+			commitsContent[moduleCommit.Commit().Message()] = pathToContent
+			buckets = append(buckets, moduleCommit.Bucket())
+			return nil
+		})
+		require.NoError(t, err)
+		return commitsContent, buckets
+	}
+
+	inMemoryContent, inMemoryBuckets := syncBuckets(t)
+	lazyContent, lazyBuckets := syncBuckets(t, SyncerWithLazyBuckets())
+	require.Len(t, lazyContent, 2)
+	assert.Equal(t, inMemoryContent, lazyContent)
+	assert.Contains(t, lazyContent["commit 2"], "LICENSE")
+	_, err := inMemoryBuckets[0].Stat(context.Background(), "a.proto")
+	assert.NoError(t, err)
+	for _, bucket := range lazyBuckets {
+		_, err := bucket.Stat(context.Background(), "a.proto")
+		assert.ErrorIs(t, err, errBucketOutOfScope)
+		_, err = bucket.Get(context.Background(), "a.proto")
+		assert.ErrorIs(t, err, errBucketOutOfScope)
+	}
+}
+
+func TestSyncTagConflictPolicy(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commit1 := testRepo.commit("commit 1", map[string]string{
+		"a/buf.yaml": "version: v1\nname: buf.test/owner/a\n",
+		"a/a.proto":  testProtoFile("a"),
+		"b/buf.yaml": "version: v1\nname: buf.test/owner/b\n",
+		"b/b.proto":  testProtoFile("b"),
+	})
+	testRepo.git("tag", "v1")
+	testRepo.push("main")
+	repo := testRepo.open()
+	moduleA := newTestSyncableModule(t, "a", "buf.test/owner/a")
+	moduleB := newTestSyncableModule(t, "b", "buf.test/owner/b")
+	otherCommitHex := strings.Repeat("f", 40)
+	// sync syncs both modules, with the "v1" tag pointing to the passed commit in module a only.
+	sync := func(t *testing.T, remoteTagCommit string, options ...SyncerOption) (*syncFuncRecorder, error) {
+		tagResolver := func(_ context.Context, module bufmoduleref.ModuleIdentity) (map[string]string, error) {
+			if module.IdentityString() == "buf.test/owner/a" {
+				return map[string]string{"v1": remoteTagCommit}, nil
+			}
+			return nil, nil
+		}
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(moduleA),
+				SyncerWithModule(moduleB),
+				SyncerWithTagResolver(tagResolver),
+			)...,
+		).Sync(context.Background(), recorder.syncFunc)
+		return recorder, err
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("independent", func(t *testing.T) {
+		recorder, err := sync(t, otherCommitHex)
+		require.NoError(t, err)
+		require.Len(t, recorder.moduleCommits, 2)
+		for _, moduleCommit := range recorder.moduleCommits {
+			assert.Equal(t, []string{"v1"}, moduleCommit.Tags())
+		}
+	})
+	t.Run("fail_if_any_exists", func(t *testing.T) {
+		recorder, err := sync(t, otherCommitHex, SyncerWithTagConflictPolicy(TagConflictPolicyFailIfAnyExists))
+		var tagConflictErr *TagConflictError
+		require.ErrorAs(t, err, &tagConflictErr)
+		assert.Equal(t, moduleA, tagConflictErr.Module)
+		assert.Equal(t, "v1", tagConflictErr.Tag)
+		assert.Equal(t, commit1.Hex(), tagConflictErr.Commit.Hex())
+		assert.Equal(t, otherCommitHex, tagConflictErr.RemoteCommit)
+		assert.Empty(t, recorder.moduleCommits, "no module is synced")
+	})
+	t.Run("fail_if_any_exists_same_commit", func(t *testing.T) {
+		recorder, err := sync(t, commit1.Hex(), SyncerWithTagConflictPolicy(TagConflictPolicyFailIfAnyExists))
+		require.NoError(t, err)
+		assert.Len(t, recorder.moduleCommits, 2)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			nil,
+			&mockErrorHandler{},
+			SyncerWithTagConflictPolicy(TagConflictPolicyFailIfAnyExists),
+		)
+		assert.Error(t, err)
+		_, err = NewSyncer(zap.NewNop(), repo, nil, &mockErrorHandler{}, SyncerWithTagConflictPolicy(42))
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncTagReconcileOnly(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commit1 := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("tag", "unchanged")
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("tag", "moved")
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.git("tag", "new")
+	testRepo.commit("commit 4", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	// the moved tag still points to the first commit in the BSR
+	tagResolver := func(context.Context, bufmoduleref.ModuleIdentity) (map[string]string, error) {
+		return map[string]string{
+			"unchanged": commit1.Hex(),
+			"moved":     commit1.Hex(),
+		}, nil
+	}
+	newTagReconcileSyncer := func(t *testing.T) Syncer {
+		return newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(func(context.Context, bufmoduleref.ModuleIdentity, map[string]struct{}) (map[string]struct{}, error) {
+				return nil, errors.New("branches history is not walked")
+			}),
+			SyncerWithTagResolver(tagResolver),
+			SyncerWithTagReconcileOnly(),
+		)
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("sync", func(t *testing.T) {
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTagReconcileSyncer(t).Sync(context.Background(), recorder.syncFunc))
+		// the commits are committed in the same second, so their order is not asserted
+		assert.ElementsMatch(t, []string{":commit 2", ":commit 3"}, recorder.branchCommitMessages())
+		var syncedTags []string
+		for _, moduleCommit := range recorder.moduleCommits {
+			syncedTags = append(syncedTags, moduleCommit.Tags()...)
+		}
+		assert.ElementsMatch(t, []string{"moved", "new"}, syncedTags)
+	})
+	t.Run("plan", func(t *testing.T) {
+		plan, err := newTagReconcileSyncer(t).Plan(context.Background())
+		require.NoError(t, err)
+		require.Len(t, plan.Branches, 1)
+		assert.Equal(t, "", plan.Branches[0].Branch)
+		var planned []string
+		for _, commitPlan := range plan.Branches[0].Commits {
+			planned = append(planned, strings.Join(commitPlan.Tags, ","))
+		}
+		assert.ElementsMatch(t, []string{"moved", "new"}, planned)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewSyncer(zap.NewNop(), repo, nil, &mockErrorHandler{}, SyncerWithTagReconcileOnly())
+		require.Error(t, err)
+		_, err = NewSyncer(
+			zap.NewNop(),
+			repo,
+			nil,
+			&mockErrorHandler{},
+			SyncerWithTagResolver(tagResolver),
+			SyncerWithTagReconcileOnly(),
+			SyncerWithHeadOnly(),
+		)
+		require.Error(t, err)
+	})
+}
+
+func TestSyncHeadOnly(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	headHash := testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	moduleToSync := newTestSyncableModule(t, "proto", "buf.test/owner/repo")
+	// HEAD is already synced, but it is synced again
+	mockBSRChecker := newMockSyncGitChecker()
+	mockBSRChecker.markSynced(headHash.Hex())
+	var resolverCalls int
+	resolver := func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+		resolverCalls++
+		return nil, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := &syncFuncRecorder{}
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(moduleToSync),
+			SyncerWithResumption(resolver),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithHeadOnly(),
+		)
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		assert.Equal(t, []string{"main:commit 3"}, recorder.branchCommitMessages())
+	}
+	assert.Zero(t, resolverCalls, "resumption is ignored")
+
+	_, err := NewSyncer(
+		zap.NewNop(),
+		repo,
+		nil,
+		&mockErrorHandler{},
+		SyncerWithModule(moduleToSync),
+		SyncerWithHeadOnly(),
+		SyncerWithAllBranches(),
+	)
+	require.Error(t, err)
+}
+
+func TestSyncModuleNotFound(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("readme", map[string]string{"README.md": "# repo"})
+	testRepo.git("tag", "-a", "v0", "-m", "release v0")
+	testRepo.commit("add module", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 3", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("tag", "v1")
+	testRepo.push("main")
+	repo := testRepo.open()
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("skipped", func(t *testing.T) {
+		recorder := &syncFuncRecorder{}
+		errorHandler := &mockErrorHandler{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		).Sync(context.Background(), recorder.syncFunc))
+		// the tagged commit before the module is added is skipped
+		assert.Equal(t, []string{"main:add module", "main:commit 3"}, recorder.branchCommitMessages())
+		// a module without tags is synced, with empty tags
+		assert.True(t, recorder.moduleCommits[0].HasModule())
+		assert.NotNil(t, recorder.moduleCommits[0].Tags())
+		assert.Empty(t, recorder.moduleCommits[0].Tags())
+		assert.True(t, recorder.moduleCommits[1].HasModule())
+		assert.Equal(t, []string{"v1"}, recorder.moduleCommits[1].Tags())
+		assert.Empty(t, errorHandler.moduleDeletedCalls)
+	})
+	t.Run("annotator", func(t *testing.T) {
+		var annotatedCommits []string
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithCommitAnnotator(func(commit git.Commit) (map[string]string, error) {
+				annotatedCommits = append(annotatedCommits, commit.Message())
+				return nil, nil
+			}),
+		).Sync(context.Background(), (&syncFuncRecorder{}).syncFunc))
+		// the annotator is not invoked for the commit without the module
+		assert.Equal(t, []string{"add module", "commit 3"}, annotatedCommits)
+	})
+}
+
+func TestSyncDeletedModule(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	deleteCommit := testRepo.commit("delete module", map[string]string{
+		"proto/buf.yaml": "",
+		"proto/a.proto":  "",
+		"proto/b.proto":  "",
+	})
+	testRepo.commit("commit 4", map[string]string{"README.md": "# repo"})
+	testRepo.commit("readd module", newTestModuleFiles("buf.test/owner/repo", "c"))
+	testRepo.push("main")
+	repo := testRepo.open()
+	moduleToSync := newTestSyncableModule(t, "proto", "buf.test/owner/repo")
+
+	type testCase struct {
+		name            string
+		options         []SyncerOption
+		expectedCommits []string
+	}
+	testCases := []testCase{
+		{
+			name: "stop",
+			expectedCommits: []string{
+				"main:commit 1",
+				"main:commit 2",
+			},
+		},
+		{
+			name:    "skip",
+			options: []SyncerOption{SyncerWithDeletedModulePolicy(DeletedModulePolicySkip)},
+			expectedCommits: []string{
+				"main:commit 1",
+				"main:commit 2",
+				"main:readd module",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		func(tc testCase) {
+			// not running in parallel, the subtests share the same repository
+			t.Run(tc.name, func(t *testing.T) {
+				errorHandler := &mockErrorHandler{}
+				recorder := &syncFuncRecorder{}
+				syncer := newTestSyncer(
+					t,
+					repo,
+					errorHandler,
+					append([]SyncerOption{SyncerWithModule(moduleToSync)}, tc.options...)...,
+				)
+				require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+				assert.Equal(t, tc.expectedCommits, recorder.branchCommitMessages())
+				assert.Equal(t, []string{"delete module"}, errorHandler.moduleDeletedCalls)
+			})
+		}(tc)
+	}
+	t.Run("abort", func(t *testing.T) {
+		abortErr := errors.New("abort")
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{moduleDeletedErr: abortErr},
+			SyncerWithModule(moduleToSync),
+		)
+		err := syncer.Sync(context.Background(), (&syncFuncRecorder{}).syncFunc)
+		require.ErrorIs(t, err, abortErr)
+		var policyErr *PolicyError
+		require.ErrorAs(t, err, &policyErr)
+		assert.Equal(t, deleteCommit.Hex(), policyErr.Commit.Hex())
+	})
+}
+
+func TestSyncWithClock(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	clock := bufsynctest.NewFakeClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	core, logs := observer.New(zap.DebugLevel)
+	syncer, err := NewSyncer(
+		zap.New(core),
+		repo,
+		storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		SyncerWithClock(clock),
+	)
+	require.NoError(t, err)
+	require.NoError(t, syncer.Sync(context.Background(), func(context.Context, ModuleCommit) error {
+		clock.Advance(time.Minute)
+		return nil
+	}))
+	branchSyncedLogs := logs.FilterMessage("branch synced").AllUntimed()
+	require.Len(t, branchSyncedLogs, 1)
+	assert.Equal(t, 2*time.Minute, branchSyncedLogs[0].ContextMap()["duration"])
+}
+
+func TestSyncRateLimit(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	startTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("paced", func(t *testing.T) {
+		clock := bufsynctest.NewFakeClock(startTime)
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithClock(clock),
+			SyncerWithRateLimit(2),
+		)
+		// SyncFunc invocations are only read after sync is done.
+		var syncTimes []time.Time
+		syncErr := make(chan error, 1)
+		go func() {
+			syncErr <- syncer.Sync(context.Background(), func(context.Context, ModuleCommit) error {
+				syncTimes = append(syncTimes, clock.Now())
+				return nil
+			})
+		}()
+		for i := 0; i < 2; i++ {
+			clock.BlockUntilWaiters(1)
+			// the token is not refilled yet, the next SyncFunc invocation keeps waiting
+			clock.Advance(200 * time.Millisecond)
+			clock.Advance(300 * time.Millisecond)
+		}
+		require.NoError(t, <-syncErr)
+		assert.Equal(
+			t,
+			[]time.Time{
+				startTime,
+				startTime.Add(500 * time.Millisecond),
+				startTime.Add(time.Second),
+			},
+			syncTimes,
+		)
+	})
+	t.Run("canceled_while_waiting", func(t *testing.T) {
+		clock := bufsynctest.NewFakeClock(startTime)
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithClock(clock),
+			SyncerWithRateLimit(1),
+		)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var syncedCommits int
+		syncErr := make(chan error, 1)
+		go func() {
+			syncErr <- syncer.Sync(ctx, func(context.Context, ModuleCommit) error {
+				syncedCommits++
+				return nil
+			})
+		}()
+		clock.BlockUntilWaiters(1)
+		cancel()
+		err := <-syncErr
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, syncedCommits)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, commitsPerSecond := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+			_, err := NewSyncer(
+				zap.NewNop(),
+				repo,
+				storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+				&mockErrorHandler{},
+				SyncerWithRateLimit(commitsPerSecond),
+			)
+			assert.Error(t, err, commitsPerSecond)
+		}
+	})
+}
+
+func TestSyncSkipUnchangedCommits(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("docs 1", map[string]string{"README.md": "# repo"})
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("docs 2", map[string]string{"README.md": "# repo\n\nmore docs"})
+	testRepo.commit("docs 3", map[string]string{"docs/guide.md": "# guide"})
+	testRepo.commit("commit 3", map[string]string{"proto/b.proto": ""})
+	testRepo.push("main")
+	mockBSRChecker := newMockSyncGitChecker()
+	recorder := &syncFuncRecorder{}
+	syncFunc := func(ctx context.Context, moduleCommit ModuleCommit) error {
+		mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+		return recorder.syncFunc(ctx, moduleCommit)
+	}
+	newSkipUnchangedSyncer := func(repo git.Repository) Syncer {
+		return newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithSkipUnchangedCommits(),
+		)
+	}
+
+	repo := testRepo.open()
+	plan, err := newSkipUnchangedSyncer(repo).Plan(context.Background())
+	require.NoError(t, err)
+	require.Len(t, plan.Branches, 1)
+	var plannedCommitMessages []string
+	for _, commitPlan := range plan.Branches[0].Commits {
+		plannedCommitMessages = append(plannedCommitMessages, commitPlan.Commit.Message())
+	}
+	assert.Equal(t, []string{"commit 1", "commit 2", "commit 3"}, plannedCommitMessages)
+	var filteredCommitMessages []string
+	for _, commit := range plan.Branches[0].FilteredCommits {
+		filteredCommitMessages = append(filteredCommitMessages, commit.Message())
+	}
+	assert.Equal(t, []string{"docs 1", "docs 2", "docs 3"}, filteredCommitMessages)
+	require.NoError(t, newSkipUnchangedSyncer(repo).Sync(context.Background(), syncFunc))
+	assert.Equal(
+		t,
+		[]string{
+			"main:commit 1",
+			"main:commit 2",
+			"main:commit 3",
+		},
+		recorder.branchCommitMessages(),
+	)
+
+	// the next changed commit is synced on top of the last synced one
+	testRepo.commit("docs 4", map[string]string{"README.md": ""})
+	testRepo.commit("commit 4", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	repo = testRepo.open()
+	require.NoError(t, newSkipUnchangedSyncer(repo).Sync(context.Background(), syncFunc))
+	assert.Equal(
+		t,
+		[]string{
+			"main:commit 1",
+			"main:commit 2",
+			"main:commit 3",
+			"main:commit 4",
+		},
+		recorder.branchCommitMessages(),
+	)
+}
+
+func TestSyncIdentityResolver(t *testing.T) {
+	t.Parallel()
+	// | o-o (main)
+	// |  └o (staging)
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("checkout", "-b", "staging")
+	testRepo.commit("staging 1", map[string]string{"proto/s.proto": testProtoFile("s")})
+	testRepo.git("checkout", "main")
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main", "staging")
+	repo := testRepo.open()
+	identityResolver := func(module Module, branch string) (bufmoduleref.ModuleIdentity, error) {
+		if branch == "staging" {
+			return bufmoduleref.ModuleIdentityForString(module.RemoteIdentity().IdentityString() + "-staging")
+		}
+		return module.RemoteIdentity(), nil
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("branch_dependent_identities", func(t *testing.T) {
+		var syncedIdentityCommits []string
+		syncFunc := func(_ context.Context, moduleCommit ModuleCommit) error {
+			syncedIdentityCommits = append(
+				syncedIdentityCommits,
+				moduleCommit.Identity().IdentityString()+":"+moduleCommit.Commit().Message(),
+			)
+			return nil
+		}
+		var defaultBranchIdentities []string
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithAllBranches(),
+			SyncerWithIdentityResolver(identityResolver),
+			SyncerWithModuleDefaultBranchGetter(func(_ context.Context, identity bufmoduleref.ModuleIdentity) (string, error) {
+				defaultBranchIdentities = append(defaultBranchIdentities, identity.IdentityString())
+				return "main", nil
+			}),
+		).Sync(context.Background(), syncFunc))
+		assert.Equal(
+			t,
+			[]string{
+				"buf.test/owner/repo:commit 1",
+				"buf.test/owner/repo:commit 2",
+				// commits shared with main are synced again to the staging identity
+				"buf.test/owner/repo-staging:commit 1",
+				"buf.test/owner/repo-staging:staging 1",
+			},
+			syncedIdentityCommits,
+		)
+		assert.Equal(t, []string{"buf.test/owner/repo", "buf.test/owner/repo-staging"}, defaultBranchIdentities)
+	})
+	t.Run("resolver_error", func(t *testing.T) {
+		resolverErr := errors.New("no identity for branch")
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithIdentityResolver(func(Module, string) (bufmoduleref.ModuleIdentity, error) {
+				return nil, resolverErr
+			}),
+		).Sync(context.Background(), func(context.Context, ModuleCommit) error {
+			return errors.New("unexpected sync")
+		})
+		assert.ErrorIs(t, err, resolverErr)
+	})
+}
+
+func TestSyncCommitFilter(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("wip 1", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("commit 2", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	mockBSRChecker := newMockSyncGitChecker()
+	recorder := &syncFuncRecorder{}
+	var lastSyncedCommit git.Hash
+	syncFunc := func(ctx context.Context, moduleCommit ModuleCommit) error {
+		mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+		lastSyncedCommit = moduleCommit.Commit().Hash()
+		return recorder.syncFunc(ctx, moduleCommit)
+	}
+	var filteredCommitMessages []string
+	newFilteredSyncer := func(repo git.Repository) Syncer {
+		return newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithResumption(func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+				return lastSyncedCommit, nil
+			}),
+			SyncerWithSkipUnchangedCommits(),
+			SyncerWithCommitFilter(func(commit git.Commit) (bool, error) {
+				filteredCommitMessages = append(filteredCommitMessages, commit.Message())
+				return !strings.HasPrefix(commit.Message(), "wip"), nil
+			}),
+		)
+	}
+
+	repo := testRepo.open()
+	plan, err := newFilteredSyncer(repo).Plan(context.Background())
+	require.NoError(t, err)
+	require.Len(t, plan.Branches, 1)
+	var plannedCommitMessages []string
+	for _, commitPlan := range plan.Branches[0].Commits {
+		plannedCommitMessages = append(plannedCommitMessages, commitPlan.Commit.Message())
+	}
+	assert.Equal(t, []string{"commit 1", "commit 2"}, plannedCommitMessages)
+	require.NoError(t, newFilteredSyncer(repo).Sync(context.Background(), syncFunc))
+	assert.Equal(t, []string{"main:commit 1", "main:commit 2"}, recorder.branchCommitMessages())
+
+	// an excluded HEAD commit records no sync point, and sync resumes after the last synced commit
+	testRepo.commit("docs 1", map[string]string{"README.md": "# repo"})
+	testRepo.commit("commit 3", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.commit("wip 2", map[string]string{"proto/e.proto": testProtoFile("e")})
+	testRepo.push("main")
+	repo = testRepo.open()
+	filteredCommitMessages = nil
+	require.NoError(t, newFilteredSyncer(repo).Sync(context.Background(), syncFunc))
+	assert.Equal(t, "commit 3", testCommitMessage(t, repo, lastSyncedCommit))
+	// the unchanged commit is skipped before the filter is invoked
+	assert.Equal(t, []string{"commit 3", "wip 2"}, filteredCommitMessages)
+	testRepo.commit("commit 4", map[string]string{"proto/e.proto": ""})
+	testRepo.push("main")
+	repo = testRepo.open()
+	require.NoError(t, newFilteredSyncer(repo).Sync(context.Background(), syncFunc))
+	assert.Equal(
+		t,
+		[]string{
+			"main:commit 1",
+			"main:commit 2",
+			"main:commit 3",
+			"main:commit 4",
+		},
+		recorder.branchCommitMessages(),
+	)
+}
+
+func TestSyncRequireSignedCommits(t *testing.T) {
+	t.Parallel()
+	signer, err := openpgp.NewEntity("Buf TestBot", "", "testbot@buf.build", nil)
+	require.NoError(t, err)
+	otherSigner, err := openpgp.NewEntity("Other TestBot", "", "other@buf.build", nil)
+	require.NoError(t, err)
+	_, sshPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshSigner, err := ssh.NewSignerFromKey(sshPrivateKey)
+	require.NoError(t, err)
+	testRepo := newTestGitRepository(t)
+	// commits without the module are not verified
+	testRepo.commit("no module", map[string]string{"README.md": "readme"})
+	testRepo.commit("signed 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.sign(signer)
+	testRepo.commit("unsigned 1", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("other signer 1", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.sign(otherSigner)
+	testRepo.commit("ssh signed 1", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.signSSH(sshSigner)
+	testRepo.commit("signed 2", map[string]string{"proto/e.proto": testProtoFile("e")})
+	testRepo.sign(signer)
+	testRepo.push("main")
+	repo := testRepo.open()
+	keyring := openpgp.EntityList{signer}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("continue", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithRequireSignedCommits(keyring),
+		).Sync(context.Background(), recorder.syncFunc))
+		assert.Equal(t, []string{"main:signed 1", "main:signed 2"}, recorder.branchCommitMessages())
+		assert.Equal(t, []string{"unsigned 1", "other signer 1", "ssh signed 1"}, errorHandler.unsignedCommitCalls)
+	})
+	t.Run("ssh", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithRequireSignedCommits(keyring),
+			SyncerWithRequireSSHSignedCommits([]ssh.PublicKey{sshSigner.PublicKey()}),
+		).Sync(context.Background(), recorder.syncFunc))
+		assert.Equal(t, []string{"main:signed 1", "main:ssh signed 1", "main:signed 2"}, recorder.branchCommitMessages())
+		assert.Equal(t, []string{"unsigned 1", "other signer 1"}, errorHandler.unsignedCommitCalls)
+	})
+	t.Run("abort", func(t *testing.T) {
+		unsignedErr := errors.New("unsigned commit")
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{unsignedCommitErr: unsignedErr},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithRequireSignedCommits(keyring),
+		).Sync(context.Background(), recorder.syncFunc)
+		require.ErrorIs(t, err, unsignedErr)
+		var policyErr *PolicyError
+		require.ErrorAs(t, err, &policyErr)
+		assert.Equal(t, "unsigned 1", testCommitMessage(t, repo, policyErr.Commit))
+		assert.Equal(t, []string{"main:signed 1"}, recorder.branchCommitMessages())
+	})
+}
+
+func TestSyncVerifyRemoteContent(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	mockBSRChecker := newMockSyncGitChecker()
+	remoteDigests := make(map[string]*manifest.Digest)
+	syncFunc := func(ctx context.Context, moduleCommit ModuleCommit) error {
+		moduleManifest, _, err := manifest.NewFromBucket(ctx, moduleCommit.Bucket())
+		require.NoError(t, err)
+		manifestBlob, err := moduleManifest.Blob()
+		require.NoError(t, err)
+		mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+		remoteDigests[moduleCommit.Commit().Hash().Hex()] = manifestBlob.Digest()
+		return nil
+	}
+	remoteContentDigestResolver := func(_ context.Context, _ bufmoduleref.ModuleIdentity, gitHash git.Hash) (*manifest.Digest, error) {
+		return remoteDigests[gitHash.Hex()], nil
+	}
+	require.NoError(t, newTestSyncer(
+		t,
+		testRepo.open(),
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+	).Sync(context.Background(), syncFunc))
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	repo := testRepo.open()
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("match", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithVerifyRemoteContent(remoteContentDigestResolver),
+		).Sync(context.Background(), recorder.syncFunc))
+		assert.Equal(t, []string{"main:commit 3"}, recorder.branchCommitMessages())
+		assert.Empty(t, errorHandler.remoteContentMismatchCalls)
+	})
+	t.Run("mismatch", func(t *testing.T) {
+		mismatchErr := errors.New("remote content mismatch")
+		errorHandler := &mockErrorHandler{remoteContentMismatchErr: mismatchErr}
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithVerifyRemoteContent(func(ctx context.Context, module bufmoduleref.ModuleIdentity, gitHash git.Hash) (*manifest.Digest, error) {
+				// tamper the remote digest, as if a prior sync pushed different content
+				digest, err := remoteContentDigestResolver(ctx, module, gitHash)
+				if err != nil || digest == nil {
+					return digest, err
+				}
+				return manifest.NewDigestFromBytes(digest.Type(), append([]byte{0}, digest.Bytes()[1:]...))
+			}),
+		).Sync(context.Background(), recorder.syncFunc)
+		require.ErrorIs(t, err, mismatchErr)
+		var syncPointErr *SyncPointError
+		require.ErrorAs(t, err, &syncPointErr)
+		assert.Equal(t, "commit 2", testCommitMessage(t, repo, syncPointErr.SyncPoint))
+		assert.Equal(t, []string{"commit 2"}, errorHandler.remoteContentMismatchCalls)
+		assert.Empty(t, recorder.moduleCommits)
+	})
+	t.Run("mismatch_continue", func(t *testing.T) {
+		errorHandler := &mockErrorHandler{}
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithVerifyRemoteContent(remoteContentDigestResolver),
+			// the locally built content no longer matches the already synced content
+			SyncerWithBucketTransformer(func(context.Context, ModuleCommit, storage.ReadBucket) (storage.ReadBucket, error) {
+				return storagemem.NewReadBucket(map[string][]byte{"extra.proto": []byte(testProtoFile("extra"))})
+			}),
+		).Sync(context.Background(), recorder.syncFunc))
+		assert.Equal(t, []string{"commit 2"}, errorHandler.remoteContentMismatchCalls)
+		assert.Equal(t, []string{"main:commit 3"}, recorder.branchCommitMessages())
+	})
+}
+
+func TestSyncSkipIdenticalRemote(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("tag", "v1")
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	// remoteDigests are the digests of the content pushed to each label, as if a prior sync pushed
+	// commit 1 and failed before pushing commit 2.
+	remoteDigests := make(map[string]*manifest.Digest)
+	require.NoError(t, newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+	).Sync(context.Background(), func(ctx context.Context, moduleCommit ModuleCommit) error {
+		if strings.TrimSpace(moduleCommit.Commit().Message()) != "commit 1" {
+			return nil
+		}
+		moduleManifest, _, err := manifest.NewFromBucket(ctx, moduleCommit.Bucket())
+		require.NoError(t, err)
+		manifestBlob, err := moduleManifest.Blob()
+		require.NoError(t, err)
+		for _, label := range append([]string{moduleCommit.Label()}, moduleCommit.Tags()...) {
+			remoteDigests[label] = manifestBlob.Digest()
+		}
+		return nil
+	}))
+	require.Len(t, remoteDigests, 2)
+	// syncSkippingIdentical syncs the repository, skipping the module commits identical to the remote
+	// digests, and returns the synced and skipped commits.
+	syncSkippingIdentical := func(t *testing.T, remoteDigests map[string]*manifest.Digest) ([]string, []string) {
+		recorder := &syncFuncRecorder{}
+		var skipped []string
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithSkipIdenticalRemote(
+				func(_ context.Context, _ bufmoduleref.ModuleIdentity, label string) (*manifest.Digest, error) {
+					return remoteDigests[label], nil
+				},
+				func(_ context.Context, moduleCommit ModuleCommit) {
+					skipped = append(skipped, strings.TrimSpace(moduleCommit.Commit().Message()))
+				},
+			),
+		).Sync(context.Background(), recorder.syncFunc))
+		return recorder.branchCommitMessages(), skipped
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("identical", func(t *testing.T) {
+		synced, skipped := syncSkippingIdentical(t, remoteDigests)
+		assert.Equal(t, []string{"main:commit 2"}, synced)
+		assert.Equal(t, []string{"commit 1"}, skipped)
+	})
+	t.Run("differing", func(t *testing.T) {
+		differingDigests := make(map[string]*manifest.Digest, len(remoteDigests))
+		for label, digest := range remoteDigests {
+			// tamper the remote digest, as if a prior sync pushed different content
+			differingDigest, err := manifest.NewDigestFromBytes(digest.Type(), append([]byte{0}, digest.Bytes()[1:]...))
+			require.NoError(t, err)
+			differingDigests[label] = differingDigest
+		}
+		synced, skipped := syncSkippingIdentical(t, differingDigests)
+		assert.Equal(t, []string{"main:commit 1", "main:commit 2"}, synced)
+		assert.Empty(t, skipped)
+	})
+	t.Run("missing_tag", func(t *testing.T) {
+		missingTagDigests := make(map[string]*manifest.Digest, len(remoteDigests))
+		for label, digest := range remoteDigests {
+			if label != "v1" {
+				missingTagDigests[label] = digest
+			}
+		}
+		// the push would still move the tag
+		synced, skipped := syncSkippingIdentical(t, missingTagDigests)
+		assert.Equal(t, []string{"main:commit 1", "main:commit 2"}, synced)
+		assert.Empty(t, skipped)
+	})
+}
+
+func TestSyncRootModule(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", map[string]string{
+		"buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+		"a.proto":  testProtoFile("a"),
+	})
+	testRepo.commit("invalid config", map[string]string{"buf.yaml": "version: v1\nname: buf.test/owner/repo\nunknown: field\n"})
+	testRepo.commit("commit 2", map[string]string{
+		"buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+		"b.proto":  testProtoFile("b"),
+	})
+	testRepo.push("main")
+	moduleIdentity, err := bufmoduleref.ModuleIdentityForString("buf.test/owner/repo")
+	require.NoError(t, err)
+	rootModule, err := NewModule(".", moduleIdentity)
+	require.NoError(t, err)
+	recorder := &syncFuncRecorder{}
+	require.NoError(t, newTestSyncer(
+		t,
+		testRepo.open(),
+		&mockErrorHandler{},
+		SyncerWithModule(rootModule),
+	).Sync(context.Background(), recorder.syncFunc))
+	// the commit with an invalid root module config is skipped
+	assert.Equal(t, []string{"main:commit 1", "main:commit 2"}, recorder.branchCommitMessages())
+	require.Len(t, recorder.moduleCommits, 2)
+	paths, err := storage.AllPaths(context.Background(), recorder.moduleCommits[1].Bucket(), "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.proto", "b.proto", "buf.yaml"}, paths)
+}
+
+func TestSyncWorkspace(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", map[string]string{
+		"buf.work.yaml":    "version: v1\ndirectories:\n  - proto/a\n  - proto/b\n",
+		"proto/a/buf.yaml": "version: v1\nname: buf.test/owner/a\n",
+		"proto/a/a.proto":  testProtoFile("a"),
+		"proto/b/buf.yaml": "version: v1\nname: buf.test/owner/b\n",
+		"proto/b/b.proto":  testProtoFile("b"),
+	})
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncedModules syncs the repository, and returns the synced modules in the format
+	// <remote identity>:<comma separated paths>.
+	syncedModules := func(t *testing.T, options ...SyncerOption) []string {
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			options...,
+		).Sync(context.Background(), recorder.syncFunc))
+		var modules []string
+		for _, moduleCommit := range recorder.moduleCommits {
+			paths, err := storage.AllPaths(context.Background(), moduleCommit.Bucket(), "")
+			require.NoError(t, err)
+			sort.Strings(paths)
+			modules = append(
+				modules,
+				moduleCommit.Identity().IdentityString()+":"+strings.Join(paths, ","),
+			)
+		}
+		return modules
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("workspace", func(t *testing.T) {
+		assert.ElementsMatch(
+			t,
+			[]string{"buf.test/owner/a:a.proto,buf.yaml", "buf.test/owner/b:b.proto,buf.yaml"},
+			syncedModules(t, SyncerWithWorkspace(".")),
+		)
+	})
+	t.Run("with_module", func(t *testing.T) {
+		assert.ElementsMatch(
+			t,
+			[]string{"buf.test/owner/a:a.proto,buf.yaml", "buf.test/owner/override:b.proto,buf.yaml"},
+			syncedModules(
+				t,
+				SyncerWithModule(newTestSyncableModule(t, "proto/b", "buf.test/owner/override")),
+				SyncerWithWorkspace("."),
+			),
+		)
+	})
+	t.Run("no_workspace", func(t *testing.T) {
+		// the workspace is read on sync
+		syncer, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithWorkspace("proto"),
+		)
+		require.NoError(t, err)
+		err = syncer.Sync(context.Background(), (&syncFuncRecorder{}).syncFunc)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `workspace "proto": no buf.work.yaml found`)
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithWorkspace("."),
+			SyncerWithWorkspace("./"),
+		)
+		require.Error(t, err)
+	})
+}
+
+func TestSyncWorkspaceMissingModuleConfig(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", map[string]string{
+		"buf.work.yaml": "version: v1\ndirectories:\n  - a\n  - b\n",
+		"a/buf.yaml":    "version: v1\nname: buf.test/owner/a\n",
+		"a/a.proto":     testProtoFile("a"),
+		"b/b.proto":     testProtoFile("b"),
+	})
+	testRepo.push("main")
+	repo := testRepo.open()
+	syncer, err := NewSyncer(
+		zap.NewNop(),
+		repo,
+		storagegit.NewProvider(repo.Objects()),
+		&mockErrorHandler{},
+		SyncerWithWorkspace("."),
+	)
+	require.NoError(t, err)
+	_, err = syncer.Plan(context.Background())
+	var modulesConfigErr *ModulesConfigError
+	require.ErrorAs(t, err, &modulesConfigErr)
+	assert.Contains(t, err.Error(), `workspace ".": directory "b" has no buf.yaml`)
+}
+
+func TestSyncRunID(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncRunLogs syncs the repository, and returns the run_id field of every emitted log.
+	syncRunLogs := func(t *testing.T, options ...SyncerOption) []interface{} {
+		core, logs := observer.New(zap.DebugLevel)
+		syncer, err := NewSyncer(
+			zap.New(core),
+			repo,
+			storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+			&mockErrorHandler{},
+			append(options, SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")))...,
+		)
+		require.NoError(t, err)
+		require.NoError(t, syncer.Sync(context.Background(), func(context.Context, ModuleCommit) error {
+			return nil
+		}))
+		require.NotZero(t, logs.Len())
+		runIDs := make([]interface{}, 0, logs.Len())
+		for _, entry := range logs.AllUntimed() {
+			runIDs = append(runIDs, entry.ContextMap()["run_id"])
+		}
+		return runIDs
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("configured", func(t *testing.T) {
+		for _, runID := range syncRunLogs(t, SyncerWithRunID("test-run")) {
+			assert.Equal(t, "test-run", runID)
+		}
+	})
+	t.Run("generated", func(t *testing.T) {
+		runIDs := syncRunLogs(t)
+		generatedRunID, ok := runIDs[0].(string)
+		require.True(t, ok)
+		require.NoError(t, uuidutil.Validate(generatedRunID))
+		for _, runID := range runIDs {
+			assert.Equal(t, generatedRunID, runID)
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+			&mockErrorHandler{},
+			SyncerWithRunID(""),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncTelemetry(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commit1 := testRepo.commit("commit 1", map[string]string{"README.md": "# repo"})
+	commit2 := testRepo.commit("commit 2", newTestModuleFiles("buf.test/owner/repo", "a"))
+	commit3 := testRepo.commit("commit 3", map[string]string{"proto/buf.yaml": "version: v42\n"})
+	commit4 := testRepo.commit("commit 4", newTestModuleFiles("buf.test/owner/repo", "b"))
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncWithTelemetry syncs the repository, and returns the ended spans and the counters.
+	syncWithTelemetry := func(t *testing.T, syncFunc SyncFunc) ([]sdktrace.ReadOnlySpan, map[string]int64, error) {
+		spanRecorder := tracetest.NewSpanRecorder()
+		meterProvider := &recordingMeterProvider{counts: make(map[string]int64)}
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder))),
+			SyncerWithMeterProvider(meterProvider),
+		)
+		err := syncer.Sync(context.Background(), syncFunc)
+		return spanRecorder.Ended(), meterProvider.counts, err
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("synced", func(t *testing.T) {
+		recorder := &syncFuncRecorder{}
+		spans, counts, err := syncWithTelemetry(t, recorder.syncFunc)
+		require.NoError(t, err)
+		spansByName := make(map[string][]sdktrace.ReadOnlySpan)
+		for _, span := range spans {
+			spansByName[span.Name()] = append(spansByName[span.Name()], span)
+		}
+		require.Len(t, spansByName["sync"], 1)
+		syncSpan := spansByName["sync"][0]
+		assert.False(t, syncSpan.Parent().IsValid())
+		require.Len(t, spansByName["sync_branch"], 1)
+		branchSpan := spansByName["sync_branch"][0]
+		assert.Equal(t, syncSpan.SpanContext().SpanID(), branchSpan.Parent().SpanID())
+		assert.Equal(t, map[string]string{"branch": "main"}, testSpanAttributes(branchSpan))
+		require.Len(t, spansByName["commits_to_sync"], 1)
+		assert.Equal(t, branchSpan.SpanContext().SpanID(), spansByName["commits_to_sync"][0].Parent().SpanID())
+		var buildSpansAttributes []map[string]string
+		for _, buildSpan := range spansByName["build_module"] {
+			assert.Equal(t, branchSpan.SpanContext().SpanID(), buildSpan.Parent().SpanID())
+			buildSpansAttributes = append(buildSpansAttributes, testSpanAttributes(buildSpan))
+		}
+		assert.Equal(
+			t,
+			[]map[string]string{
+				{"module": "buf.test/owner/repo", "branch": "main", "commit": commit1.Hex(), "skip_reason": "module not found"},
+				{"module": "buf.test/owner/repo", "branch": "main", "commit": commit2.Hex()},
+				{"module": "buf.test/owner/repo", "branch": "main", "commit": commit3.Hex(), "skip_reason": "invalid module config"},
+				{"module": "buf.test/owner/repo", "branch": "main", "commit": commit4.Hex()},
+			},
+			buildSpansAttributes,
+		)
+		var pushSpansAttributes []map[string]string
+		for _, pushSpan := range spansByName["push_module_commit"] {
+			assert.Equal(t, branchSpan.SpanContext().SpanID(), pushSpan.Parent().SpanID())
+			pushSpansAttributes = append(pushSpansAttributes, testSpanAttributes(pushSpan))
+		}
+		assert.Equal(
+			t,
+			[]map[string]string{
+				{"module": "buf.test/owner/repo", "branch": "main", "commit": commit2.Hex()},
+				{"module": "buf.test/owner/repo", "branch": "main", "commit": commit4.Hex()},
+			},
+			pushSpansAttributes,
+		)
+		for _, span := range spans {
+			assert.Equal(t, codes.Unset, span.Status().Code, span.Name())
+		}
+		assert.Equal(
+			t,
+			map[string]int64{
+				syncedCommitsCounterName:  2,
+				skippedCommitsCounterName: 1,
+				failedCommitsCounterName:  1,
+			},
+			counts,
+		)
+	})
+	t.Run("push_failure", func(t *testing.T) {
+		spans, counts, err := syncWithTelemetry(t, func(context.Context, ModuleCommit) error {
+			return errors.New("push failed")
+		})
+		var pushErr *PushError
+		require.ErrorAs(t, err, &pushErr)
+		spanStatuses := make(map[string]codes.Code)
+		for _, span := range spans {
+			spanStatuses[span.Name()] = span.Status().Code
+		}
+		assert.Equal(
+			t,
+			map[string]codes.Code{
+				"sync":               codes.Error,
+				"sync_branch":        codes.Error,
+				"commits_to_sync":    codes.Unset,
+				"build_module":       codes.Unset,
+				"push_module_commit": codes.Error,
+			},
+			spanStatuses,
+		)
+		assert.Equal(
+			t,
+			map[string]int64{
+				skippedCommitsCounterName: 1,
+				failedCommitsCounterName:  1,
+			},
+			counts,
+		)
+	})
+}
+
+func TestSyncGitNotes(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("notes", "add", "-m", "first note")
+	testRepo.git("notes", "--ref", "review", "add", "-m", "approved")
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.git("notes", "--ref", "review", "add", "-m", "changes requested")
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncNotes syncs the repository, and returns the notes of every synced commit keyed by commit
+	// message.
+	syncNotes := func(t *testing.T, options ...SyncerOption) map[string]map[string]string {
+		var recorder syncFuncRecorder
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(options, SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")))...,
+		)
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		notesByCommitMessage := make(map[string]map[string]string)
+		for _, moduleCommit := range recorder.moduleCommits {
+			notesByCommitMessage[strings.TrimSpace(moduleCommit.Commit().Message())] = moduleCommit.Notes()
+		}
+		return notesByCommitMessage
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("multiple_refs", func(t *testing.T) {
+		notes := syncNotes(t, SyncerWithGitNotes("refs/notes/commits"), SyncerWithGitNotes("review"))
+		assert.Equal(
+			t,
+			map[string]map[string]string{
+				"commit 1": {
+					"refs/notes/commits": "first note\n",
+					"refs/notes/review":  "approved\n",
+				},
+				"commit 2": nil,
+				"commit 3": {
+					"refs/notes/review": "changes requested\n",
+				},
+			},
+			notes,
+		)
+	})
+	t.Run("missing_ref", func(t *testing.T) {
+		notes := syncNotes(t, SyncerWithGitNotes("unknown"))
+		assert.Len(t, notes, 3)
+		for _, commitNotes := range notes {
+			assert.Empty(t, commitNotes)
+		}
+	})
+	t.Run("not_configured", func(t *testing.T) {
+		notes := syncNotes(t)
+		assert.Len(t, notes, 3)
+		for _, commitNotes := range notes {
+			assert.Empty(t, commitNotes)
+		}
+	})
+	t.Run("empty_ref", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+			&mockErrorHandler{},
+			SyncerWithGitNotes(""),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncCommitAnnotator(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	files := newTestModuleFiles("buf.test/owner/repo", "a")
+	files["other/buf.yaml"] = "version: v1\nname: buf.test/owner/other\n"
+	files["other/o.proto"] = testProtoFile("o")
+	commit1 := testRepo.commit("commit 1", files)
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	newAnnotatorSyncer := func(t *testing.T, annotator CommitAnnotator) Syncer {
+		return newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithModule(newTestSyncableModule(t, "other", "buf.test/owner/other")),
+			SyncerWithCommitAnnotator(annotator),
+		)
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("annotations", func(t *testing.T) {
+		annotatedCommits := make(map[string]int)
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newAnnotatorSyncer(t, func(commit git.Commit) (map[string]string, error) {
+			annotatedCommits[commit.Hash().Hex()]++
+			if commit.Hash().Hex() != commit1.Hex() {
+				return nil, nil
+			}
+			return map[string]string{"build": "42", "source": "https://ci.example.com/42"}, nil
+		}).Sync(context.Background(), recorder.syncFunc))
+		require.Len(t, recorder.moduleCommits, 4)
+		for _, moduleCommit := range recorder.moduleCommits {
+			if moduleCommit.Commit().Hash().Hex() == commit1.Hex() {
+				assert.Equal(t, map[string]string{"build": "42", "source": "https://ci.example.com/42"}, moduleCommit.Annotations())
+			} else {
+				assert.Nil(t, moduleCommit.Annotations())
+			}
+		}
+		// annotated once per commit, for both modules
+		assert.Len(t, annotatedCommits, 2)
+		for _, count := range annotatedCommits {
+			assert.Equal(t, 1, count)
+		}
+	})
+	t.Run("annotator_error", func(t *testing.T) {
+		recorder := &syncFuncRecorder{}
+		err := newAnnotatorSyncer(t, func(commit git.Commit) (map[string]string, error) {
+			return nil, errors.New("annotator failure")
+		}).Sync(context.Background(), recorder.syncFunc)
+		assert.ErrorContains(t, err, "annotator failure")
+		assert.Empty(t, recorder.moduleCommits)
+	})
+	t.Run("nil_annotator", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+			&mockErrorHandler{},
+			SyncerWithCommitAnnotator(nil),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncContinueOnBranchError(t *testing.T) {
+	t.Parallel()
+	// | o-o (main)
+	// |   ├o (a)
+	// |   |└o (b)
+	// |   └o (c)
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	testRepo.git("checkout", "-b", "a")
+	testRepo.commit("a 1", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("a")
+	testRepo.git("checkout", "-b", "b")
+	testRepo.commit("b 1", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.push("b")
+	testRepo.git("checkout", "main")
+	testRepo.git("checkout", "-b", "c")
+	testRepo.commit("c 1", map[string]string{"proto/e.proto": testProtoFile("e")})
+	testRepo.push("c")
+	testRepo.git("checkout", "main")
+	repo := testRepo.open()
+	pushErr := errors.New("push failed")
+	// syncFailingCommit syncs all branches, failing to push commit "a 1".
+	syncFailingCommit := func(t *testing.T, options ...SyncerOption) ([]string, error) {
+		recorder := &syncFuncRecorder{}
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithAllBranches(),
+			)...,
+		)
+		err := syncer.Sync(context.Background(), func(ctx context.Context, moduleCommit ModuleCommit) error {
+			if strings.TrimSpace(moduleCommit.Commit().Message()) == "a 1" {
+				return pushErr
+			}
+			return recorder.syncFunc(ctx, moduleCommit)
+		})
+		return recorder.branchCommitMessages(), err
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("fail_fast", func(t *testing.T) {
+		synced, err := syncFailingCommit(t)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, pushErr)
+		assert.Equal(t, []string{"main:commit 1", "main:commit 2"}, synced)
+	})
+	t.Run("continue", func(t *testing.T) {
+		synced, err := syncFailingCommit(t, SyncerWithContinueOnBranchError())
+		require.Error(t, err)
+		// b is stacked on top of a, so it is skipped, but c is independent from a.
+		assert.Equal(t, []string{"main:commit 1", "main:commit 2", "c:c 1"}, synced)
+		branchErrs := multierr.Errors(err)
+		require.Len(t, branchErrs, 2)
+		var pushError *PushError
+		require.ErrorAs(t, branchErrs[0], &pushError)
+		assert.Equal(t, "a", pushError.Branch)
+		assert.ErrorIs(t, branchErrs[0], pushErr)
+		assert.Contains(t, branchErrs[1].Error(), `sync branch "b"`)
+		assert.Contains(t, branchErrs[1].Error(), `depends on failed branch "a"`)
+	})
+}
+
+func TestSyncModuleCommitParents(t *testing.T) {
+	t.Parallel()
+	// | o-o---o (main)
+	// |  └o┘ (feature)
+	testRepo := newTestGitRepository(t)
+	commit1 := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("branch", "feature")
+	commit2 := testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("checkout", "feature")
+	feature1 := testRepo.commit("feature 1", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.git("checkout", "main")
+	testRepo.git("merge", "--no-ff", "-m", "merge feature", "feature")
+	testRepo.push("main")
+	repo := testRepo.open()
+
+	recorder := &syncFuncRecorder{}
+	syncer := newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+	)
+	require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+	require.Equal(
+		t,
+		[]string{"main:commit 1", "main:commit 2", "main:merge feature"},
+		recorder.branchCommitMessages(),
+	)
+	assert.Empty(t, recorder.moduleCommits[0].Parents())
+	assert.Equal(t, []git.Hash{commit1}, recorder.moduleCommits[1].Parents())
+	assert.Equal(t, []git.Hash{commit2, feature1}, recorder.moduleCommits[2].Parents())
+}
+
+func TestSyncModuleOrder(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", map[string]string{
+		"a/buf.yaml": "version: v1\nname: buf.test/owner/a\n",
+		"a/a.proto":  testProtoFile("a"),
+		"b/buf.yaml": "version: v1\nname: buf.test/owner/b\n",
+		"b/b.proto":  testProtoFile("b"),
+		"c/buf.yaml": "version: v1\nname: buf.test/owner/c\n",
+		"c/c.proto":  testProtoFile("c"),
+	})
+	testRepo.commit("commit 2", map[string]string{
+		"a/a2.proto": testProtoFile("a2"),
+		"b/b2.proto": testProtoFile("b2"),
+		"c/c2.proto": testProtoFile("c2"),
+	})
+	testRepo.push("main")
+	repo := testRepo.open()
+	moduleOptions := []SyncerOption{
+		SyncerWithModule(newTestSyncableModule(t, "a", "buf.test/owner/a")),
+		SyncerWithModule(newTestSyncableModule(t, "b", "buf.test/owner/b")),
+		SyncerWithModule(newTestSyncableModule(t, "c", "buf.test/owner/c")),
+	}
+	newModuleOrder := func(t *testing.T, identities ...string) []bufmoduleref.ModuleIdentity {
+		moduleOrder := make([]bufmoduleref.ModuleIdentity, 0, len(identities))
+		for _, identity := range identities {
+			moduleIdentity, err := bufmoduleref.ModuleIdentityForString(identity)
+			require.NoError(t, err)
+			moduleOrder = append(moduleOrder, moduleIdentity)
+		}
+		return moduleOrder
+	}
+	// syncedModules syncs the repository, and returns the synced modules in the format
+	// <commit message>:<remote identity>, in the order they were pushed.
+	syncedModules := func(t *testing.T, options ...SyncerOption) []string {
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(moduleOptions, options...)...,
+		).Sync(context.Background(), recorder.syncFunc))
+		var modules []string
+		for _, moduleCommit := range recorder.moduleCommits {
+			modules = append(
+				modules,
+				strings.TrimSpace(moduleCommit.Commit().Message())+":"+moduleCommit.Identity().IdentityString(),
+			)
+		}
+		return modules
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("registration_order", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"commit 1:buf.test/owner/a",
+			"commit 1:buf.test/owner/b",
+			"commit 1:buf.test/owner/c",
+			"commit 2:buf.test/owner/a",
+			"commit 2:buf.test/owner/b",
+			"commit 2:buf.test/owner/c",
+		}, syncedModules(t))
+	})
+	t.Run("module_order", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"commit 1:buf.test/owner/c",
+			"commit 1:buf.test/owner/b",
+			"commit 1:buf.test/owner/a",
+			"commit 2:buf.test/owner/c",
+			"commit 2:buf.test/owner/b",
+			"commit 2:buf.test/owner/a",
+		}, syncedModules(t, SyncerWithModuleOrder(newModuleOrder(t, "buf.test/owner/c", "buf.test/owner/b"))))
+	})
+	t.Run("unlisted_modules_last", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"commit 1:buf.test/owner/b",
+			"commit 1:buf.test/owner/a",
+			"commit 1:buf.test/owner/c",
+			"commit 2:buf.test/owner/b",
+			"commit 2:buf.test/owner/a",
+			"commit 2:buf.test/owner/c",
+		}, syncedModules(t, SyncerWithModuleOrder(newModuleOrder(t, "buf.test/owner/b"))))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, moduleOrder := range [][]bufmoduleref.ModuleIdentity{
+			newModuleOrder(t, "buf.test/owner/b", "buf.test/owner/b"),
+			newModuleOrder(t, "buf.test/owner/unknown"),
+		} {
+			_, err := NewSyncer(
+				zap.NewNop(),
+				repo,
+				nil,
+				&mockErrorHandler{},
+				append(moduleOptions, SyncerWithModuleOrder(moduleOrder))...,
+			)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestNewSyncerDuplicateRemoteIdentity(t *testing.T) {
+	t.Parallel()
+	_, err := NewSyncer(
+		zap.NewNop(),
+		nil,
+		nil,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "team1/proto", "buf.test/owner/repo")),
+		SyncerWithModule(newTestSyncableModule(t, "other", "buf.test/owner/other")),
+		SyncerWithModule(newTestSyncableModule(t, "team2/proto", "buf.test/owner/repo")),
+	)
+	require.Error(t, err)
+	assert.Equal(
+		t,
+		`modules in dirs "team1/proto" and "team2/proto" are all synced to the same remote identity buf.test/owner/repo`,
+		err.Error(),
+	)
+	_, err = NewSyncer(
+		zap.NewNop(),
+		nil,
+		nil,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "team1/proto", "buf.test/owner/repo")),
+		SyncerWithModule(newTestSyncableModule(t, "team2/proto", "buf.test/owner/other")),
+	)
+	require.NoError(t, err)
+}
+
+func TestSyncCommitLabelMapper(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1\n\nChange-Id: I1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2\n\nChange-Id: I2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	changeIDMapper := func(commit git.Commit) (string, error) {
+		for _, line := range strings.Split(commit.Message(), "\n") {
+			if strings.HasPrefix(line, "Change-Id: ") {
+				return strings.TrimPrefix(line, "Change-Id: "), nil
+			}
+		}
+		return "", errors.New("no change ID")
+	}
+	mockBSRChecker := newMockSyncGitChecker()
+	var checkedLabels []string
+	checker := func(ctx context.Context, module bufmoduleref.ModuleIdentity, commitHashes map[string]struct{}) (map[string]struct{}, error) {
+		for commitHash := range commitHashes {
+			checkedLabels = append(checkedLabels, commitHash)
+		}
+		return mockBSRChecker.checkFunc()(ctx, module, commitHashes)
+	}
+	// syncLabels syncs the repository, and returns the labels of the synced commits.
+	syncLabels := func(t *testing.T, repo git.Repository, options ...SyncerOption) []string {
+		var labels []string
+		syncer := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithGitCommitChecker(checker),
+			)...,
+		)
+		require.NoError(t, syncer.Sync(context.Background(), func(_ context.Context, moduleCommit ModuleCommit) error {
+			mockBSRChecker.markSynced(moduleCommit.Label())
+			labels = append(labels, moduleCommit.Label())
+			return nil
+		}))
+		return labels
+	}
+
+	assert.Equal(t, []string{"I1", "I2"}, syncLabels(t, repo, SyncerWithCommitLabelMapper(changeIDMapper)))
+	testRepo.commit("commit 3\n\nChange-Id: I3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	repo = testRepo.open()
+	// resuming queries the checker with the mapped labels, so only the new commit is synced
+	assert.Equal(t, []string{"I3"}, syncLabels(t, repo, SyncerWithCommitLabelMapper(changeIDMapper)))
+	assert.Subset(t, []string{"I1", "I2", "I3"}, checkedLabels)
+	// without a mapper, commits are labeled with their hash, which is not synced yet
+	assert.Equal(
+		t,
+		[]string{
+			testRepo.git("rev-parse", "HEAD~2"),
+			testRepo.git("rev-parse", "HEAD~1"),
+			testRepo.git("rev-parse", "HEAD"),
+		},
+		syncLabels(t, repo),
+	)
+
+	syncer := newTestSyncer(
+		t,
+		repo,
+		&mockErrorHandler{},
+		SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+		SyncerWithCommitLabelMapper(func(git.Commit) (string, error) {
+			return "", nil
+		}),
+	)
+	assert.Error(t, syncer.Sync(context.Background(), func(context.Context, ModuleCommit) error {
+		return nil
+	}))
+}
+
+func TestSyncResumeOverrides(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commit1 := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	commit2 := testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.git("checkout", "-b", "feature", "HEAD~1")
+	featureCommit := testRepo.commit("feature 1", map[string]string{"proto/f.proto": testProtoFile("f")})
+	testRepo.git("checkout", "main")
+	testRepo.push("main", "feature")
+	repo := testRepo.open()
+	mockBSRChecker := newMockSyncGitChecker()
+	mockBSRChecker.markSynced(commit1.Hex())
+	mockBSRChecker.markSynced(commit2.Hex())
+	// the resolver returns a stale sync point for every module and branch
+	var resolvedBranches []string
+	resolver := func(_ context.Context, _ bufmoduleref.ModuleIdentity, branch string) (git.Hash, error) {
+		resolvedBranches = append(resolvedBranches, branch)
+		return commit1, nil
+	}
+	// writeResumeOverrides writes the passed content to a file, and returns its path.
+	writeResumeOverrides := func(t *testing.T, content string) string {
+		overridesPath := filepath.Join(t.TempDir(), "overrides")
+		require.NoError(t, os.WriteFile(overridesPath, []byte(content), 0600))
+		return overridesPath
+	}
+	newResumeOverridesSyncer := func(t *testing.T, overridesPath string) (Syncer, error) {
+		return NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithAllBranches(),
+			SyncerWithResumption(resolver),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithResumeOverrides(overridesPath),
+		)
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("overridden_branch", func(t *testing.T) {
+		resolvedBranches = nil
+		syncer, err := newResumeOverridesSyncer(t, writeResumeOverrides(
+			t,
+			"buf.test/owner/other main "+commit1.Hex()+"\n",
+		))
+		require.NoError(t, err)
+		// the stale sync point does not match the synced commit in the default branch
+		err = syncer.Sync(context.Background(), func(context.Context, ModuleCommit) error {
+			return nil
+		})
+		assert.ErrorContains(t, err, "did you rebase or reset your default branch?")
+		var syncPointErr *SyncPointError
+		require.ErrorAs(t, err, &syncPointErr)
+		assert.Equal(t, "main", syncPointErr.Branch)
+		assert.Equal(t, commit1.Hex(), syncPointErr.SyncPoint.Hex())
+		assert.ElementsMatch(t, []string{"main", "feature"}, resolvedBranches)
+
+		resolvedBranches = nil
+		syncer, err = newResumeOverridesSyncer(t, writeResumeOverrides(
+			t,
+			"# main sync points are corrupted in the BSR\n\nbuf.test/owner/repo main "+commit2.Hex()+"\n",
+		))
+		require.NoError(t, err)
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, syncer.Sync(context.Background(), recorder.syncFunc))
+		assert.Equal(t, []string{"main:commit 3", "feature:feature 1"}, recorder.branchCommitMessages())
+		// main resumes from the override, feature falls back to the resolver
+		assert.Equal(t, []string{"feature"}, resolvedBranches)
+	})
+	t.Run("not_an_ancestor", func(t *testing.T) {
+		syncer, err := newResumeOverridesSyncer(t, writeResumeOverrides(
+			t,
+			"buf.test/owner/repo main "+featureCommit.Hex()+"\n",
+		))
+		require.NoError(t, err)
+		recorder := &syncFuncRecorder{}
+		assert.ErrorContains(t, syncer.Sync(context.Background(), recorder.syncFunc), "is not an ancestor")
+		assert.Empty(t, recorder.moduleCommits)
+	})
+	t.Run("unknown_commit", func(t *testing.T) {
+		syncer, err := newResumeOverridesSyncer(t, writeResumeOverrides(
+			t,
+			"buf.test/owner/repo main "+strings.Repeat("a", 40)+"\n",
+		))
+		require.NoError(t, err)
+		assert.Error(t, syncer.Sync(context.Background(), func(context.Context, ModuleCommit) error {
+			return nil
+		}))
+	})
+	t.Run("invalid_file", func(t *testing.T) {
+		for _, content := range []string{
+			"buf.test/owner/repo main\n",
+			"buf.test/owner main " + commit2.Hex() + "\n",
+			"buf.test/owner/repo main not-a-hash\n",
+			"buf.test/owner/repo main " + commit1.Hex() + "\nbuf.test/owner/repo main " + commit2.Hex() + "\n",
+		} {
+			_, err := newResumeOverridesSyncer(t, writeResumeOverrides(t, content))
+			assert.Error(t, err, content)
+		}
+		_, err := newResumeOverridesSyncer(t, filepath.Join(t.TempDir(), "missing"))
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncLocalResumePoint(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commit1 := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	commit2 := testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.git("checkout", "-b", "feature", "HEAD~1")
+	featureCommit := testRepo.commit("feature 1", map[string]string{"proto/f.proto": testProtoFile("f")})
+	testRepo.git("checkout", "main")
+	testRepo.push("main", "feature")
+	repo := testRepo.open()
+	// the resolver fails for every module and branch, it must be bypassed
+	var resolvedBranches []string
+	resolver := func(_ context.Context, _ bufmoduleref.ModuleIdentity, branch string) (git.Hash, error) {
+		resolvedBranches = append(resolvedBranches, branch)
+		return nil, errors.New("unexpected sync point resolution")
+	}
+	syncWithLocalResumePoint := func(t *testing.T, hash git.Hash, options ...SyncerOption) ([]string, error) {
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithResumption(resolver),
+				SyncerWithLocalResumePoint("main", hash),
+			)...,
+		).Sync(context.Background(), recorder.syncFunc)
+		return recorder.branchCommitMessages(), err
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("resume", func(t *testing.T) {
+		resolvedBranches = nil
+		synced, err := syncWithLocalResumePoint(t, commit1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"main:commit 2", "main:commit 3"}, synced)
+		assert.Empty(t, resolvedBranches)
+	})
+	t.Run("already_synced_after_resume_point", func(t *testing.T) {
+		mockBSRChecker := newMockSyncGitChecker()
+		mockBSRChecker.markSynced(commit1.Hex())
+		mockBSRChecker.markSynced(commit2.Hex())
+		synced, err := syncWithLocalResumePoint(t, commit1, SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"main:commit 3"}, synced)
+	})
+	t.Run("other_branches_resolved", func(t *testing.T) {
+		resolvedBranches = nil
+		_, err := syncWithLocalResumePoint(t, commit2, SyncerWithAllBranches())
+		assert.ErrorContains(t, err, "unexpected sync point resolution")
+		assert.Equal(t, []string{"feature"}, resolvedBranches)
+	})
+	t.Run("not_an_ancestor", func(t *testing.T) {
+		synced, err := syncWithLocalResumePoint(t, featureCommit)
+		assert.ErrorContains(t, err, "is not an ancestor")
+		assert.Empty(t, synced)
+	})
+	t.Run("unknown_commit", func(t *testing.T) {
+		unknownHash, err := git.NewHashFromHex(strings.Repeat("a", 40))
+		require.NoError(t, err)
+		_, err = syncWithLocalResumePoint(t, unknownHash)
+		assert.Error(t, err)
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			nil,
+			&mockErrorHandler{},
+			SyncerWithLocalResumePoint("main", commit1),
+			SyncerWithLocalResumePoint("main", commit2),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncBranchNameMapper(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("checkout", "-b", "release/v2")
+	testRepo.commit("release 1", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.git("checkout", "main")
+	testRepo.push("main", "release/v2")
+	branchNameMapper := func(gitBranch string) string {
+		switch gitBranch {
+		case "main":
+			return "trunk"
+		case "release/v2":
+			return "v2-stable"
+		default:
+			return gitBranch
+		}
+	}
+	mockBSRChecker := newMockSyncGitChecker()
+	// syncPoints are the last synced commits, keyed by the branch they were synced to.
+	syncPoints := make(map[string]git.Hash)
+	// syncBranches syncs all the branches with the branch name mapper, and returns the synced commits
+	// and the branches the sync points were resolved for.
+	syncBranches := func(t *testing.T, repo git.Repository, mapper BranchNameMapper) ([]string, []string, error) {
+		var resolvedBranches []string
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithAllBranches(),
+			SyncerWithBranchNameMapper(mapper),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithResumption(func(_ context.Context, _ bufmoduleref.ModuleIdentity, branch string) (git.Hash, error) {
+				resolvedBranches = append(resolvedBranches, branch)
+				return syncPoints[branch], nil
+			}),
+			SyncerWithModuleDefaultBranchGetter(func(context.Context, bufmoduleref.ModuleIdentity) (string, error) {
+				return "trunk", nil
+			}),
+		).Sync(context.Background(), func(ctx context.Context, moduleCommit ModuleCommit) error {
+			mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+			syncPoints[moduleCommit.Branch()] = moduleCommit.Commit().Hash()
+			return recorder.syncFunc(ctx, moduleCommit)
+		})
+		sort.Strings(resolvedBranches)
+		return recorder.branchCommitMessages(), resolvedBranches, err
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("first_run", func(t *testing.T) {
+		synced, resolvedBranches, err := syncBranches(t, testRepo.open(), branchNameMapper)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"trunk:commit 1", "v2-stable:release 1"}, synced)
+		assert.Equal(t, []string{"trunk", "v2-stable"}, resolvedBranches)
+	})
+	t.Run("second_run", func(t *testing.T) {
+		testRepo.git("checkout", "release/v2")
+		release2 := testRepo.commit("release 2", map[string]string{"proto/c.proto": testProtoFile("c")})
+		testRepo.git("checkout", "main")
+		testRepo.push("release/v2")
+		synced, resolvedBranches, err := syncBranches(t, testRepo.open(), branchNameMapper)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"v2-stable:release 2"}, synced)
+		assert.Equal(t, []string{"trunk", "v2-stable"}, resolvedBranches)
+		assert.Equal(t, release2, syncPoints["v2-stable"])
+		assert.NotContains(t, syncPoints, "release/v2")
+	})
+	t.Run("conflicting_branches", func(t *testing.T) {
+		_, _, err := syncBranches(t, testRepo.open(), func(string) string { return "trunk" })
+		assert.ErrorContains(t, err, `are both mapped to remote branch "trunk"`)
+	})
+}
+
+func TestSyncMaxHistoryDepth(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commit1 := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	commit3 := testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.commit("commit 4", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.commit("commit 5", map[string]string{"proto/e.proto": testProtoFile("e")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncWithDepth syncs the module, marking the synced commits as synced in the checker, and returns
+	// the synced commits and the logged max depth warnings.
+	syncWithDepth := func(
+		t *testing.T,
+		mockBSRChecker mockSyncedGitChecker,
+		options ...SyncerOption,
+	) ([]string, []observer.LoggedEntry) {
+		core, logs := observer.New(zap.WarnLevel)
+		recorder := &syncFuncRecorder{}
+		syncer, err := NewSyncer(
+			zap.New(core),
+			repo,
+			storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			)...,
+		)
+		require.NoError(t, err)
+		require.NoError(t, syncer.Sync(context.Background(), func(ctx context.Context, moduleCommit ModuleCommit) error {
+			mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+			return recorder.syncFunc(ctx, moduleCommit)
+		}))
+		warnings := logs.FilterMessage("max history depth reached before finding the sync point, syncing only the visited commits")
+		return recorder.branchCommitMessages(), warnings.AllUntimed()
+	}
+
+	// not running in parallel, the subtests share the same repository
+	for _, mergeCommitPolicy := range []MergeCommitPolicy{MergeCommitPolicyFirstParentOnly, MergeCommitPolicyInclude} {
+		mergeCommitPolicy := mergeCommitPolicy
+		t.Run(fmt.Sprintf("merge_commit_policy_%d", mergeCommitPolicy), func(t *testing.T) {
+			mockBSRChecker := newMockSyncGitChecker()
+			mockBSRChecker.markSynced(commit1.Hex())
+			synced, warnings := syncWithDepth(
+				t,
+				mockBSRChecker,
+				SyncerWithMergeCommitPolicy(mergeCommitPolicy),
+				SyncerWithMaxHistoryDepth(2),
+			)
+			assert.Equal(t, []string{"main:commit 4", "main:commit 5"}, synced)
+			require.Len(t, warnings, 1)
+			assert.Equal(t, "main", warnings[0].ContextMap()["branch"])
+			assert.Equal(t, commit3.Hex(), warnings[0].ContextMap()["first_unvisited_commit"])
+			// the next sync resumes from the last synced commit within the depth
+			synced, warnings = syncWithDepth(
+				t,
+				mockBSRChecker,
+				SyncerWithMergeCommitPolicy(mergeCommitPolicy),
+				SyncerWithMaxHistoryDepth(2),
+			)
+			assert.Empty(t, synced)
+			assert.Empty(t, warnings)
+		})
+	}
+	t.Run("sync_point_within_depth", func(t *testing.T) {
+		mockBSRChecker := newMockSyncGitChecker()
+		mockBSRChecker.markSynced(commit1.Hex())
+		synced, warnings := syncWithDepth(t, mockBSRChecker, SyncerWithMaxHistoryDepth(5))
+		assert.Equal(t, []string{"main:commit 2", "main:commit 3", "main:commit 4", "main:commit 5"}, synced)
+		assert.Empty(t, warnings)
+	})
+	t.Run("invalid_depth", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithMaxHistoryDepth(0),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestSyncBuildPipeline(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("unchanged", map[string]string{"README.md": "# repo"})
+	testRepo.commit("commit 4", map[string]string{"proto/d.proto": testProtoFile("d")})
+	testRepo.commit("commit 5", map[string]string{"proto/e.proto": testProtoFile("e")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncPipelined syncs the repository with the builder, and returns the synced commits in order.
+	syncPipelined := func(t *testing.T, builder bufmodulebuild.ModuleBucketBuilder, syncFunc SyncFunc, options ...SyncerOption) []string {
+		var syncedCommits []string
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				func(s *syncer) error {
+					s.moduleBucketBuilder = builder
+					return nil
+				},
+			)...,
+		).Sync(context.Background(), func(ctx context.Context, moduleCommit ModuleCommit) error {
+			syncedCommits = append(syncedCommits, moduleCommit.Commit().Message())
+			if syncFunc != nil {
+				return syncFunc(ctx, moduleCommit)
+			}
+			return nil
+		}))
+		return syncedCommits
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("push_order_preserved", func(t *testing.T) {
+		expectedCommits := syncPipelined(t, &delayedModuleBucketBuilder{}, nil, SyncerWithSkipUnchangedCommits())
+		assert.Equal(t, []string{"commit 1", "commit 2", "commit 4", "commit 5"}, expectedCommits)
+		for _, depth := range []int{1, 2, 10} {
+			builder := &delayedModuleBucketBuilder{}
+			syncedCommits := syncPipelined(
+				t,
+				builder,
+				// let the pipeline build ahead of every push
+				func(context.Context, ModuleCommit) error {
+					time.Sleep(10 * time.Millisecond)
+					return nil
+				},
+				SyncerWithSkipUnchangedCommits(),
+				SyncerWithBuildPipelineDepth(depth),
+			)
+			assert.Equal(t, expectedCommits, syncedCommits, "depth %d", depth)
+			// the unchanged commit is built ahead, and discarded
+			assert.Equal(t, 5, builder.buildCount(), "depth %d", depth)
+		}
+	})
+	t.Run("builds_ahead", func(t *testing.T) {
+		builder := &delayedModuleBucketBuilder{built: make(chan struct{}, 5)}
+		var buildsDuringFirstPush int
+		syncedCommits := syncPipelined(
+			t,
+			builder,
+			func(_ context.Context, moduleCommit ModuleCommit) error {
+				if moduleCommit.Commit().Message() != "commit 1" {
+					return nil
+				}
+				// the first module commit and the two next ones are built while it is pushed
+				for i := 0; i < 3; i++ {
+					select {
+					case <-builder.built:
+					case <-time.After(10 * time.Second):
+						return errors.New("modules not built ahead")
+					}
+				}
+				// no more modules than the depth are built ahead
+				time.Sleep(50 * time.Millisecond)
+				buildsDuringFirstPush = builder.buildCount()
+				return nil
+			},
+			SyncerWithBuildPipelineDepth(2),
+		)
+		assert.Equal(t, []string{"commit 1", "commit 2", "unchanged", "commit 4", "commit 5"}, syncedCommits)
+		assert.Equal(t, 3, buildsDuringFirstPush)
+	})
+	t.Run("invalid_depth", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithBuildPipelineDepth(0),
+		)
+		assert.Error(t, err)
+	})
+	t.Run("lazy_buckets", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithBuildPipelineDepth(2),
+			SyncerWithLazyBuckets(),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func BenchmarkSyncBuildPipeline(b *testing.B) {
+	testRepo := newTestGitRepository(b)
+	testRepo.commit("commit 0", newTestModuleFiles("buf.test/owner/repo", "a"))
+	for i := 1; i < 10; i++ {
+		name := fmt.Sprintf("file%d", i)
+		testRepo.commit(fmt.Sprintf("commit %d", i), map[string]string{"proto/" + name + ".proto": testProtoFile(name)})
+	}
+	testRepo.push("main")
+	repo := testRepo.open()
+	for _, benchmark := range []struct {
+		name    string
+		options []SyncerOption
+	}{
+		{name: "sequential"},
+		{name: "pipelined", options: []SyncerOption{SyncerWithBuildPipelineDepth(4)}},
+	} {
+		benchmark := benchmark
+		b.Run(benchmark.name, func(b *testing.B) {
+			// builds take as long as pushes, so pipelining hides most of the build time
+			builder := &delayedModuleBucketBuilder{delay: 5 * time.Millisecond}
+			syncFunc := func(context.Context, ModuleCommit) error {
+				time.Sleep(5 * time.Millisecond)
+				return nil
+			}
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, newTestSyncer(
+					b,
+					repo,
+					&mockErrorHandler{},
+					append(
+						benchmark.options,
+						SyncerWithModule(newTestSyncableModule(b, "proto", "buf.test/owner/repo")),
+						func(s *syncer) error {
+							s.moduleBucketBuilder = builder
+							return nil
+						},
+					)...,
+				).Sync(context.Background(), syncFunc))
+			}
+		})
+	}
+}
+
+func TestSyncDeterministicManifest(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	files := newTestModuleFiles("buf.test/owner/repo", "a")
+	files["docs/README.md"] = "readme"
+	require.NoError(t, os.MkdirAll(filepath.Join(testRepo.localDir, "proto"), 0755))
+	for link, target := range map[string]string{
+		"proto/buf.md":    "../docs/README.md",
+		"proto/docs":      "../docs",
+		"proto/b.proto":   "missing.proto",
+		"proto/c.proto":   "c.proto",
+		"proto/LICENSE":   "/etc/hosts",
+		"proto/vendor.md": "../../outside.md",
+	} {
+		require.NoError(t, os.Symlink(target, filepath.Join(testRepo.localDir, link)))
+	}
+	commit := testRepo.commit("commit 1", files)
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncDigest syncs the module to a new syncer, and returns the manifest digest of its only module
+	// commit, and the module commit files.
+	syncDigest := func(t *testing.T, options ...SyncerOption) (string, map[string]string, error) {
+		var (
+			digest string
+			files  map[string]string
+		)
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithDeterministicManifest(),
+			)...,
+		).Sync(context.Background(), func(ctx context.Context, moduleCommit ModuleCommit) error {
+			assert.Equal(t, commit, moduleCommit.Commit().Hash())
+			moduleDigest, err := moduleCommitDigest(ctx, moduleCommit)
+			require.NoError(t, err)
+			digest = moduleDigest.String()
+			files = make(map[string]string)
+			return storage.WalkReadObjects(ctx, moduleCommit.Bucket(), "", func(object storage.ReadObject) error {
+				data, err := io.ReadAll(object)
+				files[object.Path()] = string(data)
+				return err
+			})
+		})
+		return digest, files, err
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("same_commit_twice", func(t *testing.T) {
+		digest, files, err := syncDigest(t)
+		require.NoError(t, err)
+		// only the symlinks to files in the repository are synced
+		assert.Equal(
+			t,
+			map[string]string{
+				"buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+				"a.proto":  testProtoFile("a"),
+				"buf.md":   "readme",
+			},
+			files,
+		)
+		otherDigest, _, err := syncDigest(t)
+		require.NoError(t, err)
+		assert.Equal(t, digest, otherDigest)
+		lazyDigest, _, err := syncDigest(t, SyncerWithLazyBuckets())
+		require.NoError(t, err)
+		assert.Equal(t, digest, lazyDigest)
+	})
+	t.Run("nondeterministic_build", func(t *testing.T) {
+		_, _, err := syncDigest(t, func(s *syncer) error {
+			s.moduleBucketBuilder = &countingModuleBucketBuilder{}
+			return nil
+		})
+		assert.ErrorIs(t, err, ErrNondeterministicManifest)
+	})
+}
+
+func TestSyncTreeCache(t *testing.T) {
+	t.Parallel()
+	// | o-o-o (main)
+	// |  \
+	// |   o (staging)
+	testRepo := newTestGitRepository(t)
+	testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.git("checkout", "-b", "staging")
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.git("checkout", "main")
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	// only changes a path outside the module dir
+	testRepo.commit("commit 4", map[string]string{"README.md": "# repo\n"})
+	testRepo.push("main", "staging")
+	repo := testRepo.open()
+	// the staging branch syncs to its own module, so the shared commit is synced to both modules
+	identityResolver := func(module Module, branch string) (bufmoduleref.ModuleIdentity, error) {
+		if branch == "staging" {
+			return bufmoduleref.ModuleIdentityForString(module.RemoteIdentity().IdentityString() + "-staging")
+		}
+		return module.RemoteIdentity(), nil
+	}
+	// syncBuilds syncs all branches, returning the synced module commits and the number of builds.
+	syncBuilds := func(t *testing.T, options ...SyncerOption) ([]string, int) {
+		builder := &countingModuleBucketBuilder{}
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithAllBranches(),
+				SyncerWithIdentityResolver(identityResolver),
+				SyncerWithModuleDefaultBranchGetter(func(context.Context, bufmoduleref.ModuleIdentity) (string, error) {
+					return "main", nil
+				}),
+				func(s *syncer) error {
+					s.moduleBucketBuilder = builder
+					return nil
+				},
+			)...,
+		).Sync(context.Background(), recorder.syncFunc))
+		return recorder.branchCommitMessages(), builder.builds
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("without_cache", func(t *testing.T) {
+		synced, builds := syncBuilds(t)
+		assert.Equal(t, []string{"main:commit 1", "main:commit 2", "main:commit 4", "staging:commit 1", "staging:commit 3"}, synced)
+		assert.Equal(t, 5, builds)
+	})
+	t.Run("with_cache", func(t *testing.T) {
+		synced, builds := syncBuilds(t, SyncerWithTreeCacheSize(10))
+		assert.Equal(t, []string{"main:commit 1", "main:commit 2", "main:commit 4", "staging:commit 1", "staging:commit 3"}, synced)
+		// the shared ancestor is built once for both branches, and commit 4 reuses the module built for
+		// commit 2, as its module dir tree is unchanged
+		assert.Equal(t, 3, builds)
+	})
+	t.Run("invalid_size", func(t *testing.T) {
+		_, err := NewSyncer(
+			zap.NewNop(),
+			repo,
+			storagegit.NewProvider(repo.Objects()),
+			&mockErrorHandler{},
+			SyncerWithTreeCacheSize(0),
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestTreeCache(t *testing.T) {
+	t.Parallel()
+	cache := newTreeCache(2)
+	keyA := treeCacheKey{moduleDir: "proto", treeHash: "a"}
+	keyB := treeCacheKey{moduleDir: "proto", treeHash: "b"}
+	keyC := treeCacheKey{moduleDir: "other", treeHash: "a"}
+	moduleA, moduleB, moduleC := &bufmodulebuild.BuiltModule{}, &bufmodulebuild.BuiltModule{}, &bufmodulebuild.BuiltModule{}
+	cache.add(keyA, moduleA)
+	cache.add(keyB, moduleB)
+	builtModule, ok := cache.get(keyA)
+	require.True(t, ok)
+	assert.Same(t, moduleA, builtModule)
+	// B is the least recently used
+	cache.add(keyC, moduleC)
+	_, ok = cache.get(keyB)
+	assert.False(t, ok)
+	builtModule, ok = cache.get(keyA)
+	require.True(t, ok)
+	assert.Same(t, moduleA, builtModule)
+	builtModule, ok = cache.get(keyC)
+	require.True(t, ok)
+	assert.Same(t, moduleC, builtModule)
+}
+
+func TestSyncHeadFirstBackfill(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commitHashes := []git.Hash{testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))}
+	for i := 2; i <= 5; i++ {
+		name := fmt.Sprintf("p%d", i)
+		commitHashes = append(
+			commitHashes,
+			testRepo.commit(fmt.Sprintf("commit %d", i), map[string]string{"proto/" + name + ".proto": testProtoFile(name)}),
+		)
+	}
+	testRepo.push("main")
+	checker := newMockSyncGitChecker()
+	// the first 2 commits are synced, with the sync point recorded by a previous checkpoint
+	checker.markSynced(commitHashes[0].Hex())
+	checker.markSynced(commitHashes[1].Hex())
+	syncPoint := commitHashes[1]
+	var checkpoints []git.Hash
+	// syncHeadFirst syncs the repository head first, failing after syncing maxSynced module commits if
+	// positive, and returns the synced commit hashes in order.
+	syncHeadFirst := func(t *testing.T, repo git.Repository, maxSynced int) ([]git.Hash, error) {
+		var syncedHashes []git.Hash
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithHeadFirstBackfill(),
+			SyncerWithGitCommitChecker(checker.checkFunc()),
+			SyncerWithResumption(func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+				return syncPoint, nil
+			}),
+			SyncerWithCheckpointHook(func(_ context.Context, _ Module, _ string, commitHash git.Hash) error {
+				checkpoints = append(checkpoints, commitHash)
+				syncPoint = commitHash
+				return nil
+			}),
+		).Sync(context.Background(), func(_ context.Context, moduleCommit ModuleCommit) error {
+			if maxSynced > 0 && len(syncedHashes) == maxSynced {
+				return errors.New("abort")
+			}
+			syncedHashes = append(syncedHashes, moduleCommit.Commit().Hash())
+			checker.markSynced(moduleCommit.Commit().Hash().Hex())
+			return nil
+		})
+		return syncedHashes, err
+	}
+
+	// not running in parallel, the subtests resume from each other
+	t.Run("partial", func(t *testing.T) {
+		syncedHashes, err := syncHeadFirst(t, testRepo.open(), 2)
+		require.Error(t, err)
+		// the HEAD commit is synced first, and its history is backfilled
+		assert.Equal(t, []git.Hash{commitHashes[4], commitHashes[3]}, syncedHashes)
+		// the history is not fully synced after the sync point
+		assert.Empty(t, checkpoints)
+		assert.Equal(t, commitHashes[1], syncPoint)
+	})
+	commitHashes = append(
+		commitHashes,
+		testRepo.commit("commit 6", map[string]string{"proto/p6.proto": testProtoFile("p6")}),
+	)
+	testRepo.push("main")
+	repo := testRepo.open()
+	t.Run("resume", func(t *testing.T) {
+		syncedHashes, err := syncHeadFirst(t, repo, 0)
+		require.NoError(t, err)
+		// the commits synced ahead of the sync point are skipped, and the rest of the history backfilled
+		assert.Equal(t, []git.Hash{commitHashes[5], commitHashes[2]}, syncedHashes)
+		assert.Equal(t, []git.Hash{commitHashes[5]}, checkpoints)
+	})
+	t.Run("up_to_date", func(t *testing.T) {
+		syncedHashes, err := syncHeadFirst(t, repo, 0)
+		require.NoError(t, err)
+		assert.Empty(t, syncedHashes)
+		assert.Len(t, checkpoints, 1)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, options := range [][]SyncerOption{
+			{SyncerWithHeadOnly()},
+			{SyncerWithMergeCommitPolicy(MergeCommitPolicyInclude)},
+			{SyncerWithCheckpointInterval(2), SyncerWithCheckpointHook(func(context.Context, Module, string, git.Hash) error { return nil })},
+		} {
+			_, err := NewSyncer(
+				zap.NewNop(),
+				repo,
+				storagegit.NewProvider(repo.Objects()),
+				&mockErrorHandler{},
+				append(options, SyncerWithHeadFirstBackfill())...,
+			)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestSyncInitialSyncConfirmation(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commitHashes := []git.Hash{testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))}
+	for i := 2; i <= 5; i++ {
+		name := fmt.Sprintf("p%d", i)
+		commitHashes = append(
+			commitHashes,
+			testRepo.commit(fmt.Sprintf("commit %d", i), map[string]string{"proto/" + name + ".proto": testProtoFile(name)}),
+		)
+	}
+	testRepo.git("checkout", "-b", "feature")
+	testRepo.commit("feature 1", map[string]string{"proto/f.proto": testProtoFile("f")})
+	testRepo.git("checkout", "main")
+	testRepo.push("main", "feature")
+	repo := testRepo.open()
+	type confirmCall struct {
+		moduleDir   string
+		branch      string
+		commitCount int
+	}
+	// syncConfirming syncs the repository with a confirmer answering confirmed, and returns the
+	// number of synced module commits, and the confirmer calls.
+	syncConfirming := func(t *testing.T, threshold int, confirmed bool, options ...SyncerOption) (int, []confirmCall) {
+		var calls []confirmCall
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithInitialSyncConfirmation(threshold, func(_ context.Context, module Module, branch string, commitCount int) (bool, error) {
+					calls = append(calls, confirmCall{moduleDir: module.Dir(), branch: branch, commitCount: commitCount})
+					return confirmed, nil
+				}),
+			)...,
+		).Sync(context.Background(), recorder.syncFunc))
+		return len(recorder.moduleCommits), calls
+	}
+
+	t.Run("confirm", func(t *testing.T) {
+		t.Parallel()
+		synced, calls := syncConfirming(t, 3, true)
+		assert.Equal(t, 5, synced)
+		assert.Equal(t, []confirmCall{{moduleDir: "proto", branch: "main", commitCount: 5}}, calls)
+	})
+	t.Run("deny", func(t *testing.T) {
+		t.Parallel()
+		synced, calls := syncConfirming(t, 3, false)
+		assert.Zero(t, synced)
+		assert.Len(t, calls, 1)
+	})
+	t.Run("below_threshold", func(t *testing.T) {
+		t.Parallel()
+		synced, calls := syncConfirming(t, 5, false)
+		assert.Equal(t, 5, synced)
+		assert.Empty(t, calls)
+	})
+	t.Run("sync_point", func(t *testing.T) {
+		t.Parallel()
+		checker := newMockSyncGitChecker()
+		checker.markSynced(commitHashes[0].Hex())
+		synced, calls := syncConfirming(
+			t,
+			1,
+			false,
+			SyncerWithGitCommitChecker(checker.checkFunc()),
+			SyncerWithResumption(func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+				return commitHashes[0], nil
+			}),
+		)
+		assert.Equal(t, 4, synced)
+		assert.Empty(t, calls)
+	})
+	t.Run("all_branches", func(t *testing.T) {
+		t.Parallel()
+		var (
+			calls        []confirmCall
+			syncedBefore []int
+		)
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithAllBranches(),
+			SyncerWithInitialSyncConfirmation(3, func(_ context.Context, module Module, branch string, commitCount int) (bool, error) {
+				calls = append(calls, confirmCall{moduleDir: module.Dir(), branch: branch, commitCount: commitCount})
+				syncedBefore = append(syncedBefore, len(recorder.moduleCommits))
+				return branch == "main", nil
+			}),
+		).Sync(context.Background(), recorder.syncFunc))
+		// every branch is confirmed before any commit is synced
+		assert.Equal(
+			t,
+			[]confirmCall{
+				{moduleDir: "proto", branch: "main", commitCount: 5},
+				{moduleDir: "proto", branch: "feature", commitCount: 6},
+			},
+			calls,
+		)
+		assert.Equal(t, []int{0, 0}, syncedBefore)
+		require.Len(t, recorder.moduleCommits, 5)
+		for _, moduleCommit := range recorder.moduleCommits {
+			assert.Equal(t, "main", moduleCommit.Branch())
+		}
+	})
+	t.Run("sync_point_in_other_branch", func(t *testing.T) {
+		t.Parallel()
+		checker := newMockSyncGitChecker()
+		checker.markSynced(commitHashes[0].Hex())
+		_, calls := syncConfirming(
+			t,
+			1,
+			false,
+			SyncerWithAllBranches(),
+			SyncerWithGitCommitChecker(checker.checkFunc()),
+			SyncerWithResumption(func(_ context.Context, _ bufmoduleref.ModuleIdentity, branch string) (git.Hash, error) {
+				if branch == "main" {
+					return commitHashes[0], nil
+				}
+				return nil, nil
+			}),
+		)
+		// the module is not synced for the first time
+		assert.Empty(t, calls)
+	})
+	t.Run("confirmer_error", func(t *testing.T) {
+		t.Parallel()
+		confirmErr := errors.New("confirm")
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithInitialSyncConfirmation(3, func(context.Context, Module, string, int) (bool, error) {
+				return false, confirmErr
+			}),
+		).Sync(context.Background(), recorder.syncFunc)
+		assert.ErrorIs(t, err, confirmErr)
+		assert.Empty(t, recorder.moduleCommits)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, option := range []SyncerOption{
+			SyncerWithInitialSyncConfirmation(0, func(context.Context, Module, string, int) (bool, error) { return true, nil }),
+			SyncerWithInitialSyncConfirmation(3, nil),
+		} {
+			_, err := NewSyncer(
+				zap.NewNop(),
+				repo,
+				storagegit.NewProvider(repo.Objects()),
+				&mockErrorHandler{},
+				option,
+			)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestSyncCheckpointHook(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commitHashes := []git.Hash{testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))}
+	for i := 2; i <= 5; i++ {
+		name := fmt.Sprintf("p%d", i)
+		commitHashes = append(
+			commitHashes,
+			testRepo.commit(fmt.Sprintf("commit %d", i), map[string]string{"proto/" + name + ".proto": testProtoFile(name)}),
+		)
+	}
+	testRepo.push("main")
+	repo := testRepo.open()
+	// syncCheckpoints syncs the repository, and returns the checkpointed commit hashes.
+	syncCheckpoints := func(t *testing.T, options ...SyncerOption) []git.Hash {
+		var checkpoints []git.Hash
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			append(
+				options,
+				SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+				SyncerWithCheckpointHook(func(_ context.Context, module Module, branch string, commitHash git.Hash) error {
+					assert.Equal(t, "proto", module.Dir())
+					assert.Equal(t, "main", branch)
+					// the checkpointed commit is synced already
+					assert.Equal(t, commitHash.Hex(), recorder.moduleCommits[len(recorder.moduleCommits)-1].Commit().Hash().Hex())
+					checkpoints = append(checkpoints, commitHash)
+					return nil
+				}),
+			)...,
+		).Sync(context.Background(), recorder.syncFunc))
+		require.Len(t, recorder.moduleCommits, 5)
+		return checkpoints
+	}
+
+	t.Run("default_interval", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, commitHashes, syncCheckpoints(t))
+	})
+	t.Run("interval", func(t *testing.T) {
+		t.Parallel()
+		// the last commit is checkpointed at the end of the branch
+		assert.Equal(
+			t,
+			[]git.Hash{commitHashes[1], commitHashes[3], commitHashes[4]},
+			syncCheckpoints(t, SyncerWithCheckpointInterval(2)),
+		)
+	})
+	t.Run("interval_longer_than_branch", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, []git.Hash{commitHashes[4]}, syncCheckpoints(t, SyncerWithCheckpointInterval(10)))
+	})
+	t.Run("hook_error", func(t *testing.T) {
+		t.Parallel()
+		hookErr := errors.New("checkpoint")
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithCheckpointInterval(2),
+			SyncerWithCheckpointHook(func(context.Context, Module, string, git.Hash) error {
+				return hookErr
+			}),
+		).Sync(context.Background(), recorder.syncFunc)
+		require.ErrorIs(t, err, hookErr)
+		assert.Len(t, recorder.moduleCommits, 2, "sync stops at the failed checkpoint")
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, options := range [][]SyncerOption{
+			{SyncerWithCheckpointInterval(0)},
+			{SyncerWithCheckpointInterval(2)},
+		} {
+			_, err := NewSyncer(
+				zap.NewNop(),
+				repo,
+				storagegit.NewProvider(repo.Objects()),
+				&mockErrorHandler{},
+				options...,
+			)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestSyncInterruptCheckpoint(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepository(t)
+	commit1 := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	mockBSRChecker := newMockSyncGitChecker()
+	newInterruptSyncer := func(t *testing.T) Syncer {
+		return newTestSyncer(
+			t,
+			repo,
+			&mockErrorHandler{},
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithInterruptCheckpoint(),
+		)
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("interrupted_during_commit", func(t *testing.T) {
+		recorder := &syncFuncRecorder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		err := newInterruptSyncer(t).Sync(ctx, func(ctx context.Context, moduleCommit ModuleCommit) error {
+			// interrupted while the first commit is in flight
+			cancel()
+			// the in-flight commit is not canceled
+			require.NoError(t, ctx.Err())
+			mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+			return recorder.syncFunc(ctx, moduleCommit)
+		})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrInterrupted)
+		assert.Equal(t, []string{"main:commit 1"}, recorder.branchCommitMessages())
+		require.Len(t, recorder.moduleCommits, 1)
+		assert.Equal(t, commit1, recorder.moduleCommits[0].Commit().Hash())
+	})
+	t.Run("resume", func(t *testing.T) {
+		recorder := &syncFuncRecorder{}
+		require.NoError(t, newInterruptSyncer(t).Sync(context.Background(), func(ctx context.Context, moduleCommit ModuleCommit) error {
+			mockBSRChecker.markSynced(moduleCommit.Commit().Hash().Hex())
+			return recorder.syncFunc(ctx, moduleCommit)
+		}))
+		assert.Equal(t, []string{"main:commit 2", "main:commit 3"}, recorder.branchCommitMessages())
+	})
+	t.Run("interrupted_before_sync", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := newInterruptSyncer(t).Sync(ctx, func(ctx context.Context, moduleCommit ModuleCommit) error {
+			return errors.New("unexpected sync")
+		})
+		assert.ErrorIs(t, err, ErrInterrupted)
+	})
+}
+
+func TestSyncSHA256Repository(t *testing.T) {
+	t.Parallel()
+	testRepo := newTestGitRepositoryWithObjectFormat(t, "sha256")
+	commit1 := testRepo.commit("commit 1", newTestModuleFiles("buf.test/owner/repo", "a"))
+	testRepo.commit("commit 2", map[string]string{"proto/b.proto": testProtoFile("b")})
+	testRepo.commit("commit 3", map[string]string{"proto/c.proto": testProtoFile("c")})
+	testRepo.push("main")
+	repo := testRepo.open()
+	require.Equal(t, git.HashAlgorithmSHA256, repo.HashAlgorithm())
+	require.Equal(t, git.HashAlgorithmSHA256, commit1.Algorithm())
+	mockBSRChecker := newMockSyncGitChecker()
+	mockBSRChecker.markSynced(commit1.Hex())
+	syncWithSyncPoint := func(t *testing.T, syncPoint git.Hash, errorHandler ErrorHandler) ([]string, error) {
+		recorder := &syncFuncRecorder{}
+		err := newTestSyncer(
+			t,
+			repo,
+			errorHandler,
+			SyncerWithModule(newTestSyncableModule(t, "proto", "buf.test/owner/repo")),
+			SyncerWithGitCommitChecker(mockBSRChecker.checkFunc()),
+			SyncerWithResumption(func(context.Context, bufmoduleref.ModuleIdentity, string) (git.Hash, error) {
+				return syncPoint, nil
+			}),
+		).Sync(context.Background(), recorder.syncFunc)
+		return recorder.branchCommitMessages(), err
+	}
+
+	// not running in parallel, the subtests share the same repository
+	t.Run("sha256_sync_point", func(t *testing.T) {
+		synced, err := syncWithSyncPoint(t, commit1, &mockErrorHandler{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"main:commit 2", "main:commit 3"}, synced)
+	})
+	t.Run("sha1_sync_point", func(t *testing.T) {
+		sha1SyncPoint, err := git.NewHashFromHex(commit1.Hex()[:40])
+		require.NoError(t, err)
+		require.Equal(t, git.HashAlgorithmSHA1, sha1SyncPoint.Algorithm())
+		synced, err := syncWithSyncPoint(t, sha1SyncPoint, &mockErrorHandler{
+			invalidSyncPointErr: errors.New("invalid sync point"),
+		})
+		var syncPointErr *SyncPointError
+		require.ErrorAs(t, err, &syncPointErr)
+		assert.Equal(t, sha1SyncPoint, syncPointErr.SyncPoint)
+		assert.Empty(t, synced)
+	})
+}
+
+// testCommitMessage returns the message of the commit with the passed hash.
+func testCommitMessage(t *testing.T, repo git.Repository, hash git.Hash) string {
+	commit, err := repo.Objects().Commit(hash)
+	require.NoError(t, err)
+	return commit.Message()
+}
+
+// newTestSyncer returns a syncer for the repository, with a nop logger.
+func newTestSyncer(t testing.TB, repo git.Repository, errorHandler ErrorHandler, options ...SyncerOption) Syncer {
+	syncer, err := NewSyncer(
+		zap.NewNop(),
+		repo,
+		storagegit.NewProvider(repo.Objects(), storagegit.ProviderWithSymlinks()),
+		errorHandler,
+		options...,
+	)
+	require.NoError(t, err)
+	return syncer
+}
+
+// newTestModuleFiles returns the files for a module with the passed identity in the "proto" dir,
+// with a single proto file.
+func newTestModuleFiles(identity string, protoFileName string) map[string]string {
+	return map[string]string{
+		"proto/buf.yaml":                    "version: v1\nname: " + identity + "\n",
+		"proto/" + protoFileName + ".proto": testProtoFile(protoFileName),
+	}
+}
+
+// testProtoFile returns a valid proto file content with a package named after the passed name.
+func testProtoFile(name string) string {
+	return "syntax = \"proto3\";\n\npackage " + name + ";\n"
+}
+
+// syncFuncRecorder records all module commits received in its SyncFunc.
+type syncFuncRecorder struct {
+	moduleCommits []ModuleCommit
+}
+
+func (r *syncFuncRecorder) syncFunc(_ context.Context, moduleCommit ModuleCommit) error {
+	r.moduleCommits = append(r.moduleCommits, moduleCommit)
+	return nil
+}
+
+// branchCommitMessages returns the recorded module commits in the format <branch>:<commit message>.
+func (r *syncFuncRecorder) branchCommitMessages() []string {
+	var branchCommitMessages []string
+	for _, moduleCommit := range r.moduleCommits {
+		branchCommitMessages = append(
+			branchCommitMessages,
+			moduleCommit.Branch()+":"+strings.TrimSpace(moduleCommit.Commit().Message()),
+		)
+	}
+	return branchCommitMessages
+}
+
+func newTestSyncableModule(t testing.TB, dir string, identity string) Module {
+	moduleIdentity, err := bufmoduleref.ModuleIdentityForString(identity)
+	require.NoError(t, err)
+	module, err := newSyncableModule(dir, moduleIdentity)
+	require.NoError(t, err)
+	return module
+}
+
+// testSpanAttributes returns the attributes of the span as strings, keyed by attribute key.
+func testSpanAttributes(span sdktrace.ReadOnlySpan) map[string]string {
+	attributes := make(map[string]string)
+	for _, attribute := range span.Attributes() {
+		attributes[string(attribute.Key)] = attribute.Value.Emit()
+	}
+	return attributes
+}
+
+// recordingMeterProvider is a MeterProvider whose meters count the increments of their Int64Counters,
+// keyed by counter name.
+type recordingMeterProvider struct {
+	noop.MeterProvider
+	counts map[string]int64
+}
+
+func (p *recordingMeterProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return &recordingMeter{counts: p.counts}
+}
+
+type recordingMeter struct {
+	noop.Meter
+	counts map[string]int64
+}
+
+func (m *recordingMeter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return &recordingCounter{name: name, counts: m.counts}, nil
+}
+
+type recordingCounter struct {
+	noop.Int64Counter
+	name   string
+	counts map[string]int64
+}
+
+func (c *recordingCounter) Add(_ context.Context, incr int64, _ ...metric.AddOption) {
+	c.counts[c.name] += incr
+}
+
+// blockingModuleBucketBuilder builds modules, except the ones with a file at blockPath, for which it
+// blocks until unblock is closed or the context is done.
+type blockingModuleBucketBuilder struct {
+	blockPath string
+	unblock   chan struct{}
+	// ignoreContext blocks until unblock is closed, even if the context is done.
+	ignoreContext bool
+	// running is the number of builds in progress.
+	running atomic.Int32
+	// buildErrs receives the error of every build, if not nil.
+	buildErrs chan error
+}
+
+func (b *blockingModuleBucketBuilder) BuildForBucket(
+	ctx context.Context,
+	readBucket storage.ReadBucket,
+	config *bufmoduleconfig.Config,
+	options ...bufmodulebuild.BuildOption,
+) (*bufmodulebuild.BuiltModule, error) {
+	b.running.Add(1)
+	defer b.running.Add(-1)
+	builtModule, err := b.build(ctx, readBucket, config, options...)
+	if b.buildErrs != nil {
+		b.buildErrs <- err
+	}
+	return builtModule, err
+}
+
+func (b *blockingModuleBucketBuilder) build(
+	ctx context.Context,
+	readBucket storage.ReadBucket,
+	config *bufmoduleconfig.Config,
+	options ...bufmodulebuild.BuildOption,
+) (*bufmodulebuild.BuiltModule, error) {
+	if _, err := readBucket.Stat(ctx, b.blockPath); err == nil {
+		if b.ignoreContext {
+			<-b.unblock
+			// the build of the module continues past the timeout, without the context
+			ctx = context.Background()
+		} else {
+			select {
+			case <-b.unblock:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return bufmodulebuild.NewModuleBucketBuilder().BuildForBucket(ctx, readBucket, config, options...)
+}
+
+// delayedModuleBucketBuilder builds modules after a delay, counting the builds. Builds can run
+// concurrently with the sync, with SyncerWithBuildPipelineDepth.
+type delayedModuleBucketBuilder struct {
+	delay time.Duration
+	// built receives a value after every build, if not nil.
+	built chan struct{}
+
+	mu     sync.Mutex
+	builds int
+}
+
+func (b *delayedModuleBucketBuilder) BuildForBucket(
+	ctx context.Context,
+	readBucket storage.ReadBucket,
+	config *bufmoduleconfig.Config,
+	options ...bufmodulebuild.BuildOption,
+) (*bufmodulebuild.BuiltModule, error) {
+	time.Sleep(b.delay)
+	builtModule, err := bufmodulebuild.NewModuleBucketBuilder().BuildForBucket(ctx, readBucket, config, options...)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.builds++
+	b.mu.Unlock()
+	if b.built != nil {
+		b.built <- struct{}{}
+	}
+	return builtModule, nil
+}
+
+func (b *delayedModuleBucketBuilder) buildCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.builds
+}
+
+// countingModuleBucketBuilder builds modules with an extra file with the number of modules it built,
+// so every build of the same module results in a different manifest.
+type countingModuleBucketBuilder struct {
+	builds int
+}
+
+func (b *countingModuleBucketBuilder) BuildForBucket(
+	ctx context.Context,
+	readBucket storage.ReadBucket,
+	config *bufmoduleconfig.Config,
+	options ...bufmodulebuild.BuildOption,
+) (*bufmodulebuild.BuiltModule, error) {
+	builtModule, err := bufmodulebuild.NewModuleBucketBuilder().BuildForBucket(ctx, readBucket, config, options...)
+	if err != nil {
+		return nil, err
+	}
+	b.builds++
+	buildBucket, err := storagemem.NewReadBucket(map[string][]byte{"build": []byte(fmt.Sprint(b.builds))})
+	if err != nil {
+		return nil, err
+	}
+	builtModule.Bucket = storage.MultiReadBucket(builtModule.Bucket, buildBucket)
+	return builtModule, nil
+}
+
+type syncPointDivergedCall struct {
+	module    Module
+	branch    string
+	syncPoint git.Hash
+	headHash  git.Hash
+}
+
+// mockErrorHandler records the errors reported by the syncer, and returns the configured errors
+// for each callback.
+type mockErrorHandler struct {
+	invalidModuleConfigErr   error
+	buildFailureErr          error
+	lintFailureErr           error
+	invalidSyncPointErr      error
+	syncPointDivergedErr     error
+	moduleDeletedErr         error
+	unsignedCommitErr        error
+	remoteContentMismatchErr error
+
+	syncPointDivergedCalls     []syncPointDivergedCall
+	invalidModuleConfigErrs    []error
+	buildFailureErrs           []error
+	lintFailureErrs            []error
+	moduleDeletedCalls         []string
+	unsignedCommitCalls        []string
+	remoteContentMismatchCalls []string
+}
+
+func (m *mockErrorHandler) InvalidModuleConfig(_ Module, _ git.Commit, err error) error {
+	m.invalidModuleConfigErrs = append(m.invalidModuleConfigErrs, err)
+	return m.invalidModuleConfigErr
+}
+
+func (m *mockErrorHandler) BuildFailure(_ Module, _ git.Commit, err error) error {
+	m.buildFailureErrs = append(m.buildFailureErrs, err)
+	return m.buildFailureErr
+}
+
+func (m *mockErrorHandler) LintFailure(_ Module, _ git.Commit, err error) error {
+	m.lintFailureErrs = append(m.lintFailureErrs, err)
+	return m.lintFailureErr
+}
+
+func (m *mockErrorHandler) InvalidSyncPoint(Module, string, git.Hash, error) error {
+	return m.invalidSyncPointErr
+}
+
+func (m *mockErrorHandler) SyncPointDiverged(module Module, branch string, syncPoint git.Hash, headHash git.Hash) error {
+	m.syncPointDivergedCalls = append(m.syncPointDivergedCalls, syncPointDivergedCall{
+		module:    module,
+		branch:    branch,
+		syncPoint: syncPoint,
+		headHash:  headHash,
+	})
+	return m.syncPointDivergedErr
+}
+
+func (m *mockErrorHandler) ModuleDeleted(module Module, commit git.Commit) error {
+	m.moduleDeletedCalls = append(m.moduleDeletedCalls, commit.Message())
+	return m.moduleDeletedErr
+}
+
+func (m *mockErrorHandler) UnsignedCommit(module Module, commit git.Commit) error {
+	m.unsignedCommitCalls = append(m.unsignedCommitCalls, commit.Message())
+	return m.unsignedCommitErr
+}
+
+func (m *mockErrorHandler) RemoteContentMismatch(module Module, commit git.Commit, localDigest *manifest.Digest, remoteDigest *manifest.Digest) error {
+	m.remoteContentMismatchCalls = append(m.remoteContentMismatchCalls, commit.Message())
+	return m.remoteContentMismatchErr
+}
@@ -0,0 +1,102 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+
+	"github.com/bufbuild/buf/private/pkg/git"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "bufbuild/buf"
+
+	syncedCommitsCounterName  = "bufsync.commits.synced"
+	skippedCommitsCounterName = "bufsync.commits.skipped"
+	failedCommitsCounterName  = "bufsync.commits.failed"
+)
+
+// syncMetrics are the counters of the module commits processed by the syncer.
+type syncMetrics struct {
+	syncedCommits  metric.Int64Counter
+	skippedCommits metric.Int64Counter
+	failedCommits  metric.Int64Counter
+}
+
+func newSyncMetrics(meter metric.Meter) (*syncMetrics, error) {
+	syncedCommits, err := meter.Int64Counter(
+		syncedCommitsCounterName,
+		metric.WithDescription("The number of module commits synced."),
+		metric.WithUnit("{commit}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	skippedCommits, err := meter.Int64Counter(
+		skippedCommitsCounterName,
+		metric.WithDescription("The number of module commits skipped, such as unchanged or not found modules."),
+		metric.WithUnit("{commit}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	failedCommits, err := meter.Int64Counter(
+		failedCommitsCounterName,
+		metric.WithDescription("The number of module commits that failed to build or push."),
+		metric.WithUnit("{commit}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &syncMetrics{
+		syncedCommits:  syncedCommits,
+		skippedCommits: skippedCommits,
+		failedCommits:  failedCommits,
+	}, nil
+}
+
+// record adds a module commit of the module in the branch to the counter.
+func (m *syncMetrics) record(ctx context.Context, counter metric.Int64Counter, module Module, branch string) {
+	counter.Add(
+		ctx,
+		1,
+		metric.WithAttributes(
+			attribute.String("module", module.RemoteIdentity().IdentityString()),
+			attribute.String("branch", branch),
+		),
+	)
+}
+
+// moduleCommitAttributes returns the span attributes of a module commit.
+func moduleCommitAttributes(moduleIdentity string, branch string, commit git.Commit) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("module", moduleIdentity),
+		attribute.String("branch", branch),
+		attribute.String("commit", commit.Hash().Hex()),
+	)
+}
+
+// endSpan ends the span, recording the error if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,77 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"container/list"
+
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmodulebuild"
+)
+
+// treeCacheKey identifies the built module of a module dir by the hash of the module dir tree. Git
+// tree hashes identify their content, so the same key always builds the same module.
+type treeCacheKey struct {
+	moduleDir string
+	treeHash  string
+}
+
+// treeCache is a least recently used cache of built modules, bounded by number of entries.
+type treeCache struct {
+	maxEntries int
+	// entries are the cached entries, from the most to the least recently used.
+	entries *list.List
+	// elements are the entries' elements, by key.
+	elements map[treeCacheKey]*list.Element
+}
+
+type treeCacheEntry struct {
+	key         treeCacheKey
+	builtModule *bufmodulebuild.BuiltModule
+}
+
+func newTreeCache(maxEntries int) *treeCache {
+	return &treeCache{
+		maxEntries: maxEntries,
+		entries:    list.New(),
+		elements:   make(map[treeCacheKey]*list.Element),
+	}
+}
+
+// get returns the built module for the key, and marks it as the most recently used, or false if it
+// is not cached.
+func (c *treeCache) get(key treeCacheKey) (*bufmodulebuild.BuiltModule, bool) {
+	element, ok := c.elements[key]
+	if !ok {
+		return nil, false
+	}
+	c.entries.MoveToFront(element)
+	return element.Value.(*treeCacheEntry).builtModule, true
+}
+
+// add caches the built module for the key as the most recently used, evicting the least recently used
+// entry if the cache is full.
+func (c *treeCache) add(key treeCacheKey, builtModule *bufmodulebuild.BuiltModule) {
+	if element, ok := c.elements[key]; ok {
+		element.Value.(*treeCacheEntry).builtModule = builtModule
+		c.entries.MoveToFront(element)
+		return
+	}
+	c.elements[key] = c.entries.PushFront(&treeCacheEntry{key: key, builtModule: builtModule})
+	if c.entries.Len() > c.maxEntries {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.elements, oldest.Value.(*treeCacheEntry).key)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufsync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bufbuild/buf/private/buf/bufwork"
+	"github.com/bufbuild/buf/private/bufpkg/bufconfig"
+	"github.com/bufbuild/buf/private/pkg/normalpath"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagegit"
+)
+
+// addWorkspaceModules adds the modules listed in the buf.work.yaml of each workspace dir, read at the
+// HEAD commit of the current branch, synced to the identities declared in their buf.yaml. Module dirs
+// already configured with SyncerWithModule are kept as configured.
+func (s *syncer) addWorkspaceModules(ctx context.Context) error {
+	if len(s.workspaceDirs) == 0 {
+		return nil
+	}
+	currentBranch := s.repo.CurrentBranch()
+	headCommit, err := s.repo.HEADCommit(currentBranch)
+	if err != nil {
+		return fmt.Errorf("get head commit for branch %q: %w", currentBranch, err)
+	}
+	headBucket, err := s.storageGitProvider.NewReadBucket(
+		headCommit.Tree(),
+		storagegit.ReadBucketWithSymlinksIfSupported(),
+	)
+	if err != nil {
+		return err
+	}
+	configuredDirs := make(map[string]struct{}, len(s.modulesToSync))
+	for _, module := range s.modulesToSync {
+		configuredDirs[module.Dir()] = struct{}{}
+	}
+	for _, workspaceDir := range s.workspaceDirs {
+		workspaceBucket := storage.MapReadBucket(headBucket, storage.MapOnPrefix(workspaceDir))
+		workspaceConfigFilePath, err := bufwork.ExistingConfigFilePath(ctx, workspaceBucket)
+		if err != nil {
+			return err
+		}
+		if workspaceConfigFilePath == "" {
+			return fmt.Errorf(
+				"workspace %q: no %s found in HEAD commit %s",
+				workspaceDir,
+				bufwork.ExternalConfigV1FilePath,
+				headCommit.Hash(),
+			)
+		}
+		workspaceConfig, err := bufwork.GetConfigForBucket(ctx, workspaceBucket, workspaceDir)
+		if err != nil {
+			return fmt.Errorf("workspace %q: %w", workspaceDir, err)
+		}
+		for _, directory := range workspaceConfig.Directories {
+			moduleDir := normalpath.Join(workspaceDir, directory)
+			if _, configured := configuredDirs[moduleDir]; configured {
+				continue
+			}
+			moduleBucket := storage.MapReadBucket(headBucket, storage.MapOnPrefix(moduleDir))
+			moduleConfigFilePath, err := bufconfig.ExistingConfigFilePath(ctx, moduleBucket)
+			if err != nil {
+				return err
+			}
+			if moduleConfigFilePath == "" {
+				return fmt.Errorf(
+					"workspace %q: directory %q has no %s in HEAD commit %s",
+					workspaceDir,
+					directory,
+					bufconfig.ExternalConfigV1FilePath,
+					headCommit.Hash(),
+				)
+			}
+			moduleConfig, err := bufconfig.GetConfigForBucket(ctx, moduleBucket)
+			if err != nil {
+				return fmt.Errorf("workspace %q: directory %q: %w", workspaceDir, directory, err)
+			}
+			if moduleConfig.ModuleIdentity == nil {
+				return fmt.Errorf(
+					"workspace %q: module in directory %q has no name in its %s",
+					workspaceDir,
+					directory,
+					moduleConfigFilePath,
+				)
+			}
+			module, err := newSyncableModule(moduleDir, moduleConfig.ModuleIdentity)
+			if err != nil {
+				return fmt.Errorf("workspace %q: directory %q: %w", workspaceDir, directory, err)
+			}
+			s.modulesToSync = append(s.modulesToSync, module)
+			configuredDirs[moduleDir] = struct{}{}
+		}
+	}
+	return nil
+}
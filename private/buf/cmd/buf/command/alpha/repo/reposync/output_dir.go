@@ -0,0 +1,217 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reposync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bufbuild/buf/private/buf/bufsync"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
+	"github.com/bufbuild/buf/private/pkg/git"
+	"github.com/bufbuild/buf/private/pkg/normalpath"
+	"github.com/bufbuild/buf/private/pkg/storage"
+)
+
+const (
+	// outputDirManifestPath is the path of the manifest in the output dir recording the synced module
+	// commits.
+	outputDirManifestPath = "sync-manifest.json"
+	// outputDirManifestFlushInterval is the number of module commits written to the output dir
+	// between two writes of the manifest.
+	outputDirManifestFlushInterval = 100
+)
+
+// outputDir is a sync destination that writes the bucket of every module commit to
+// <module-identity>/<branch>/<git-commit-hash> in a bucket, instead of pushing it to the BSR. It
+// records the synced module commits in a manifest at the bucket root, to resume from.
+//
+// The manifest is written every outputDirManifestFlushInterval module commits, and when flushed at
+// the end of the sync. If the sync crashes before, the module commits written since the last write
+// of the manifest are not recorded, and are synced again by the next sync, which overwrites them.
+type outputDir struct {
+	bucket   storage.ReadWriteBucket
+	manifest outputDirManifest
+	// unflushedCommits is the number of module commits recorded since the manifest was last written.
+	unflushedCommits int
+	// syncPoints are the last commits synced for each module and branch.
+	syncPoints map[outputDirModuleBranch]string
+	// syncedCommits are the commits synced for each module, in any branch.
+	syncedCommits map[string]map[string]struct{}
+	// tags are the commits each tag points to for each module, keyed by tag name.
+	tags map[string]map[string]string
+}
+
+// outputDirModuleBranch is a module identity and a branch, indexing the output dir sync points.
+type outputDirModuleBranch struct {
+	module string
+	branch string
+}
+
+// outputDirManifest is the manifest of an output dir.
+type outputDirManifest struct {
+	// Commits are the synced module commits, in the order they were synced.
+	Commits []outputDirManifestCommit `json:"commits"`
+}
+
+// outputDirManifestCommit is a module commit synced to an output dir.
+type outputDirManifestCommit struct {
+	Module string   `json:"module"`
+	Branch string   `json:"branch"`
+	Commit string   `json:"commit"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// newOutputDir returns an output dir writing to the bucket, reading the manifest of a previous
+// sync to the bucket if any.
+func newOutputDir(ctx context.Context, bucket storage.ReadWriteBucket) (*outputDir, error) {
+	o := &outputDir{
+		bucket:        bucket,
+		syncPoints:    make(map[outputDirModuleBranch]string),
+		syncedCommits: make(map[string]map[string]struct{}),
+		tags:          make(map[string]map[string]string),
+	}
+	data, err := storage.ReadPath(ctx, bucket, outputDirManifestPath)
+	if err != nil {
+		if storage.IsNotExist(err) {
+			return o, nil
+		}
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &o.manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest %q: %w", outputDirManifestPath, err)
+	}
+	for _, manifestCommit := range o.manifest.Commits {
+		o.index(manifestCommit)
+	}
+	return o, nil
+}
+
+// index records the manifest commit in the sync points, synced commits and tags of its module.
+func (o *outputDir) index(manifestCommit outputDirManifestCommit) {
+	o.syncPoints[outputDirModuleBranch{module: manifestCommit.Module, branch: manifestCommit.Branch}] = manifestCommit.Commit
+	syncedCommits, ok := o.syncedCommits[manifestCommit.Module]
+	if !ok {
+		syncedCommits = make(map[string]struct{})
+		o.syncedCommits[manifestCommit.Module] = syncedCommits
+	}
+	syncedCommits[manifestCommit.Commit] = struct{}{}
+	for _, tag := range manifestCommit.Tags {
+		tags, ok := o.tags[manifestCommit.Module]
+		if !ok {
+			tags = make(map[string]string)
+			o.tags[manifestCommit.Module] = tags
+		}
+		tags[tag] = manifestCommit.Commit
+	}
+}
+
+// syncPointResolver returns a SyncPointResolver that resolves the last commit synced to the output
+// dir for a module and branch.
+func (o *outputDir) syncPointResolver() bufsync.SyncPointResolver {
+	return func(_ context.Context, module bufmoduleref.ModuleIdentity, branch string) (git.Hash, error) {
+		syncPoint, ok := o.syncPoints[outputDirModuleBranch{module: module.IdentityString(), branch: branch}]
+		if !ok {
+			// No syncpoint
+			return nil, nil
+		}
+		hash, err := git.NewHashFromHex(syncPoint)
+		if err != nil {
+			return nil, fmt.Errorf("parse manifest commit hash: %w", err)
+		}
+		return hash, nil
+	}
+}
+
+// syncGitCommitChecker returns a SyncedGitCommitChecker that checks the commits synced to the output
+// dir for a module, in any branch.
+func (o *outputDir) syncGitCommitChecker() bufsync.SyncedGitCommitChecker {
+	return func(_ context.Context, module bufmoduleref.ModuleIdentity, commitHashes map[string]struct{}) (map[string]struct{}, error) {
+		syncedHashes := make(map[string]struct{})
+		syncedCommits := o.syncedCommits[module.IdentityString()]
+		for commitHash := range commitHashes {
+			if _, ok := syncedCommits[commitHash]; ok {
+				syncedHashes[commitHash] = struct{}{}
+			}
+		}
+		return syncedHashes, nil
+	}
+}
+
+// tagResolver returns a TagResolver that resolves the tags of the module commits synced to the output
+// dir for a module, pointing to the last commit synced with each tag.
+func (o *outputDir) tagResolver() bufsync.TagResolver {
+	return func(_ context.Context, module bufmoduleref.ModuleIdentity) (map[string]string, error) {
+		tags := make(map[string]string, len(o.tags[module.IdentityString()]))
+		for tag, commitHash := range o.tags[module.IdentityString()] {
+			tags[tag] = commitHash
+		}
+		return tags, nil
+	}
+}
+
+// writeModuleCommit writes the module commit bucket to the output dir, and records it in the
+// manifest, which is written if outputDirManifestFlushInterval module commits were recorded since
+// its last write. It returns the dir the bucket was written to.
+func (o *outputDir) writeModuleCommit(ctx context.Context, moduleCommit bufsync.ModuleCommit) (string, error) {
+	dir := normalpath.Join(
+		moduleCommit.Identity().IdentityString(),
+		moduleCommit.Branch(),
+		moduleCommit.Commit().Hash().Hex(),
+	)
+	// Clear any partial write from an interrupted sync.
+	if err := o.bucket.DeleteAll(ctx, dir); err != nil {
+		return "", fmt.Errorf("clear %q: %w", dir, err)
+	}
+	if _, err := storage.Copy(
+		ctx,
+		moduleCommit.Bucket(),
+		storage.MapWriteBucket(o.bucket, storage.MapOnPrefix(dir)),
+	); err != nil {
+		return "", fmt.Errorf("write %q: %w", dir, err)
+	}
+	manifestCommit := outputDirManifestCommit{
+		Module: moduleCommit.Identity().IdentityString(),
+		Branch: moduleCommit.Branch(),
+		Commit: moduleCommit.Commit().Hash().Hex(),
+		Tags:   moduleCommit.Tags(),
+	}
+	o.manifest.Commits = append(o.manifest.Commits, manifestCommit)
+	o.index(manifestCommit)
+	o.unflushedCommits++
+	if o.unflushedCommits >= outputDirManifestFlushInterval {
+		if err := o.flush(ctx); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// flush writes the manifest, if any module commit was recorded since its last write.
+func (o *outputDir) flush(ctx context.Context) error {
+	if o.unflushedCommits == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(o.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.PutPath(ctx, o.bucket, outputDirManifestPath, data); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	o.unflushedCommits = 0
+	return nil
+}
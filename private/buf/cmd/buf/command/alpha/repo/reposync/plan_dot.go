@@ -0,0 +1,183 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reposync
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/buf/private/buf/bufsync"
+	"github.com/bufbuild/buf/private/pkg/git"
+)
+
+// planDOTShortHashLength is the length of the commit hashes in the DOT node labels.
+const planDOTShortHashLength = 7
+
+// planDOTNodeStatus is the sync status of a commit in the DOT graph of a plan. A commit found more
+// than once in a plan, such as a sync point of a module that is in-scope for another module, gets
+// the highest status.
+type planDOTNodeStatus int
+
+const (
+	// planDOTNodeStatusSynced is a commit not traversed by the plan, either a sync point or a parent
+	// of a traversed commit, which is already synced.
+	planDOTNodeStatusSynced planDOTNodeStatus = iota + 1
+	// planDOTNodeStatusFiltered is a commit traversed by the plan that is not synced for any module.
+	planDOTNodeStatusFiltered
+	// planDOTNodeStatusInScope is a commit that is synced for at least one module.
+	planDOTNodeStatusInScope
+)
+
+// attributes returns the DOT node attributes for the status.
+func (s planDOTNodeStatus) attributes() string {
+	switch s {
+	case planDOTNodeStatusInScope:
+		return `fillcolor="palegreen"`
+	case planDOTNodeStatusFiltered:
+		return `fillcolor="khaki", style="filled,dashed"`
+	default:
+		return `fillcolor="lightgray"`
+	}
+}
+
+// planDOTNode is a commit in the DOT graph of a plan.
+type planDOTNode struct {
+	hash     git.Hash
+	status   planDOTNodeStatus
+	branches []string
+	tags     []string
+	// parents are the parents of a traversed commit, nil for commits not traversed by the plan.
+	parents []git.Hash
+}
+
+// planDOTGraph is the commit graph of a plan, with its nodes in the order they were added.
+type planDOTGraph struct {
+	hexToNode map[string]*planDOTNode
+	nodes     []*planDOTNode
+}
+
+// addNode adds a commit to the graph, or merges it with the node of the same commit if any.
+func (g *planDOTGraph) addNode(hash git.Hash, status planDOTNodeStatus, branch string, tags []string, parents []git.Hash) {
+	node, ok := g.hexToNode[hash.Hex()]
+	if !ok {
+		node = &planDOTNode{hash: hash}
+		g.hexToNode[hash.Hex()] = node
+		g.nodes = append(g.nodes, node)
+	}
+	if status > node.status {
+		node.status = status
+	}
+	if branch != "" && !containsString(node.branches, branch) {
+		node.branches = append(node.branches, branch)
+	}
+	for _, tag := range tags {
+		if !containsString(node.tags, tag) {
+			node.tags = append(node.tags, tag)
+		}
+	}
+	if node.parents == nil {
+		node.parents = parents
+	}
+}
+
+// printPlanDOT prints the commit graph of the plan as a Graphviz DOT digraph. In-scope commits are
+// green, filtered commits are yellow and dashed, and already synced commits, the sync points and
+// the parents the plan stops at, are gray. Nodes are labeled with the short commit hash, followed by
+// the branches and tags of the commit, and edges go from a commit to its parents.
+func printPlanDOT(writer io.Writer, plan bufsync.SyncPlan) error {
+	graph := &planDOTGraph{hexToNode: make(map[string]*planDOTNode)}
+	for _, branchPlan := range plan.Branches {
+		for _, commitPlan := range branchPlan.Commits {
+			graph.addNode(
+				commitPlan.Commit.Hash(),
+				planDOTNodeStatusInScope,
+				branchPlan.Branch,
+				commitPlan.Tags,
+				commitPlan.Commit.Parents(),
+			)
+		}
+		for _, commit := range branchPlan.FilteredCommits {
+			graph.addNode(commit.Hash(), planDOTNodeStatusFiltered, branchPlan.Branch, nil, commit.Parents())
+		}
+		syncPoints := make([]git.Hash, 0, len(branchPlan.SyncPoints))
+		for _, syncPoint := range branchPlan.SyncPoints {
+			syncPoints = append(syncPoints, syncPoint)
+		}
+		sort.Slice(syncPoints, func(i, j int) bool {
+			return syncPoints[i].Hex() < syncPoints[j].Hex()
+		})
+		for _, syncPoint := range syncPoints {
+			graph.addNode(syncPoint, planDOTNodeStatusSynced, branchPlan.Branch, nil, nil)
+		}
+	}
+	// The parents of the traversed commits that are not in the plan are where the plan stops.
+	traversedNodes := graph.nodes
+	for _, node := range traversedNodes {
+		for _, parent := range node.parents {
+			graph.addNode(parent, planDOTNodeStatusSynced, "", nil, nil)
+		}
+	}
+	var builder strings.Builder
+	builder.WriteString("digraph sync_plan {\n")
+	builder.WriteString("  rankdir=\"RL\";\n")
+	builder.WriteString("  node [shape=\"box\", style=\"filled\", fontname=\"monospace\"];\n")
+	for _, node := range graph.nodes {
+		labelLines := []string{shortHash(node.hash)}
+		labelLines = append(labelLines, node.branches...)
+		for _, tag := range node.tags {
+			labelLines = append(labelLines, "tag: "+tag)
+		}
+		fmt.Fprintf(
+			&builder,
+			"  %s [label=%s, %s];\n",
+			quoteDOT(node.hash.Hex()),
+			quoteDOT(strings.Join(labelLines, "\n")),
+			node.status.attributes(),
+		)
+	}
+	for _, node := range traversedNodes {
+		for _, parent := range node.parents {
+			fmt.Fprintf(&builder, "  %s -> %s;\n", quoteDOT(node.hash.Hex()), quoteDOT(parent.Hex()))
+		}
+	}
+	builder.WriteString("}\n")
+	_, err := io.WriteString(writer, builder.String())
+	return err
+}
+
+// shortHash returns the abbreviated hex of a commit hash.
+func shortHash(hash git.Hash) string {
+	hex := hash.Hex()
+	if len(hex) > planDOTShortHashLength {
+		return hex[:planDOTShortHashLength]
+	}
+	return hex
+}
+
+// quoteDOT returns the value as a DOT quoted string, with its newlines as centered line breaks.
+func quoteDOT(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
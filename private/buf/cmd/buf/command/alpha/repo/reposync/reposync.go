@@ -15,11 +15,22 @@
 package reposync
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/bufbuild/buf/private/buf/bufcli"
 	"github.com/bufbuild/buf/private/buf/bufsync"
 	"github.com/bufbuild/buf/private/bufpkg/bufanalysis"
@@ -27,6 +38,7 @@ import (
 	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
 	"github.com/bufbuild/buf/private/gen/proto/connect/buf/alpha/registry/v1alpha1/registryv1alpha1connect"
 	registryv1alpha1 "github.com/bufbuild/buf/private/gen/proto/go/buf/alpha/registry/v1alpha1"
+	"github.com/bufbuild/buf/private/pkg/app"
 	"github.com/bufbuild/buf/private/pkg/app/appcmd"
 	"github.com/bufbuild/buf/private/pkg/app/appflag"
 	"github.com/bufbuild/buf/private/pkg/command"
@@ -36,20 +48,127 @@ import (
 	"github.com/bufbuild/buf/private/pkg/normalpath"
 	"github.com/bufbuild/buf/private/pkg/storage"
 	"github.com/bufbuild/buf/private/pkg/storage/storagegit"
+	"github.com/bufbuild/buf/private/pkg/storage/storageos"
 	"github.com/bufbuild/buf/private/pkg/stringutil"
+	"github.com/bufbuild/buf/private/pkg/uuidutil"
 	"github.com/bufbuild/connect-go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/http/httpguts"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
-	errorFormatFlagName      = "error-format"
-	moduleFlagName           = "module"
-	createFlagName           = "create"
-	createVisibilityFlagName = "create-visibility"
-	allBranchesFlagName      = "all-branches"
+	errorFormatFlagName          = "error-format"
+	moduleFlagName               = "module"
+	createFlagName               = "create"
+	createVisibilityFlagName     = "create-visibility"
+	allBranchesFlagName          = "all-branches"
+	printCommitsFlagName         = "print-commits"
+	planFormatFlagName           = "plan-format"
+	gitDirFlagName               = "git-dir"
+	mergeCommitsFlagName         = "merge-commits"
+	headOnlyFlagName             = "head-only"
+	moduleVisibilityFlagName     = "module-visibility"
+	onlyModuleChangesFlagName    = "only-module-changes"
+	requireSignedFlagName        = "require-signed"
+	gitNotesFlagName             = "git-notes"
+	continueOnErrorFlagName      = "continue-on-error"
+	rateLimitFlagName            = "rate-limit"
+	excludePathFlagName          = "exclude-path"
+	resumeOverrideFileFlagName   = "resume-override-file"
+	buildTimeoutFlagName         = "build-timeout"
+	outputDirFlagName            = "output-dir"
+	tagsOnlyFlagName             = "tags-only"
+	workspaceFlagName            = "workspace"
+	coalesceWindowFlagName       = "coalesce-window"
+	localResumePointFlagName     = "local-resume-point"
+	moduleOrderFlagName          = "module-order"
+	clientHeaderFlagName         = "client-header"
+	moduleTemplateFlagName       = "module-template"
+	moduleDirFlagName            = "module-dir"
+	moduleStdinFlagName          = "module-stdin"
+	lintFlagName                 = "lint"
+	lintFailFlagName             = "lint-fail"
+	maxDepthFlagName             = "max-depth"
+	statusFlagName               = "status"
+	statusFormatFlagName         = "status-format"
+	branchIdentitySuffixFlagName = "branch-identity-suffix"
+	annotationFlagName           = "annotation"
+	reanchorOnRebaseFlagName     = "reanchor-on-rebase"
+	requireCleanFlagName         = "require-clean"
+	branchFlagName               = "branch"
+	strictModulesFlagName        = "strict-modules"
+	initialSyncThresholdFlagName = "initial-sync-threshold"
+	yesFlagName                  = "yes"
+	noConfirmFlagName            = "no-confirm"
+
+	// exitCodeBuildFailure is the exit code used when sync completes, but some module commits were
+	// skipped because they failed to build, failed lint, or had an invalid module config.
+	exitCodeBuildFailure = 2
+	// exitCodePushFailure is the exit code used when sync fails to push a module commit to the BSR,
+	// which may succeed if retried.
+	exitCodePushFailure = 3
+	// exitCodeSyncPointFailure is the exit code used when sync fails because a sync point is invalid,
+	// or diverged from its branch.
+	exitCodeSyncPointFailure = 4
+	// exitCodeConfigFailure is the exit code used when sync fails because of invalid flags or an
+	// invalid sync configuration.
+	exitCodeConfigFailure = 5
+	// exitCodePolicyFailure is the exit code used when sync stops at a module commit refused by a
+	// policy flag, because it fails lint with --lint-fail or is not signed with --require-signed.
+	exitCodePolicyFailure = 6
+
+	// defaultInitialSyncThreshold is the number of commits of a module in a branch without a sync
+	// point above which the sync must be confirmed.
+	defaultInitialSyncThreshold = 1000
+
+	// branchPlaceholder is replaced by the branch name in the module identities passed to --module.
+	branchPlaceholder = "{branch}"
+	// dirPlaceholder is replaced by the module directory in the module identity passed to --module-template.
+	dirPlaceholder = "{dir}"
+
+	mergeCommitsFirstParentOnly = "first-parent-only"
+	mergeCommitsInclude         = "include"
+	mergeCommitsSkip            = "skip"
+
+	planFormatTable = "table"
+	planFormatDOT   = "dot"
+
+	statusFormatText = "text"
+	statusFormatJSON = "json"
+)
+
+var (
+	allMergeCommitsStrings = []string{
+		mergeCommitsFirstParentOnly,
+		mergeCommitsInclude,
+		mergeCommitsSkip,
+	}
+	mergeCommitsStringToMergeCommitPolicy = map[string]bufsync.MergeCommitPolicy{
+		mergeCommitsFirstParentOnly: bufsync.MergeCommitPolicyFirstParentOnly,
+		mergeCommitsInclude:         bufsync.MergeCommitPolicyInclude,
+		mergeCommitsSkip:            bufsync.MergeCommitPolicySkip,
+	}
+	allPlanFormatStrings = []string{
+		planFormatTable,
+		planFormatDOT,
+	}
+	planFormatStringToPlanPrinter = map[string]func(io.Writer, bufsync.SyncPlan) error{
+		planFormatTable: printPlan,
+		planFormatDOT:   printPlanDOT,
+	}
+	allStatusFormatStrings = []string{
+		statusFormatText,
+		statusFormatJSON,
+	}
+	statusFormatStringToStatusPrinter = map[string]func(io.Writer, []moduleBranchStatus) error{
+		statusFormatText: printStatusText,
+		statusFormatJSON: printStatusJSON,
+	}
 )
 
 // NewCommand returns a new Command.
@@ -65,11 +184,29 @@ func NewCommand(
 			"Only commits in the current branch that are pushed to the 'origin' remote are processed. " +
 			"Syncing all branches is possible using '--all-branches' flag." +
 			// TODO rephrase in favor of a default module behavior.
-			"Only modules specified via '--module' are synced.",
+			"Only modules specified via '--module' are synced. " +
+			"Use the '--debug' flag to log how each module is resolved in each commit. " +
+			fmt.Sprintf(
+				"It exits with code 0 if sync succeeds. "+
+					"It exits with code %d if sync completes, but some module commits were skipped because they failed to build or failed lint. "+
+					"It exits with code %d if it fails to push a module commit, which may succeed if retried. "+
+					"It exits with code %d if a sync point is invalid or diverged, like after a rebase or a force push. "+
+					"It exits with code %d if the flags or the sync configuration are invalid. "+
+					"It exits with code %d if it stops at a module commit that fails lint with '--lint-fail', or is not signed with '--require-signed'.",
+				exitCodeBuildFailure,
+				exitCodePushFailure,
+				exitCodeSyncPointFailure,
+				exitCodeConfigFailure,
+				exitCodePolicyFailure,
+			),
 		Args: cobra.NoArgs,
 		Run: builder.NewRunFunc(
 			func(ctx context.Context, container appflag.Container) error {
-				return run(ctx, container, flags)
+				err := run(ctx, container, flags)
+				if appcmd.IsInvalidArgumentError(err) {
+					return app.WrapError(exitCodeConfigFailure, err)
+				}
+				return err
 			},
 			// bufcli.NewErrorInterceptor(), // TODO re-enable
 		),
@@ -78,11 +215,51 @@ func NewCommand(
 }
 
 type flags struct {
-	ErrorFormat      string
-	Modules          []string
-	Create           bool
-	CreateVisibility string
-	AllBranches      bool
+	ErrorFormat        string
+	Modules            []string
+	Create             bool
+	CreateVisibility   string
+	ModuleVisibilities []string
+	AllBranches        bool
+	PrintCommits       bool
+	PlanFormat         string
+	GitDir             string
+	MergeCommits       string
+	HeadOnly           bool
+	OnlyModuleChanges  bool
+	RequireSigned      string
+	GitNotes           []string
+	ContinueOnError    bool
+	RateLimit          float64
+	ExcludePaths       []string
+	ResumeOverrideFile string
+	BuildTimeout       time.Duration
+	OutputDir          string
+	TagsOnly           bool
+	Workspaces         []string
+	CoalesceWindow     time.Duration
+	LocalResumePoints  []string
+	ModuleOrder        []string
+	ClientHeaders      []string
+	ModuleTemplate     string
+	ModuleDirs         []string
+	ModuleStdin        bool
+	Lint               bool
+	LintFail           bool
+	MaxDepth           int
+	Status             bool
+	StatusFormat       string
+	// BranchIdentitySuffixes are the suffixes appended to the module names for branches, in the format
+	// <branch>=<suffix>.
+	BranchIdentitySuffixes []string
+	Annotations            []string
+	ReanchorOnRebase       bool
+	RequireClean           bool
+	Branch                 string
+	StrictModules          bool
+	InitialSyncThreshold   int
+	Yes                    bool
+	NoConfirm              bool
 }
 
 func newFlags() *flags {
@@ -107,7 +284,48 @@ func (f *flags) Bind(flagSet *pflag.FlagSet) {
 		"The module(s) to sync to the BSR; this must be in the format <module-path>:<module-name>. "+
 			"The <module-path> is the directory relative to the git repository, and the <module-name> "+
 			"is the module's fully qualified name (FQN) as defined in "+
-			"https://buf.build/docs/bsr/module/manage/#how-modules-are-defined",
+			"https://buf.build/docs/bsr/module/manage/#how-modules-are-defined. "+
+			"If a single module is set, the <module-path> can be omitted to sync the repository root. "+
+			"The <module-name> can contain a "+branchPlaceholder+" placeholder, which is replaced by the "+
+			"branch being synced, with any '/' replaced by '-', such as buf.build/acme/foo-"+branchPlaceholder+".",
+	)
+	flagSet.StringVar(
+		&f.ModuleTemplate,
+		moduleTemplateFlagName,
+		"",
+		fmt.Sprintf(
+			"The module name template to sync each --%s to, such as buf.build/acme/"+dirPlaceholder+". "+
+				"The "+dirPlaceholder+" placeholder is replaced by the module dir, with any '/' replaced by '-'. "+
+				"The template can also contain a "+branchPlaceholder+" placeholder, as in --%s. Must be set with --%s",
+			moduleDirFlagName,
+			moduleFlagName,
+			moduleDirFlagName,
+		),
+	)
+	flagSet.StringSliceVar(
+		&f.ModuleDirs,
+		moduleDirFlagName,
+		nil,
+		fmt.Sprintf(
+			"The module dir(s) to sync to the module names generated from --%s, relative to the git repository. "+
+				"Modules also set in --%s are synced as set in --%s. Must be set with --%s",
+			moduleTemplateFlagName,
+			moduleFlagName,
+			moduleFlagName,
+			moduleTemplateFlagName,
+		),
+	)
+	flagSet.BoolVar(
+		&f.ModuleStdin,
+		moduleStdinFlagName,
+		false,
+		fmt.Sprintf(
+			"Read the modules to sync from stdin, one per line in the format <module-path>:<module-name> as in --%s, "+
+				"such as the output of a command generating them. Empty lines are ignored. "+
+				"The modules are synced along with the ones set in --%s.",
+			moduleFlagName,
+			moduleFlagName,
+		),
 	)
 	bufcli.BindCreateVisibility(flagSet, &f.CreateVisibility, createVisibilityFlagName, createFlagName)
 	flagSet.BoolVar(
@@ -116,6 +334,20 @@ func (f *flags) Bind(flagSet *pflag.FlagSet) {
 		false,
 		fmt.Sprintf("Create the repository if it does not exist. Must set a visibility using --%s", createVisibilityFlagName),
 	)
+	flagSet.StringSliceVar(
+		&f.ModuleVisibilities,
+		moduleVisibilityFlagName,
+		nil,
+		fmt.Sprintf(
+			"The visibility to create a module's repository with, if created, overriding --%s for that module; "+
+				"this must be in the format <module-path>:<visibility>. The <module-path> must match the one in --%s. "+
+				"The <visibility> accepts the same values as --%s. Can only be set if --%s is set",
+			createVisibilityFlagName,
+			moduleFlagName,
+			createVisibilityFlagName,
+			createFlagName,
+		),
+	)
 	flagSet.BoolVar(
 		&f.AllBranches,
 		allBranchesFlagName,
@@ -126,6 +358,307 @@ func (f *flags) Bind(flagSet *pflag.FlagSet) {
 			"from 'refs/remotes/origin/HEAD', and then all the rest of the branches present in "+
 			"'refs/remotes/origin/*' in a lexicographical order.",
 	)
+	flagSet.BoolVar(
+		&f.PrintCommits,
+		printCommitsFlagName,
+		false,
+		"Print the commits that would be synced for each branch and module, and exit without syncing. "+
+			"Resumption and branch filters are applied, but modules are not built.",
+	)
+	flagSet.StringVar(
+		&f.PlanFormat,
+		planFormatFlagName,
+		planFormatTable,
+		fmt.Sprintf(
+			"The format of the commits printed with --%s. Must be one of %s. "+
+				"%q prints a row per branch, commit, and module. "+
+				"%q prints a Graphviz DOT graph of the commits, colored by whether they would be synced, "+
+				"are filtered, or are already synced.",
+			printCommitsFlagName,
+			stringutil.SliceToString(allPlanFormatStrings),
+			planFormatTable,
+			planFormatDOT,
+		),
+	)
+	flagSet.StringVar(
+		&f.GitDir,
+		gitDirFlagName,
+		git.DotGitDir,
+		"The path to the git directory to read objects and refs from. "+
+			"This can be a bare repository, such as a mirror clone, in which case its local branches "+
+			"are synced as if they were pushed to the 'origin' remote, and its HEAD is the default branch.",
+	)
+	flagSet.StringVar(
+		&f.MergeCommits,
+		mergeCommitsFlagName,
+		mergeCommitsFirstParentOnly,
+		fmt.Sprintf(
+			"How to handle merge commits. Must be one of %s. "+
+				"%q syncs merge commits, but not the commits brought in to a branch by a merge. "+
+				"%q syncs the commits brought in by a merge before the merge commit. "+
+				"%q syncs the commits brought in by a merge, but not the merge commit itself.",
+			stringutil.SliceToString(allMergeCommitsStrings),
+			mergeCommitsFirstParentOnly,
+			mergeCommitsInclude,
+			mergeCommitsSkip,
+		),
+	)
+	flagSet.BoolVar(
+		&f.HeadOnly,
+		headOnlyFlagName,
+		false,
+		fmt.Sprintf(
+			"Sync only the HEAD commit of the current branch, even if it is already synced, without syncing its history. "+
+				"Cannot be set with --%s.",
+			allBranchesFlagName,
+		),
+	)
+	flagSet.BoolVar(
+		&f.OnlyModuleChanges,
+		onlyModuleChangesFlagName,
+		false,
+		"Sync a module only in the commits that change files in its directory, compared to the commit's first parent. "+
+			"Root commits are always synced.",
+	)
+	flagSet.StringVar(
+		&f.RequireSigned,
+		requireSignedFlagName,
+		"",
+		"The path to an ASCII-armored GPG public keyring, or to SSH public keys one per line as in an authorized_keys file. "+
+			"If set, sync is aborted on any commit with a module that is not signed by one of the keys.",
+	)
+	flagSet.StringSliceVar(
+		&f.GitNotes,
+		gitNotesFlagName,
+		nil,
+		"The git notes ref(s) to read notes from, like 'refs/notes/commits', and push them along with each commit. "+
+			"Refs not starting with 'refs/' are read from 'refs/notes/', like git does.",
+	)
+	flagSet.BoolVar(
+		&f.ContinueOnError,
+		continueOnErrorFlagName,
+		false,
+		"Continue syncing the rest of the branches when a branch fails to sync, and fail at the end with all the errors. "+
+			"Branches with commits to sync on top of a failed branch are skipped.",
+	)
+	flagSet.Float64Var(
+		&f.RateLimit,
+		rateLimitFlagName,
+		0,
+		"The maximum number of commits pushed to the BSR per second, such as 0.5 for one commit every two seconds. "+
+			"Zero means no limit.",
+	)
+	flagSet.StringSliceVar(
+		&f.ExcludePaths,
+		excludePathFlagName,
+		nil,
+		"The path(s) to exclude from the synced modules, relative to the git repository root, such as proto/experimental. "+
+			"Paths can be glob patterns, and excluding a directory excludes all the files under it.",
+	)
+	flagSet.StringVar(
+		&f.ResumeOverrideFile,
+		resumeOverrideFileFlagName,
+		"",
+		"The path to a file overriding the commits the sync resumes from, instead of the ones recorded in the BSR. "+
+			"Each line is in the format \"<module-identity> <branch> <git-hash>\", and the modules and branches "+
+			"not listed resume from the BSR.",
+	)
+	flagSet.DurationVar(
+		&f.BuildTimeout,
+		buildTimeoutFlagName,
+		0,
+		"The maximum duration to build a module in a commit, such as 1m. Builds that time out are handled as build failures. "+
+			"Zero means no timeout.",
+	)
+	flagSet.StringVar(
+		&f.OutputDir,
+		outputDirFlagName,
+		"",
+		"The directory to write the module commits to, instead of pushing them to the BSR. "+
+			"Each module commit is written to <module-identity>/<branch>/<git-commit-hash>, and the synced commits are "+
+			"recorded in a manifest in the directory, to resume from.",
+	)
+	flagSet.BoolVar(
+		&f.TagsOnly,
+		tagsOnlyFlagName,
+		false,
+		fmt.Sprintf(
+			"Only sync the tagged commits with tags that are missing or point to a different commit in the BSR, "+
+				"without syncing the history of any branch. Cannot be set with --%s.",
+			headOnlyFlagName,
+		),
+	)
+	flagSet.StringSliceVar(
+		&f.Workspaces,
+		workspaceFlagName,
+		nil,
+		fmt.Sprintf(
+			"The workspace dir(s) to sync the modules from, relative to the git repository. The modules listed in the "+
+				"buf.work.yaml of the workspace at the HEAD commit are synced to the module names in their buf.yaml. "+
+				"Modules also set in --%s are synced as set in --%s. Cannot be set with --%s.",
+			moduleFlagName,
+			moduleFlagName,
+			createFlagName,
+		),
+	)
+	flagSet.DurationVar(
+		&f.CoalesceWindow,
+		coalesceWindowFlagName,
+		0,
+		"The window to coalesce consecutive commits in a branch into a single module commit for the last one, such as 1m. "+
+			"Commits committed within the window of the next commit are not synced, and their tags are synced with the last commit. "+
+			"Zero means no coalescing.",
+	)
+	flagSet.StringSliceVar(
+		&f.LocalResumePoints,
+		localResumePointFlagName,
+		nil,
+		"The commit to resume syncing a branch after for all modules, instead of the sync point in the BSR; "+
+			"this must be in the format <branch>:<git-commit-hash>. The commit must be an ancestor of the branch HEAD commit.",
+	)
+	flagSet.StringSliceVar(
+		&f.ModuleOrder,
+		moduleOrderFlagName,
+		nil,
+		fmt.Sprintf(
+			"The module name(s) to sync first in every commit, in order, such as to push a module before the modules that import it. "+
+				"The modules not set are synced after them, in the order set in --%s.",
+			moduleFlagName,
+		),
+	)
+	flagSet.StringSliceVar(
+		&f.ClientHeaders,
+		clientHeaderFlagName,
+		nil,
+		"The header to set in every request to the BSR, such as a custom User-Agent; "+
+			"this must be in the format <key>=<value>. Setting the same key multiple times sends all its values.",
+	)
+	flagSet.BoolVar(
+		&f.Lint,
+		lintFlagName,
+		false,
+		"Run the lint checks in the module config of each module commit before syncing it. "+
+			"Module commits that fail to compile or fail lint are skipped, like module commits that fail to build.",
+	)
+	flagSet.BoolVar(
+		&f.LintFail,
+		lintFailFlagName,
+		false,
+		fmt.Sprintf(
+			"Abort sync on the first module commit that fails to compile or fails lint, instead of skipping it. "+
+				"Can only be set if --%s is set",
+			lintFlagName,
+		),
+	)
+	flagSet.IntVar(
+		&f.MaxDepth,
+		maxDepthFlagName,
+		0,
+		"The max number of commits to look at in each branch, from its HEAD commit, when looking for the commits to sync. "+
+			"If the last synced commit is not found within them, only those commits are synced. If not set, the whole history is looked at.",
+	)
+	flagSet.BoolVar(
+		&f.Status,
+		statusFlagName,
+		false,
+		fmt.Sprintf(
+			"Print the sync status of each module in each branch, without syncing: the last synced commit, "+
+				"the HEAD commit, and the number of commits that would be synced. Cannot be set with --%s.",
+			printCommitsFlagName,
+		),
+	)
+	flagSet.StringVar(
+		&f.StatusFormat,
+		statusFormatFlagName,
+		statusFormatText,
+		fmt.Sprintf(
+			"The format to print the sync status with. Must be one of %s. Can only be set if --%s is set",
+			stringutil.SliceToString(allStatusFormatStrings),
+			statusFlagName,
+		),
+	)
+	flagSet.StringSliceVar(
+		&f.BranchIdentitySuffixes,
+		branchIdentitySuffixFlagName,
+		nil,
+		fmt.Sprintf(
+			"The suffix to append to the <module-name> of every module set in --%s when syncing a branch, "+
+				"in the format <branch>=<suffix>, such as staging=-staging to sync the staging branch of "+
+				"buf.build/acme/foo to buf.build/acme/foo-staging. Branches without a suffix are synced "+
+				"to the <module-name> as is. The suffix is appended after the %s placeholder is replaced.",
+			moduleFlagName,
+			branchPlaceholder,
+		),
+	)
+	flagSet.StringSliceVar(
+		&f.Annotations,
+		annotationFlagName,
+		nil,
+		"The annotation to push along with every commit, such as a CI build number or a source URL; "+
+			"this must be in the format <key>=<value>.",
+	)
+	flagSet.BoolVar(
+		&f.ReanchorOnRebase,
+		reanchorOnRebaseFlagName,
+		false,
+		"Resume syncing a branch from its most recent commit already synced to the BSR when its last synced commit "+
+			"is no longer found in the repository, such as after a rebase. Already synced commits are not pushed again.",
+	)
+	flagSet.BoolVar(
+		&f.RequireClean,
+		requireCleanFlagName,
+		false,
+		fmt.Sprintf(
+			"Fail if the working tree or the index has uncommitted changes, instead of warning about them. "+
+				"Only commits pushed to the remote are synced. Cannot be set with --%s.",
+			allBranchesFlagName,
+		),
+	)
+	flagSet.StringVar(
+		&f.Branch,
+		branchFlagName,
+		"",
+		fmt.Sprintf(
+			"The branch to sync instead of the current branch, such as the branch the HEAD commit belongs to "+
+				"when git HEAD is detached in CI checkouts. The branch must be pushed to the remote. Cannot be set with --%s.",
+			allBranchesFlagName,
+		),
+	)
+	flagSet.BoolVar(
+		&f.StrictModules,
+		strictModulesFlagName,
+		false,
+		"Fail if a module dir is not found in any commit of the branches to sync, such as for a typo in the dir, "+
+			"instead of warning about it.",
+	)
+	flagSet.IntVar(
+		&f.InitialSyncThreshold,
+		initialSyncThresholdFlagName,
+		defaultInitialSyncThreshold,
+		fmt.Sprintf(
+			"The number of commits of a module in a branch above which its sync is confirmed with an interactive prompt, "+
+				"if the module has no sync point in any branch, such as on the first sync, unless --%s or --%s is set. "+
+				"From a non-interactive terminal, the branch is skipped with a warning instead of prompting. Zero means no confirmation.",
+			yesFlagName,
+			noConfirmFlagName,
+		),
+	)
+	flagSet.BoolVar(
+		&f.Yes,
+		yesFlagName,
+		false,
+		fmt.Sprintf("Sync the branches above the --%s without confirming.", initialSyncThresholdFlagName),
+	)
+	flagSet.BoolVar(
+		&f.NoConfirm,
+		noConfirmFlagName,
+		false,
+		fmt.Sprintf(
+			"Skip the branches above the --%s without prompting, such as in non-interactive runs. Cannot be set with --%s.",
+			initialSyncThresholdFlagName,
+			yesFlagName,
+		),
+	)
 }
 
 func run(
@@ -148,89 +681,532 @@ func run(
 	} else if flags.Create {
 		return appcmd.NewInvalidArgumentErrorf("--%s is required if --%s is set.", createVisibilityFlagName, createFlagName)
 	}
-	return sync(
-		ctx,
-		container,
-		flags.Modules,
+	if len(flags.Workspaces) > 0 && flags.Create {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set both --%s and --%s.", workspaceFlagName, createFlagName)
+	}
+	if flags.OutputDir != "" && flags.Create {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set both --%s and --%s.", outputDirFlagName, createFlagName)
+	}
+	if flags.LintFail && !flags.Lint {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set --%s without --%s.", lintFailFlagName, lintFlagName)
+	}
+	if len(flags.ModuleVisibilities) > 0 && !flags.Create {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set --%s without --%s.", moduleVisibilityFlagName, createFlagName)
+	}
+	if flags.ModuleTemplate != "" && len(flags.ModuleDirs) == 0 {
+		return appcmd.NewInvalidArgumentErrorf("--%s is required if --%s is set.", moduleDirFlagName, moduleTemplateFlagName)
+	}
+	if len(flags.ModuleDirs) > 0 && flags.ModuleTemplate == "" {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set --%s without --%s.", moduleDirFlagName, moduleTemplateFlagName)
+	}
+	templateModules, err := expandModuleTemplate(flags.ModuleTemplate, flags.ModuleDirs)
+	if err != nil {
+		return appcmd.NewInvalidArgumentErrorf("--%s: %s", moduleTemplateFlagName, err.Error())
+	}
+	var stdinModules []string
+	if flags.ModuleStdin {
+		stdinModules, err = readModules(container.Stdin())
+		if err != nil {
+			return appcmd.NewInvalidArgumentErrorf("--%s: %s", moduleStdinFlagName, err.Error())
+		}
+	}
+	moduleVisibilities, err := parseModuleVisibilities(flags.ModuleVisibilities)
+	if err != nil {
+		return appcmd.NewInvalidArgumentError(err.Error())
+	}
+	if flags.HeadOnly && flags.AllBranches {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set both --%s and --%s.", headOnlyFlagName, allBranchesFlagName)
+	}
+	if flags.RequireClean && flags.AllBranches {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set both --%s and --%s.", requireCleanFlagName, allBranchesFlagName)
+	}
+	if flags.Branch != "" && flags.AllBranches {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set both --%s and --%s.", branchFlagName, allBranchesFlagName)
+	}
+	if flags.TagsOnly && flags.HeadOnly {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set both --%s and --%s.", tagsOnlyFlagName, headOnlyFlagName)
+	}
+	if flags.CoalesceWindow < 0 {
+		return appcmd.NewInvalidArgumentErrorf("--%s must not be negative.", coalesceWindowFlagName)
+	}
+	if flags.BuildTimeout < 0 {
+		return appcmd.NewInvalidArgumentErrorf("--%s must not be negative.", buildTimeoutFlagName)
+	}
+	if flags.RateLimit < 0 {
+		return appcmd.NewInvalidArgumentErrorf("--%s must not be negative.", rateLimitFlagName)
+	}
+	if flags.MaxDepth < 0 {
+		return appcmd.NewInvalidArgumentErrorf("--%s must not be negative.", maxDepthFlagName)
+	}
+	if flags.InitialSyncThreshold < 0 {
+		return appcmd.NewInvalidArgumentErrorf("--%s must not be negative.", initialSyncThresholdFlagName)
+	}
+	if flags.Yes && flags.NoConfirm {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set both --%s and --%s.", yesFlagName, noConfirmFlagName)
+	}
+	mergeCommitPolicy, ok := mergeCommitsStringToMergeCommitPolicy[flags.MergeCommits]
+	if !ok {
+		return appcmd.NewInvalidArgumentErrorf(
+			"--%s must be one of %s, got %q.",
+			mergeCommitsFlagName,
+			stringutil.SliceToString(allMergeCommitsStrings),
+			flags.MergeCommits,
+		)
+	}
+	printPlanFunc, ok := planFormatStringToPlanPrinter[flags.PlanFormat]
+	if !ok {
+		return appcmd.NewInvalidArgumentErrorf(
+			"--%s must be one of %s, got %q.",
+			planFormatFlagName,
+			stringutil.SliceToString(allPlanFormatStrings),
+			flags.PlanFormat,
+		)
+	}
+	if flags.PlanFormat != planFormatTable && !flags.PrintCommits {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set --%s without --%s.", planFormatFlagName, printCommitsFlagName)
+	}
+	var printStatusFunc func(io.Writer, []moduleBranchStatus) error
+	if flags.Status {
+		if flags.PrintCommits {
+			return appcmd.NewInvalidArgumentErrorf("Cannot set both --%s and --%s.", statusFlagName, printCommitsFlagName)
+		}
+		printStatusFunc, ok = statusFormatStringToStatusPrinter[flags.StatusFormat]
+		if !ok {
+			return appcmd.NewInvalidArgumentErrorf(
+				"--%s must be one of %s, got %q.",
+				statusFormatFlagName,
+				stringutil.SliceToString(allStatusFormatStrings),
+				flags.StatusFormat,
+			)
+		}
+	} else if flags.StatusFormat != statusFormatText {
+		return appcmd.NewInvalidArgumentErrorf("Cannot set --%s without --%s.", statusFormatFlagName, statusFlagName)
+	}
+	moduleOrder := make([]bufmoduleref.ModuleIdentity, 0, len(flags.ModuleOrder))
+	for _, moduleName := range flags.ModuleOrder {
+		identity, err := bufmoduleref.ModuleIdentityForString(moduleName)
+		if err != nil {
+			return appcmd.NewInvalidArgumentErrorf("--%s: %s", moduleOrderFlagName, err.Error())
+		}
+		moduleOrder = append(moduleOrder, identity)
+	}
+	localResumePoints, err := parseLocalResumePoints(flags.LocalResumePoints)
+	if err != nil {
+		return appcmd.NewInvalidArgumentErrorf("--%s: %s", localResumePointFlagName, err.Error())
+	}
+	clientHeaders, err := parseClientHeaders(flags.ClientHeaders)
+	if err != nil {
+		return appcmd.NewInvalidArgumentErrorf("--%s: %s", clientHeaderFlagName, err.Error())
+	}
+	branchIdentitySuffixes, err := parseBranchIdentitySuffixes(flags.BranchIdentitySuffixes)
+	if err != nil {
+		return appcmd.NewInvalidArgumentErrorf("--%s: %s", branchIdentitySuffixFlagName, err.Error())
+	}
+	annotations, err := parseAnnotations(flags.Annotations)
+	if err != nil {
+		return appcmd.NewInvalidArgumentErrorf("--%s: %s", annotationFlagName, err.Error())
+	}
+	var signedCommitsKeyring openpgp.KeyRing
+	var sshSigningKeys []ssh.PublicKey
+	if flags.RequireSigned != "" {
+		signedCommitsKeyring, sshSigningKeys, err = readSigningKeys(flags.RequireSigned)
+		if err != nil {
+			return appcmd.NewInvalidArgumentErrorf("--%s: %s", requireSignedFlagName, err.Error())
+		}
+	}
+	return sync(ctx, container, &syncOptions{
+		modules: append(append(flags.Modules, stdinModules...), templateModules...),
 		// No need to pass `flags.Create`, this is not empty iff `flags.Create`
-		flags.CreateVisibility,
-		flags.AllBranches,
-	)
+		createWithVisibility:   flags.CreateVisibility,
+		moduleVisibilities:     moduleVisibilities,
+		allBranches:            flags.AllBranches,
+		printCommits:           flags.PrintCommits,
+		printPlanFunc:          printPlanFunc,
+		gitDir:                 flags.GitDir,
+		mergeCommitPolicy:      mergeCommitPolicy,
+		headOnly:               flags.HeadOnly,
+		onlyModuleChanges:      flags.OnlyModuleChanges,
+		signedCommitsKeyring:   signedCommitsKeyring,
+		sshSigningKeys:         sshSigningKeys,
+		gitNotesRefs:           flags.GitNotes,
+		continueOnError:        flags.ContinueOnError,
+		rateLimit:              flags.RateLimit,
+		excludePaths:           flags.ExcludePaths,
+		resumeOverrideFile:     flags.ResumeOverrideFile,
+		buildTimeout:           flags.BuildTimeout,
+		outputDirPath:          flags.OutputDir,
+		tagsOnly:               flags.TagsOnly,
+		workspaces:             flags.Workspaces,
+		coalesceWindow:         flags.CoalesceWindow,
+		localResumePoints:      localResumePoints,
+		moduleOrder:            moduleOrder,
+		clientHeaders:          clientHeaders,
+		lint:                   flags.Lint,
+		lintFail:               flags.LintFail,
+		maxDepth:               flags.MaxDepth,
+		printStatusFunc:        printStatusFunc,
+		branchIdentitySuffixes: branchIdentitySuffixes,
+		annotations:            annotations,
+		reanchorOnRebase:       flags.ReanchorOnRebase,
+		requireClean:           flags.RequireClean,
+		branch:                 flags.Branch,
+		strictModules:          flags.StrictModules,
+		initialSyncThreshold:   flags.InitialSyncThreshold,
+		confirmInitialSync:     initialSyncConfirmer(container, flags.Yes, flags.NoConfirm),
+	})
+}
+
+// syncOptions are the options of a sync, set from the flags.
+type syncOptions struct {
+	modules                []string
+	createWithVisibility   string
+	moduleVisibilities     map[string]string
+	allBranches            bool
+	printCommits           bool
+	printPlanFunc          func(io.Writer, bufsync.SyncPlan) error
+	gitDir                 string
+	mergeCommitPolicy      bufsync.MergeCommitPolicy
+	headOnly               bool
+	onlyModuleChanges      bool
+	signedCommitsKeyring   openpgp.KeyRing
+	sshSigningKeys         []ssh.PublicKey
+	gitNotesRefs           []string
+	continueOnError        bool
+	rateLimit              float64
+	excludePaths           []string
+	resumeOverrideFile     string
+	buildTimeout           time.Duration
+	outputDirPath          string
+	tagsOnly               bool
+	workspaces             []string
+	coalesceWindow         time.Duration
+	localResumePoints      map[string]git.Hash
+	moduleOrder            []bufmoduleref.ModuleIdentity
+	clientHeaders          http.Header
+	lint                   bool
+	lintFail               bool
+	maxDepth               int
+	printStatusFunc        func(io.Writer, []moduleBranchStatus) error
+	branchIdentitySuffixes map[string]string
+	annotations            map[string]string
+	reanchorOnRebase       bool
+	requireClean           bool
+	branch                 string
+	strictModules          bool
+	initialSyncThreshold   int
+	confirmInitialSync     bufsync.InitialSyncConfirmer
 }
 
 func sync(
 	ctx context.Context,
 	container appflag.Container,
-	modules []string,
-	createWithVisibility string,
-	allBranches bool,
-) error {
-	if len(modules) == 0 {
+	options *syncOptions,
+) (retErr error) {
+	if len(options.modules) == 0 && len(options.workspaces) == 0 {
 		container.Logger().Info("no modules to sync")
 		return nil
 	}
-	// Assume that this command is run from the repository root. If not, `OpenRepository` will return
-	// a dir not found error.
-	repo, err := git.OpenRepository(ctx, git.DotGitDir, command.NewRunner())
+	// Unless a git dir is passed, assume that this command is run from the repository root. If not,
+	// `OpenRepository` will return a dir not found error.
+	var openRepositoryOptions []git.OpenRepositoryOption
+	if options.branch != "" {
+		openRepositoryOptions = append(openRepositoryOptions, git.OpenRepositoryWithCurrentBranch(options.branch))
+	}
+	repo, err := git.OpenRepository(ctx, options.gitDir, command.NewRunner(), openRepositoryOptions...)
 	if err != nil {
-		return fmt.Errorf("open repository: %w", err)
+		if errors.Is(err, git.ErrDetachedHEAD) {
+			return appcmd.NewInvalidArgumentErrorf(
+				"git HEAD is detached in %q, set --%s to the branch the HEAD commit belongs to",
+				options.gitDir,
+				branchFlagName,
+			)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return appcmd.NewInvalidArgumentErrorf(
+				"git directory %q not found, run this command from the repository root or set --%s: %s",
+				options.gitDir,
+				gitDirFlagName,
+				err.Error(),
+			)
+		}
+		return fmt.Errorf("open repository %q: %w", options.gitDir, err)
 	}
 	defer repo.Close()
+	if !options.allBranches {
+		// Syncing the current branch from a working tree may suggest that its uncommitted changes are
+		// synced, which they are not.
+		uncommittedChanges, err := git.ListUncommittedChanges(ctx, options.gitDir, command.NewRunner())
+		if err != nil {
+			return fmt.Errorf("list uncommitted changes in %q: %w", options.gitDir, err)
+		}
+		if len(uncommittedChanges) > 0 {
+			if options.requireClean {
+				return appcmd.NewInvalidArgumentErrorf(
+					"the working tree has uncommitted changes, only commits pushed to the remote are synced: %s",
+					strings.Join(uncommittedChanges, ", "),
+				)
+			}
+			container.Logger().Warn(
+				"the working tree has uncommitted changes, only commits pushed to the remote are synced",
+				zap.Strings("paths", uncommittedChanges),
+			)
+		}
+	}
 	storageProvider := storagegit.NewProvider(
 		repo.Objects(),
 		storagegit.ProviderWithSymlinks(),
 	)
-	clientConfig, err := bufcli.NewConnectClientConfig(container)
-	if err != nil {
-		return fmt.Errorf("create connect client %w", err)
-	}
 	syncerOptions := []bufsync.SyncerOption{
-		bufsync.SyncerWithResumption(syncPointResolver(clientConfig)),
-		bufsync.SyncerWithGitCommitChecker(syncGitCommitChecker(clientConfig)),
-		bufsync.SyncerWithModuleDefaultBranchGetter(defaultBranchGetter(clientConfig)),
+		bufsync.SyncerWithMergeCommitPolicy(options.mergeCommitPolicy),
 	}
-	if allBranches {
+	// When syncing to an output dir, no connect clients are created, and modules default branches
+	// are not validated.
+	var (
+		clientConfig *connectclient.Config
+		destination  *outputDir
+	)
+	if options.outputDirPath != "" {
+		if err := os.MkdirAll(options.outputDirPath, 0755); err != nil {
+			return fmt.Errorf("create output dir %q: %w", options.outputDirPath, err)
+		}
+		outputBucket, err := storageos.NewProvider().NewReadWriteBucket(options.outputDirPath)
+		if err != nil {
+			return fmt.Errorf("open output dir %q: %w", options.outputDirPath, err)
+		}
+		destination, err = newOutputDir(ctx, outputBucket)
+		if err != nil {
+			return fmt.Errorf("open output dir %q: %w", options.outputDirPath, err)
+		}
+		defer func() {
+			// The manifest is written even if the sync fails or is interrupted, so the next sync resumes
+			// after the module commits already written.
+			retErr = multierr.Append(retErr, destination.flush(context.Background()))
+		}()
+		syncerOptions = append(
+			syncerOptions,
+			bufsync.SyncerWithResumption(destination.syncPointResolver()),
+			bufsync.SyncerWithGitCommitChecker(destination.syncGitCommitChecker()),
+			bufsync.SyncerWithTagResolver(destination.tagResolver()),
+		)
+	} else {
+		clientConfig, err = bufcli.NewConnectClientConfigWithHeaders(container, options.clientHeaders)
+		if err != nil {
+			return fmt.Errorf("create connect client %w", err)
+		}
+		syncerOptions = append(
+			syncerOptions,
+			bufsync.SyncerWithResumption(syncPointResolver(clientConfig)),
+			bufsync.SyncerWithGitCommitChecker(syncGitCommitChecker(clientConfig)),
+			bufsync.SyncerWithModuleDefaultBranchGetter(defaultBranchGetter(clientConfig)),
+			// Repositories created while syncing have their default branch set to the git default branch.
+			bufsync.SyncerWithExpectedDefaultBranch(repo.DefaultBranch()),
+			bufsync.SyncerWithTagResolver(tagResolver(clientConfig)),
+		)
+	}
+	if options.lint {
+		// Dependencies are read from the BSR to compile the modules, even when syncing to an output dir.
+		if clientConfig == nil {
+			clientConfig, err = bufcli.NewConnectClientConfigWithHeaders(container, options.clientHeaders)
+			if err != nil {
+				return fmt.Errorf("create connect client %w", err)
+			}
+		}
+		moduleReader, err := bufcli.NewModuleReaderAndCreateCacheDirs(container, clientConfig)
+		if err != nil {
+			return err
+		}
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithLintOnSync(bufsync.LintConfig{ModuleReader: moduleReader}))
+	}
+	if options.allBranches {
 		syncerOptions = append(syncerOptions, bufsync.SyncerWithAllBranches())
 	}
-	for _, module := range modules {
-		var moduleIdentityOverride bufmoduleref.ModuleIdentity
-		colon := strings.IndexRune(module, ':')
-		if colon == -1 {
-			return appcmd.NewInvalidArgumentErrorf("module %q is missing an identity", module)
+	if options.headOnly {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithHeadOnly())
+	}
+	if options.maxDepth > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithMaxHistoryDepth(options.maxDepth))
+	}
+	if options.tagsOnly {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithTagReconcileOnly())
+	}
+	if options.onlyModuleChanges {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithSkipUnchangedCommits())
+	}
+	if options.signedCommitsKeyring != nil {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithRequireSignedCommits(options.signedCommitsKeyring))
+	}
+	if len(options.sshSigningKeys) > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithRequireSSHSignedCommits(options.sshSigningKeys))
+	}
+	if options.continueOnError {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithContinueOnBranchError())
+	}
+	if options.reanchorOnRebase {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithReanchorOnMissingSyncPoint())
+	}
+	if options.strictModules {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithStrictModules())
+	}
+	if options.initialSyncThreshold > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithInitialSyncConfirmation(options.initialSyncThreshold, options.confirmInitialSync))
+	}
+	if options.rateLimit > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithRateLimit(options.rateLimit))
+	}
+	if len(options.excludePaths) > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithPathExclude(options.excludePaths...))
+	}
+	if options.resumeOverrideFile != "" {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithResumeOverrides(options.resumeOverrideFile))
+	}
+	if options.buildTimeout > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithBuildTimeout(options.buildTimeout))
+	}
+	if options.coalesceWindow > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithTimeWindowCoalesce(options.coalesceWindow))
+	}
+	for branch, hash := range options.localResumePoints {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithLocalResumePoint(branch, hash))
+	}
+	if len(options.moduleOrder) > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithModuleOrder(options.moduleOrder))
+	}
+	for _, gitNotesRef := range options.gitNotesRefs {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithGitNotes(gitNotesRef))
+	}
+	if len(options.annotations) > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithCommitAnnotator(
+			func(git.Commit) (map[string]string, error) {
+				return options.annotations, nil
+			},
+		))
+	}
+	for _, workspace := range options.workspaces {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithWorkspace(workspace))
+	}
+	syncModules := make([]bufsync.Module, 0, len(options.modules))
+	// identityTemplates are the module identities resolved per branch, with a branch placeholder or
+	// branch identity suffixes, keyed by module path.
+	identityTemplates := make(map[string]string)
+	for _, module := range options.modules {
+		modulePath, identityTemplate, err := parseModule(module, len(options.modules) > 1)
+		if err != nil {
+			return appcmd.NewInvalidArgumentError(err.Error())
+		}
+		// Validate the identities of all branches with a suffix upfront, before syncing any of them.
+		for branch, suffix := range options.branchIdentitySuffixes {
+			if _, err := moduleIdentityForBranch(identityTemplate+suffix, branch); err != nil {
+				return appcmd.NewInvalidArgumentErrorf("--%s: %s", branchIdentitySuffixFlagName, err.Error())
+			}
 		}
-		moduleIdentityOverride, err = bufmoduleref.ModuleIdentityForString(module[colon+1:])
+		// The module is synced to the identity for the default branch, unless resolved for another branch.
+		moduleIdentityOverride, err := moduleIdentityForBranch(
+			identityTemplate+options.branchIdentitySuffixes[repo.DefaultBranch()],
+			repo.DefaultBranch(),
+		)
 		if err != nil {
 			return fmt.Errorf("module identity: %w", err)
 		}
-		module = normalpath.Normalize(module[:colon])
-		syncModule, err := bufsync.NewModule(module, moduleIdentityOverride)
+		syncModule, err := bufsync.NewModule(modulePath, moduleIdentityOverride)
 		if err != nil {
 			return fmt.Errorf("prepare module for sync: %w", err)
 		}
+		if strings.Contains(identityTemplate, branchPlaceholder) || len(options.branchIdentitySuffixes) > 0 {
+			identityTemplates[syncModule.Dir()] = identityTemplate
+		}
+		syncModules = append(syncModules, syncModule)
 		syncerOptions = append(syncerOptions, bufsync.SyncerWithModule(syncModule))
 	}
+	createVisibilities, err := moduleCreateVisibilities(syncModules, options.createWithVisibility, options.moduleVisibilities)
+	if err != nil {
+		return appcmd.NewInvalidArgumentError(err.Error())
+	}
+	if len(identityTemplates) > 0 {
+		syncerOptions = append(syncerOptions, bufsync.SyncerWithIdentityResolver(
+			func(module bufsync.Module, branch string) (bufmoduleref.ModuleIdentity, error) {
+				identityTemplate, ok := identityTemplates[module.Dir()]
+				if !ok {
+					return module.RemoteIdentity(), nil
+				}
+				if branch == "" {
+					branch = repo.DefaultBranch()
+				}
+				identity, err := moduleIdentityForBranch(identityTemplate+options.branchIdentitySuffixes[branch], branch)
+				if err != nil {
+					return nil, err
+				}
+				// Repositories created for other branches use the same visibility as the module's.
+				if visibility, ok := createVisibilities[module.RemoteIdentity().IdentityString()]; ok {
+					createVisibilities[identity.IdentityString()] = visibility
+				}
+				return identity, nil
+			},
+		))
+	}
+	runID, err := uuidutil.New()
+	if err != nil {
+		return fmt.Errorf("generate run ID: %w", err)
+	}
+	syncerOptions = append(
+		syncerOptions,
+		bufsync.SyncerWithRunID(runID.String()),
+		bufsync.SyncerWithInterruptCheckpoint(),
+	)
+	errorHandler := newErrorHandler(container.Logger().With(zap.String("run_id", runID.String())), options.lintFail)
 	syncer, err := bufsync.NewSyncer(
 		container.Logger(),
 		repo,
 		storageProvider,
-		newErrorHandler(container.Logger()),
+		errorHandler,
 		syncerOptions...,
 	)
 	if err != nil {
-		return fmt.Errorf("new syncer: %w", err)
-	}
-	return syncer.Sync(ctx, func(ctx context.Context, moduleCommit bufsync.ModuleCommit) error {
-		syncPoint, err := pushOrCreate(
-			ctx,
-			clientConfig,
-			repo,
-			moduleCommit.Commit(),
-			moduleCommit.Branch(),
-			moduleCommit.Tags(),
-			moduleCommit.Identity(),
-			moduleCommit.Bucket(),
-			createWithVisibility,
-		)
+		return app.WrapError(exitCodeConfigFailure, fmt.Errorf("new syncer: %w", err))
+	}
+	container.Logger().Info("sync started", zap.String("run_id", runID.String()))
+	if options.printStatusFunc != nil {
+		plan, err := syncer.Plan(ctx)
+		if err != nil {
+			return newSyncError(fmt.Errorf("plan sync: %w", err))
+		}
+		statuses, err := newModuleBranchStatuses(plan, repo.HEADCommit)
+		if err != nil {
+			return err
+		}
+		return options.printStatusFunc(container.Stdout(), statuses)
+	}
+	if options.printCommits {
+		plan, err := syncer.Plan(ctx)
+		if err != nil {
+			return newSyncError(fmt.Errorf("plan sync: %w", err))
+		}
+		if err := options.printPlanFunc(container.Stdout(), plan); err != nil {
+			return err
+		}
+		return errorHandler.buildFailuresError()
+	}
+	summary := newSyncSummary()
+	if err := syncer.Sync(ctx, func(ctx context.Context, moduleCommit bufsync.ModuleCommit) error {
+		if destination != nil {
+			dir, err := destination.writeModuleCommit(ctx, moduleCommit)
+			if err != nil {
+				return fmt.Errorf(
+					"failed to write %s at %s to output dir: %w",
+					moduleCommit.Identity().IdentityString(),
+					moduleCommit.Commit().Hash(),
+					err,
+				)
+			}
+			summary.record(moduleCommit.Identity().IdentityString())
+			_, err = fmt.Fprintf(
+				container.Stderr(),
+				"%s:%s -> %s\n",
+				moduleCommit.Branch(), moduleCommit.Commit().Hash().Hex(),
+				filepath.Join(options.outputDirPath, dir),
+			)
+			return err
+		}
+		syncPoint, err := pushOrCreate(ctx, clientConfig, moduleCommit, createVisibilities, repo.DefaultBranch())
 		if err != nil {
 			// We failed to push. We fail hard on this because the error may be recoverable
 			// (i.e., the BSR may be down) and we should re-attempt this commit.
@@ -241,6 +1217,7 @@ func sync(
 				err,
 			)
 		}
+		summary.record(moduleCommit.Identity().IdentityString())
 		_, err = container.Stderr().Write([]byte(
 			// from local                     -> to remote
 			// <git-branch>:<git-commit-hash> -> <module-identity>:<bsr-commit-name>
@@ -251,7 +1228,399 @@ func sync(
 			)),
 		)
 		return err
-	})
+	}); err != nil {
+		if errors.Is(err, bufsync.ErrInterrupted) {
+			if printErr := summary.printInterrupted(container.Stderr()); printErr != nil {
+				return multierr.Append(newSyncError(err), printErr)
+			}
+		}
+		return newSyncError(err)
+	}
+	return errorHandler.buildFailuresError()
+}
+
+// syncSummary counts the module commits synced in a run, by module identity.
+type syncSummary struct {
+	moduleCommits map[string]int
+}
+
+func newSyncSummary() *syncSummary {
+	return &syncSummary{moduleCommits: make(map[string]int)}
+}
+
+// record counts a synced module commit of the module identity.
+func (s *syncSummary) record(moduleIdentity string) {
+	s.moduleCommits[moduleIdentity]++
+}
+
+// printInterrupted prints what was synced before the sync was interrupted.
+func (s *syncSummary) printInterrupted(writer io.Writer) error {
+	var total int
+	moduleIdentities := make([]string, 0, len(s.moduleCommits))
+	for moduleIdentity, count := range s.moduleCommits {
+		total += count
+		moduleIdentities = append(moduleIdentities, moduleIdentity)
+	}
+	sort.Strings(moduleIdentities)
+	if _, err := fmt.Fprintf(writer, "Sync interrupted after syncing %d module commit(s).\n", total); err != nil {
+		return err
+	}
+	for _, moduleIdentity := range moduleIdentities {
+		if _, err := fmt.Fprintf(writer, "  %s: %d\n", moduleIdentity, s.moduleCommits[moduleIdentity]); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(writer, "Run the command again to resume from the last synced commit.")
+	return err
+}
+
+// parseModule parses a module flag, in the format <module-path>:<module-name>, returning the
+// normalized module path and the module identity template. If the path is not required, a module
+// flag with only a <module-name> is synced from the repository root.
+func parseModule(moduleFlag string, pathRequired bool) (string, string, error) {
+	colon := strings.IndexRune(moduleFlag, ':')
+	if colon == -1 {
+		if pathRequired {
+			return "", "", fmt.Errorf("module %q is missing a path or an identity, only a single module can be set without a path", moduleFlag)
+		}
+		return ".", moduleFlag, nil
+	}
+	return normalpath.Normalize(moduleFlag[:colon]), moduleFlag[colon+1:], nil
+}
+
+// expandModuleTemplate expands the module identity template for each of the module dirs, returning
+// module flags in the format <module-path>:<module-name>. The dir placeholder is replaced by the
+// normalized module dir, with any '/' replaced by '-'.
+func expandModuleTemplate(moduleTemplate string, moduleDirs []string) ([]string, error) {
+	if len(moduleDirs) == 0 {
+		return nil, nil
+	}
+	if !strings.Contains(moduleTemplate, dirPlaceholder) {
+		return nil, fmt.Errorf("module template %q is missing a %s placeholder", moduleTemplate, dirPlaceholder)
+	}
+	moduleFlags := make([]string, 0, len(moduleDirs))
+	seenModuleDirs := make(map[string]struct{}, len(moduleDirs))
+	for _, moduleDir := range moduleDirs {
+		modulePath := normalpath.Normalize(moduleDir)
+		if modulePath == "." {
+			return nil, fmt.Errorf("module dir %q cannot be the repository root", moduleDir)
+		}
+		if _, ok := seenModuleDirs[modulePath]; ok {
+			return nil, fmt.Errorf("duplicate module dir %q", modulePath)
+		}
+		seenModuleDirs[modulePath] = struct{}{}
+		identity := strings.ReplaceAll(moduleTemplate, dirPlaceholder, strings.ReplaceAll(modulePath, "/", "-"))
+		// Identities with a branch placeholder are validated once resolved for a branch.
+		if !strings.Contains(identity, branchPlaceholder) {
+			if _, err := bufmoduleref.ModuleIdentityForString(identity); err != nil {
+				return nil, fmt.Errorf("module identity for dir %q: %w", modulePath, err)
+			}
+		}
+		moduleFlags = append(moduleFlags, modulePath+":"+identity)
+	}
+	return moduleFlags, nil
+}
+
+// parseLocalResumePoints parses the local resume point flags, in the format
+// <branch>:<git-commit-hash>, returning the commit hashes keyed by branch.
+func parseLocalResumePoints(localResumePointFlags []string) (map[string]git.Hash, error) {
+	localResumePoints := make(map[string]git.Hash, len(localResumePointFlags))
+	for _, localResumePointFlag := range localResumePointFlags {
+		colon := strings.LastIndex(localResumePointFlag, ":")
+		if colon == -1 {
+			return nil, fmt.Errorf("local resume point %q is missing a branch", localResumePointFlag)
+		}
+		branch := localResumePointFlag[:colon]
+		hash, err := git.NewHashFromHex(localResumePointFlag[colon+1:])
+		if err != nil {
+			return nil, fmt.Errorf("local resume point %q: %w", localResumePointFlag, err)
+		}
+		if _, ok := localResumePoints[branch]; ok {
+			return nil, fmt.Errorf("duplicate local resume point for branch %q", branch)
+		}
+		localResumePoints[branch] = hash
+	}
+	return localResumePoints, nil
+}
+
+// parseBranchIdentitySuffixes parses the branch identity suffix flags, in the format
+// <branch>=<suffix>, returning the suffixes keyed by branch.
+func parseBranchIdentitySuffixes(branchIdentitySuffixFlags []string) (map[string]string, error) {
+	branchIdentitySuffixes := make(map[string]string, len(branchIdentitySuffixFlags))
+	for _, branchIdentitySuffixFlag := range branchIdentitySuffixFlags {
+		equals := strings.LastIndex(branchIdentitySuffixFlag, "=")
+		if equals == -1 {
+			return nil, fmt.Errorf("branch identity suffix %q is missing a suffix", branchIdentitySuffixFlag)
+		}
+		branch := branchIdentitySuffixFlag[:equals]
+		suffix := branchIdentitySuffixFlag[equals+1:]
+		if branch == "" {
+			return nil, fmt.Errorf("branch identity suffix %q is missing a branch", branchIdentitySuffixFlag)
+		}
+		if suffix == "" {
+			return nil, fmt.Errorf("branch identity suffix %q is missing a suffix", branchIdentitySuffixFlag)
+		}
+		if _, ok := branchIdentitySuffixes[branch]; ok {
+			return nil, fmt.Errorf("duplicate branch identity suffix for branch %q", branch)
+		}
+		branchIdentitySuffixes[branch] = suffix
+	}
+	return branchIdentitySuffixes, nil
+}
+
+// parseClientHeaders parses the client header flags, in the format <key>=<value>. Keys set multiple
+// times keep all their values, in order.
+func parseClientHeaders(clientHeaderFlags []string) (http.Header, error) {
+	clientHeaders := make(http.Header, len(clientHeaderFlags))
+	for _, clientHeaderFlag := range clientHeaderFlags {
+		key, value, found := strings.Cut(clientHeaderFlag, "=")
+		if !found {
+			return nil, fmt.Errorf("header %q must be in the format <key>=<value>", clientHeaderFlag)
+		}
+		if !httpguts.ValidHeaderFieldName(key) {
+			return nil, fmt.Errorf("header %q has an invalid key %q", clientHeaderFlag, key)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("header %q has an invalid value %q", clientHeaderFlag, value)
+		}
+		clientHeaders.Add(key, value)
+	}
+	return clientHeaders, nil
+}
+
+// parseAnnotations parses the annotation flags, in the format <key>=<value>, returning the values
+// keyed by key.
+func parseAnnotations(annotationFlags []string) (map[string]string, error) {
+	annotations := make(map[string]string, len(annotationFlags))
+	for _, annotationFlag := range annotationFlags {
+		key, value, found := strings.Cut(annotationFlag, "=")
+		if !found {
+			return nil, fmt.Errorf("annotation %q must be in the format <key>=<value>", annotationFlag)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("annotation %q is missing a key", annotationFlag)
+		}
+		if _, ok := annotations[key]; ok {
+			return nil, fmt.Errorf("duplicate annotation for key %q", key)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
+
+// readSigningKeys reads the keys trusted to sign commits from a file, either an ASCII-armored GPG
+// public keyring, or SSH public keys, one per line as in an authorized_keys file.
+func readSigningKeys(signingKeysPath string) (openpgp.KeyRing, []ssh.PublicKey, error) {
+	data, err := os.ReadFile(signingKeysPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP")) {
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("read keyring %q: %w", signingKeysPath, err)
+		}
+		return keyring, nil, nil
+	}
+	var sshSigningKeys []ssh.PublicKey
+	for rest := data; len(bytes.TrimSpace(rest)) > 0; {
+		var sshSigningKey ssh.PublicKey
+		sshSigningKey, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read SSH signing keys %q: %w", signingKeysPath, err)
+		}
+		sshSigningKeys = append(sshSigningKeys, sshSigningKey)
+	}
+	if len(sshSigningKeys) == 0 {
+		return nil, nil, fmt.Errorf("no GPG keyring or SSH public keys in %q", signingKeysPath)
+	}
+	return nil, sshSigningKeys, nil
+}
+
+// moduleIdentityForBranch returns the module identity for a branch, replacing any branch placeholder
+// in the identity template by the branch name, with any '/' replaced by '-'.
+func moduleIdentityForBranch(identityTemplate string, branch string) (bufmoduleref.ModuleIdentity, error) {
+	identity := strings.ReplaceAll(identityTemplate, branchPlaceholder, strings.ReplaceAll(branch, "/", "-"))
+	moduleIdentity, err := bufmoduleref.ModuleIdentityForString(identity)
+	if err != nil {
+		if identity != identityTemplate {
+			return nil, fmt.Errorf("module identity %q for branch %q: %w", identityTemplate, branch, err)
+		}
+		return nil, err
+	}
+	return moduleIdentity, nil
+}
+
+// readModules reads the module flags from the reader, one per line in the format
+// <module-path>:<module-name>, skipping empty lines. The module flags are parsed with parseModule
+// along with the ones passed to --module.
+func readModules(reader io.Reader) ([]string, error) {
+	var modules []string
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		module := strings.TrimSpace(scanner.Text())
+		if module == "" {
+			continue
+		}
+		if !strings.ContainsRune(module, ':') {
+			return nil, fmt.Errorf("line %d: module %q must be in the format <module-path>:<module-name>", lineNumber, module)
+		}
+		modules = append(modules, module)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read modules: %w", err)
+	}
+	return modules, nil
+}
+
+// parseModuleVisibilities parses the per-module visibility flags, in the format
+// <module-path>:<visibility>, returning the visibilities keyed by normalized module path.
+func parseModuleVisibilities(moduleVisibilityFlags []string) (map[string]string, error) {
+	moduleVisibilities := make(map[string]string, len(moduleVisibilityFlags))
+	for _, moduleVisibilityFlag := range moduleVisibilityFlags {
+		colon := strings.LastIndex(moduleVisibilityFlag, ":")
+		if colon == -1 {
+			return nil, fmt.Errorf("module visibility %q is missing a visibility", moduleVisibilityFlag)
+		}
+		visibility := moduleVisibilityFlag[colon+1:]
+		if _, err := bufcli.VisibilityFlagToVisibility(visibility); err != nil {
+			return nil, fmt.Errorf("module visibility %q: %w", moduleVisibilityFlag, err)
+		}
+		modulePath := normalpath.Normalize(moduleVisibilityFlag[:colon])
+		if _, ok := moduleVisibilities[modulePath]; ok {
+			return nil, fmt.Errorf("duplicate module visibility for module path %q", modulePath)
+		}
+		moduleVisibilities[modulePath] = visibility
+	}
+	return moduleVisibilities, nil
+}
+
+// moduleCreateVisibilities returns the visibility to create each module's repository with, keyed by
+// the module identity. Modules without a visibility in moduleVisibilities, keyed by module path, are
+// created with createWithVisibility. If createWithVisibility is empty, repositories are not created
+// and this returns nil.
+func moduleCreateVisibilities(
+	modules []bufsync.Module,
+	createWithVisibility string,
+	moduleVisibilities map[string]string,
+) (map[string]string, error) {
+	if createWithVisibility == "" {
+		return nil, nil
+	}
+	unusedModuleVisibilities := make(map[string]struct{}, len(moduleVisibilities))
+	for modulePath := range moduleVisibilities {
+		unusedModuleVisibilities[modulePath] = struct{}{}
+	}
+	createVisibilities := make(map[string]string, len(modules))
+	for _, module := range modules {
+		visibility := createWithVisibility
+		if moduleVisibility, ok := moduleVisibilities[module.Dir()]; ok {
+			visibility = moduleVisibility
+			delete(unusedModuleVisibilities, module.Dir())
+		}
+		createVisibilities[module.RemoteIdentity().IdentityString()] = visibility
+	}
+	if len(unusedModuleVisibilities) > 0 {
+		return nil, fmt.Errorf(
+			"--%s set for module paths not present in --%s: %s",
+			moduleVisibilityFlagName,
+			moduleFlagName,
+			stringutil.SliceToString(stringutil.MapToSortedSlice(unusedModuleVisibilities)),
+		)
+	}
+	return createVisibilities, nil
+}
+
+// newSyncError returns an error with an exit code that tells apart push failures, which may succeed
+// if sync is retried, from module build, policy and sync point failures, which need changes in the
+// git repository or the BSR to succeed. Other errors are returned unchanged.
+func newSyncError(err error) error {
+	var (
+		pushErr      *bufsync.PushError
+		buildErr     *bufsync.BuildError
+		policyErr    *bufsync.PolicyError
+		syncPointErr *bufsync.SyncPointError
+		configErr    *bufsync.ModulesConfigError
+	)
+	switch {
+	case errors.As(err, &pushErr):
+		return app.WrapError(exitCodePushFailure, err)
+	case errors.As(err, &buildErr):
+		return app.WrapError(exitCodeBuildFailure, err)
+	case errors.As(err, &policyErr):
+		return app.WrapError(exitCodePolicyFailure, err)
+	case errors.As(err, &syncPointErr):
+		return app.WrapError(exitCodeSyncPointFailure, err)
+	case errors.As(err, &configErr), errors.Is(err, bufsync.ErrModuleNotFound):
+		return app.WrapError(exitCodeConfigFailure, err)
+	default:
+		return err
+	}
+}
+
+// initialSyncConfirmer returns an InitialSyncConfirmer that confirms the initial syncs with --yes,
+// skips them with --no-confirm, and prompts the user for each of them otherwise. From a non-TTY
+// stdin, it skips them with a warning instead of prompting.
+func initialSyncConfirmer(container appflag.Container, yes bool, noConfirm bool) bufsync.InitialSyncConfirmer {
+	return func(_ context.Context, module bufsync.Module, branch string, commitCount int) (bool, error) {
+		if yes {
+			return true, nil
+		}
+		if noConfirm {
+			return false, nil
+		}
+		answer, err := bufcli.PromptUser(
+			container,
+			fmt.Sprintf(
+				"Module %s has no sync point in any branch, sync all its %d commits in branch %q? [y/n] ",
+				module.String(),
+				commitCount,
+				branch,
+			),
+		)
+		if err != nil {
+			if errors.Is(err, bufcli.ErrNotATTY) {
+				container.Logger().Warn(
+					fmt.Sprintf(
+						"cannot confirm the initial sync from a non-TTY device, skipping branch; set --%s to sync it",
+						yesFlagName,
+					),
+					zap.Stringer("module", module),
+					zap.String("branch", branch),
+					zap.Int("commits", commitCount),
+				)
+				return false, nil
+			}
+			return false, err
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes", nil
+	}
+}
+
+// printPlan prints a table of the commits to sync, one row per branch, commit, and module.
+func printPlan(writer io.Writer, plan bufsync.SyncPlan) error {
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tabWriter, "BRANCH\tCOMMIT\tMODULE\tTAGS"); err != nil {
+		return err
+	}
+	for _, branchPlan := range plan.Branches {
+		for _, commitPlan := range branchPlan.Commits {
+			for _, module := range commitPlan.Modules {
+				if _, err := fmt.Fprintf(
+					tabWriter,
+					"%s\t%s\t%s\t%s\n",
+					branchPlan.Branch,
+					commitPlan.Commit.Hash().Hex(),
+					module.String(),
+					strings.Join(commitPlan.Tags, ","),
+				); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return tabWriter.Flush()
 }
 
 func syncPointResolver(clientConfig *connectclient.Config) bufsync.SyncPointResolver {
@@ -297,15 +1666,73 @@ func syncGitCommitChecker(clientConfig *connectclient.Config) bufsync.SyncedGitC
 			}
 			return nil, fmt.Errorf("get labels in namespace: %w", err)
 		}
-		syncedHashes := make(map[string]struct{})
+		labelNames := make([]string, 0, len(res.Msg.Labels))
 		for _, label := range res.Msg.Labels {
-			syncedHash := label.LabelName.Name
-			if _, expected := commitHashes[syncedHash]; !expected {
-				return nil, fmt.Errorf("received unexpected synced hash %q, expected %v", syncedHash, commitHashes)
+			labelNames = append(labelNames, label.LabelName.Name)
+		}
+		return syncedGitCommitHashes(commitHashes, labelNames)
+	}
+}
+
+// syncedGitCommitHashes returns the commit hashes found in the label names of the git commit label
+// namespace the commits are synced under. Label names are compared as is, so the hashes of any object format, SHA-1 or SHA-256,
+// match the commit hashes they were pushed with.
+func syncedGitCommitHashes(commitHashes map[string]struct{}, labelNames []string) (map[string]struct{}, error) {
+	syncedHashes := make(map[string]struct{})
+	for _, labelName := range labelNames {
+		if _, expected := commitHashes[labelName]; !expected {
+			return nil, fmt.Errorf("received unexpected synced hash %q, expected %v", labelName, commitHashes)
+		}
+		syncedHashes[labelName] = struct{}{}
+	}
+	return syncedHashes, nil
+}
+
+func tagResolver(clientConfig *connectclient.Config) bufsync.TagResolver {
+	return func(ctx context.Context, module bufmoduleref.ModuleIdentity) (map[string]string, error) {
+		service := connectclient.Make(clientConfig, module.Remote(), registryv1alpha1connect.NewLabelServiceClient)
+		res, err := service.GetLabelsInNamespace(ctx, connect.NewRequest(&registryv1alpha1.GetLabelsInNamespaceRequest{
+			RepositoryOwner: module.Owner(),
+			RepositoryName:  module.Repository(),
+			LabelNamespace:  registryv1alpha1.LabelNamespace_LABEL_NAMESPACE_TAG,
+		}))
+		if err != nil {
+			if connect.CodeOf(err) == connect.CodeNotFound {
+				// Repo is not created
+				return nil, nil
 			}
-			syncedHashes[syncedHash] = struct{}{}
+			return nil, fmt.Errorf("get labels in namespace: %w", err)
+		}
+		// Tags point to BSR commits, resolve the git commit hash each BSR commit was synced from.
+		gitCommitHashes := make(map[string]string)
+		tags := make(map[string]string, len(res.Msg.Labels))
+		for _, label := range res.Msg.Labels {
+			commitID := label.GetLabelValue().GetCommitId()
+			gitCommitHash, ok := gitCommitHashes[commitID]
+			if !ok {
+				commitLabels, err := service.GetLabels(ctx, connect.NewRequest(&registryv1alpha1.GetLabelsRequest{
+					RepositoryOwner: module.Owner(),
+					RepositoryName:  module.Repository(),
+					LabelValue:      &registryv1alpha1.LabelValue{CommitId: commitID},
+				}))
+				if err != nil {
+					return nil, fmt.Errorf("get labels for commit %q: %w", commitID, err)
+				}
+				for _, commitLabel := range commitLabels.Msg.Labels {
+					if commitLabel.GetLabelName().GetNamespace() == registryv1alpha1.LabelNamespace_LABEL_NAMESPACE_GIT_COMMIT {
+						gitCommitHash = commitLabel.GetLabelName().GetName()
+						break
+					}
+				}
+				gitCommitHashes[commitID] = gitCommitHash
+			}
+			if gitCommitHash == "" {
+				// Not synced from a git commit, the tag needs to be moved anyway.
+				continue
+			}
+			tags[label.GetLabelName().GetName()] = gitCommitHash
 		}
-		return syncedHashes, nil
+		return tags, nil
 	}
 }
 
@@ -328,16 +1755,35 @@ func defaultBranchGetter(clientConfig *connectclient.Config) bufsync.ModuleDefau
 
 type syncErrorHandler struct {
 	logger *zap.Logger
+	// lintFail aborts sync on lint failures, instead of skipping the module commits.
+	lintFail bool
+	// buildFailures is the number of module commits skipped because they failed to build, failed
+	// lint, or had an invalid module config.
+	buildFailures int
 }
 
-func newErrorHandler(logger *zap.Logger) bufsync.ErrorHandler {
-	return &syncErrorHandler{logger: logger}
+func newErrorHandler(logger *zap.Logger, lintFail bool) *syncErrorHandler {
+	return &syncErrorHandler{logger: logger, lintFail: lintFail}
+}
+
+// buildFailuresError returns an error with an exit code if any module commit was skipped because it
+// failed to build, or nil otherwise.
+func (s *syncErrorHandler) buildFailuresError() error {
+	if s.buildFailures == 0 {
+		return nil
+	}
+	return app.NewErrorf(
+		exitCodeBuildFailure,
+		"sync completed, but %d module commit(s) failed to build and were skipped",
+		s.buildFailures,
+	)
 }
 
 func (s *syncErrorHandler) BuildFailure(module bufsync.Module, commit git.Commit, err error) error {
 	// We failed to build the module. We can warn on this and carry on.
 	// Note that because of resumption, Syncer will typically only come
 	// across this commit once, we will not log this warning again.
+	s.buildFailures++
 	s.logger.Warn(
 		"module build failure",
 		zap.Stringer("commit", commit.Hash()),
@@ -347,10 +1793,26 @@ func (s *syncErrorHandler) BuildFailure(module bufsync.Module, commit git.Commit
 	return nil
 }
 
+func (s *syncErrorHandler) LintFailure(module bufsync.Module, commit git.Commit, err error) error {
+	if s.lintFail {
+		return fmt.Errorf("commit %s for module %s failed lint: %w", commit.Hash(), module, err)
+	}
+	// Like build failures, we can warn on this and carry on.
+	s.buildFailures++
+	s.logger.Warn(
+		"module lint failure",
+		zap.Stringer("commit", commit.Hash()),
+		zap.Stringer("module", module),
+		zap.Error(err),
+	)
+	return nil
+}
+
 func (s *syncErrorHandler) InvalidModuleConfig(module bufsync.Module, commit git.Commit, err error) error {
 	// We found a module but the module config is invalid. We can warn on this
 	// and carry on. Note that because of resumption, Syncer will typically only come
 	// across this commit once, we will not log this warning again.
+	s.buildFailures++
 	s.logger.Warn(
 		"invalid module config",
 		zap.Stringer("commit", commit.Hash()),
@@ -369,11 +1831,9 @@ func (s *syncErrorHandler) InvalidSyncPoint(
 	// The most likely culprit for an invalid sync point is a rebase, where the last known
 	// commit has been garbage collected. In this case, let's present a better error message.
 	//
-	// We may want to provide a flag for sync to continue despite this, accumulating the error,
-	// and error at the end, so that other branches can continue to sync, but this branch is
-	// out of date. This is not trivial if the branch that's been rebased is a long-lived
-	// branch (like main) whose artifacts are consumed by other branches, as we may fail to
-	// sync those commits if we continue. So we now we simply error.
+	// With --continue-on-error, sync continues with the rest of the branches and errors at the
+	// end, so this branch is out of date, and the branches whose commits to sync are reachable
+	// from this branch are skipped. Otherwise, we simply error.
 	if errors.Is(err, git.ErrObjectNotFound) {
 		return fmt.Errorf(
 			"last synced commit %s was not found for module %s; did you rebase?",
@@ -385,27 +1845,70 @@ func (s *syncErrorHandler) InvalidSyncPoint(
 	return err
 }
 
+func (s *syncErrorHandler) SyncPointDiverged(
+	module bufsync.Module,
+	branch string,
+	syncPoint git.Hash,
+	headHash git.Hash,
+) error {
+	// The branch was most likely force-pushed after it was synced. Re-syncing from the merge base
+	// would push a history to the BSR that is not linear with the already synced commits, so we
+	// error and let the user decide how to proceed.
+	return fmt.Errorf(
+		"last synced commit %s for module %s is not an ancestor of branch %q HEAD commit %s; did you force push?",
+		syncPoint,
+		module,
+		branch,
+		headHash,
+	)
+}
+
+func (s *syncErrorHandler) ModuleDeleted(module bufsync.Module, commit git.Commit) error {
+	// The module was deleted in this commit. We can warn on this and carry on, the syncer stops
+	// syncing the module in the rest of the branch, as the newer commits have no module.
+	s.logger.Warn(
+		"module deleted",
+		zap.Stringer("commit", commit.Hash()),
+		zap.Stringer("module", module),
+	)
+	return nil
+}
+
+func (s *syncErrorHandler) UnsignedCommit(module bufsync.Module, commit git.Commit) error {
+	// Only signed commits should be published, we fail hard and let the user decide how to proceed.
+	return fmt.Errorf(
+		"commit %s for module %s is not signed by any of the --%s keys",
+		commit.Hash(),
+		module,
+		requireSignedFlagName,
+	)
+}
+
+func (s *syncErrorHandler) RemoteContentMismatch(
+	module bufsync.Module,
+	commit git.Commit,
+	localDigest *manifest.Digest,
+	remoteDigest *manifest.Digest,
+) error {
+	// The commit was synced with different content, re-syncing it would not fix the BSR module, so
+	// we error and let the user decide how to proceed.
+	return fmt.Errorf(
+		"commit %s for module %s was synced with content digest %s, but its content digest is %s",
+		commit.Hash(),
+		module,
+		remoteDigest,
+		localDigest,
+	)
+}
+
 func pushOrCreate(
 	ctx context.Context,
 	clientConfig *connectclient.Config,
-	repo git.Repository,
-	commit git.Commit,
-	branch string,
-	tags []string,
-	moduleIdentity bufmoduleref.ModuleIdentity,
-	moduleBucket storage.ReadBucket,
-	createWithVisibility string,
+	moduleCommit bufsync.ModuleCommit,
+	createVisibilities map[string]string,
+	defaultBranch string,
 ) (*registryv1alpha1.GitSyncPoint, error) {
-	modulePin, err := push(
-		ctx,
-		clientConfig,
-		repo,
-		commit,
-		branch,
-		tags,
-		moduleIdentity,
-		moduleBucket,
-	)
+	modulePin, err := push(ctx, clientConfig, moduleCommit)
 	if err != nil {
 		// We rely on Push* returning a NotFound error to denote the repository is not created.
 		// This technically could be a NotFound error for some other entity than the repository
@@ -413,20 +1916,13 @@ func pushOrCreate(
 		// is already created, and there is no side effect. The 99% case is that a NotFound
 		// error is because the repository does not exist, and we want to avoid having to do
 		// a GetRepository RPC call for every call to push --create.
-		if createWithVisibility != "" && connect.CodeOf(err) == connect.CodeNotFound {
-			if err := create(ctx, clientConfig, moduleIdentity, createWithVisibility); err != nil {
+		moduleIdentity := moduleCommit.Identity()
+		createWithVisibility, shouldCreate := createVisibilities[moduleIdentity.IdentityString()]
+		if shouldCreate && connect.CodeOf(err) == connect.CodeNotFound {
+			if err := create(ctx, clientConfig, moduleIdentity, createWithVisibility, defaultBranch); err != nil {
 				return nil, fmt.Errorf("create repo: %w", err)
 			}
-			return push(
-				ctx,
-				clientConfig,
-				repo,
-				commit,
-				branch,
-				tags,
-				moduleIdentity,
-				moduleBucket,
-			)
+			return push(ctx, clientConfig, moduleCommit)
 		}
 		return nil, fmt.Errorf("push: %w", err)
 	}
@@ -436,14 +1932,41 @@ func pushOrCreate(
 func push(
 	ctx context.Context,
 	clientConfig *connectclient.Config,
-	repo git.Repository,
+	moduleCommit bufsync.ModuleCommit,
+) (*registryv1alpha1.GitSyncPoint, error) {
+	service := connectclient.Make(clientConfig, moduleCommit.Identity().Remote(), registryv1alpha1connect.NewSyncServiceClient)
+	request, err := newSyncGitCommitRequest(
+		ctx,
+		moduleCommit.Commit(),
+		moduleCommit.Branch(),
+		moduleCommit.TagDetails(),
+		moduleCommit.Notes(),
+		moduleCommit.Annotations(),
+		moduleCommit.Identity(),
+		moduleCommit.Bucket(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := service.SyncGitCommit(ctx, connect.NewRequest(request))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Msg.SyncPoint, nil
+}
+
+// newSyncGitCommitRequest returns the request to push the module bucket of the git commit, with all
+// the blobs its manifest references.
+func newSyncGitCommitRequest(
+	ctx context.Context,
 	commit git.Commit,
 	branch string,
-	tags []string,
+	tags []bufsync.TagDetail,
+	notes map[string]string,
+	annotations map[string]string,
 	moduleIdentity bufmoduleref.ModuleIdentity,
 	moduleBucket storage.ReadBucket,
-) (*registryv1alpha1.GitSyncPoint, error) {
-	service := connectclient.Make(clientConfig, moduleIdentity.Remote(), registryv1alpha1connect.NewSyncServiceClient)
+) (*registryv1alpha1.SyncGitCommitRequest, error) {
 	m, blobSet, err := manifest.NewFromBucket(ctx, moduleBucket)
 	if err != nil {
 		return nil, err
@@ -452,14 +1975,37 @@ func push(
 	if err != nil {
 		return nil, err
 	}
-	resp, err := service.SyncGitCommit(ctx, connect.NewRequest(&registryv1alpha1.SyncGitCommitRequest{
-		Owner:      moduleIdentity.Owner(),
-		Repository: moduleIdentity.Repository(),
-		Manifest:   bucketManifest,
-		Blobs:      blobs,
-		Hash:       commit.Hash().Hex(),
-		Branch:     branch,
-		Tags:       tags,
+	var (
+		tagNames      = make([]string, 0, len(tags))
+		annotatedTags []*registryv1alpha1.GitAnnotatedTag
+	)
+	for _, tag := range tags {
+		tagNames = append(tagNames, tag.Name)
+		// Lightweight tags have no tagger nor message.
+		if tag.Tagger == nil {
+			continue
+		}
+		annotatedTags = append(annotatedTags, &registryv1alpha1.GitAnnotatedTag{
+			Name: tag.Name,
+			Tagger: &registryv1alpha1.GitIdentity{
+				Name:  tag.Tagger.Name(),
+				Email: tag.Tagger.Email(),
+				Time:  timestamppb.New(tag.Tagger.Timestamp()),
+			},
+			Message: tag.Message,
+		})
+	}
+	return &registryv1alpha1.SyncGitCommitRequest{
+		Owner:         moduleIdentity.Owner(),
+		Repository:    moduleIdentity.Repository(),
+		Manifest:      bucketManifest,
+		Blobs:         blobs,
+		Hash:          commit.Hash().Hex(),
+		Branch:        branch,
+		Tags:          tagNames,
+		AnnotatedTags: annotatedTags,
+		Notes:         notes,
+		Annotations:   annotations,
 		Author: &registryv1alpha1.GitIdentity{
 			Name:  commit.Author().Name(),
 			Email: commit.Author().Email(),
@@ -470,18 +2016,17 @@ func push(
 			Email: commit.Committer().Email(),
 			Time:  timestamppb.New(commit.Committer().Timestamp()),
 		},
-	}))
-	if err != nil {
-		return nil, err
-	}
-	return resp.Msg.SyncPoint, nil
+	}, nil
 }
 
+// create creates the repository of the module, and sets its default branch to the git default branch,
+// so it passes the default branch validation of the next syncs.
 func create(
 	ctx context.Context,
 	clientConfig *connectclient.Config,
 	moduleIdentity bufmoduleref.ModuleIdentity,
 	visibility string,
+	defaultBranch string,
 ) error {
 	service := connectclient.Make(clientConfig, moduleIdentity.Remote(), registryv1alpha1connect.NewRepositoryServiceClient)
 	visiblity, err := bufcli.VisibilityFlagToVisibility(visibility)
@@ -489,15 +2034,31 @@ func create(
 		return err
 	}
 	fullName := moduleIdentity.Owner() + "/" + moduleIdentity.Repository()
-	_, err = service.CreateRepositoryByFullName(
+	res, err := service.CreateRepositoryByFullName(
 		ctx,
 		connect.NewRequest(&registryv1alpha1.CreateRepositoryByFullNameRequest{
 			FullName:   fullName,
 			Visibility: visiblity,
 		}),
 	)
-	if err != nil && connect.CodeOf(err) == connect.CodeAlreadyExists {
-		return connect.NewError(connect.CodeInternal, fmt.Errorf("expected repository %s to be missing but found the repository to already exist", fullName))
+	if err != nil {
+		if connect.CodeOf(err) == connect.CodeAlreadyExists {
+			return connect.NewError(connect.CodeInternal, fmt.Errorf("expected repository %s to be missing but found the repository to already exist", fullName))
+		}
+		return err
 	}
-	return err
+	if res.Msg.Repository.GetDefaultBranch() == defaultBranch {
+		return nil
+	}
+	if _, err := service.UpdateRepositorySettingsByName(
+		ctx,
+		connect.NewRequest(&registryv1alpha1.UpdateRepositorySettingsByNameRequest{
+			OwnerName:      moduleIdentity.Owner(),
+			RepositoryName: moduleIdentity.Repository(),
+			DefaultBranch:  &defaultBranch,
+		}),
+	); err != nil {
+		return fmt.Errorf("set default branch of repository %s to %q: %w", fullName, defaultBranch, err)
+	}
+	return nil
 }
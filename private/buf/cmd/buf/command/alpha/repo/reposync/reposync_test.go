@@ -0,0 +1,1599 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reposync
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/bufbuild/buf/private/buf/bufsync"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule/bufmoduleref"
+	"github.com/bufbuild/buf/private/gen/proto/connect/buf/alpha/registry/v1alpha1/registryv1alpha1connect"
+	registryv1alpha1 "github.com/bufbuild/buf/private/gen/proto/go/buf/alpha/registry/v1alpha1"
+	"github.com/bufbuild/buf/private/pkg/app/appcmd"
+	"github.com/bufbuild/buf/private/pkg/app/appcmd/appcmdtesting"
+	"github.com/bufbuild/buf/private/pkg/app/appflag"
+	"github.com/bufbuild/buf/private/pkg/command"
+	"github.com/bufbuild/buf/private/pkg/connectclient"
+	"github.com/bufbuild/buf/private/pkg/git"
+	"github.com/bufbuild/buf/private/pkg/manifest"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNewSyncGitCommitRequest(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	gitDir, commits := newTestBareGitRepository(t, map[string]string{"a.proto": "syntax = \"proto3\";\n\npackage a;\n"})
+	repo, err := git.OpenRepository(ctx, gitDir, command.NewRunner())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, repo.Close()) })
+	commit, err := repo.Objects().Commit(commits[0])
+	require.NoError(t, err)
+	moduleIdentity, err := bufmoduleref.ModuleIdentityForString("buf.build/acme/weather")
+	require.NoError(t, err)
+	bucket, err := storagemem.NewReadBucket(map[string][]byte{"a.proto": []byte("syntax = \"proto3\";\n")})
+	require.NoError(t, err)
+
+	request, err := newSyncGitCommitRequest(
+		ctx,
+		commit,
+		"main",
+		[]bufsync.TagDetail{
+			{Name: "v1"},
+			{Name: "v2", Tagger: commit.Author(), Message: "release v2"},
+		},
+		map[string]string{"refs/notes/commits": "note\n"},
+		map[string]string{"build": "42", "source": "https://ci.example.com/42"},
+		moduleIdentity,
+		bucket,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "acme", request.Owner)
+	assert.Equal(t, "weather", request.Repository)
+	assert.Equal(t, commits[0].Hex(), request.Hash)
+	assert.Equal(t, "main", request.Branch)
+	assert.Equal(t, []string{"v1", "v2"}, request.Tags)
+	// only annotated tags have tag metadata
+	require.Len(t, request.AnnotatedTags, 1)
+	assert.Equal(t, "v2", request.AnnotatedTags[0].Name)
+	assert.Equal(t, "Buf TestBot", request.AnnotatedTags[0].Tagger.Name)
+	assert.Equal(t, "release v2", request.AnnotatedTags[0].Message)
+	assert.Equal(t, map[string]string{"refs/notes/commits": "note\n"}, request.Notes)
+	assert.Equal(t, map[string]string{"build": "42", "source": "https://ci.example.com/42"}, request.Annotations)
+	assert.Equal(t, "Buf TestBot", request.Author.Name)
+	assert.Len(t, request.Blobs, 1)
+
+	request, err = newSyncGitCommitRequest(
+		ctx,
+		commit,
+		"main",
+		nil,
+		nil,
+		nil,
+		moduleIdentity,
+		bucket,
+	)
+	require.NoError(t, err)
+	assert.Nil(t, request.Annotations)
+	assert.Nil(t, request.AnnotatedTags)
+
+	// every digest referenced by the manifest resolves to a blob of the request
+	bucket, err = storagemem.NewReadBucket(map[string][]byte{
+		"a.proto": []byte("syntax = \"proto3\";\n"),
+		"b.proto": []byte("syntax = \"proto3\";\n\npackage b;\n"),
+	})
+	require.NoError(t, err)
+	request, err = newSyncGitCommitRequest(
+		ctx,
+		commit,
+		"main",
+		nil,
+		nil,
+		nil,
+		moduleIdentity,
+		bucket,
+	)
+	require.NoError(t, err)
+	requestManifest, err := manifest.NewFromReader(bytes.NewReader(request.Manifest.Content))
+	require.NoError(t, err)
+	requestBlobDigests := make(map[string]struct{}, len(request.Blobs))
+	for _, blob := range request.Blobs {
+		requestBlobDigests[hex.EncodeToString(blob.Digest.Digest)] = struct{}{}
+	}
+	require.Len(t, requestManifest.Digests(), 2)
+	for _, digest := range requestManifest.Digests() {
+		assert.Contains(t, requestBlobDigests, digest.Hex())
+	}
+}
+
+func TestModuleCreateVisibilities(t *testing.T) {
+	t.Parallel()
+	publicModule := newTestModule(t, "proto/public", "buf.test/owner/public")
+	privateModule := newTestModule(t, "proto/private", "buf.test/owner/private")
+	defaultModule := newTestModule(t, "proto/default", "buf.test/owner/default")
+	modules := []bufsync.Module{publicModule, privateModule, defaultModule}
+
+	moduleVisibilities, err := parseModuleVisibilities([]string{
+		"proto/public:public",
+		"./proto/private:private",
+	})
+	require.NoError(t, err)
+	createVisibilities, err := moduleCreateVisibilities(modules, "private", moduleVisibilities)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]string{
+			"buf.test/owner/public":  "public",
+			"buf.test/owner/private": "private",
+			"buf.test/owner/default": "private",
+		},
+		createVisibilities,
+	)
+	createVisibilities, err = moduleCreateVisibilities(modules, "", nil)
+	require.NoError(t, err)
+	assert.Nil(t, createVisibilities, "not creating")
+
+	_, err = parseModuleVisibilities([]string{"proto/public:protected"})
+	assert.Error(t, err, "invalid visibility")
+	_, err = parseModuleVisibilities([]string{"proto/public"})
+	assert.Error(t, err, "missing visibility")
+	_, err = parseModuleVisibilities([]string{"proto/public:public", "proto/public:private"})
+	assert.Error(t, err, "duplicate module path")
+	moduleVisibilities, err = parseModuleVisibilities([]string{"proto/unknown:public"})
+	require.NoError(t, err)
+	_, err = moduleCreateVisibilities(modules, "private", moduleVisibilities)
+	assert.Error(t, err, "unknown module path")
+}
+
+func TestModuleIdentityForBranch(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		name             string
+		identityTemplate string
+		branch           string
+		expectedIdentity string
+	}
+	testCases := []testCase{
+		{
+			name:             "no_placeholder",
+			identityTemplate: "buf.build/acme/foo",
+			branch:           "staging",
+			expectedIdentity: "buf.build/acme/foo",
+		},
+		{
+			name:             "placeholder",
+			identityTemplate: "buf.build/acme/foo-{branch}",
+			branch:           "staging",
+			expectedIdentity: "buf.build/acme/foo-staging",
+		},
+		{
+			name:             "placeholder_with_slashed_branch",
+			identityTemplate: "buf.build/acme/foo-{branch}",
+			branch:           "release/v1",
+			expectedIdentity: "buf.build/acme/foo-release-v1",
+		},
+	}
+	for _, tc := range testCases {
+		func(tc testCase) {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+				identity, err := moduleIdentityForBranch(tc.identityTemplate, tc.branch)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedIdentity, identity.IdentityString())
+			})
+		}(tc)
+	}
+	t.Run("invalid_identity", func(t *testing.T) {
+		t.Parallel()
+		_, err := moduleIdentityForBranch("buf.build/{branch}", "staging")
+		assert.Error(t, err)
+	})
+}
+
+func TestParseModule(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		name                     string
+		moduleFlag               string
+		pathRequired             bool
+		expectedModulePath       string
+		expectedIdentityTemplate string
+	}
+	testCases := []testCase{
+		{
+			name:                     "path_and_identity",
+			moduleFlag:               "./proto/:buf.build/acme/foo",
+			pathRequired:             true,
+			expectedModulePath:       "proto",
+			expectedIdentityTemplate: "buf.build/acme/foo",
+		},
+		{
+			name:                     "root_path_and_identity",
+			moduleFlag:               ".:buf.build/acme/foo",
+			pathRequired:             true,
+			expectedModulePath:       ".",
+			expectedIdentityTemplate: "buf.build/acme/foo",
+		},
+		{
+			name:                     "identity_only",
+			moduleFlag:               "buf.build/acme/foo",
+			expectedModulePath:       ".",
+			expectedIdentityTemplate: "buf.build/acme/foo",
+		},
+	}
+	for _, tc := range testCases {
+		func(tc testCase) {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+				modulePath, identityTemplate, err := parseModule(tc.moduleFlag, tc.pathRequired)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedModulePath, modulePath)
+				assert.Equal(t, tc.expectedIdentityTemplate, identityTemplate)
+			})
+		}(tc)
+	}
+	t.Run("identity_only_with_path_required", func(t *testing.T) {
+		t.Parallel()
+		_, _, err := parseModule("buf.build/acme/foo", true)
+		assert.Error(t, err)
+	})
+}
+
+func TestExpandModuleTemplate(t *testing.T) {
+	t.Parallel()
+	moduleFlags, err := expandModuleTemplate(
+		"buf.build/acme/{dir}",
+		[]string{"foo", "./proto/bar/", "proto/baz/v1"},
+	)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"foo:buf.build/acme/foo",
+			"proto/bar:buf.build/acme/proto-bar",
+			"proto/baz/v1:buf.build/acme/proto-baz-v1",
+		},
+		moduleFlags,
+	)
+	moduleFlags, err = expandModuleTemplate("buf.build/acme/{dir}-{branch}", []string{"proto/foo"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"proto/foo:buf.build/acme/proto-foo-{branch}"}, moduleFlags)
+	for _, moduleDirs := range [][]string{
+		{"."},
+		{"foo", "./foo"},
+	} {
+		_, err := expandModuleTemplate("buf.build/acme/{dir}", moduleDirs)
+		assert.Error(t, err, moduleDirs)
+	}
+	_, err = expandModuleTemplate("buf.build/acme/foo", []string{"foo"})
+	assert.Error(t, err)
+	_, err = expandModuleTemplate("buf.build/{dir}", []string{"foo"})
+	assert.Error(t, err)
+}
+
+func TestReadModules(t *testing.T) {
+	t.Parallel()
+	modules, err := readModules(strings.NewReader("proto/a:buf.build/acme/a\n\n  proto/b:buf.build/acme/b-{branch}  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"proto/a:buf.build/acme/a", "proto/b:buf.build/acme/b-{branch}"}, modules)
+	modules, err = readModules(strings.NewReader(""))
+	require.NoError(t, err)
+	assert.Empty(t, modules)
+	_, err = readModules(strings.NewReader("proto/a:buf.build/acme/a\nbuf.build/acme/b\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestParseLocalResumePoints(t *testing.T) {
+	t.Parallel()
+	localResumePoints, err := parseLocalResumePoints([]string{
+		"main:" + strings.Repeat("a", 40),
+		"feature/x:" + strings.Repeat("b", 40),
+	})
+	require.NoError(t, err)
+	require.Len(t, localResumePoints, 2)
+	assert.Equal(t, strings.Repeat("a", 40), localResumePoints["main"].Hex())
+	assert.Equal(t, strings.Repeat("b", 40), localResumePoints["feature/x"].Hex())
+	for _, localResumePointFlag := range []string{
+		strings.Repeat("a", 40),
+		"main:not-a-hash",
+	} {
+		_, err := parseLocalResumePoints([]string{localResumePointFlag})
+		assert.Error(t, err, localResumePointFlag)
+	}
+	_, err = parseLocalResumePoints([]string{"main:" + strings.Repeat("a", 40), "main:" + strings.Repeat("b", 40)})
+	assert.Error(t, err)
+}
+
+func TestParseBranchIdentitySuffixes(t *testing.T) {
+	t.Parallel()
+	branchIdentitySuffixes, err := parseBranchIdentitySuffixes([]string{"main=-prod", "release/v1=-v1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"main": "-prod", "release/v1": "-v1"}, branchIdentitySuffixes)
+
+	_, err = parseBranchIdentitySuffixes([]string{"main"})
+	assert.Error(t, err, "missing suffix")
+	_, err = parseBranchIdentitySuffixes([]string{"main="})
+	assert.Error(t, err, "empty suffix")
+	_, err = parseBranchIdentitySuffixes([]string{"=-prod"})
+	assert.Error(t, err, "empty branch")
+	_, err = parseBranchIdentitySuffixes([]string{"main=-prod", "main=-main"})
+	assert.Error(t, err, "duplicate branch")
+}
+
+func TestParseAnnotations(t *testing.T) {
+	t.Parallel()
+	annotations, err := parseAnnotations([]string{"build=42", "source=https://ci.example.com/job?id=42", "empty="})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]string{"build": "42", "source": "https://ci.example.com/job?id=42", "empty": ""},
+		annotations,
+	)
+
+	_, err = parseAnnotations([]string{"build"})
+	assert.Error(t, err, "missing value")
+	_, err = parseAnnotations([]string{"=42"})
+	assert.Error(t, err, "empty key")
+	_, err = parseAnnotations([]string{"build=42", "build=43"})
+	assert.Error(t, err, "duplicate key")
+}
+
+func TestParseClientHeaders(t *testing.T) {
+	t.Parallel()
+	clientHeaders, err := parseClientHeaders([]string{
+		"User-Agent=reposync/1.0",
+		"x-route=a=b",
+		"X-Route=c",
+		"X-Empty=",
+	})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		http.Header{
+			"User-Agent": {"reposync/1.0"},
+			"X-Route":    {"a=b", "c"},
+			"X-Empty":    {""},
+		},
+		clientHeaders,
+	)
+	for _, clientHeaderFlag := range []string{
+		"User-Agent",
+		"=value",
+		"Invalid Key=value",
+		"X-Key=invalid\nvalue",
+	} {
+		_, err := parseClientHeaders([]string{clientHeaderFlag})
+		assert.Error(t, err, clientHeaderFlag)
+	}
+}
+
+func TestSyncedGitCommitHashes(t *testing.T) {
+	t.Parallel()
+	sha1Hash := strings.Repeat("a", 40)
+	sha256Hash := strings.Repeat("b", 64)
+	commitHashes := map[string]struct{}{
+		sha1Hash:                {},
+		sha256Hash:              {},
+		strings.Repeat("c", 64): {},
+	}
+	syncedHashes, err := syncedGitCommitHashes(commitHashes, []string{sha1Hash, sha256Hash})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{sha1Hash: {}, sha256Hash: {}}, syncedHashes)
+	// a SHA-256 hash truncated to the SHA-1 length is not the same commit
+	_, err = syncedGitCommitHashes(commitHashes, []string{sha256Hash[:40]})
+	assert.Error(t, err)
+}
+
+func TestSyncSummaryPrintInterrupted(t *testing.T) {
+	t.Parallel()
+	summary := newSyncSummary()
+	summary.record("buf.test/owner/b")
+	summary.record("buf.test/owner/a")
+	summary.record("buf.test/owner/b")
+	var buffer bytes.Buffer
+	require.NoError(t, summary.printInterrupted(&buffer))
+	assert.Equal(
+		t,
+		`Sync interrupted after syncing 3 module commit(s).
+  buf.test/owner/a: 1
+  buf.test/owner/b: 2
+Run the command again to resume from the last synced commit.
+`,
+		buffer.String(),
+	)
+}
+
+func TestPrintPlanDOT(t *testing.T) {
+	t.Parallel()
+	newHash := func(hashDigit string) git.Hash {
+		hash, err := git.NewHashFromHex(strings.Repeat(hashDigit, 40))
+		require.NoError(t, err)
+		return hash
+	}
+	// | 1(sync point)-2(v1)-3(filtered)-4 (main)
+	// |                 └5 (feature)  /
+	// |                   6(synced)--┘
+	module := newTestModule(t, "proto", "buf.test/owner/repo")
+	commit1 := &testCommit{hash: newHash("2"), parents: []git.Hash{newHash("1")}}
+	commit2 := &testCommit{hash: newHash("3"), parents: []git.Hash{newHash("2")}}
+	commit3 := &testCommit{hash: newHash("4"), parents: []git.Hash{newHash("3"), newHash("6")}}
+	featureCommit := &testCommit{hash: newHash("5"), parents: []git.Hash{newHash("2")}}
+	plan := bufsync.SyncPlan{
+		Branches: []bufsync.BranchSyncPlan{
+			{
+				Branch:     "main",
+				SyncPoints: map[bufsync.Module]git.Hash{module: newHash("1")},
+				Commits: []bufsync.CommitSyncPlan{
+					{Commit: commit1, Modules: []bufsync.Module{module}, Tags: []string{"v1"}},
+					{Commit: commit3, Modules: []bufsync.Module{module}},
+				},
+				FilteredCommits: []git.Commit{commit2},
+			},
+			{
+				Branch: "feature",
+				Commits: []bufsync.CommitSyncPlan{
+					{Commit: featureCommit, Modules: []bufsync.Module{module}},
+				},
+			},
+		},
+	}
+	var stdout bytes.Buffer
+	require.NoError(t, printPlanDOT(&stdout, plan))
+	hex := func(hashDigit string) string {
+		return strings.Repeat(hashDigit, 40)
+	}
+	expected := `digraph sync_plan {
+  rankdir="RL";
+  node [shape="box", style="filled", fontname="monospace"];
+  "` + hex("2") + `" [label="2222222\nmain\ntag: v1", fillcolor="palegreen"];
+  "` + hex("4") + `" [label="4444444\nmain", fillcolor="palegreen"];
+  "` + hex("3") + `" [label="3333333\nmain", fillcolor="khaki", style="filled,dashed"];
+  "` + hex("1") + `" [label="1111111\nmain", fillcolor="lightgray"];
+  "` + hex("5") + `" [label="5555555\nfeature", fillcolor="palegreen"];
+  "` + hex("6") + `" [label="6666666", fillcolor="lightgray"];
+  "` + hex("2") + `" -> "` + hex("1") + `";
+  "` + hex("4") + `" -> "` + hex("3") + `";
+  "` + hex("4") + `" -> "` + hex("6") + `";
+  "` + hex("3") + `" -> "` + hex("2") + `";
+  "` + hex("5") + `" -> "` + hex("2") + `";
+}
+`
+	assert.Equal(t, expected, stdout.String())
+	assert.Equal(t, `"a\"b\\c\nd"`, quoteDOT("a\"b\\c\nd"))
+}
+
+func TestReadSigningKeys(t *testing.T) {
+	t.Parallel()
+	entity, err := openpgp.NewEntity("Buf TestBot", "", "testbot@buf.build", nil)
+	require.NoError(t, err)
+	var armoredKeyring bytes.Buffer
+	armorWriter, err := armor.Encode(&armoredKeyring, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(armorWriter))
+	require.NoError(t, armorWriter.Close())
+	keyringPath := filepath.Join(t.TempDir(), "keyring.asc")
+	require.NoError(t, os.WriteFile(keyringPath, armoredKeyring.Bytes(), 0600))
+
+	keyring, sshSigningKeys, err := readSigningKeys(keyringPath)
+	require.NoError(t, err)
+	assert.Len(t, keyring.KeysById(entity.PrimaryKey.KeyId), 1)
+	assert.Empty(t, sshSigningKeys)
+
+	sshPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshSigningKey, err := ssh.NewPublicKey(sshPublicKey)
+	require.NoError(t, err)
+	sshSigningKeysPath := filepath.Join(t.TempDir(), "allowed_signers")
+	require.NoError(t, os.WriteFile(
+		sshSigningKeysPath,
+		[]byte("# signing keys\n\n"+string(ssh.MarshalAuthorizedKey(sshSigningKey))),
+		0600,
+	))
+	keyring, sshSigningKeys, err = readSigningKeys(sshSigningKeysPath)
+	require.NoError(t, err)
+	assert.Nil(t, keyring)
+	require.Len(t, sshSigningKeys, 1)
+	assert.Equal(t, sshSigningKey.Marshal(), sshSigningKeys[0].Marshal())
+
+	invalidKeyringPath := filepath.Join(t.TempDir(), "invalid.asc")
+	require.NoError(t, os.WriteFile(invalidKeyringPath, []byte("not a keyring"), 0600))
+	_, _, err = readSigningKeys(invalidKeyringPath)
+	assert.Error(t, err)
+	emptyPath := filepath.Join(t.TempDir(), "empty")
+	require.NoError(t, os.WriteFile(emptyPath, nil, 0600))
+	_, _, err = readSigningKeys(emptyPath)
+	assert.Error(t, err)
+	_, _, err = readSigningKeys(filepath.Join(t.TempDir(), "missing.asc"))
+	assert.Error(t, err)
+}
+
+func TestOutputDir(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	bucket := storagemem.NewReadWriteBucket()
+	destination, err := newOutputDir(ctx, bucket)
+	require.NoError(t, err)
+	module, err := bufmoduleref.ModuleIdentityForString("buf.build/acme/weather")
+	require.NoError(t, err)
+	otherModule, err := bufmoduleref.ModuleIdentityForString("buf.build/acme/other")
+	require.NoError(t, err)
+	syncPoint, err := destination.syncPointResolver()(ctx, module, "main")
+	require.NoError(t, err)
+	assert.Nil(t, syncPoint)
+
+	commit1 := newTestModuleCommit(t, module, "main", "1", map[string]string{"buf.yaml": "version: v1", "a.proto": "a"})
+	commit1.tags = []string{"v1", "latest"}
+	dir, err := destination.writeModuleCommit(ctx, commit1)
+	require.NoError(t, err)
+	assert.Equal(t, "buf.build/acme/weather/main/"+commit1.Commit().Hash().Hex(), dir)
+	data, err := storage.ReadPath(ctx, bucket, dir+"/a.proto")
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(data))
+	commit2 := newTestModuleCommit(t, module, "main", "2", map[string]string{"buf.yaml": "version: v1"})
+	commit2.tags = []string{"latest"}
+	_, err = destination.writeModuleCommit(ctx, commit2)
+	require.NoError(t, err)
+	featureCommit := newTestModuleCommit(t, module, "feature/x", "3", map[string]string{"buf.yaml": "version: v1"})
+	_, err = destination.writeModuleCommit(ctx, featureCommit)
+	require.NoError(t, err)
+	paths, err := storage.AllPaths(ctx, bucket, "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"buf.build/acme/weather/main/" + commit1.Commit().Hash().Hex() + "/a.proto",
+		"buf.build/acme/weather/main/" + commit1.Commit().Hash().Hex() + "/buf.yaml",
+		"buf.build/acme/weather/main/" + commit2.Commit().Hash().Hex() + "/buf.yaml",
+		"buf.build/acme/weather/feature/x/" + featureCommit.Commit().Hash().Hex() + "/buf.yaml",
+	}, paths)
+
+	// a new sync resumes from the flushed manifest
+	require.NoError(t, destination.flush(ctx))
+	destination, err = newOutputDir(ctx, bucket)
+	require.NoError(t, err)
+	for branch, expectedSyncPoint := range map[string]bufsync.ModuleCommit{"main": commit2, "feature/x": featureCommit} {
+		syncPoint, err := destination.syncPointResolver()(ctx, module, branch)
+		require.NoError(t, err)
+		assert.Equal(t, expectedSyncPoint.Commit().Hash(), syncPoint, branch)
+	}
+	tags, err := destination.tagResolver()(ctx, module)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"v1":     commit1.Commit().Hash().Hex(),
+		"latest": commit2.Commit().Hash().Hex(),
+	}, tags)
+	syncPoint, err = destination.syncPointResolver()(ctx, otherModule, "main")
+	require.NoError(t, err)
+	assert.Nil(t, syncPoint)
+	unsyncedCommit := newTestModuleCommit(t, module, "main", "4", nil)
+	syncedHashes, err := destination.syncGitCommitChecker()(ctx, module, map[string]struct{}{
+		commit1.Commit().Hash().Hex():        {},
+		featureCommit.Commit().Hash().Hex():  {},
+		unsyncedCommit.Commit().Hash().Hex(): {},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{
+		commit1.Commit().Hash().Hex():       {},
+		featureCommit.Commit().Hash().Hex(): {},
+	}, syncedHashes)
+	syncedHashes, err = destination.syncGitCommitChecker()(ctx, otherModule, map[string]struct{}{
+		commit1.Commit().Hash().Hex(): {},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, syncedHashes)
+
+	require.NoError(t, storage.PutPath(ctx, bucket, outputDirManifestPath, []byte("not json")))
+	_, err = newOutputDir(ctx, bucket)
+	assert.Error(t, err)
+}
+
+func TestOutputDirManifestFlush(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	bucket := storagemem.NewReadWriteBucket()
+	destination, err := newOutputDir(ctx, bucket)
+	require.NoError(t, err)
+	module, err := bufmoduleref.ModuleIdentityForString("buf.build/acme/weather")
+	require.NoError(t, err)
+	emptyBucket, err := storagemem.NewReadBucket(nil)
+	require.NoError(t, err)
+	var lastHash git.Hash
+	for i := 1; i <= outputDirManifestFlushInterval+1; i++ {
+		lastHash, err = git.NewHashFromHex(fmt.Sprintf("%040x", i))
+		require.NoError(t, err)
+		_, err = destination.writeModuleCommit(ctx, &testModuleCommit{
+			identity: module,
+			branch:   "main",
+			commit:   &testCommit{hash: lastHash},
+			bucket:   emptyBucket,
+		})
+		require.NoError(t, err)
+		// the unflushed module commits are resolved from the output dir
+		syncPoint, err := destination.syncPointResolver()(ctx, module, "main")
+		require.NoError(t, err)
+		assert.Equal(t, lastHash, syncPoint)
+		exists, err := storage.Exists(ctx, bucket, outputDirManifestPath)
+		require.NoError(t, err)
+		assert.Equal(t, i >= outputDirManifestFlushInterval, exists, i)
+	}
+
+	// a sync crashing before the flush resumes after the last written manifest
+	resumed, err := newOutputDir(ctx, bucket)
+	require.NoError(t, err)
+	syncPoint, err := resumed.syncPointResolver()(ctx, module, "main")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%040x", outputDirManifestFlushInterval), syncPoint.Hex())
+
+	require.NoError(t, destination.flush(ctx))
+	resumed, err = newOutputDir(ctx, bucket)
+	require.NoError(t, err)
+	syncPoint, err = resumed.syncPointResolver()(ctx, module, "main")
+	require.NoError(t, err)
+	assert.Equal(t, lastHash, syncPoint)
+	assert.Len(t, resumed.manifest.Commits, outputDirManifestFlushInterval+1)
+}
+
+func TestExitCodes(t *testing.T) {
+	t.Parallel()
+	validModuleFiles := map[string]string{
+		"proto/buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+		"proto/a.proto":  "syntax = \"proto3\";\n\npackage a;\n",
+	}
+	gitDir, commits := newTestBareGitRepository(t, validModuleFiles)
+	invalidConfigGitDir, _ := newTestBareGitRepository(
+		t,
+		validModuleFiles,
+		map[string]string{"proto/buf.yaml": "version: v42\n"},
+	)
+	twoCommitsGitDir, twoCommits := newTestBareGitRepository(
+		t,
+		validModuleFiles,
+		map[string]string{"proto/b.proto": "syntax = \"proto3\";\n\npackage b;\n"},
+	)
+	testCases := []struct {
+		name             string
+		gitDir           string
+		outputDirFiles   map[string]string
+		args             []string
+		expectedExitCode int
+		// expectedManifest is set if the manifest is expected to record the synced commit.
+		expectedManifest bool
+	}{
+		{
+			name:             "success",
+			gitDir:           gitDir,
+			expectedExitCode: 0,
+			expectedManifest: true,
+		},
+		{
+			name:             "build_failure",
+			gitDir:           invalidConfigGitDir,
+			expectedExitCode: exitCodeBuildFailure,
+		},
+		{
+			name:   "push_failure",
+			gitDir: gitDir,
+			// the module commits cannot be written under a file
+			outputDirFiles:   map[string]string{"buf.test": "not a dir"},
+			expectedExitCode: exitCodePushFailure,
+		},
+		{
+			name:   "sync_point_failure",
+			gitDir: gitDir,
+			outputDirFiles: map[string]string{
+				outputDirManifestPath: fmt.Sprintf(
+					`{"commits":[{"module":"buf.test/owner/repo","branch":"main","commit":%q}]}`,
+					"0123456789abcdef0123456789abcdef01234567",
+				),
+			},
+			expectedExitCode: exitCodeSyncPointFailure,
+		},
+		{
+			// the HEAD commit is synced, but the sync point is its parent, like after a rebase
+			name:   "default_branch_sync_point_mismatch",
+			gitDir: twoCommitsGitDir,
+			outputDirFiles: map[string]string{
+				outputDirManifestPath: fmt.Sprintf(
+					`{"commits":[{"module":"buf.test/owner/repo","branch":"main","commit":%q},{"module":"buf.test/owner/repo","branch":"main","commit":%q}]}`,
+					twoCommits[1].Hex(),
+					twoCommits[0].Hex(),
+				),
+			},
+			expectedExitCode: exitCodeSyncPointFailure,
+		},
+		{
+			// the module fails the default lint checks
+			name:             "lint_failure",
+			gitDir:           gitDir,
+			args:             []string{"--" + lintFlagName},
+			expectedExitCode: exitCodeBuildFailure,
+		},
+		{
+			name:             "lint_fail",
+			gitDir:           gitDir,
+			args:             []string{"--" + lintFlagName, "--" + lintFailFlagName},
+			expectedExitCode: exitCodePolicyFailure,
+		},
+		{
+			name:             "lint_fail_without_lint",
+			gitDir:           gitDir,
+			args:             []string{"--" + lintFailFlagName},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name:             "invalid_flags",
+			gitDir:           gitDir,
+			args:             []string{"--" + headOnlyFlagName, "--" + allBranchesFlagName},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name:             "plan_format_without_print_commits",
+			gitDir:           gitDir,
+			args:             []string{"--" + planFormatFlagName, planFormatDOT},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name:             "module_dir_without_module_template",
+			gitDir:           gitDir,
+			args:             []string{"--" + moduleDirFlagName, "proto"},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name:             "status_format_without_status",
+			gitDir:           gitDir,
+			args:             []string{"--" + statusFormatFlagName, statusFormatJSON},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name:             "negative_max_depth",
+			gitDir:           gitDir,
+			args:             []string{"--" + maxDepthFlagName, "-1"},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name:             "invalid_config",
+			gitDir:           gitDir,
+			args:             []string{"--" + workspaceFlagName, "proto"},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			// the module not found is skipped with a warning
+			name:             "module_not_found",
+			gitDir:           gitDir,
+			args:             []string{"--" + moduleFlagName, "protoo:buf.test/owner/typo"},
+			expectedExitCode: 0,
+		},
+		{
+			name:   "module_not_found_strict_modules",
+			gitDir: gitDir,
+			args: []string{
+				"--" + moduleFlagName, "protoo:buf.test/owner/typo",
+				"--" + strictModulesFlagName,
+			},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+	}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			outputDir := t.TempDir()
+			for path, content := range testCase.outputDirFiles {
+				require.NoError(t, os.WriteFile(filepath.Join(outputDir, path), []byte(content), 0600))
+			}
+			cacheDir := t.TempDir()
+			stderr := bytes.NewBuffer(nil)
+			appcmdtesting.RunCommandExitCode(
+				t,
+				func(use string) *appcmd.Command { return NewCommand(use, appflag.NewBuilder(use)) },
+				testCase.expectedExitCode,
+				func(string) map[string]string { return map[string]string{"BUF_CACHE_DIR": cacheDir} },
+				nil,
+				nil,
+				stderr,
+				append(
+					[]string{
+						"--" + gitDirFlagName, testCase.gitDir,
+						"--" + outputDirFlagName, outputDir,
+						"--" + moduleFlagName, "proto:buf.test/owner/repo",
+					},
+					testCase.args...,
+				)...,
+			)
+			if testCase.expectedExitCode == 0 {
+				assert.Contains(t, stderr.String(), "main:"+commits[0].Hex())
+			}
+			if testCase.expectedManifest {
+				// the manifest is written at the end of the sync
+				data, err := os.ReadFile(filepath.Join(outputDir, outputDirManifestPath))
+				require.NoError(t, err)
+				assert.Contains(t, string(data), commits[0].Hex())
+			}
+		})
+	}
+}
+
+func TestPrintCommitsDOT(t *testing.T) {
+	t.Parallel()
+	gitDir, commits := newTestBareGitRepository(
+		t,
+		map[string]string{
+			"proto/buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+			"proto/a.proto":  "syntax = \"proto3\";\n\npackage a;\n",
+		},
+		map[string]string{"README.md": "# repo\n"},
+	)
+	stdout := bytes.NewBuffer(nil)
+	appcmdtesting.RunCommandExitCode(
+		t,
+		func(use string) *appcmd.Command { return NewCommand(use, appflag.NewBuilder(use)) },
+		0,
+		nil,
+		nil,
+		stdout,
+		nil,
+		"--"+gitDirFlagName, gitDir,
+		"--"+outputDirFlagName, t.TempDir(),
+		"--"+moduleFlagName, "proto:buf.test/owner/repo",
+		"--"+onlyModuleChangesFlagName,
+		"--"+printCommitsFlagName,
+		"--"+planFormatFlagName, planFormatDOT,
+	)
+	assert.True(t, strings.HasPrefix(stdout.String(), "digraph sync_plan {\n"), stdout.String())
+	assert.True(t, strings.HasSuffix(stdout.String(), "}\n"), stdout.String())
+	assert.Contains(t, stdout.String(), fmt.Sprintf("%q [label=\"%s\\nmain\", fillcolor=\"palegreen\"];", commits[0].Hex(), commits[0].Hex()[:7]))
+	assert.Contains(t, stdout.String(), fmt.Sprintf("%q [label=\"%s\\nmain\", fillcolor=\"khaki\"", commits[1].Hex(), commits[1].Hex()[:7]))
+	assert.Contains(t, stdout.String(), fmt.Sprintf("%q -> %q;", commits[1].Hex(), commits[0].Hex()))
+}
+
+func TestModuleBranchStatuses(t *testing.T) {
+	t.Parallel()
+	newHash := func(hashDigit string) git.Hash {
+		hash, err := git.NewHashFromHex(strings.Repeat(hashDigit, 40))
+		require.NoError(t, err)
+		return hash
+	}
+	moduleA := newTestModule(t, "a", "buf.test/owner/a")
+	moduleB := newTestModule(t, "b", "buf.test/owner/b")
+	plan := bufsync.SyncPlan{
+		Branches: []bufsync.BranchSyncPlan{
+			{
+				Branch:     "main",
+				SyncPoints: map[bufsync.Module]git.Hash{moduleA: newHash("1")},
+				Commits: []bufsync.CommitSyncPlan{
+					{Commit: &testCommit{hash: newHash("2")}, Modules: []bufsync.Module{moduleA, moduleB}},
+					{Commit: &testCommit{hash: newHash("3")}, Modules: []bufsync.Module{moduleA}},
+					{Commit: &testCommit{hash: newHash("4")}, Modules: []bufsync.Module{moduleA}},
+				},
+			},
+			{
+				Branch: "feature",
+				Commits: []bufsync.CommitSyncPlan{
+					{Commit: &testCommit{hash: newHash("5")}, Modules: []bufsync.Module{moduleB}},
+				},
+			},
+			{
+				Branch:     "release",
+				SyncPoints: map[bufsync.Module]git.Hash{moduleA: newHash("6"), moduleB: newHash("6")},
+			},
+			{
+				// tagged commits not reachable from any branch
+				Commits: []bufsync.CommitSyncPlan{
+					{Commit: &testCommit{hash: newHash("7")}, Modules: []bufsync.Module{moduleA}},
+				},
+			},
+		},
+	}
+	heads := map[string]git.Hash{"main": newHash("4"), "feature": newHash("5"), "release": newHash("6")}
+	statuses, err := newModuleBranchStatuses(plan, func(branch string) (git.Commit, error) {
+		return &testCommit{hash: heads[branch]}, nil
+	})
+	require.NoError(t, err)
+	hex := func(hashDigit string) string {
+		return strings.Repeat(hashDigit, 40)
+	}
+	assert.Equal(
+		t,
+		[]moduleBranchStatus{
+			{Branch: "main", Module: moduleA.String(), SyncPoint: hex("1"), Head: hex("4"), Behind: 3},
+			{Branch: "main", Module: moduleB.String(), Head: hex("4"), Behind: 1},
+			{Branch: "feature", Module: moduleB.String(), Head: hex("5"), Behind: 1},
+			{Branch: "release", Module: moduleA.String(), SyncPoint: hex("6"), Head: hex("6"), Behind: 0},
+			{Branch: "release", Module: moduleB.String(), SyncPoint: hex("6"), Head: hex("6"), Behind: 0},
+		},
+		statuses,
+	)
+	var stdout bytes.Buffer
+	require.NoError(t, printStatusJSON(&stdout, statuses[:2]))
+	assert.Equal(
+		t,
+		fmt.Sprintf(`{"branch":"main","module":%q,"sync_point":%q,"head":%q,"behind":3}`, moduleA.String(), hex("1"), hex("4"))+"\n"+
+			fmt.Sprintf(`{"branch":"main","module":%q,"head":%q,"behind":1}`, moduleB.String(), hex("4"))+"\n",
+		stdout.String(),
+	)
+	stdout.Reset()
+	require.NoError(t, printStatusText(&stdout, statuses[1:2]))
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"BRANCH", "MODULE", "SYNC", "POINT", "HEAD", "BEHIND"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"main", moduleB.String(), "-", hex("4"), "1"}, strings.Fields(lines[1]))
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+	gitDir, commits := newTestBareGitRepository(
+		t,
+		map[string]string{
+			"proto/buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+			"proto/a.proto":  "syntax = \"proto3\";\n\npackage a;\n",
+		},
+		map[string]string{"proto/b.proto": "syntax = \"proto3\";\n\npackage b;\n"},
+	)
+	outputDir := t.TempDir()
+	runCommand := func(t *testing.T, args ...string) moduleBranchStatus {
+		stdout := bytes.NewBuffer(nil)
+		appcmdtesting.RunCommandExitCode(
+			t,
+			func(use string) *appcmd.Command { return NewCommand(use, appflag.NewBuilder(use)) },
+			0,
+			nil,
+			nil,
+			stdout,
+			nil,
+			append(
+				[]string{
+					"--" + gitDirFlagName, gitDir,
+					"--" + outputDirFlagName, outputDir,
+					"--" + moduleFlagName, "proto:buf.test/owner/repo",
+				},
+				args...,
+			)...,
+		)
+		var status moduleBranchStatus
+		if stdout.Len() > 0 {
+			require.NoError(t, json.Unmarshal(stdout.Bytes(), &status))
+		}
+		return status
+	}
+
+	// not running in parallel, the subtests share the same output dir
+	t.Run("never_synced", func(t *testing.T) {
+		status := runCommand(t, "--"+statusFlagName, "--"+statusFormatFlagName, statusFormatJSON)
+		assert.Equal(t, moduleBranchStatus{Branch: "main", Module: "proto:buf.test/owner/repo", Head: commits[1].Hex(), Behind: 2}, status)
+	})
+	t.Run("synced", func(t *testing.T) {
+		runCommand(t)
+		status := runCommand(t, "--"+statusFlagName, "--"+statusFormatFlagName, statusFormatJSON)
+		assert.Equal(
+			t,
+			moduleBranchStatus{Branch: "main", Module: "proto:buf.test/owner/repo", SyncPoint: commits[1].Hex(), Head: commits[1].Hex()},
+			status,
+		)
+	})
+}
+
+func TestBranchIdentitySuffix(t *testing.T) {
+	t.Parallel()
+	gitDir, commits := newTestBareGitRepository(
+		t,
+		map[string]string{
+			"proto/buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+			"proto/a.proto":  "syntax = \"proto3\";\n\npackage a;\n",
+		},
+		map[string]string{"proto/b.proto": "syntax = \"proto3\";\n\npackage b;\n"},
+	)
+	stderr := bytes.NewBuffer(nil)
+	require.NoError(
+		t,
+		command.NewRunner().Run(
+			context.Background(),
+			"git",
+			command.RunWithArgs("branch", "staging", commits[0].Hex()),
+			command.RunWithDir(gitDir),
+			command.RunWithStderr(stderr),
+		),
+		stderr.String(),
+	)
+	runCommand := func(t *testing.T, expectedExitCode int, outputDir string, args ...string) {
+		appcmdtesting.RunCommandExitCode(
+			t,
+			func(use string) *appcmd.Command { return NewCommand(use, appflag.NewBuilder(use)) },
+			expectedExitCode,
+			nil,
+			nil,
+			nil,
+			nil,
+			append(
+				[]string{
+					"--" + gitDirFlagName, gitDir,
+					"--" + outputDirFlagName, outputDir,
+					"--" + allBranchesFlagName,
+				},
+				args...,
+			)...,
+		)
+	}
+
+	t.Run("two_branches_two_suffixes", func(t *testing.T) {
+		t.Parallel()
+		outputDir := t.TempDir()
+		runCommand(
+			t,
+			0,
+			outputDir,
+			"--"+moduleFlagName, "proto:buf.test/owner/repo",
+			"--"+branchIdentitySuffixFlagName, "main=-prod",
+			"--"+branchIdentitySuffixFlagName, "staging=-staging",
+		)
+		for _, expectedDir := range []string{
+			"buf.test/owner/repo-prod/main/" + commits[0].Hex(),
+			"buf.test/owner/repo-prod/main/" + commits[1].Hex(),
+			"buf.test/owner/repo-staging/staging/" + commits[0].Hex(),
+		} {
+			assert.DirExists(t, filepath.Join(outputDir, expectedDir))
+		}
+		assert.NoDirExists(t, filepath.Join(outputDir, "buf.test/owner/repo"))
+		assert.NoDirExists(t, filepath.Join(outputDir, "buf.test/owner/repo-staging/main"))
+	})
+	t.Run("invalid_identity", func(t *testing.T) {
+		t.Parallel()
+		runCommand(
+			t,
+			exitCodeConfigFailure,
+			t.TempDir(),
+			"--"+moduleFlagName, "proto:buf.test/owner/repo",
+			"--"+branchIdentitySuffixFlagName, "staging=/staging",
+		)
+	})
+}
+
+func TestModuleStdin(t *testing.T) {
+	t.Parallel()
+	gitDir, commits := newTestBareGitRepository(
+		t,
+		map[string]string{
+			"proto/a/buf.yaml": "version: v1\nname: buf.test/owner/a\n",
+			"proto/a/a.proto":  "syntax = \"proto3\";\n\npackage a;\n",
+			"proto/b/buf.yaml": "version: v1\nname: buf.test/owner/b\n",
+			"proto/b/b.proto":  "syntax = \"proto3\";\n\npackage b;\n",
+		},
+	)
+	outputDir := t.TempDir()
+	appcmdtesting.RunCommandExitCode(
+		t,
+		func(use string) *appcmd.Command { return NewCommand(use, appflag.NewBuilder(use)) },
+		0,
+		nil,
+		strings.NewReader("proto/b:buf.test/owner/b\n"),
+		nil,
+		nil,
+		"--"+gitDirFlagName, gitDir,
+		"--"+outputDirFlagName, outputDir,
+		"--"+moduleFlagName, "proto/a:buf.test/owner/a",
+		"--"+moduleStdinFlagName,
+	)
+	// the modules from stdin are synced along with the ones from --module
+	assert.DirExists(t, filepath.Join(outputDir, "buf.test/owner/a/main", commits[0].Hex()))
+	assert.DirExists(t, filepath.Join(outputDir, "buf.test/owner/b/main", commits[0].Hex()))
+}
+
+func TestRequireClean(t *testing.T) {
+	t.Parallel()
+	bareGitDir, commits := newTestBareGitRepository(
+		t,
+		map[string]string{
+			"proto/buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+			"proto/a.proto":  "syntax = \"proto3\";\n\npackage a;\n",
+		},
+	)
+	cleanWorkDir := newTestGitClone(t, bareGitDir)
+	dirtyWorkDir := newTestGitClone(t, bareGitDir)
+	require.NoError(t, os.WriteFile(filepath.Join(dirtyWorkDir, "proto/a.proto"), []byte("syntax = \"proto2\";\n"), 0600))
+	testCases := []struct {
+		name             string
+		workDir          string
+		args             []string
+		expectedExitCode int
+		expectedWarning  bool
+	}{
+		{
+			name:    "clean",
+			workDir: cleanWorkDir,
+		},
+		{
+			name:    "clean_require_clean",
+			workDir: cleanWorkDir,
+			args:    []string{"--" + requireCleanFlagName},
+		},
+		{
+			name:            "dirty",
+			workDir:         dirtyWorkDir,
+			expectedWarning: true,
+		},
+		{
+			name:             "dirty_require_clean",
+			workDir:          dirtyWorkDir,
+			args:             []string{"--" + requireCleanFlagName},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name:             "require_clean_all_branches",
+			workDir:          cleanWorkDir,
+			args:             []string{"--" + requireCleanFlagName, "--" + allBranchesFlagName},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+	}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			outputDir := t.TempDir()
+			stderr := bytes.NewBuffer(nil)
+			appcmdtesting.RunCommandExitCode(
+				t,
+				func(use string) *appcmd.Command { return NewCommand(use, appflag.NewBuilder(use)) },
+				testCase.expectedExitCode,
+				nil,
+				nil,
+				nil,
+				stderr,
+				append(
+					[]string{
+						"--" + gitDirFlagName, filepath.Join(testCase.workDir, git.DotGitDir),
+						"--" + outputDirFlagName, outputDir,
+						"--" + moduleFlagName, "proto:buf.test/owner/repo",
+					},
+					testCase.args...,
+				)...,
+			)
+			if testCase.expectedWarning {
+				assert.Contains(t, stderr.String(), "uncommitted changes")
+				assert.Contains(t, stderr.String(), "proto/a.proto")
+			} else if testCase.expectedExitCode == 0 {
+				assert.NotContains(t, stderr.String(), "uncommitted changes")
+			}
+			if testCase.expectedExitCode == 0 {
+				// only the pushed commit is synced, without the uncommitted changes
+				assert.DirExists(t, filepath.Join(outputDir, "buf.test/owner/repo/main", commits[0].Hex()))
+			}
+		})
+	}
+}
+
+func TestDetachedHEAD(t *testing.T) {
+	t.Parallel()
+	bareGitDir, commits := newTestBareGitRepository(
+		t,
+		map[string]string{
+			"proto/buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+			"proto/a.proto":  "syntax = \"proto3\";\n\npackage a;\n",
+		},
+	)
+	workDir := newTestGitClone(t, bareGitDir)
+	stderr := bytes.NewBuffer(nil)
+	require.NoError(
+		t,
+		command.NewRunner().Run(
+			context.Background(),
+			"git",
+			command.RunWithArgs("checkout", "--detach"),
+			command.RunWithDir(workDir),
+			command.RunWithStderr(stderr),
+		),
+		stderr.String(),
+	)
+	testCases := []struct {
+		name             string
+		args             []string
+		expectedExitCode int
+	}{
+		{
+			name:             "without_branch",
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name: "branch",
+			args: []string{"--" + branchFlagName, "main"},
+		},
+		{
+			name:             "branch_all_branches",
+			args:             []string{"--" + branchFlagName, "main", "--" + allBranchesFlagName},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+	}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			outputDir := t.TempDir()
+			stderr := bytes.NewBuffer(nil)
+			appcmdtesting.RunCommandExitCode(
+				t,
+				func(use string) *appcmd.Command { return NewCommand(use, appflag.NewBuilder(use)) },
+				testCase.expectedExitCode,
+				nil,
+				nil,
+				nil,
+				stderr,
+				append(
+					[]string{
+						"--" + gitDirFlagName, filepath.Join(workDir, git.DotGitDir),
+						"--" + outputDirFlagName, outputDir,
+						"--" + moduleFlagName, "proto:buf.test/owner/repo",
+					},
+					testCase.args...,
+				)...,
+			)
+			if testCase.expectedExitCode == 0 {
+				assert.DirExists(t, filepath.Join(outputDir, "buf.test/owner/repo/main", commits[0].Hex()))
+			} else if testCase.args == nil {
+				assert.Contains(t, stderr.String(), "--"+branchFlagName)
+			}
+		})
+	}
+}
+
+func TestInitialSyncConfirmation(t *testing.T) {
+	t.Parallel()
+	gitDir, commits := newTestBareGitRepository(
+		t,
+		map[string]string{
+			"proto/buf.yaml": "version: v1\nname: buf.test/owner/repo\n",
+			"proto/a.proto":  "syntax = \"proto3\";\n\npackage a;\n",
+		},
+		map[string]string{"proto/b.proto": "syntax = \"proto3\";\n\npackage b;\n"},
+	)
+	testCases := []struct {
+		name             string
+		args             []string
+		expectedExitCode int
+		expectedSynced   bool
+	}{
+		{
+			// the commits are below the default threshold
+			name:           "default",
+			expectedSynced: true,
+		},
+		{
+			name:           "below_threshold",
+			args:           []string{"--" + initialSyncThresholdFlagName, "2"},
+			expectedSynced: true,
+		},
+		{
+			// the branch is skipped without prompting from a non-TTY stdin
+			name: "non_tty",
+			args: []string{"--" + initialSyncThresholdFlagName, "1"},
+		},
+		{
+			name:           "yes",
+			args:           []string{"--" + initialSyncThresholdFlagName, "1", "--" + yesFlagName},
+			expectedSynced: true,
+		},
+		{
+			name: "no_confirm",
+			args: []string{"--" + initialSyncThresholdFlagName, "1", "--" + noConfirmFlagName},
+		},
+		{
+			name:           "zero_threshold",
+			args:           []string{"--" + initialSyncThresholdFlagName, "0"},
+			expectedSynced: true,
+		},
+		{
+			name:             "yes_and_no_confirm",
+			args:             []string{"--" + yesFlagName, "--" + noConfirmFlagName},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+		{
+			name:             "negative_threshold",
+			args:             []string{"--" + initialSyncThresholdFlagName, "-1"},
+			expectedExitCode: exitCodeConfigFailure,
+		},
+	}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			outputDir := t.TempDir()
+			stderr := bytes.NewBuffer(nil)
+			appcmdtesting.RunCommandExitCode(
+				t,
+				func(use string) *appcmd.Command { return NewCommand(use, appflag.NewBuilder(use)) },
+				testCase.expectedExitCode,
+				nil,
+				nil,
+				nil,
+				stderr,
+				append(
+					[]string{
+						"--" + gitDirFlagName, gitDir,
+						"--" + outputDirFlagName, outputDir,
+						"--" + moduleFlagName, "proto:buf.test/owner/repo",
+					},
+					testCase.args...,
+				)...,
+			)
+			for _, commit := range commits {
+				commitDir := filepath.Join(outputDir, "buf.test/owner/repo/main", commit.Hex())
+				if testCase.expectedSynced {
+					assert.DirExists(t, commitDir)
+				} else {
+					assert.NoDirExists(t, commitDir)
+				}
+			}
+			if testCase.name == "non_tty" {
+				assert.Contains(t, stderr.String(), "--"+yesFlagName)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+	moduleIdentity, err := bufmoduleref.ModuleIdentityForString("buf.test/owner/repo")
+	require.NoError(t, err)
+	t.Run("sets_default_branch", func(t *testing.T) {
+		t.Parallel()
+		repositoryService := &mockRepositoryService{createdDefaultBranch: "main"}
+		clientConfig := newTestClientConfig(t, repositoryService)
+		require.NoError(t, create(context.Background(), clientConfig, moduleIdentity, "private", "master"))
+		require.Len(t, repositoryService.createRequests, 1)
+		assert.Equal(t, "owner/repo", repositoryService.createRequests[0].FullName)
+		assert.Equal(t, registryv1alpha1.Visibility_VISIBILITY_PRIVATE, repositoryService.createRequests[0].Visibility)
+		require.Len(t, repositoryService.updateRequests, 1)
+		assert.Equal(t, "owner", repositoryService.updateRequests[0].OwnerName)
+		assert.Equal(t, "repo", repositoryService.updateRequests[0].RepositoryName)
+		assert.Equal(t, "master", repositoryService.updateRequests[0].GetDefaultBranch())
+		// only the default branch is updated
+		assert.Nil(t, repositoryService.updateRequests[0].Description)
+		assert.Equal(t, registryv1alpha1.Visibility_VISIBILITY_UNSPECIFIED, repositoryService.updateRequests[0].Visibility)
+	})
+	t.Run("matching_default_branch", func(t *testing.T) {
+		t.Parallel()
+		repositoryService := &mockRepositoryService{createdDefaultBranch: "main"}
+		clientConfig := newTestClientConfig(t, repositoryService)
+		require.NoError(t, create(context.Background(), clientConfig, moduleIdentity, "public", "main"))
+		require.Len(t, repositoryService.createRequests, 1)
+		assert.Empty(t, repositoryService.updateRequests)
+	})
+	t.Run("set_default_branch_error", func(t *testing.T) {
+		t.Parallel()
+		repositoryService := &mockRepositoryService{
+			createdDefaultBranch: "main",
+			updateErr:            connect.NewError(connect.CodePermissionDenied, errors.New("permission denied")),
+		}
+		clientConfig := newTestClientConfig(t, repositoryService)
+		err := create(context.Background(), clientConfig, moduleIdentity, "public", "master")
+		require.Error(t, err)
+		assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+		assert.Contains(t, err.Error(), `set default branch of repository owner/repo to "master"`)
+	})
+	t.Run("already_exists", func(t *testing.T) {
+		t.Parallel()
+		repositoryService := &mockRepositoryService{
+			createErr: connect.NewError(connect.CodeAlreadyExists, errors.New("already exists")),
+		}
+		clientConfig := newTestClientConfig(t, repositoryService)
+		err := create(context.Background(), clientConfig, moduleIdentity, "public", "master")
+		require.Error(t, err)
+		assert.Equal(t, connect.CodeInternal, connect.CodeOf(err))
+		assert.Empty(t, repositoryService.updateRequests)
+	})
+}
+
+// mockRepositoryService records the repository creations and settings updates.
+type mockRepositoryService struct {
+	registryv1alpha1connect.UnimplementedRepositoryServiceHandler
+
+	// createdDefaultBranch is the default branch of the created repositories.
+	createdDefaultBranch string
+	createErr            error
+	updateErr            error
+
+	createRequests []*registryv1alpha1.CreateRepositoryByFullNameRequest
+	updateRequests []*registryv1alpha1.UpdateRepositorySettingsByNameRequest
+}
+
+func (s *mockRepositoryService) CreateRepositoryByFullName(
+	_ context.Context,
+	req *connect.Request[registryv1alpha1.CreateRepositoryByFullNameRequest],
+) (*connect.Response[registryv1alpha1.CreateRepositoryByFullNameResponse], error) {
+	s.createRequests = append(s.createRequests, req.Msg)
+	if s.createErr != nil {
+		return nil, s.createErr
+	}
+	return connect.NewResponse(&registryv1alpha1.CreateRepositoryByFullNameResponse{
+		Repository: &registryv1alpha1.Repository{
+			Name:          strings.TrimPrefix(req.Msg.FullName, "owner/"),
+			Visibility:    req.Msg.Visibility,
+			DefaultBranch: s.createdDefaultBranch,
+		},
+	}), nil
+}
+
+func (s *mockRepositoryService) UpdateRepositorySettingsByName(
+	_ context.Context,
+	req *connect.Request[registryv1alpha1.UpdateRepositorySettingsByNameRequest],
+) (*connect.Response[registryv1alpha1.UpdateRepositorySettingsByNameResponse], error) {
+	s.updateRequests = append(s.updateRequests, req.Msg)
+	if s.updateErr != nil {
+		return nil, s.updateErr
+	}
+	return connect.NewResponse(&registryv1alpha1.UpdateRepositorySettingsByNameResponse{}), nil
+}
+
+// newTestClientConfig returns a client config for a test server serving the repository service.
+func newTestClientConfig(t *testing.T, repositoryService registryv1alpha1connect.RepositoryServiceHandler) *connectclient.Config {
+	mux := http.NewServeMux()
+	mux.Handle(registryv1alpha1connect.NewRepositoryServiceHandler(repositoryService))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return connectclient.NewConfig(
+		server.Client(),
+		connectclient.WithAddressMapper(func(string) string { return server.URL }),
+	)
+}
+
+func newTestModule(t *testing.T, dir string, identity string) bufsync.Module {
+	moduleIdentity, err := bufmoduleref.ModuleIdentityForString(identity)
+	require.NoError(t, err)
+	module, err := bufsync.NewModule(dir, moduleIdentity)
+	require.NoError(t, err)
+	return module
+}
+
+// testModuleCommit is a bufsync.ModuleCommit with only the methods used by the output dir.
+type testModuleCommit struct {
+	bufsync.ModuleCommit
+
+	identity bufmoduleref.ModuleIdentity
+	branch   string
+	commit   git.Commit
+	bucket   storage.ReadBucket
+	tags     []string
+}
+
+// newTestModuleCommit returns a module commit for a git commit with a hash made of the passed hex
+// digit, and a bucket with the passed files.
+func newTestModuleCommit(
+	t *testing.T,
+	identity bufmoduleref.ModuleIdentity,
+	branch string,
+	hashDigit string,
+	files map[string]string,
+) *testModuleCommit {
+	hash, err := git.NewHashFromHex(strings.Repeat(hashDigit, 40))
+	require.NoError(t, err)
+	pathToData := make(map[string][]byte, len(files))
+	for path, content := range files {
+		pathToData[path] = []byte(content)
+	}
+	bucket, err := storagemem.NewReadBucket(pathToData)
+	require.NoError(t, err)
+	return &testModuleCommit{
+		identity: identity,
+		branch:   branch,
+		commit:   &testCommit{hash: hash},
+		bucket:   bucket,
+	}
+}
+
+func (c *testModuleCommit) Identity() bufmoduleref.ModuleIdentity { return c.identity }
+func (c *testModuleCommit) Branch() string                        { return c.branch }
+func (c *testModuleCommit) Commit() git.Commit                    { return c.commit }
+func (c *testModuleCommit) Bucket() storage.ReadBucket            { return c.bucket }
+func (c *testModuleCommit) Tags() []string                        { return c.tags }
+func (c *testModuleCommit) Notes() map[string]string              { return nil }
+func (c *testModuleCommit) Annotations() map[string]string        { return nil }
+func (c *testModuleCommit) TagDetails() []bufsync.TagDetail {
+	tagDetails := make([]bufsync.TagDetail, 0, len(c.tags))
+	for _, tag := range c.tags {
+		tagDetails = append(tagDetails, bufsync.TagDetail{Name: tag})
+	}
+	return tagDetails
+}
+
+// testCommit is a git.Commit with only a hash and parents.
+type testCommit struct {
+	git.Commit
+
+	hash    git.Hash
+	parents []git.Hash
+}
+
+func (c *testCommit) Hash() git.Hash      { return c.hash }
+func (c *testCommit) Parents() []git.Hash { return c.parents }
+
+// newTestBareGitRepository returns the git dir of a bare repository with a commit in the main branch
+// for each set of files, in order, and the commit hashes.
+func newTestBareGitRepository(t *testing.T, commitFiles ...map[string]string) (string, []git.Hash) {
+	runner := command.NewRunner()
+	dir := t.TempDir()
+	workDir := filepath.Join(dir, "work")
+	bareDir := filepath.Join(dir, "bare")
+	runGit := func(dir string, args ...string) string {
+		stdout := bytes.NewBuffer(nil)
+		stderr := bytes.NewBuffer(nil)
+		err := runner.Run(
+			context.Background(),
+			"git",
+			command.RunWithArgs(args...),
+			command.RunWithDir(dir),
+			command.RunWithStdout(stdout),
+			command.RunWithStderr(stderr),
+		)
+		require.NoError(t, err, stderr.String())
+		return strings.TrimSpace(stdout.String())
+	}
+	require.NoError(t, os.MkdirAll(workDir, 0700))
+	require.NoError(t, os.MkdirAll(bareDir, 0700))
+	runGit(bareDir, "init", "--bare")
+	runGit(bareDir, "symbolic-ref", "HEAD", "refs/heads/main")
+	runGit(workDir, "init")
+	runGit(workDir, "checkout", "-b", "main")
+	commits := make([]git.Hash, 0, len(commitFiles))
+	for i, files := range commitFiles {
+		for path, content := range files {
+			require.NoError(t, os.MkdirAll(filepath.Join(workDir, filepath.Dir(path)), 0700))
+			require.NoError(t, os.WriteFile(filepath.Join(workDir, path), []byte(content), 0600))
+		}
+		runGit(workDir, "add", ".")
+		runGit(
+			workDir,
+			"-c", "user.name=Buf TestBot",
+			"-c", "user.email=testbot@buf.build",
+			"commit", "-m", fmt.Sprintf("commit %d", i+1),
+		)
+		hash, err := git.NewHashFromHex(runGit(workDir, "rev-parse", "HEAD"))
+		require.NoError(t, err)
+		commits = append(commits, hash)
+	}
+	runGit(workDir, "push", bareDir, "main")
+	return bareDir, commits
+}
+
+// newTestGitClone returns the working tree of a clone of the bare repository, with the bare
+// repository as its "origin" remote.
+func newTestGitClone(t *testing.T, bareDir string) string {
+	workDir := filepath.Join(t.TempDir(), "work")
+	stderr := bytes.NewBuffer(nil)
+	require.NoError(
+		t,
+		command.NewRunner().Run(
+			context.Background(),
+			"git",
+			command.RunWithArgs("clone", bareDir, workDir),
+			command.RunWithStderr(stderr),
+		),
+		stderr.String(),
+	)
+	return workDir
+}
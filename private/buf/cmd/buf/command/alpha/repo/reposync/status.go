@@ -0,0 +1,122 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reposync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/bufbuild/buf/private/buf/bufsync"
+	"github.com/bufbuild/buf/private/pkg/git"
+)
+
+// moduleBranchStatus is the sync status of a module in a branch.
+type moduleBranchStatus struct {
+	Branch string `json:"branch"`
+	Module string `json:"module"`
+	// SyncPoint is the hex hash of the commit the BSR module was last synced from in the branch, empty
+	// if the module was never synced in the branch.
+	SyncPoint string `json:"sync_point,omitempty"`
+	// Head is the hex hash of the HEAD commit of the branch.
+	Head string `json:"head"`
+	// Behind is the number of commits that would be synced for the module in the branch.
+	Behind int `json:"behind"`
+}
+
+// newModuleBranchStatuses returns the sync status of every module in every branch of the plan, sorted
+// by branch in the plan order, and then by module. Modules without a sync point nor commits to sync
+// in a branch, and the tagged commits not reachable from any branch, are not present.
+func newModuleBranchStatuses(
+	plan bufsync.SyncPlan,
+	headCommit func(branch string) (git.Commit, error),
+) ([]moduleBranchStatus, error) {
+	var statuses []moduleBranchStatus
+	for _, branchPlan := range plan.Branches {
+		if branchPlan.Branch == "" {
+			continue
+		}
+		head, err := headCommit(branchPlan.Branch)
+		if err != nil {
+			return nil, fmt.Errorf("get head commit for branch %q: %w", branchPlan.Branch, err)
+		}
+		modulesBehind := make(map[bufsync.Module]int)
+		for module := range branchPlan.SyncPoints {
+			modulesBehind[module] = 0
+		}
+		for _, commitPlan := range branchPlan.Commits {
+			for _, module := range commitPlan.Modules {
+				modulesBehind[module]++
+			}
+		}
+		branchStatuses := make([]moduleBranchStatus, 0, len(modulesBehind))
+		for module, behind := range modulesBehind {
+			status := moduleBranchStatus{
+				Branch: branchPlan.Branch,
+				Module: module.String(),
+				Head:   head.Hash().Hex(),
+				Behind: behind,
+			}
+			if syncPoint, ok := branchPlan.SyncPoints[module]; ok {
+				status.SyncPoint = syncPoint.Hex()
+			}
+			branchStatuses = append(branchStatuses, status)
+		}
+		sort.Slice(branchStatuses, func(i, j int) bool {
+			return branchStatuses[i].Module < branchStatuses[j].Module
+		})
+		statuses = append(statuses, branchStatuses...)
+	}
+	return statuses, nil
+}
+
+// printStatusText prints a table of the statuses, one row per branch and module.
+func printStatusText(writer io.Writer, statuses []moduleBranchStatus) error {
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tabWriter, "BRANCH\tMODULE\tSYNC POINT\tHEAD\tBEHIND"); err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		syncPoint := status.SyncPoint
+		if syncPoint == "" {
+			syncPoint = "-"
+		}
+		if _, err := fmt.Fprintf(
+			tabWriter,
+			"%s\t%s\t%s\t%s\t%d\n",
+			status.Branch,
+			status.Module,
+			syncPoint,
+			status.Head,
+			status.Behind,
+		); err != nil {
+			return err
+		}
+	}
+	return tabWriter.Flush()
+}
+
+// printStatusJSON prints the statuses as JSON, one object per line.
+func printStatusJSON(writer io.Writer, statuses []moduleBranchStatus) error {
+	encoder := json.NewEncoder(writer)
+	for _, status := range statuses {
+		if err := encoder.Encode(status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -40,6 +40,23 @@ func NewSetCLIVersionInterceptor(version string) connect.UnaryInterceptorFunc {
 	return interceptor
 }
 
+// NewSetHeadersInterceptor returns a new Connect Interceptor that sets the passed headers into all request headers,
+// replacing any previous values of the same keys.
+func NewSetHeadersInterceptor(header http.Header) connect.UnaryInterceptorFunc {
+	interceptor := func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			for key, values := range header {
+				req.Header().Del(key)
+				for _, value := range values {
+					req.Header().Add(key, value)
+				}
+			}
+			return next(ctx, req)
+		}
+	}
+	return interceptor
+}
+
 // NewCLIWarningInterceptor returns a new Connect Interceptor that logs CLI warnings returned by server responses.
 func NewCLIWarningInterceptor(container applog.Container) connect.UnaryInterceptorFunc {
 	interceptor := func(next connect.UnaryFunc) connect.UnaryFunc {
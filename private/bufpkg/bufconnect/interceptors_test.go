@@ -20,6 +20,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"testing"
 
 	"github.com/bufbuild/buf/private/pkg/app"
@@ -107,6 +108,24 @@ func TestNewAuthorizationInterceptorProvider(t *testing.T) {
 	assert.Equal(t, tokenEnvKey, authErr.tokenEnvKey)
 }
 
+func TestSetHeadersInterceptor(t *testing.T) {
+	t.Parallel()
+	req := connect.NewRequest(&bytes.Buffer{})
+	req.Header().Set("User-Agent", "connect-go")
+	req.Header().Set("X-Other", "other")
+	_, err := NewSetHeadersInterceptor(http.Header{
+		"User-Agent": {"reposync/1.0"},
+		"X-Route":    {"a", "b"},
+	})(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		// the outgoing request has the headers
+		assert.Equal(t, []string{"reposync/1.0"}, req.Header().Values("User-Agent"))
+		assert.Equal(t, []string{"a", "b"}, req.Header().Values("X-Route"))
+		assert.Equal(t, []string{"other"}, req.Header().Values("X-Other"))
+		return connect.NewResponse(&bytes.Buffer{}), nil
+	})(context.Background(), req)
+	assert.NoError(t, err)
+}
+
 func TestCLIWarningInterceptor(t *testing.T) {
 	t.Parallel()
 	warningMessage := "This is a warning message from the BSR"
@@ -335,6 +335,15 @@ func NewErrorf(exitCode int, format string, args ...interface{}) error {
 	return newAppError(exitCode, fmt.Sprintf(format, args...))
 }
 
+// WrapError returns a new Error that contains an exit code and wraps err, with the message of err.
+//
+// The exit code cannot be 0.
+func WrapError(exitCode int, err error) error {
+	appErr := newAppError(exitCode, err.Error())
+	appErr.err = err
+	return appErr
+}
+
 // GetExitCode gets the exit code.
 //
 // If err == nil, this returns 0.
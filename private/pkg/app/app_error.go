@@ -21,6 +21,8 @@ import (
 type appError struct {
 	exitCode int
 	message  string
+	// err is the wrapped error, if any.
+	err error
 }
 
 func newAppError(exitCode int, message string) *appError {
@@ -42,6 +44,10 @@ func (e *appError) Error() string {
 	return e.message
 }
 
+func (e *appError) Unwrap() error {
+	return e.err
+}
+
 func printError(container StderrContainer, err error) {
 	if errString := err.Error(); errString != "" {
 		_, _ = fmt.Fprintln(container.Stderr(), errString)
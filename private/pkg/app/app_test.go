@@ -15,6 +15,8 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -160,3 +162,13 @@ func TestEnvBool(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, true, val)
 }
+
+func TestWrapError(t *testing.T) {
+	t.Parallel()
+	cause := errors.New("foo")
+	err := WrapError(5, fmt.Errorf("bar: %w", cause))
+	assert.Equal(t, "bar: foo", err.Error())
+	assert.Equal(t, 5, GetExitCode(err))
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, 1, GetExitCode(WrapError(0, cause)))
+}
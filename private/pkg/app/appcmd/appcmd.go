@@ -86,6 +86,13 @@ func NewInvalidArgumentErrorf(format string, args ...interface{}) error {
 	return NewInvalidArgumentError(fmt.Sprintf(format, args...))
 }
 
+// IsInvalidArgumentError returns true if err was created by NewInvalidArgumentError, or wraps an
+// error created by it.
+func IsInvalidArgumentError(err error) bool {
+	asErr := &invalidArgumentError{}
+	return errors.As(err, &asErr)
+}
+
 // Main runs the application using the OS container and calling os.Exit on the return value of Run.
 func Main(ctx context.Context, command *Command) {
 	app.Main(ctx, newRunFunc(command))
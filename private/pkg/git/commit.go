@@ -28,6 +28,9 @@ type commit struct {
 	author    Ident
 	committer Ident
 	message   string
+	// signature and signedPayload are only set for signed commits.
+	signature     string
+	signedPayload []byte
 }
 
 func (c *commit) Hash() Hash {
@@ -48,6 +51,12 @@ func (c *commit) Committer() Ident {
 func (c *commit) Message() string {
 	return c.message
 }
+func (c *commit) Signature() string {
+	return c.signature
+}
+func (c *commit) SignedPayload() []byte {
+	return c.signedPayload
+}
 func (c *commit) String() string {
 	return c.author.Timestamp().String() + " " + c.hash.String()
 }
@@ -57,10 +66,26 @@ func parseCommit(hash Hash, data []byte) (*commit, error) {
 		hash: hash,
 	}
 	buffer := bytes.NewBuffer(data)
+	// payload is the commit data without the signature header, which is what the signature covers.
+	var (
+		payload        bytes.Buffer
+		signatureLines []string
+		inSignature    bool
+	)
 	line, err := buffer.ReadString('\n')
 	for err != io.EOF && line != "\n" {
+		if inSignature && strings.HasPrefix(line, " ") {
+			// continuation line of a multi-line signature header
+			signatureLines = append(signatureLines, strings.TrimRight(line[1:], "\n"))
+			line, err = buffer.ReadString('\n')
+			continue
+		}
+		inSignature = false
 		header, value, _ := strings.Cut(line, " ")
 		value = strings.TrimRight(value, "\n")
+		if header != "gpgsig" {
+			payload.WriteString(line)
+		}
 		switch header {
 		case "tree":
 			if c.tree != nil {
@@ -83,12 +108,24 @@ func parseCommit(hash Hash, data []byte) (*commit, error) {
 			if c.committer, err = parseIdent([]byte(value)); err != nil {
 				return nil, err
 			}
+		case "gpgsig":
+			if signatureLines != nil {
+				return nil, errors.New("too many gpgsig headers")
+			}
+			signatureLines = []string{value}
+			inSignature = true
 		default:
 			// We do not parse the remaining headers.
 		}
 		line, err = buffer.ReadString('\n')
 	}
 	c.message = buffer.String()
+	if signatureLines != nil {
+		c.signature = strings.Join(signatureLines, "\n")
+		payload.WriteString(line)
+		payload.WriteString(c.message)
+		c.signedPayload = payload.Bytes()
+	}
 	c.message = strings.TrimRight(c.message, "\n")
 	return c, err
 }
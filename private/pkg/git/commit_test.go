@@ -80,3 +80,62 @@ Hello World
 		commit.Message(),
 	)
 }
+
+func TestParseSignedCommit(t *testing.T) {
+	t.Parallel()
+
+	hash, err := parseHashFromHex("43848150a6f5f6d76eeef6e0f69eb46290eefab6")
+	require.NoError(t, err)
+	commit, err := parseCommit(
+		hash,
+		[]byte(`tree 5edab9f970913225f985d9673ac19d61d36f0942
+author Bob <bob@buf.build> 1680571785 -0700
+committer Alice <alice@buf.build> 1680636827 -0700
+gpgsig -----BEGIN PGP SIGNATURE-----
+ 
+ iQEzBAABCAAdFiEE
+ -----END PGP SIGNATURE-----
+
+Hello World
+`))
+	require.NoError(t, err)
+	assert.Equal(t,
+		"-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----",
+		commit.Signature(),
+	)
+	assert.Equal(t,
+		`tree 5edab9f970913225f985d9673ac19d61d36f0942
+author Bob <bob@buf.build> 1680571785 -0700
+committer Alice <alice@buf.build> 1680636827 -0700
+
+Hello World
+`,
+		string(commit.SignedPayload()),
+	)
+	assert.Equal(t,
+		"Alice",
+		commit.Committer().Name(),
+	)
+	assert.Equal(t,
+		"Hello World",
+		commit.Message(),
+	)
+}
+
+func TestParseUnsignedCommit(t *testing.T) {
+	t.Parallel()
+
+	hash, err := parseHashFromHex("43848150a6f5f6d76eeef6e0f69eb46290eefab6")
+	require.NoError(t, err)
+	commit, err := parseCommit(
+		hash,
+		[]byte(`tree 5edab9f970913225f985d9673ac19d61d36f0942
+author Bob <bob@buf.build> 1680571785 -0700
+committer Alice <alice@buf.build> 1680636827 -0700
+
+Hello World
+`))
+	require.NoError(t, err)
+	assert.Empty(t, commit.Signature())
+	assert.Nil(t, commit.SignedPayload())
+}
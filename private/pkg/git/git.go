@@ -17,7 +17,9 @@ package git
 import (
 	"context"
 	"errors"
+	"io"
 	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/bufbuild/buf/private/pkg/app"
@@ -53,6 +55,9 @@ var (
 	// ErrTreeNodeNotFound is an error found in the error chain when
 	// ObjectReader is unable to find the target object.
 	ErrObjectNotFound = errors.New("object not found")
+	// ErrDetachedHEAD is an error found in the error chain when OpenRepository is unable to detect
+	// the checked out branch because git HEAD is detached, such as in CI checkouts of a commit.
+	ErrDetachedHEAD = errors.New("no current branch, git HEAD is detached")
 )
 
 // ObjectMode is how to interpret a tree node's object. See the Mode* constants
@@ -159,6 +164,17 @@ func NewLister(runner command.Runner) Lister {
 	return newLister(runner)
 }
 
+// ListUncommittedChanges returns the paths with uncommitted changes in the working tree or in the
+// index of the repository at the `.git` directory, including untracked files that are not ignored.
+// The working tree is the parent directory of the `.git` directory.
+//
+// Bare repositories have no working tree, so they have no uncommitted changes.
+//
+// The returned paths are relative to the working tree, in the order reported by `git status`.
+func ListUncommittedChanges(ctx context.Context, gitDirPath string, runner command.Runner) ([]string, error) {
+	return listUncommittedChanges(ctx, gitDirPath, runner)
+}
+
 // ListFilesAndUnstagedFilesOptions are options for ListFilesAndUnstagedFiles.
 type ListFilesAndUnstagedFilesOptions struct {
 	// IgnorePathRegexps are regexes of paths to ignore.
@@ -168,15 +184,42 @@ type ListFilesAndUnstagedFilesOptions struct {
 	IgnorePathRegexps []*regexp.Regexp
 }
 
+// HashAlgorithm is the algorithm of the hashes of the objects in a git repository, set by its object
+// format.
+type HashAlgorithm int
+
+const (
+	// HashAlgorithmSHA1 is the hash algorithm of the SHA-1 object format, the default one.
+	HashAlgorithmSHA1 HashAlgorithm = iota + 1
+	// HashAlgorithmSHA256 is the hash algorithm of the SHA-256 object format, such as in repositories
+	// created with `git init --object-format=sha256`.
+	HashAlgorithmSHA256
+)
+
+// String returns the name of the object format of the hash algorithm, as git names it.
+func (a HashAlgorithm) String() string {
+	switch a {
+	case HashAlgorithmSHA1:
+		return "sha1"
+	case HashAlgorithmSHA256:
+		return "sha256"
+	default:
+		return strconv.Itoa(int(a))
+	}
+}
+
 // Hash represents the hash of a Git object (tree, blob, or commit).
 type Hash interface {
 	// Hex is the hexadecimal representation of this ID.
 	Hex() string
 	// String returns the hexadecimal representation of this ID.
 	String() string
+	// Algorithm is the hash algorithm of this ID, given by its length.
+	Algorithm() HashAlgorithm
 }
 
-// NewHashFromHex creates a new hash that is validated.
+// NewHashFromHex creates a new hash that is validated. Both SHA-1 and SHA-256 hashes are valid,
+// with 40 and 64 hexadecimal characters respectively.
 func NewHashFromHex(value string) (Hash, error) {
 	return parseHashFromHex(value)
 }
@@ -210,6 +253,13 @@ type Commit interface {
 	Committer() Ident
 	// Message is the commit message.
 	Message() string
+	// Signature is the ASCII-armored signature of the commit, such as a GPG or SSH
+	// signature. It is empty if the commit is not signed.
+	Signature() string
+	// SignedPayload is the raw commit object content covered by the signature, which
+	// is the commit object without its signature header. It is nil if the commit is
+	// not signed.
+	SignedPayload() []byte
 	// String outputs the Author timestamp and Hex.
 	String() string
 }
@@ -228,10 +278,17 @@ type AnnotatedTag interface {
 	Message() string
 }
 
-// ObjectReader reads objects (commits, trees, blobs, tags) from a `.git` directory.
+// ObjectReader reads objects (commits, trees, blobs, tags) from a `.git` directory. It is safe for
+// concurrent use, reading one object at a time.
 type ObjectReader interface {
 	// Blob reads the blob identified by the hash.
 	Blob(id Hash) ([]byte, error)
+	// BlobReader streams the content of the blob identified by the hash as it is read, instead of
+	// reading it in memory. Each blob is streamed independently of the other reads, so blobs can be
+	// streamed concurrently, up to the number of CPUs at a time: streaming more blobs blocks until
+	// a streamed blob is closed. The reader is not safe for concurrent use, and must be closed once
+	// done.
+	BlobReader(id Hash) (io.ReadCloser, error)
 	// Commit reads the commit identified by the hash.
 	Commit(id Hash) (Commit, error)
 	// Tree reads the tree identified by the hash.
@@ -269,13 +326,18 @@ type Repository interface {
 	// DefaultBranch is the default branch of the repository. This is either configured via the
 	// `OpenRepositoryWithDefaultBranch` option, or discovered from the value in
 	// `.git/refs/remotes/origin/HEAD`. Therefore, discovery requires that the repository is pushed to
-	// a remote named `origin`.
+	// a remote named `origin`. For bare repositories, it is discovered from the value in `HEAD`.
 	DefaultBranch() string
-	// CurrentBranch is the current checked out branch.
+	// HashAlgorithm is the hash algorithm of the repository objects, detected from its object format.
+	HashAlgorithm() HashAlgorithm
+	// CurrentBranch is the current checked out branch. This is either configured via the
+	// `OpenRepositoryWithCurrentBranch` option, or discovered from git HEAD. For bare repositories, it
+	// is the default branch unless configured.
 	CurrentBranch() string
 	// ForEachBranch ranges over branches in the repository in an undefined order.
 	//
-	// Only branches pushed to a remote named "origin" are visited.
+	// Only branches pushed to a remote named "origin" are visited. For bare repositories, local
+	// branches are visited instead.
 	ForEachBranch(func(branch string, headHash Hash) error) error
 	// ForEachCommit ranges over commits for the target branch in topological order.
 	//
@@ -293,6 +355,18 @@ type Repository interface {
 	//
 	// TODO: only loop over remote tags, or inform the callback if the tag is local/remote.
 	ForEachTag(func(tag string, commitHash Hash) error) error
+	// ForEachRef ranges over all refs in the repository in an undefined order, such as branches,
+	// remote branches, tags, and any custom refs. Symbolic refs like `HEAD` are not visited.
+	//
+	// The ref is the full ref name, like `refs/heads/main`, and the hash is the object the ref points
+	// to, which is a tag for annotated tags.
+	ForEachRef(func(ref string, hash Hash) error) error
+	// ForEachNote ranges over the Git notes written in the passed notes ref, like
+	// `refs/notes/commits`, in an undefined order. The hash is the object the note is attached to,
+	// and the note is its full message.
+	//
+	// If the notes ref does not exist, nothing is ranged and no error is returned.
+	ForEachNote(notesRef string, f func(objectHash Hash, note string) error) error
 	// Objects exposes the underlying object reader to read objects directly from the
 	// `.git` directory.
 	Objects() ObjectReader
@@ -300,8 +374,11 @@ type Repository interface {
 	Close() error
 }
 
-// OpenRepository opens a new Repository from a `.git` directory. The provided path to the `.git`
-// dir need not be normalized or cleaned.
+// OpenRepository opens a new Repository from a `.git` directory, or from a bare repository directory.
+// The provided path to the git dir need not be normalized or cleaned.
+//
+// Bare repositories, such as mirror clones, have no remotes, so their local branches are treated as
+// the branches pushed to the `origin` remote.
 //
 // Internally, OpenRepository will spawns a new process to communicate with `git-cat-file`, so the
 // caller must close the repository to clean up resources.
@@ -326,3 +403,15 @@ func OpenRepositoryWithDefaultBranch(name string) OpenRepositoryOption {
 		return nil
 	}
 }
+
+// OpenRepositoryWithCurrentBranch configures the current branch for this repository, instead of
+// detecting the checked out branch. This is required to open a repository with a detached HEAD.
+func OpenRepositoryWithCurrentBranch(name string) OpenRepositoryOption {
+	return func(r *openRepositoryOpts) error {
+		if name == "" {
+			return errors.New("current branch cannot be empty")
+		}
+		r.currentBranch = name
+		return nil
+	}
+}
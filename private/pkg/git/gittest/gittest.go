@@ -48,6 +48,24 @@ func ScaffoldGitRepository(t *testing.T) git.Repository {
 	return repo
 }
 
+// ScaffoldBareGitRepository returns the bare "origin" remote of the repository scaffolded by
+// ScaffoldGitRepository, with the same branches and tags.
+func ScaffoldBareGitRepository(t *testing.T) git.Repository {
+	runner := command.NewRunner()
+	dir := scaffoldGitRepository(t, runner)
+	repo, err := git.OpenRepository(
+		context.Background(),
+		path.Join(path.Dir(dir), "remote"),
+		runner,
+		git.OpenRepositoryWithDefaultBranch(DefaultBranch),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, repo.Close())
+	})
+	return repo
+}
+
 // the resulting Git repo looks like so:
 //
 //	.
@@ -137,6 +155,10 @@ func scaffoldGitRepository(t *testing.T, runner command.Runner) string {
 	runInDir(t, runner, local, "git", "tag", "v3.0")
 	runInDir(t, runner, local, "git", "push", "--follow-tags")
 
+	// (7) add some notes
+	runInDir(t, runner, local, "git", "notes", "add", "-m", "initial note", "release/v1")
+	runInDir(t, runner, local, "git", "notes", "--ref", "review", "add", "-m", "reviewed", "v3.0")
+
 	return local
 }
 
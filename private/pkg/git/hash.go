@@ -19,11 +19,26 @@ import (
 	"fmt"
 )
 
-// hashLength is the length, in bytes, of digests/hashes in object format SHA1
-const hashLength = 20
+const (
+	// sha1HashLength is the length, in bytes, of digests/hashes in object format SHA1
+	sha1HashLength = 20
+	// sha256HashLength is the length, in bytes, of digests/hashes in object format SHA256
+	sha256HashLength = 32
+)
+
+// hashLength returns the length, in bytes, of the digests/hashes of the hash algorithm.
+func (a HashAlgorithm) hashLength() int {
+	if a == HashAlgorithmSHA256 {
+		return sha256HashLength
+	}
+	return sha1HashLength
+}
 
-// hashHexLength is the length, in hexadecimal characters, of digests/hashes in object format SHA1
-var hashHexLength = hex.EncodedLen(hashLength)
+// hashHexLength returns the length, in hexadecimal characters, of the digests/hashes of the hash
+// algorithm.
+func (a HashAlgorithm) hashHexLength() int {
+	return hex.EncodedLen(a.hashLength())
+}
 
 type hash struct {
 	raw []byte
@@ -42,9 +57,16 @@ func (i *hash) String() string {
 	return i.hex
 }
 
+func (i *hash) Algorithm() HashAlgorithm {
+	if len(i.raw) == sha256HashLength {
+		return HashAlgorithmSHA256
+	}
+	return HashAlgorithmSHA1
+}
+
 func newHashFromBytes(data []byte) (*hash, error) {
-	if len(data) != hashLength {
-		return nil, fmt.Errorf("hash is not %d bytes", hashLength)
+	if len(data) != sha1HashLength && len(data) != sha256HashLength {
+		return nil, fmt.Errorf("hash is not %d or %d bytes", sha1HashLength, sha256HashLength)
 	}
 	dst := make([]byte, hex.EncodedLen(len(data)))
 	hex.Encode(dst, data)
@@ -55,8 +77,12 @@ func newHashFromBytes(data []byte) (*hash, error) {
 }
 
 func parseHashFromHex(data string) (*hash, error) {
-	if len(data) != hashHexLength {
-		return nil, fmt.Errorf("hash is not %d characters", hashHexLength)
+	if len(data) != HashAlgorithmSHA1.hashHexLength() && len(data) != HashAlgorithmSHA256.hashHexLength() {
+		return nil, fmt.Errorf(
+			"hash is not %d or %d characters",
+			HashAlgorithmSHA1.hashHexLength(),
+			HashAlgorithmSHA256.hashHexLength(),
+		)
 	}
 	raw, err := hex.DecodeString(data)
 	return &hash{
@@ -30,6 +30,23 @@ func TestParseHashFromHex(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id.Hex(), hex)
 	require.Equal(t, id.Raw(), []byte{0x5e, 0xda, 0xb9, 0xf9, 0x70, 0x91, 0x32, 0x25, 0xf9, 0x85, 0xd9, 0x67, 0x3a, 0xc1, 0x9d, 0x61, 0xd3, 0x6f, 0x9, 0x42})
+	require.Equal(t, id.Algorithm(), HashAlgorithmSHA1)
+}
+
+func TestParseHashFromHexSHA256(t *testing.T) {
+	t.Parallel()
+
+	const hex = "804fde3a78d84cdd3925ce31d95b6fa3a6a430d096678c72a018c73f3660f504"
+
+	id, err := parseHashFromHex(hex)
+
+	require.NoError(t, err)
+	require.Equal(t, id.Hex(), hex)
+	require.Len(t, id.Raw(), 32)
+	require.Equal(t, id.Algorithm(), HashAlgorithmSHA256)
+
+	_, err = parseHashFromHex(hex[:50])
+	require.Error(t, err)
 }
 
 func TestNewHashFromBytes(t *testing.T) {
@@ -42,4 +59,7 @@ func TestNewHashFromBytes(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id.Hex(), "5edab9f970913225f985d9673ac19d61d36f0942")
 	require.Equal(t, id.Raw(), bytes)
+
+	_, err = newHashFromBytes(append(bytes, 0x0))
+	require.Error(t, err)
 }
@@ -22,6 +22,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bufbuild/buf/private/pkg/command"
@@ -38,14 +39,35 @@ const (
 // exitTime is the amount of time we'll wait for git-cat-file(1) to exit.
 var exitTime = 5 * time.Second
 var errObjectTypeMismatch = errors.New("object type mismatch")
+var errBlobReaderClosed = errors.New("blob reader is closed")
 
 type objectReader struct {
-	rx      *bufio.Reader
-	tx      io.WriteCloser
-	process command.Process
+	// mu serializes the requests and the reads of the responses, so the object reader can be used
+	// concurrently, such as when copying a bucket in parallel. Streamed blobs are read from their
+	// own process, and do not hold mu between reads.
+	mu            sync.Mutex
+	rx            *bufio.Reader
+	tx            io.WriteCloser
+	process       command.Process
+	gitDirPath    string
+	hashAlgorithm HashAlgorithm
+	// blobRunner starts the processes streaming blobs. It is not the runner of the object reader
+	// process, which holds one of its slots until the repository is closed, so streams do not wait
+	// on it, and at least one blob can always be streamed.
+	blobRunner command.Runner
 }
 
-func newObjectReader(gitDirPath string, runner command.Runner) (*objectReader, error) {
+func newObjectReader(gitDirPath string, runner command.Runner, hashAlgorithm HashAlgorithm) (*objectReader, error) {
+	objectReader, err := startObjectReader(gitDirPath, runner, hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	objectReader.blobRunner = command.NewRunner()
+	return objectReader, nil
+}
+
+// startObjectReader starts a git-cat-file(1) process with the runner, without a blob runner.
+func startObjectReader(gitDirPath string, runner command.Runner, hashAlgorithm HashAlgorithm) (*objectReader, error) {
 	rx, stdout := io.Pipe()
 	stdin, tx := io.Pipe()
 	process, err := runner.Start(
@@ -61,9 +83,11 @@ func newObjectReader(gitDirPath string, runner command.Runner) (*objectReader, e
 		return nil, err
 	}
 	return &objectReader{
-		rx:      bufio.NewReader(rx),
-		tx:      tx,
-		process: process,
+		rx:            bufio.NewReader(rx),
+		tx:            tx,
+		process:       process,
+		gitDirPath:    gitDirPath,
+		hashAlgorithm: hashAlgorithm,
 	}, nil
 }
 
@@ -83,6 +107,23 @@ func (o *objectReader) Blob(hash Hash) ([]byte, error) {
 	return o.read(objectTypeBlob, hash)
 }
 
+// BlobReader streams the blob from a new git-cat-file(1) process, which exits when the returned
+// reader is closed. Reading other objects, or streaming other blobs, does not interrupt it.
+func (o *objectReader) BlobReader(hash Hash) (io.ReadCloser, error) {
+	blobObjectReader, err := startObjectReader(o.gitDirPath, o.blobRunner, o.hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	objLen, err := blobObjectReader.request(objectTypeBlob, hash)
+	if err != nil {
+		return nil, multierr.Append(err, blobObjectReader.close())
+	}
+	return &blobReader{
+		objectReader: blobObjectReader,
+		content:      io.LimitReader(blobObjectReader.rx, objLen),
+	}, nil
+}
+
 func (o *objectReader) Commit(hash Hash) (Commit, error) {
 	data, err := o.read(objectTypeCommit, hash)
 	if err != nil {
@@ -96,7 +137,7 @@ func (o *objectReader) Tree(hash Hash) (Tree, error) {
 	if err != nil {
 		return nil, err
 	}
-	return parseTree(hash, data)
+	return parseTree(hash, data, o.hashAlgorithm)
 }
 
 func (o *objectReader) Tag(hash Hash) (AnnotatedTag, error) {
@@ -108,57 +149,71 @@ func (o *objectReader) Tag(hash Hash) (AnnotatedTag, error) {
 }
 
 func (o *objectReader) read(objectType string, id Hash) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	objLen, err := o.request(objectType, id)
+	if err != nil {
+		return nil, err
+	}
+	objContent := make([]byte, objLen)
+	if _, err := io.ReadAtLeast(o.rx, objContent, int(objLen)); err != nil {
+		return nil, err
+	}
+	// TODO: We can verify the object content if we move from opaque object IDs
+	// to ones that know about being hardened SHA1 or SHA256.
+	if err := o.readTrailer(); err != nil {
+		return nil, err
+	}
+	return objContent, nil
+}
+
+// request requests the object, and reads the response header. It returns the length of the object
+// content to read from rx, followed by the trailer. It must be called with mu held.
+func (o *objectReader) request(objectType string, id Hash) (int64, error) {
 	// request
 	if _, err := fmt.Fprintf(o.tx, "%s\n", id.Hex()); err != nil {
-		return nil, err
+		return 0, err
 	}
 	// response
 	header, err := o.rx.ReadBytes('\n')
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	headerStr := strings.TrimRight(string(header), "\n")
 	parts := strings.Split(headerStr, " ")
 	if len(parts) == 2 && parts[1] == "missing" {
-		return nil, fmt.Errorf(
+		return 0, fmt.Errorf(
 			"git-cat-file: %s: %w",
 			parts[0],
 			ErrObjectNotFound,
 		)
 	}
 	if len(parts) != 3 {
-		return nil, fmt.Errorf("git-cat-file: malformed header: %q", headerStr)
+		return 0, fmt.Errorf("git-cat-file: malformed header: %q", headerStr)
 	}
 	objID, err := parseHashFromHex(parts[0])
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	if id.Hex() != objID.Hex() {
-		return nil, fmt.Errorf("git-cat-file: mismatched object ID: %s, %s", id.Hex(), objID.Hex())
+		return 0, fmt.Errorf("git-cat-file: mismatched object ID: %s, %s", id.Hex(), objID.Hex())
 	}
 	objType := parts[1]
 	objLenStr := parts[2]
 	objLen, err := strconv.ParseInt(objLenStr, 10, 64)
 	if err != nil {
-		return nil, err
-	}
-	objContent := make([]byte, objLen)
-	if _, err := io.ReadAtLeast(o.rx, objContent, int(objLen)); err != nil {
-		return nil, err
-	}
-	// TODO: We can verify the object content if we move from opaque object IDs
-	// to ones that know about being hardened SHA1 or SHA256.
-	trailer, err := o.rx.ReadBytes('\n')
-	if err != nil {
-		return nil, err
-	}
-	if len(trailer) != 1 {
-		return nil, errors.New("git-cat-file: unexpected trailer")
+		return 0, err
 	}
-	// Check the response type. It's check here to consume the complete request
+	// Check the response type. It's checked after consuming the complete response
 	// first.
 	if objType != objectType {
-		return nil, fmt.Errorf(
+		if _, err := io.CopyN(io.Discard, o.rx, objLen); err != nil {
+			return 0, err
+		}
+		if err := o.readTrailer(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf(
 			"git-cat-file: object %q is a %s, not a %s: %w",
 			id,
 			objType,
@@ -166,5 +221,49 @@ func (o *objectReader) read(objectType string, id Hash) ([]byte, error) {
 			errObjectTypeMismatch,
 		)
 	}
-	return objContent, nil
+	return objLen, nil
+}
+
+func (o *objectReader) readTrailer() error {
+	trailer, err := o.rx.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	if len(trailer) != 1 {
+		return errors.New("git-cat-file: unexpected trailer")
+	}
+	return nil
+}
+
+// blobReader streams the content of a blob from an object reader of its own, until it is closed.
+type blobReader struct {
+	objectReader *objectReader
+	content      io.Reader
+	closed       bool
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	b.objectReader.mu.Lock()
+	defer b.objectReader.mu.Unlock()
+	if b.closed {
+		return 0, errBlobReaderClosed
+	}
+	return b.content.Read(p)
+}
+
+// Close discards the rest of the blob content, and waits for the object reader to exit.
+func (b *blobReader) Close() error {
+	b.objectReader.mu.Lock()
+	defer b.objectReader.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if _, err := io.Copy(io.Discard, b.content); err != nil {
+		return multierr.Append(err, b.objectReader.close())
+	}
+	return multierr.Combine(
+		b.objectReader.readTrailer(),
+		b.objectReader.close(),
+	)
 }
@@ -28,18 +28,23 @@ const (
 	packedRefsHeader      = "# pack-refs with: peeled fully-peeled sorted "
 	tagRefPrefix          = "refs/tags/"
 	originBranchRefPrefix = "refs/remotes/origin/"
+	localBranchRefPrefix  = "refs/heads/"
 	unpeeledRefPrefix     = '^'
 )
 
-// parsePackedRefs reads a `packed-refs` file, returning the packed branches and tags
-func parsePackedRefs(data []byte) (
+// parsePackedRefs reads a `packed-refs` file, returning the packed branches, tags, and all refs. Only
+// branch refs with the passed prefix are returned as branches. All refs are returned with their full
+// name, and the hash they point to, which is the tag object for annotated tags.
+func parsePackedRefs(data []byte, branchRefPrefix string) (
 	map[string]Hash, // branches
 	map[string]Hash, // tags
+	map[string]Hash, // refs
 	error,
 ) {
 	var (
 		packedBranches = map[string]Hash{}
 		packedTags     = map[string]Hash{}
+		packedRefs     = map[string]Hash{}
 	)
 	/*
 		data is in the format
@@ -57,7 +62,7 @@ func parsePackedRefs(data []byte) (
 		lines = append(lines, scanner.Text())
 	}
 	if scanner.Err() != nil {
-		return nil, nil, scanner.Err()
+		return nil, nil, nil, scanner.Err()
 	}
 	for i := 0; i < len(lines); i++ {
 		line := lines[i]
@@ -68,20 +73,21 @@ func parsePackedRefs(data []byte) (
 			//
 			// The comment should match `packedRefsHeader`. We can safely skip this comment if so.
 			if line != packedRefsHeader {
-				return nil, nil, fmt.Errorf("unknown packed-refs header: %q", line)
+				return nil, nil, nil, fmt.Errorf("unknown packed-refs header: %q", line)
 			}
 			continue
 		}
 		hashHex, ref, found := strings.Cut(line, " ")
 		if !found {
-			return nil, nil, errors.New("invalid packed-refs file")
+			return nil, nil, nil, errors.New("invalid packed-refs file")
 		}
 		hash, err := parseHashFromHex(hashHex)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
-		if strings.HasPrefix(ref, originBranchRefPrefix) {
-			branchName := strings.TrimPrefix(ref, originBranchRefPrefix)
+		packedRefs[ref] = hash
+		if strings.HasPrefix(ref, branchRefPrefix) {
+			branchName := strings.TrimPrefix(ref, branchRefPrefix)
 			packedBranches[branchName] = hash
 		} else if strings.HasPrefix(ref, tagRefPrefix) {
 			tagName := strings.TrimPrefix(ref, tagRefPrefix)
@@ -95,12 +101,12 @@ func parsePackedRefs(data []byte) (
 				nextLine = strings.TrimPrefix(nextLine, string(unpeeledRefPrefix))
 				hash, err = parseHashFromHex(nextLine)
 				if err != nil {
-					return nil, nil, err
+					return nil, nil, nil, err
 				}
 			}
 			packedTags[tagName] = hash
 		}
 		// We ignore all kinds of refs.
 	}
-	return packedBranches, packedTags, nil
+	return packedBranches, packedTags, packedRefs, nil
 }
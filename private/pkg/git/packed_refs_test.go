@@ -29,7 +29,7 @@ func TestReadPackedRefs(t *testing.T) {
 	allBytes, err := os.ReadFile(path.Join("testdata", "packed-refs"))
 	require.NoError(t, err)
 
-	branches, tags, err := parsePackedRefs(allBytes)
+	branches, tags, refs, err := parsePackedRefs(allBytes, originBranchRefPrefix)
 
 	require.NoError(t, err)
 	hexBranches := map[string]string{}
@@ -49,4 +49,17 @@ func TestReadPackedRefs(t *testing.T) {
 		"v0.2.0":  "ace9301f315979bd053b7658c017391fe1af8804",
 		"v1.10.0": "ebb191e8268db7cee389e3abb0d1edc1852337a3",
 	})
+	hexRefs := map[string]string{}
+	for ref, hash := range refs {
+		hexRefs[ref] = hash.Hex()
+	}
+	assert.Equal(t, hexRefs, map[string]string{
+		"refs/remotes/origin/main":              "45c2edc61040013349e094663e492996e0c044e3",
+		"refs/remotes/origin/paralleltest":      "1fddd89116e24df213d43b7d837f5dd29ee9cbf0",
+		"refs/remotes/otherorigin/main":         "27523d9000238e0f7fb35d6052d10016852beee3",
+		"refs/remotes/otherorigin/paralleltest": "959e716b38b179bd5a4e7edfc549db2e30df3c8e",
+		"refs/tags/v0.1.0":                      "4acbbca27c6d7bc0f4027c1897f89da140789e55",
+		"refs/tags/v1.10.0":                     "ebb191e8268db7cee389e3abb0d1edc1852337a3",
+		"refs/tags/v0.2.0":                      "170e69af5a7a768c5d3be15e4734919ea051188d",
+	})
 }
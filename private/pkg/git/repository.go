@@ -24,6 +24,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/bufbuild/buf/private/pkg/command"
@@ -33,23 +34,32 @@ import (
 
 const defaultRemoteName = "origin"
 
-var defaultBranchRefPrefix = []byte("ref: refs/remotes/" + defaultRemoteName + "/")
+var (
+	defaultBranchRefPrefix     = []byte("ref: " + originBranchRefPrefix)
+	bareDefaultBranchRefPrefix = []byte("ref: " + localBranchRefPrefix)
+)
 
 type openRepositoryOpts struct {
 	defaultBranch string
+	currentBranch string
 }
 
 type repository struct {
 	gitDirPath       string
 	defaultBranch    string
 	checkedOutBranch string
+	hashAlgorithm    HashAlgorithm
 	objectReader     *objectReader
+	// branchRefPrefix is the prefix of the refs considered branches, which are remote branches in
+	// regular repositories, and local branches in bare repositories.
+	branchRefPrefix string
 
 	// packedOnce controls the fields below related to reading the `packed-refs` file
 	packedOnce      sync.Once
 	packedReadError error
 	packedBranches  map[string]Hash
 	packedTags      map[string]Hash
+	packedRefs      map[string]Hash
 }
 
 func openGitRepository(
@@ -72,25 +82,61 @@ func openGitRepository(
 	if err != nil {
 		return nil, err
 	}
-	reader, err := newObjectReader(gitDirPath, runner)
+	if err := validateDirPathExists(filepath.Join(gitDirPath, "objects")); err != nil {
+		return nil, fmt.Errorf("%s is not a git directory, no object store found: %w", gitDirPath, err)
+	}
+	isBare, err := detectIsBareRepository(ctx, gitDirPath, runner)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("automatically determine if repository is bare: %w", err)
 	}
-	if opts.defaultBranch == "" {
-		opts.defaultBranch, err = detectDefaultBranch(gitDirPath)
-		if err != nil {
-			return nil, fmt.Errorf("automatically determine default branch: %w", err)
-		}
+	hashAlgorithm, err := detectHashAlgorithm(ctx, gitDirPath, runner)
+	if err != nil {
+		return nil, fmt.Errorf("automatically determine object format: %w", err)
 	}
-	checkedOutBranch, err := detectCheckedOutBranch(ctx, gitDirPath, runner)
+	reader, err := newObjectReader(gitDirPath, runner, hashAlgorithm)
 	if err != nil {
-		return nil, fmt.Errorf("automatically determine checked out branch: %w", err)
+		return nil, err
+	}
+	var (
+		checkedOutBranch string
+		branchRefPrefix  = originBranchRefPrefix
+	)
+	if isBare {
+		// Bare repositories have no remotes nor checked out branch, their local branches are the
+		// ones pushed or mirrored, and their HEAD points to the default branch.
+		branchRefPrefix = localBranchRefPrefix
+		if opts.defaultBranch == "" {
+			opts.defaultBranch, err = detectBareDefaultBranch(gitDirPath)
+			if err != nil {
+				return nil, fmt.Errorf("automatically determine default branch: %w", err)
+			}
+		}
+		checkedOutBranch = opts.defaultBranch
+		if opts.currentBranch != "" {
+			checkedOutBranch = opts.currentBranch
+		}
+	} else {
+		if opts.defaultBranch == "" {
+			opts.defaultBranch, err = detectDefaultBranch(gitDirPath)
+			if err != nil {
+				return nil, fmt.Errorf("automatically determine default branch: %w", err)
+			}
+		}
+		checkedOutBranch = opts.currentBranch
+		if checkedOutBranch == "" {
+			checkedOutBranch, err = detectCheckedOutBranch(ctx, gitDirPath, runner)
+			if err != nil {
+				return nil, fmt.Errorf("automatically determine checked out branch: %w", err)
+			}
+		}
 	}
 	return &repository{
 		gitDirPath:       gitDirPath,
 		defaultBranch:    opts.defaultBranch,
 		checkedOutBranch: checkedOutBranch,
+		hashAlgorithm:    hashAlgorithm,
 		objectReader:     reader,
+		branchRefPrefix:  branchRefPrefix,
 	}, nil
 }
 
@@ -105,7 +151,7 @@ func (r *repository) Objects() ObjectReader {
 func (r *repository) ForEachBranch(f func(string, Hash) error) error {
 	seen := map[string]struct{}{}
 	// Read unpacked branch refs.
-	dir := path.Join(r.gitDirPath, "refs", "remotes", defaultRemoteName)
+	dir := path.Join(r.gitDirPath, r.branchRefPrefix)
 	if err := filepathextended.Walk(dir, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -146,6 +192,10 @@ func (r *repository) ForEachBranch(f func(string, Hash) error) error {
 	return nil
 }
 
+func (r *repository) HashAlgorithm() HashAlgorithm {
+	return r.hashAlgorithm
+}
+
 func (r *repository) DefaultBranch() string {
 	return r.defaultBranch
 }
@@ -246,9 +296,135 @@ func (r *repository) ForEachTag(f func(string, Hash) error) error {
 	return nil
 }
 
+func (r *repository) ForEachRef(f func(string, Hash) error) error {
+	seen := map[string]struct{}{}
+	// Read unpacked refs.
+	dir := path.Join(r.gitDirPath, "refs")
+	if err := filepathextended.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		refName, err := filepath.Rel(r.gitDirPath, path)
+		if err != nil {
+			return err
+		}
+		refName = normalpath.Normalize(refName)
+		if strings.HasSuffix(refName, "/HEAD") {
+			// symbolic refs, like `refs/remotes/origin/HEAD`
+			return nil
+		}
+		hashBytes, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		hashBytes = bytes.TrimSuffix(hashBytes, []byte{'\n'})
+		hash, err := parseHashFromHex(string(hashBytes))
+		if err != nil {
+			return fmt.Errorf("parse ref %q: %w", refName, err)
+		}
+		seen[refName] = struct{}{}
+		return f(refName, hash)
+	}); err != nil {
+		return err
+	}
+	// Read packed refs that haven't been seen yet.
+	if err := r.readPackedRefs(); err != nil {
+		return err
+	}
+	for refName, hash := range r.packedRefs {
+		if _, found := seen[refName]; !found {
+			if err := f(refName, hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *repository) ForEachNote(notesRef string, f func(Hash, string) error) error {
+	notesCommitHash, err := r.readRef(notesRef)
+	if err != nil {
+		return err
+	}
+	if notesCommitHash == nil {
+		// no notes written in this ref
+		return nil
+	}
+	notesCommit, err := r.objectReader.Commit(notesCommitHash)
+	if err != nil {
+		return fmt.Errorf("read notes commit %q: %w", notesCommitHash, err)
+	}
+	return r.forEachNoteInTree(notesCommit.Tree(), "", f)
+}
+
+// forEachNoteInTree ranges over the notes in a notes tree. Notes are blobs named after the hex of
+// the annotated object, and may be nested in fanout directories named after the first characters
+// of the hex, like `ab/cdef...`.
+func (r *repository) forEachNoteInTree(treeHash Hash, hexPrefix string, f func(Hash, string) error) error {
+	tree, err := r.objectReader.Tree(treeHash)
+	if err != nil {
+		return fmt.Errorf("read notes tree %q: %w", treeHash, err)
+	}
+	for _, node := range tree.Nodes() {
+		objectHex := hexPrefix + node.Name()
+		if node.Mode() == ModeDir {
+			if len(objectHex) >= r.hashAlgorithm.hashHexLength() {
+				continue
+			}
+			if err := r.forEachNoteInTree(node.Hash(), objectHex, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(objectHex) != r.hashAlgorithm.hashHexLength() {
+			// not a note, trees in notes refs may hold other files
+			continue
+		}
+		objectHash, err := parseHashFromHex(objectHex)
+		if err != nil {
+			continue
+		}
+		note, err := r.objectReader.Blob(node.Hash())
+		if err != nil {
+			return fmt.Errorf("read note for object %q: %w", objectHex, err)
+		}
+		if err := f(objectHash, string(note)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRef reads the hash a full ref name points to, either unpacked or packed. It returns a nil
+// hash if the ref does not exist.
+func (r *repository) readRef(refName string) (Hash, error) {
+	hashBytes, err := os.ReadFile(path.Join(r.gitDirPath, refName))
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := r.readPackedRefs(); err != nil {
+			return nil, err
+		}
+		if hash, ok := r.packedRefs[refName]; ok {
+			return hash, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hashBytes = bytes.TrimSuffix(hashBytes, []byte{'\n'})
+	hash, err := parseHashFromHex(string(hashBytes))
+	if err != nil {
+		return nil, fmt.Errorf("parse ref %q: %w", refName, err)
+	}
+	return hash, nil
+}
+
 // HEADCommit resolves the HEAD commit from branch name if its present in the "origin" remote.
 func (r *repository) HEADCommit(branch string) (Commit, error) {
-	commitBytes, err := os.ReadFile(path.Join(r.gitDirPath, "refs", "remotes", defaultRemoteName, branch))
+	commitBytes, err := os.ReadFile(path.Join(r.gitDirPath, r.branchRefPrefix, branch))
 	if errors.Is(err, fs.ErrNotExist) {
 		// it may be that the branch ref is packed; let's read the packed refs
 		if err := r.readPackedRefs(); err != nil {
@@ -285,6 +461,7 @@ func (r *repository) readPackedRefs() error {
 			if errors.Is(err, os.ErrNotExist) {
 				r.packedBranches = map[string]Hash{}
 				r.packedTags = map[string]Hash{}
+				r.packedRefs = map[string]Hash{}
 				return
 			}
 			r.packedReadError = err
@@ -295,7 +472,7 @@ func (r *repository) readPackedRefs() error {
 			r.packedReadError = err
 			return
 		}
-		r.packedBranches, r.packedTags, r.packedReadError = parsePackedRefs(allBytes)
+		r.packedBranches, r.packedTags, r.packedRefs, r.packedReadError = parsePackedRefs(allBytes, r.branchRefPrefix)
 	})
 	return r.packedReadError
 }
@@ -314,6 +491,71 @@ func detectDefaultBranch(gitDirPath string) (string, error) {
 	return string(data), nil
 }
 
+func detectBareDefaultBranch(gitDirPath string) (string, error) {
+	path := path.Join(gitDirPath, "HEAD")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, bareDefaultBranchRefPrefix) {
+		return "", errors.New("invalid contents in " + path)
+	}
+	data = bytes.TrimPrefix(data, bareDefaultBranchRefPrefix)
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	return string(data), nil
+}
+
+func detectIsBareRepository(ctx context.Context, gitDirPath string, runner command.Runner) (bool, error) {
+	var (
+		stdOutBuffer = bytes.NewBuffer(nil)
+		stdErrBuffer = bytes.NewBuffer(nil)
+	)
+	if err := runner.Run(
+		ctx,
+		"git",
+		command.RunWithArgs(
+			"rev-parse",
+			"--is-bare-repository",
+		),
+		command.RunWithStdout(stdOutBuffer),
+		command.RunWithStderr(stdErrBuffer),
+		command.RunWithDir(gitDirPath),
+	); err != nil {
+		return false, fmt.Errorf("git rev-parse: %w (%s)", err, stdErrBuffer.String())
+	}
+	return string(bytes.TrimSuffix(stdOutBuffer.Bytes(), []byte("\n"))) == "true", nil
+}
+
+// detectHashAlgorithm detects the hash algorithm of the repository object format. Versions of git
+// without SHA-256 support echo back the unknown flag, in which case the object format is SHA-1.
+func detectHashAlgorithm(ctx context.Context, gitDirPath string, runner command.Runner) (HashAlgorithm, error) {
+	var (
+		stdOutBuffer = bytes.NewBuffer(nil)
+		stdErrBuffer = bytes.NewBuffer(nil)
+	)
+	if err := runner.Run(
+		ctx,
+		"git",
+		command.RunWithArgs(
+			"rev-parse",
+			"--show-object-format",
+		),
+		command.RunWithStdout(stdOutBuffer),
+		command.RunWithStderr(stdErrBuffer),
+		command.RunWithDir(gitDirPath),
+	); err != nil {
+		return 0, fmt.Errorf("git rev-parse: %w (%s)", err, stdErrBuffer.String())
+	}
+	switch objectFormat := string(bytes.TrimSuffix(stdOutBuffer.Bytes(), []byte("\n"))); objectFormat {
+	case HashAlgorithmSHA1.String(), "--show-object-format":
+		return HashAlgorithmSHA1, nil
+	case HashAlgorithmSHA256.String():
+		return HashAlgorithmSHA256, nil
+	default:
+		return 0, fmt.Errorf("unsupported object format %q", objectFormat)
+	}
+}
+
 func detectCheckedOutBranch(ctx context.Context, gitDirPath string, runner command.Runner) (string, error) {
 	var (
 		stdOutBuffer = bytes.NewBuffer(nil)
@@ -346,7 +588,7 @@ func detectCheckedOutBranch(ctx context.Context, gitDirPath string, runner comma
 		return "", errors.New("empty current branch")
 	}
 	if currentBranch == "HEAD" {
-		return "", errors.New("no current branch, git HEAD is detached")
+		return "", ErrDetachedHEAD
 	}
 	return currentBranch, nil
 }
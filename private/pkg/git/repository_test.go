@@ -15,8 +15,17 @@
 package git_test
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/bufbuild/buf/private/pkg/command"
 	"github.com/bufbuild/buf/private/pkg/git"
 	"github.com/bufbuild/buf/private/pkg/git/gittest"
 	"github.com/stretchr/testify/assert"
@@ -81,6 +90,107 @@ func TestCommits(t *testing.T) {
 	assert.Empty(t, commits[2].Parents())
 }
 
+func TestBlobReader(t *testing.T) {
+	t.Parallel()
+
+	repo := gittest.ScaffoldGitRepository(t)
+	headCommit, err := repo.HEADCommit(gittest.DefaultBranch)
+	require.NoError(t, err)
+	tree, err := repo.Objects().Tree(headCommit.Tree())
+	require.NoError(t, err)
+	bufYAMLNode, err := tree.Descendant("proto/buf.yaml", repo.Objects())
+	require.NoError(t, err)
+	randomBinaryNode, err := tree.Descendant("randomBinary", repo.Objects())
+	require.NoError(t, err)
+
+	reader, err := repo.Objects().BlobReader(bufYAMLNode.Hash())
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "some buf.yaml", string(data))
+	require.NoError(t, reader.Close())
+	data, err = repo.Objects().Blob(randomBinaryNode.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "some executable", string(data))
+
+	// reading other objects does not interrupt an open blob
+	reader, err = repo.Objects().BlobReader(bufYAMLNode.Hash())
+	require.NoError(t, err)
+	partialData := make([]byte, 4)
+	_, err = io.ReadFull(reader, partialData)
+	require.NoError(t, err)
+	assert.Equal(t, "some", string(partialData))
+	commit, err := repo.Objects().Commit(headCommit.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "third commit", commit.Message())
+	otherReader, err := repo.Objects().BlobReader(randomBinaryNode.Hash())
+	require.NoError(t, err)
+	data, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, " buf.yaml", string(data))
+	data, err = io.ReadAll(otherReader)
+	require.NoError(t, err)
+	assert.Equal(t, "some executable", string(data))
+	assert.NoError(t, otherReader.Close())
+	assert.NoError(t, reader.Close())
+	_, err = reader.Read(partialData)
+	assert.Error(t, err)
+
+	// a partially read blob can be closed
+	reader, err = repo.Objects().BlobReader(bufYAMLNode.Hash())
+	require.NoError(t, err)
+	_, err = io.ReadFull(reader, partialData)
+	require.NoError(t, err)
+	assert.NoError(t, reader.Close())
+
+	// a type mismatch consumes the object, so the next objects can be read
+	_, err = repo.Objects().BlobReader(headCommit.Tree())
+	assert.Error(t, err)
+	data, err = repo.Objects().Blob(randomBinaryNode.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "some executable", string(data))
+}
+
+func TestConcurrentObjectReads(t *testing.T) {
+	t.Parallel()
+
+	repo := gittest.ScaffoldGitRepository(t)
+	headCommit, err := repo.HEADCommit(gittest.DefaultBranch)
+	require.NoError(t, err)
+	tree, err := repo.Objects().Tree(headCommit.Tree())
+	require.NoError(t, err)
+	bufYAMLNode, err := tree.Descendant("proto/buf.yaml", repo.Objects())
+	require.NoError(t, err)
+	randomBinaryNode, err := tree.Descendant("randomBinary", repo.Objects())
+	require.NoError(t, err)
+	var waitGroup sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		waitGroup.Add(2)
+		go func() {
+			defer waitGroup.Done()
+			data, err := repo.Objects().Blob(bufYAMLNode.Hash())
+			if err == nil && string(data) != "some buf.yaml" {
+				err = fmt.Errorf("unexpected buf.yaml content %q", string(data))
+			}
+			errs <- err
+		}()
+		go func() {
+			defer waitGroup.Done()
+			data, err := repo.Objects().Blob(randomBinaryNode.Hash())
+			if err == nil && string(data) != "some executable" {
+				err = fmt.Errorf("unexpected randomBinary content %q", string(data))
+			}
+			errs <- err
+		}()
+	}
+	waitGroup.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
 func TestBranches(t *testing.T) {
 	t.Parallel()
 
@@ -118,3 +228,267 @@ func TestBranches(t *testing.T) {
 		"smian/branch2",
 	})
 }
+
+func TestBareRepository(t *testing.T) {
+	t.Parallel()
+
+	repo := gittest.ScaffoldBareGitRepository(t)
+	assert.Equal(t, gittest.DefaultBranch, repo.DefaultBranch())
+	assert.Equal(t, gittest.DefaultBranch, repo.CurrentBranch())
+
+	var branches []string
+	err := repo.ForEachBranch(func(branch string, headHash git.Hash) error {
+		branches = append(branches, branch)
+		headCommit, err := repo.HEADCommit(branch)
+		require.NoError(t, err)
+		assert.Equal(t, headHash, headCommit.Hash())
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, branches, []string{
+		"master",
+		"smian/branch1",
+		"smian/branch2",
+	})
+
+	var commits []git.Commit
+	err = repo.ForEachCommit(gittest.DefaultBranch, func(c git.Commit) error {
+		commits = append(commits, c)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, commits, 3)
+	assert.Equal(t, commits[0].Message(), "third commit")
+
+	var tags []string
+	err = repo.ForEachTag(func(tag string, _ git.Hash) error {
+		tags = append(tags, tag)
+		return nil
+	})
+	require.NoError(t, err)
+	// only annotated tags are pushed with --follow-tags
+	require.ElementsMatch(t, tags, []string{
+		"branch/v1",
+		"branch/v2",
+	})
+}
+
+func TestOpenRepositoryWithoutObjects(t *testing.T) {
+	t.Parallel()
+
+	_, err := git.OpenRepository(context.Background(), t.TempDir(), command.NewRunner())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a git directory")
+}
+
+func TestRefs(t *testing.T) {
+	t.Parallel()
+
+	repo := gittest.ScaffoldGitRepository(t)
+	refs := make(map[string]git.Hash)
+	err := repo.ForEachRef(func(ref string, hash git.Hash) error {
+		refs[ref] = hash
+		return nil
+	})
+	require.NoError(t, err)
+	for _, expectedRef := range []string{
+		"refs/heads/master",
+		"refs/heads/smian/branch1",
+		"refs/remotes/origin/master",
+		"refs/remotes/origin/smian/branch2",
+		"refs/tags/release/v1",
+		"refs/tags/v3.0",
+	} {
+		assert.Contains(t, refs, expectedRef)
+	}
+	assert.NotContains(t, refs, "refs/remotes/origin/HEAD")
+	masterHead, err := repo.HEADCommit(gittest.DefaultBranch)
+	require.NoError(t, err)
+	assert.Equal(t, masterHead.Hash(), refs["refs/remotes/origin/master"])
+}
+
+func TestNotes(t *testing.T) {
+	t.Parallel()
+
+	repo := gittest.ScaffoldGitRepository(t)
+	tags := make(map[string]git.Hash)
+	err := repo.ForEachTag(func(tag string, hash git.Hash) error {
+		tags[tag] = hash
+		return nil
+	})
+	require.NoError(t, err)
+	for notesRef, expectedNotes := range map[string]map[string]string{
+		"refs/notes/commits": {
+			tags["release/v1"].Hex(): "initial note\n",
+		},
+		"refs/notes/review": {
+			tags["v3.0"].Hex(): "reviewed\n",
+		},
+		"refs/notes/unknown": {},
+	} {
+		notes := make(map[string]string)
+		err := repo.ForEachNote(notesRef, func(commitHash git.Hash, note string) error {
+			notes[commitHash.Hex()] = note
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, expectedNotes, notes, notesRef)
+	}
+}
+
+func TestSHA256Repository(t *testing.T) {
+	t.Parallel()
+
+	runner := command.NewRunner()
+	dir := t.TempDir()
+	runGit := func(args ...string) string {
+		stdout := bytes.NewBuffer(nil)
+		require.NoError(t, runner.Run(
+			context.Background(),
+			"git",
+			command.RunWithArgs(append([]string{"-c", "user.name=Buf TestBot", "-c", "user.email=testbot@buf.build"}, args...)...),
+			command.RunWithDir(dir),
+			command.RunWithStdout(stdout),
+		))
+		return strings.TrimSpace(stdout.String())
+	}
+	runGit("init", "--object-format=sha256", "--initial-branch", "main")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "proto"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "proto", "a.proto"), []byte("syntax = \"proto3\";\n"), 0600))
+	runGit("add", "-A")
+	runGit("commit", "-m", "initial commit")
+	runGit("notes", "add", "-m", "initial note")
+	headHex := runGit("rev-parse", "HEAD")
+	require.Len(t, headHex, 64)
+	runGit("pack-refs", "--all")
+	repo, err := git.OpenRepository(
+		context.Background(),
+		filepath.Join(dir, git.DotGitDir),
+		runner,
+		git.OpenRepositoryWithDefaultBranch("main"),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, repo.Close())
+	})
+	assert.Equal(t, git.HashAlgorithmSHA256, repo.HashAlgorithm())
+
+	headHash, err := git.NewHashFromHex(headHex)
+	require.NoError(t, err)
+	assert.Equal(t, git.HashAlgorithmSHA256, headHash.Algorithm())
+	commit, err := repo.Objects().Commit(headHash)
+	require.NoError(t, err)
+	assert.Equal(t, "initial commit", commit.Message())
+	assert.Equal(t, git.HashAlgorithmSHA256, commit.Tree().Algorithm())
+	tree, err := repo.Objects().Tree(commit.Tree())
+	require.NoError(t, err)
+	node, err := tree.Descendant("proto/a.proto", repo.Objects())
+	require.NoError(t, err)
+	blob, err := repo.Objects().Blob(node.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "syntax = \"proto3\";\n", string(blob))
+
+	refs := make(map[string]git.Hash)
+	require.NoError(t, repo.ForEachRef(func(ref string, hash git.Hash) error {
+		refs[ref] = hash
+		return nil
+	}))
+	assert.Equal(t, headHash, refs["refs/heads/main"])
+	notes := make(map[string]string)
+	require.NoError(t, repo.ForEachNote("refs/notes/commits", func(commitHash git.Hash, note string) error {
+		notes[commitHash.Hex()] = note
+		return nil
+	}))
+	assert.Equal(t, map[string]string{headHex: "initial note\n"}, notes)
+}
+
+func TestListUncommittedChanges(t *testing.T) {
+	t.Parallel()
+
+	runner := command.NewRunner()
+	dir := t.TempDir()
+	runGit := func(args ...string) {
+		stderr := bytes.NewBuffer(nil)
+		require.NoError(t, runner.Run(
+			context.Background(),
+			"git",
+			command.RunWithArgs(append([]string{"-c", "user.name=Buf TestBot", "-c", "user.email=testbot@buf.build"}, args...)...),
+			command.RunWithDir(dir),
+			command.RunWithStderr(stderr),
+		), stderr.String())
+	}
+	writeFile := func(path string, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0600))
+	}
+	listUncommittedChanges := func() []string {
+		changes, err := git.ListUncommittedChanges(context.Background(), filepath.Join(dir, git.DotGitDir), runner)
+		require.NoError(t, err)
+		return changes
+	}
+	runGit("init", "--initial-branch", "main")
+	writeFile(".gitignore", "*.bin\n")
+	writeFile("proto/a.proto", "syntax = \"proto3\";\n")
+	writeFile("proto/b.proto", "syntax = \"proto3\";\n")
+	runGit("add", "-A")
+	runGit("commit", "-m", "initial commit")
+	// not running in parallel, the subtests share the same repository
+	t.Run("clean", func(t *testing.T) {
+		writeFile("ignored.bin", "ignored")
+		assert.Empty(t, listUncommittedChanges())
+	})
+	t.Run("dirty", func(t *testing.T) {
+		writeFile("proto/a.proto", "syntax = \"proto2\";\n")
+		writeFile("proto/c.proto", "syntax = \"proto3\";\n")
+		runGit("mv", "proto/b.proto", "proto/d.proto")
+		assert.ElementsMatch(t, []string{"proto/a.proto", "proto/c.proto", "proto/d.proto"}, listUncommittedChanges())
+	})
+	t.Run("bare", func(t *testing.T) {
+		bareDir := filepath.Join(t.TempDir(), "bare")
+		runGit("clone", "--bare", dir, bareDir)
+		changes, err := git.ListUncommittedChanges(context.Background(), bareDir, runner)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+	})
+}
+
+func TestOpenRepositoryDetachedHEAD(t *testing.T) {
+	t.Parallel()
+
+	runner := command.NewRunner()
+	dir := t.TempDir()
+	runGit := func(args ...string) {
+		stderr := bytes.NewBuffer(nil)
+		require.NoError(t, runner.Run(
+			context.Background(),
+			"git",
+			command.RunWithArgs(append([]string{"-c", "user.name=Buf TestBot", "-c", "user.email=testbot@buf.build"}, args...)...),
+			command.RunWithDir(dir),
+			command.RunWithStderr(stderr),
+		), stderr.String())
+	}
+	runGit("init", "--initial-branch", "main")
+	runGit("commit", "--allow-empty", "-m", "initial commit")
+	runGit("checkout", "--detach")
+	gitDirPath := filepath.Join(dir, git.DotGitDir)
+
+	_, err := git.OpenRepository(
+		context.Background(),
+		gitDirPath,
+		runner,
+		git.OpenRepositoryWithDefaultBranch("main"),
+	)
+	require.ErrorIs(t, err, git.ErrDetachedHEAD)
+	repo, err := git.OpenRepository(
+		context.Background(),
+		gitDirPath,
+		runner,
+		git.OpenRepositoryWithDefaultBranch("main"),
+		git.OpenRepositoryWithCurrentBranch("release"),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, repo.Close())
+	})
+	assert.Equal(t, "release", repo.CurrentBranch())
+}
@@ -0,0 +1,83 @@
+// Copyright 2020-2023 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bufbuild/buf/private/pkg/command"
+	"github.com/bufbuild/buf/private/pkg/normalpath"
+)
+
+func listUncommittedChanges(ctx context.Context, gitDirPath string, runner command.Runner) ([]string, error) {
+	gitDirPath, err := filepath.Abs(normalpath.Unnormalize(gitDirPath))
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDirPathExists(gitDirPath); err != nil {
+		return nil, err
+	}
+	isBare, err := detectIsBareRepository(ctx, gitDirPath, runner)
+	if err != nil {
+		return nil, fmt.Errorf("automatically determine if repository is bare: %w", err)
+	}
+	if isBare {
+		return nil, nil
+	}
+	var (
+		stdOutBuffer = bytes.NewBuffer(nil)
+		stdErrBuffer = bytes.NewBuffer(nil)
+	)
+	if err := runner.Run(
+		ctx,
+		"git",
+		command.RunWithArgs(
+			"status",
+			"--porcelain",
+			"-z",
+		),
+		command.RunWithStdout(stdOutBuffer),
+		command.RunWithStderr(stdErrBuffer),
+		command.RunWithDir(filepath.Dir(gitDirPath)), // exec command at the root of the working tree
+	); err != nil {
+		return nil, fmt.Errorf("git status: %w (%s)", err, stdErrBuffer.String())
+	}
+	return parseStatusPorcelain(stdOutBuffer.Bytes())
+}
+
+// parseStatusPorcelain parses the paths out of the NUL-terminated entries of
+// `git status --porcelain -z`, in the format `XY <path>`. Renamed and copied entries are followed by
+// an extra entry with their original path, which is skipped.
+func parseStatusPorcelain(output []byte) ([]string, error) {
+	var paths []string
+	entries := bytes.Split(bytes.TrimSuffix(output, []byte{0}), []byte{0})
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) == 0 {
+			continue
+		}
+		if len(entry) < 4 || entry[2] != ' ' {
+			return nil, fmt.Errorf("malformed git status entry %q", string(entry))
+		}
+		paths = append(paths, string(entry[3:]))
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+	return paths, nil
+}
@@ -27,7 +27,7 @@ type tree struct {
 	nodes []TreeNode
 }
 
-func parseTree(hash Hash, data []byte) (*tree, error) {
+func parseTree(hash Hash, data []byte, hashAlgorithm HashAlgorithm) (*tree, error) {
 	t := &tree{
 		hash: hash,
 	}
@@ -44,7 +44,7 @@ func parseTree(hash Hash, data []byte) (*tree, error) {
 		if i == -1 {
 			return nil, errors.New("parse tree")
 		}
-		length := i + 1 + hashLength
+		length := i + 1 + hashAlgorithm.hashLength()
 		node, err := parseTreeNode(data[:length])
 		if err != nil {
 			return nil, fmt.Errorf("parse tree: %w", err)
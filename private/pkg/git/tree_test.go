@@ -41,7 +41,7 @@ func TestParseTree(t *testing.T) {
 	hash, err := parseHashFromHex("43848150a6f5f6d76eeef6e0f69eb46290eefab6")
 	require.NoError(t, err)
 
-	tree, err := parseTree(hash, bytes)
+	tree, err := parseTree(hash, bytes, HashAlgorithmSHA1)
 
 	assert.NoError(t, err)
 	assert.Equal(t, tree.Hash(), hash)
@@ -56,3 +56,34 @@ func TestParseTree(t *testing.T) {
 	assert.Equal(t, tree.Nodes()[2].Name(), "c")
 	assert.Equal(t, tree.Nodes()[2].Mode(), ModeDir)
 }
+
+func TestParseTreeSHA256(t *testing.T) {
+	t.Parallel()
+
+	/*
+		This is generated using the following procedure:
+		```sh
+		➜ git init --object-format=sha256
+		➜ touch a.proto
+		➜ mkdir c && touch c/d.proto
+		➜ git add * && git commit -m 'initial commit'
+		```
+		Then simply `git cat-file` the tree at HEAD and encode to base64.
+	*/
+	bytes, err := base64.StdEncoding.DecodeString("MTAwNjQ0IGEucHJvdG8ARzoPTDvoqTaBomfjsemn3NoRhUNv4UH3dJEgowNyGBM0MDAwMCBjAHq6I0TpwQC2O25Q09hUS8uVOtDZYl79aNoVrm4r7BQM")
+	require.NoError(t, err)
+	hash, err := parseHashFromHex("804fde3a78d84cdd3925ce31d95b6fa3a6a430d096678c72a018c73f3660f504")
+	require.NoError(t, err)
+
+	tree, err := parseTree(hash, bytes, HashAlgorithmSHA256)
+
+	assert.NoError(t, err)
+	assert.Equal(t, tree.Hash(), hash)
+	assert.Len(t, tree.Nodes(), 2)
+	assert.Equal(t, tree.Nodes()[0].Hash().Hex(), "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813")
+	assert.Equal(t, tree.Nodes()[0].Name(), "a.proto")
+	assert.Equal(t, tree.Nodes()[0].Mode(), ModeFile)
+	assert.Equal(t, tree.Nodes()[1].Hash().Hex(), "7aba2344e9c100b63b6e50d3d8544bcb953ad0d9625efd68da15ae6e2bec140c")
+	assert.Equal(t, tree.Nodes()[1].Name(), "c")
+	assert.Equal(t, tree.Nodes()[1].Mode(), ModeDir)
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	"go.uber.org/multierr"
 )
@@ -168,12 +169,15 @@ func (s *BlobSet) BlobFor(digest string) (Blob, bool) {
 	return blob, true
 }
 
-// Blobs returns a slice of the blobs in the set.
+// Blobs returns a slice of the blobs in the set, sorted by digest.
 func (s *BlobSet) Blobs() []Blob {
 	blobs := make([]Blob, 0, len(s.digestToBlob))
 	for _, b := range s.digestToBlob {
 		blobs = append(blobs, b)
 	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].Digest().String() < blobs[j].Digest().String()
+	})
 	return blobs
 }
 
@@ -15,6 +15,8 @@
 package storage
 
 import (
+	"path"
+
 	"github.com/bufbuild/buf/private/pkg/normalpath"
 )
 
@@ -63,6 +65,25 @@ func MatchPathContained(containingDir string) Matcher {
 	})
 }
 
+// MatchPathGlobOrContained returns a Matcher for the paths that match the glob pattern, or are
+// contained by a directory that matches it, using path.Match semantics.
+//
+// The pattern is expected to be valid for path.Match. Invalid patterns match no path.
+func MatchPathGlobOrContained(pattern string) Matcher {
+	return pathMatcherFunc(func(matchPath string) bool {
+		for {
+			if matches, _ := path.Match(pattern, matchPath); matches {
+				return true
+			}
+			parentPath := normalpath.Dir(matchPath)
+			if parentPath == matchPath || parentPath == "." {
+				return false
+			}
+			matchPath = parentPath
+		}
+	})
+}
+
 // MatchOr returns an Or of the Matchers.
 func MatchOr(matchers ...Matcher) Matcher {
 	return orMatcher(matchers)
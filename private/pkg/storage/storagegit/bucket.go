@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/bufbuild/buf/private/pkg/git"
 	"github.com/bufbuild/buf/private/pkg/normalpath"
@@ -27,21 +28,33 @@ import (
 	"github.com/bufbuild/buf/private/pkg/storage/storageutil"
 )
 
+// maxSymlinkHops is the max number of symlinks followed to resolve a path, as MAXSYMLINKS on Linux.
+const maxSymlinkHops = 40
+
 type bucket struct {
-	objectReader git.ObjectReader
-	symlinks     bool
-	root         git.Tree
+	objectReader         git.ObjectReader
+	symlinks             bool
+	streamingBlobs       bool
+	submodules           bool
+	missingSubmoduleFunc func(string, git.Hash)
+	root                 git.Tree
 }
 
 func newBucket(
 	objectReader git.ObjectReader,
 	symlinksIfSupported bool,
+	streamingBlobs bool,
+	submodules bool,
+	missingSubmoduleFunc func(string, git.Hash),
 	root git.Tree,
 ) (storage.ReadBucket, error) {
 	return &bucket{
-		objectReader: objectReader,
-		symlinks:     symlinksIfSupported,
-		root:         root,
+		objectReader:         objectReader,
+		symlinks:             symlinksIfSupported,
+		streamingBlobs:       streamingBlobs,
+		submodules:           submodules,
+		missingSubmoduleFunc: missingSubmoduleFunc,
+		root:                 root,
 	}, nil
 }
 
@@ -50,7 +63,7 @@ func (b *bucket) Get(ctx context.Context, path string) (storage.ReadObjectCloser
 	if err != nil {
 		return nil, err
 	}
-	node, err := b.root.Descendant(path, b.objectReader)
+	node, err := b.resolve(path)
 	if err != nil {
 		if errors.Is(err, git.ErrTreeNodeNotFound) {
 			return nil, storage.NewErrNotExist(path)
@@ -59,6 +72,17 @@ func (b *bucket) Get(ctx context.Context, path string) (storage.ReadObjectCloser
 	}
 	switch node.Mode() {
 	case git.ModeFile, git.ModeExe:
+		if b.streamingBlobs {
+			blobReader, err := b.objectReader.BlobReader(node.Hash())
+			if err != nil {
+				return nil, err
+			}
+			return &namedReader{
+				info:   b.newObjectInfo(path),
+				reader: blobReader,
+				closer: blobReader,
+			}, nil
+		}
 		data, err := b.objectReader.Blob(node.Hash())
 		if err != nil {
 			return nil, err
@@ -67,33 +91,13 @@ func (b *bucket) Get(ctx context.Context, path string) (storage.ReadObjectCloser
 			info:   b.newObjectInfo(path),
 			reader: bytes.NewReader(data),
 		}, nil
-	case git.ModeSymlink:
-		if !b.symlinks {
-			return nil, storage.NewErrNotExist(path)
-		}
-		// Symlinks are stored as blobs that reference the target path as a relative
-		// path. We can follow this symlink trivially.
-		data, err := b.objectReader.Blob(node.Hash())
-		if err != nil {
-			return nil, err
-		}
-		path, err := normalpath.NormalizeAndValidate(
-			normalpath.Join(
-				normalpath.Base(path),
-				string(data),
-			),
-		)
-		if err != nil {
-			return nil, fmt.Errorf("invalid path %q: %w", path, err)
-		}
-		return b.Get(ctx, path)
 	default:
 		return nil, storage.NewErrNotExist(path)
 	}
 }
 
 func (b *bucket) Stat(ctx context.Context, path string) (storage.ObjectInfo, error) {
-	node, err := b.root.Descendant(path, b.objectReader)
+	node, err := b.resolve(path)
 	if err != nil {
 		if errors.Is(err, git.ErrTreeNodeNotFound) {
 			return nil, storage.NewErrNotExist(path)
@@ -103,11 +107,6 @@ func (b *bucket) Stat(ctx context.Context, path string) (storage.ObjectInfo, err
 	switch node.Mode() {
 	case git.ModeFile, git.ModeExe:
 		return b.newObjectInfo(path), nil
-	case git.ModeSymlink:
-		if !b.symlinks {
-			return nil, storage.NewErrNotExist(path)
-		}
-		return b.newObjectInfo(path), nil
 	default:
 		return nil, storage.NewErrNotExist(path)
 	}
@@ -134,18 +133,21 @@ func (b *bucket) walk(
 	walkFn func(string) error,
 ) error {
 	if prefix != "." {
-		node, err := parent.Descendant(prefix, b.objectReader)
+		node, err := b.descendant(prefix)
 		if err != nil {
 			if errors.Is(err, git.ErrTreeNodeNotFound) {
 				return storage.NewErrNotExist(prefix)
 			}
 			return err
 		}
-		if node.Mode() != git.ModeDir {
+		if node.Mode() != git.ModeDir && (node.Mode() != git.ModeSubmodule || !b.submodules) {
 			return errors.New("prefix is not a directory")
 		}
-		subTree, err := b.objectReader.Tree(node.Hash())
+		subTree, err := b.nodeTree(node)
 		if err != nil {
+			if errors.Is(err, git.ErrTreeNodeNotFound) {
+				return storage.NewErrNotExist(prefix)
+			}
 			return err
 		}
 		parent = subTree
@@ -167,7 +169,18 @@ func (b *bucket) walkTree(
 				return err
 			}
 		case git.ModeSymlink:
-			if b.symlinks {
+			if !b.symlinks {
+				continue
+			}
+			// Only symlinks to files are walked, so that every walked path can be read.
+			target, err := b.resolve(path)
+			if err != nil {
+				if errors.Is(err, git.ErrTreeNodeNotFound) {
+					continue
+				}
+				return err
+			}
+			if target.Mode() == git.ModeFile || target.Mode() == git.ModeExe {
 				if err := walkFn(path); err != nil {
 					return err
 				}
@@ -180,6 +193,23 @@ func (b *bucket) walkTree(
 			if err := b.walkTree(subTree, objectReader, path, walkFn); err != nil {
 				return err
 			}
+		case git.ModeSubmodule:
+			if !b.submodules {
+				continue
+			}
+			subTree, err := b.nodeTree(node)
+			if err != nil {
+				if errors.Is(err, git.ErrTreeNodeNotFound) {
+					if b.missingSubmoduleFunc != nil {
+						b.missingSubmoduleFunc(path, node.Hash())
+					}
+					continue
+				}
+				return err
+			}
+			if err := b.walkTree(subTree, objectReader, path, walkFn); err != nil {
+				return err
+			}
 		default:
 			// ignored
 		}
@@ -187,6 +217,86 @@ func (b *bucket) walkTree(
 	return nil
 }
 
+// resolve returns the node at the path, following symlinks if enabled. Symlinks are stored as blobs
+// with the target path, relative to the symlink's dir. Symlinked dirs are not followed. It returns an
+// error with git.ErrTreeNodeNotFound in its chain if the path is a symlink and symlinks are not
+// enabled, or if a symlink target is not found, is outside the bucket, or more than maxSymlinkHops
+// symlinks are followed, such as in a cycle.
+//
+// path is expected to be normalized by calling functions
+func (b *bucket) resolve(path string) (git.TreeNode, error) {
+	for hops := 0; ; hops++ {
+		node, err := b.descendant(path)
+		if err != nil {
+			return nil, err
+		}
+		if node.Mode() != git.ModeSymlink {
+			return node, nil
+		}
+		if !b.symlinks {
+			return nil, git.ErrTreeNodeNotFound
+		}
+		if hops == maxSymlinkHops {
+			return nil, fmt.Errorf("too many levels of symlinks resolving %q: %w", path, git.ErrTreeNodeNotFound)
+		}
+		target, err := b.objectReader.Blob(node.Hash())
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(string(target), "/") {
+			return nil, fmt.Errorf("absolute symlink target %q: %w", target, git.ErrTreeNodeNotFound)
+		}
+		path, err = normalpath.NormalizeAndValidate(normalpath.Join(normalpath.Dir(path), string(target)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid symlink target %q: %v: %w", target, err, git.ErrTreeNodeNotFound)
+		}
+	}
+}
+
+// descendant returns the node at the path from the root tree, descending into the
+// submodules in the path if submodules are enabled.
+func (b *bucket) descendant(path string) (git.TreeNode, error) {
+	if !b.submodules {
+		return b.root.Descendant(path, b.objectReader)
+	}
+	parent := b.root
+	names := normalpath.Components(path)
+	for i, name := range names {
+		node, err := parent.Descendant(name, b.objectReader)
+		if err != nil {
+			return nil, err
+		}
+		if i == len(names)-1 {
+			return node, nil
+		}
+		if node.Mode() != git.ModeDir && node.Mode() != git.ModeSubmodule {
+			return nil, git.ErrTreeNodeNotFound
+		}
+		parent, err = b.nodeTree(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, git.ErrTreeNodeNotFound
+}
+
+// nodeTree returns the tree of a directory node, or the tree of the commit a submodule node
+// is checked out at. If the submodule commit is not in the object store, this returns an error
+// with git.ErrTreeNodeNotFound in its chain.
+func (b *bucket) nodeTree(node git.TreeNode) (git.Tree, error) {
+	if node.Mode() != git.ModeSubmodule {
+		return b.objectReader.Tree(node.Hash())
+	}
+	commit, err := b.objectReader.Commit(node.Hash())
+	if err != nil {
+		if errors.Is(err, git.ErrObjectNotFound) {
+			return nil, fmt.Errorf("submodule %s: %w", node.Hash(), git.ErrTreeNodeNotFound)
+		}
+		return nil, err
+	}
+	return b.objectReader.Tree(commit.Tree())
+}
+
 // path is expected to be normalized by calling functions
 func (b *bucket) newObjectInfo(path string) storage.ObjectInfo {
 	return storageutil.NewObjectInfo(
@@ -198,6 +308,8 @@ func (b *bucket) newObjectInfo(path string) storage.ObjectInfo {
 type namedReader struct {
 	info   storage.ObjectInfo
 	reader io.Reader
+	// closer is nil if the reader does not need to be closed.
+	closer io.Closer
 }
 
 var _ storage.ReadObjectCloser = (*namedReader)(nil)
@@ -214,5 +326,8 @@ func (br *namedReader) Read(p []byte) (n int, err error) {
 }
 
 func (br *namedReader) Close() error {
-	return nil
+	if br.closer == nil {
+		return nil
+	}
+	return br.closer.Close()
 }
@@ -46,6 +46,9 @@ func (p *provider) NewReadBucket(treeHash git.Hash, options ...ReadBucketOption)
 	return newBucket(
 		p.objectReader,
 		p.symlinks && opts.symlinksIfSupported,
+		opts.streamingBlobs,
+		opts.submodules,
+		opts.missingSubmoduleFunc,
 		tree,
 	)
 }
@@ -54,5 +57,8 @@ func (p *provider) NewReadBucket(treeHash git.Hash, options ...ReadBucketOption)
 // as a combination of the provider options and read write bucket options
 // so there's no potential issues in newBucket
 type readBucketOptions struct {
-	symlinksIfSupported bool
+	symlinksIfSupported  bool
+	streamingBlobs       bool
+	submodules           bool
+	missingSubmoduleFunc func(string, git.Hash)
 }
@@ -48,12 +48,39 @@ type ReadBucketOption func(*readBucketOptions)
 // ReadBucketWithSymlinksIfSupported returns a ReadBucketOption that results
 // in symlink support being enabled for this bucket. If the Provider did not have symlink
 // support, this is a no-op.
+//
+// Symlink targets are resolved relative to the dir of the symlink, as on a filesystem. Only
+// symlinks to files are followed: symlinks to dirs, dangling or cyclic symlinks, and symlinks
+// to targets outside the bucket are skipped by Walk, and do not exist for Get and Stat.
 func ReadBucketWithSymlinksIfSupported() ReadBucketOption {
 	return func(b *readBucketOptions) {
 		b.symlinksIfSupported = true
 	}
 }
 
+// ReadBucketWithStreamingBlobs returns a ReadBucketOption that results in the files of this
+// bucket being streamed from the object store as they are read, instead of being read in memory
+// when they are opened. Each open file is streamed by a git process of its own, so files can be
+// read concurrently, up to the number of CPUs at a time: opening more files blocks until an open
+// file is closed. Files must be closed once done to let their process exit.
+func ReadBucketWithStreamingBlobs() ReadBucketOption {
+	return func(b *readBucketOptions) {
+		b.streamingBlobs = true
+	}
+}
+
+// ReadBucketWithSubmodules returns a ReadBucketOption that results in the content of
+// submodules being included in this bucket at their paths, by reading the commits the
+// submodules are checked out at from the same object store. When a submodule commit is not
+// found in the object store, the submodule is skipped and, if not nil, missingSubmoduleFunc
+// is called with the submodule path and commit hash while walking the bucket.
+func ReadBucketWithSubmodules(missingSubmoduleFunc func(path string, commitHash git.Hash)) ReadBucketOption {
+	return func(b *readBucketOptions) {
+		b.submodules = true
+		b.missingSubmoduleFunc = missingSubmoduleFunc
+	}
+}
+
 // NewProvider creates a new Provider for a git repository.
 func NewProvider(objectReader git.ObjectReader, options ...ProviderOption) Provider {
 	return newProvider(objectReader, options...)
@@ -15,13 +15,28 @@
 package storagegit
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
+	"github.com/bufbuild/buf/private/pkg/command"
+	"github.com/bufbuild/buf/private/pkg/git"
 	"github.com/bufbuild/buf/private/pkg/git/gittest"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
 	"github.com/bufbuild/buf/private/pkg/storage/storagetesting"
+	"github.com/bufbuild/buf/private/pkg/thread"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// largeBlobSize is the size of the large file in the repository created by newLargeBlobRepository.
+const largeBlobSize = 16 << 20
+
 func TestNewBucketAtTreeHash(t *testing.T) {
 	t.Parallel()
 
@@ -73,3 +88,270 @@ func TestNewBucketAtTreeHash(t *testing.T) {
 		},
 	)
 }
+
+func TestNewBucketWithStreamingBlobs(t *testing.T) {
+	t.Parallel()
+
+	repo := gittest.ScaffoldGitRepository(t)
+	provider := NewProvider(repo.Objects())
+	headCommit, err := repo.HEADCommit(repo.DefaultBranch())
+	require.NoError(t, err)
+	bucket, err := provider.NewReadBucket(headCommit.Tree(), ReadBucketWithStreamingBlobs())
+	require.NoError(t, err)
+
+	storagetesting.AssertPathToContent(
+		t,
+		bucket,
+		"proto/acme/petstore",
+		map[string]string{
+			"proto/acme/petstore/v1/a.proto": "cats",
+			"proto/acme/petstore/v1/b.proto": "animals",
+			"proto/acme/petstore/v1/e.proto": "loblaws",
+			"proto/acme/petstore/v1/f.proto": "merchant of venice",
+		},
+	)
+	// opening another file does not interrupt the reads of the open file
+	readObjectCloser, err := bucket.Get(context.Background(), "proto/buf.yaml")
+	require.NoError(t, err)
+	data, err := storage.ReadPath(context.Background(), bucket, "randomBinary")
+	require.NoError(t, err)
+	assert.Equal(t, "some executable", string(data))
+	data, err = io.ReadAll(readObjectCloser)
+	require.NoError(t, err)
+	assert.Equal(t, "some buf.yaml", string(data))
+	assert.NoError(t, readObjectCloser.Close())
+}
+
+func TestCopyStreamingBucketInParallel(t *testing.T) {
+	t.Parallel()
+
+	repo := gittest.ScaffoldGitRepository(t)
+	provider := NewProvider(repo.Objects())
+	headCommit, err := repo.HEADCommit(repo.DefaultBranch())
+	require.NoError(t, err)
+	bucket, err := provider.NewReadBucket(headCommit.Tree())
+	require.NoError(t, err)
+	streamingBucket, err := provider.NewReadBucket(headCommit.Tree(), ReadBucketWithStreamingBlobs())
+	require.NoError(t, err)
+	ctx := context.Background()
+	paths, err := storage.AllPaths(ctx, bucket, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+	pathToContent := make(map[string]string, len(paths))
+	for _, path := range paths {
+		data, err := storage.ReadPath(ctx, bucket, path)
+		require.NoError(t, err)
+		pathToContent[path] = string(data)
+	}
+
+	// the files are copied concurrently
+	copyBucket := storagemem.NewReadWriteBucket()
+	copied, err := storage.Copy(ctx, streamingBucket, copyBucket)
+	require.NoError(t, err)
+	assert.Equal(t, len(paths), copied)
+	storagetesting.AssertPathToContent(t, copyBucket, "", pathToContent)
+
+	// as many files as can be streamed at a time are open at once, and read interleaved
+	if len(paths) > thread.Parallelism() {
+		paths = paths[:thread.Parallelism()]
+	}
+	readObjectClosers := make([]storage.ReadObjectCloser, len(paths))
+	for i, path := range paths {
+		readObjectClosers[i], err = streamingBucket.Get(ctx, path)
+		require.NoError(t, err)
+	}
+	contents := make([][]byte, len(paths))
+	for {
+		var read bool
+		for i := len(paths) - 1; i >= 0; i-- {
+			buffer := make([]byte, 1)
+			n, err := readObjectClosers[i].Read(buffer)
+			if err != io.EOF {
+				require.NoError(t, err)
+			}
+			if n > 0 {
+				read = true
+				contents[i] = append(contents[i], buffer[:n]...)
+			}
+		}
+		if !read {
+			break
+		}
+	}
+	for i, path := range paths {
+		assert.Equal(t, pathToContent[path], string(contents[i]), path)
+		assert.NoError(t, readObjectClosers[i].Close())
+	}
+}
+
+func TestNewBucketWithSymlinks(t *testing.T) {
+	t.Parallel()
+
+	objectReader, treeHash := newTestRepository(t, func(dir string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "docs"), 0700))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "proto"), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "README.md"), []byte("readme"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "proto", "a.proto"), []byte("a"), 0600))
+		for link, target := range map[string]string{
+			// symlinks to files, relative to the symlink dir
+			"proto/buf.md":   "../docs/README.md",
+			"proto/b.proto":  "a.proto",
+			"proto/c.proto":  "b.proto",
+			"proto/dir":      "../docs",
+			"proto/dangling": "missing.proto",
+			"proto/cycle1":   "cycle2",
+			"proto/cycle2":   "cycle1",
+			"proto/outside":  "../../outside",
+			"proto/absolute": "/etc/hosts",
+			// symlinked dirs are not followed
+			"proto/readme.md": "dir/README.md",
+		} {
+			require.NoError(t, os.Symlink(target, filepath.Join(dir, link)))
+		}
+	})
+
+	bucket, err := NewProvider(objectReader, ProviderWithSymlinks()).NewReadBucket(treeHash, ReadBucketWithSymlinksIfSupported())
+	require.NoError(t, err)
+	// only the symlinks to files are walked, and read the target content
+	storagetesting.AssertPathToContent(
+		t,
+		bucket,
+		"",
+		map[string]string{
+			"docs/README.md": "readme",
+			"proto/a.proto":  "a",
+			"proto/b.proto":  "a",
+			"proto/buf.md":   "readme",
+			"proto/c.proto":  "a",
+		},
+	)
+	storagetesting.AssertObjectInfo(t, bucket, "proto/buf.md", "proto/buf.md")
+	for _, path := range []string{"proto/dir", "proto/readme.md", "proto/dangling", "proto/cycle1", "proto/outside", "proto/absolute"} {
+		storagetesting.AssertNotExist(t, bucket, path)
+	}
+
+	bucket, err = NewProvider(objectReader).NewReadBucket(treeHash, ReadBucketWithSymlinksIfSupported())
+	require.NoError(t, err)
+	storagetesting.AssertPaths(t, bucket, "", "docs/README.md", "proto/a.proto")
+	storagetesting.AssertNotExist(t, bucket, "proto/b.proto")
+}
+
+func TestNewBucketWalkSymlinkToDir(t *testing.T) {
+	t.Parallel()
+
+	objectReader, treeHash := newTestRepository(t, func(dir string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "vendor", "acme"), 0700))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "proto"), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "vendor", "acme", "acme.proto"), []byte("acme"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "proto", "a.proto"), []byte("a"), 0600))
+		require.NoError(t, os.Symlink("../vendor/acme", filepath.Join(dir, "proto", "acme")))
+	})
+
+	bucket, err := NewProvider(objectReader, ProviderWithSymlinks()).NewReadBucket(treeHash, ReadBucketWithSymlinksIfSupported())
+	require.NoError(t, err)
+	// the symlinked dir is skipped, not walked into, and does not fail the walk
+	storagetesting.AssertPaths(t, bucket, "proto", "proto/a.proto")
+	storagetesting.AssertPaths(t, bucket, "", "proto/a.proto", "vendor/acme/acme.proto")
+	storagetesting.AssertNotExist(t, bucket, "proto/acme")
+	storagetesting.AssertNotExist(t, bucket, "proto/acme/acme.proto")
+}
+
+// TestStreamingBlobsMemory is not parallel, to measure the memory allocated by the test only.
+func TestStreamingBlobsMemory(t *testing.T) {
+	objectReader, treeHash := newLargeBlobRepository(t)
+	inMemoryAllocated := allocatedReadingLargeBlob(t, objectReader, treeHash)
+	streamingAllocated := allocatedReadingLargeBlob(t, objectReader, treeHash, ReadBucketWithStreamingBlobs())
+	assert.GreaterOrEqual(t, inMemoryAllocated, uint64(largeBlobSize))
+	assert.Less(t, streamingAllocated, uint64(largeBlobSize/16))
+}
+
+func BenchmarkReadLargeBlob(b *testing.B) {
+	objectReader, treeHash := newLargeBlobRepository(b)
+	for _, benchmark := range []struct {
+		name    string
+		options []ReadBucketOption
+	}{
+		{name: "in_memory"},
+		{name: "streaming", options: []ReadBucketOption{ReadBucketWithStreamingBlobs()}},
+	} {
+		benchmark := benchmark
+		b.Run(benchmark.name, func(b *testing.B) {
+			bucket, err := NewProvider(objectReader).NewReadBucket(treeHash, benchmark.options...)
+			require.NoError(b, err)
+			b.SetBytes(largeBlobSize)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				readLargeBlob(b, bucket)
+			}
+		})
+	}
+}
+
+// allocatedReadingLargeBlob returns the bytes allocated to read the large file of the tree.
+func allocatedReadingLargeBlob(
+	t *testing.T,
+	objectReader git.ObjectReader,
+	treeHash git.Hash,
+	options ...ReadBucketOption,
+) uint64 {
+	bucket, err := NewProvider(objectReader).NewReadBucket(treeHash, options...)
+	require.NoError(t, err)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	readLargeBlob(t, bucket)
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func readLargeBlob(t testing.TB, bucket storage.ReadBucket) {
+	readObjectCloser, err := bucket.Get(context.Background(), "large.bin")
+	require.NoError(t, err)
+	written, err := io.Copy(io.Discard, readObjectCloser)
+	require.NoError(t, err)
+	require.Equal(t, int64(largeBlobSize), written)
+	require.NoError(t, readObjectCloser.Close())
+}
+
+// newLargeBlobRepository returns the object reader of a repository with a commit of a single large
+// file, and the tree hash of the commit.
+func newLargeBlobRepository(t testing.TB) (git.ObjectReader, git.Hash) {
+	return newTestRepository(t, func(dir string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "large.bin"), bytes.Repeat([]byte("0123456789abcdef"), largeBlobSize/16), 0600))
+	})
+}
+
+// newTestRepository returns the object reader of a repository with a single commit of the files
+// written by writeFiles to the working tree dir, and the tree hash of the commit.
+func newTestRepository(t testing.TB, writeFiles func(dir string)) (git.ObjectReader, git.Hash) {
+	runner := command.NewRunner()
+	dir := t.TempDir()
+	runGit := func(args ...string) string {
+		stdout := bytes.NewBuffer(nil)
+		require.NoError(t, runner.Run(
+			context.Background(),
+			"git",
+			command.RunWithArgs(append([]string{"-c", "user.name=Buf TestBot", "-c", "user.email=testbot@buf.build"}, args...)...),
+			command.RunWithDir(dir),
+			command.RunWithStdout(stdout),
+		))
+		return string(bytes.TrimSpace(stdout.Bytes()))
+	}
+	runGit("init", "--initial-branch", "main")
+	writeFiles(dir)
+	runGit("add", "-A")
+	runGit("commit", "-m", "files")
+	treeHash, err := git.NewHashFromHex(runGit("rev-parse", "HEAD^{tree}"))
+	require.NoError(t, err)
+	repo, err := git.OpenRepository(
+		context.Background(),
+		filepath.Join(dir, git.DotGitDir),
+		runner,
+		git.OpenRepositoryWithDefaultBranch("main"),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, repo.Close())
+	})
+	return repo.Objects(), treeHash
+}